@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/nobletooth/kiwi/pkg/config"
 	"github.com/nobletooth/kiwi/pkg/port"
@@ -27,14 +28,24 @@ func main() {
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, os.Kill)
+	defer cancel()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM) // SIGTERM is sent by process managers, e.g. Kubernetes.
 
 	go func() { // Listen for OS interrupts in the background.
-		select {
-		case sig := <-signals:
-			slog.Info("Received termination signal, cancelling server context.", "signal", sig)
-			cancel()
+		sig := <-signals
+		slog.Info("Received termination signal, shutting down gracefully.", "signal", sig)
+		cancel()
+		sig = <-signals
+		slog.Error("Received another termination signal, exiting immediately.", "signal", sig)
+		os.Exit(1)
+	}()
+
+	// The admin server starts first, so that health checks and metrics are served while the storage is opened.
+	admin := port.NewAdminServer()
+	go func() {
+		if err := admin.Run(ctx); err != nil {
+			slog.Error("Admin server stopped.", "err", err)
 		}
 	}()
 
@@ -43,11 +54,14 @@ func main() {
 		slog.Error("Failed to instantiate a Kiwi storage instance.", "err", err)
 		os.Exit(1)
 	}
+	admin.SetReady(true)
 
-	if err := port.RunRedisServer(ctx, store); err != nil {
+	err = port.RunRedisServer(ctx, store)
+	cancel() // Stops the admin server as well, e.g. after the SHUTDOWN command.
+	if err != nil {
 		slog.Error("Kiwi server stopped.", "err", err)
 		os.Exit(1)
 	}
 
-	slog.Info("Closed Kiwi.")
+	slog.Info("Closed Kiwi gracefully.")
 }
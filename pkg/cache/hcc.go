@@ -4,7 +4,10 @@
 // needs to be added, the hand checks the entry it's pointing to:
 //   - If the entry's reference bit is 'true', it sets it to 'false' and moves to the next entry.
 //     This gives the entry a "second chance".
-//   - If the entry's reference bit is 'false', it evicts that entry and replaces it with the new one.
+//   - If the entry's reference bit is 'false', it evicts that entry.
+//
+// Each entry is charged against the capacity of the cache, e.g. by its size in bytes, and the hand keeps evicting
+// entries until the new one fits. The new entry is then placed right behind the hand, so it's visited last.
 //
 // Expiration Policy (TTL with Reaper):
 // Entries are given a Time-To-Live (TTL). To manage expirations efficiently, entries are distributed to time-based
@@ -34,6 +37,7 @@ type expirableClockCacheEntry[K comparable, V any] struct {
 	// access from Get and the eviction loop.
 	ref       atomic.Bool
 	expiresAt time.Time // The timestamp when this entry is considered expired.
+	charge    int       // The amount this entry takes up of the cache's capacity.
 }
 
 // getTimeBucket rounds down the timestamp to the last timestamp that the reaper cleared given the tickInterval.
@@ -44,7 +48,8 @@ func getTimeBucket(timestamp time.Time, tickInterval time.Duration) time.Time {
 // HyperClock is a thread-safe, fixed-capacity, in-memory cache that combines the CLOCK (Second-Chance)
 // eviction algorithm with a time-based expiration mechanism.
 type HyperClock[K comparable, V any] struct {
-	capacity int // Maximum number of entries the cache can hold.
+	capacity int          // Maximum total charge of the entries the cache can hold.
+	usage    atomic.Int64 // Total charge of the entries in the cache; updated under mux, but read without it.
 	// hand is the "clock hand" that points to the next candidate for eviction in the circular list.
 	hand  *LinkedListNode[*expirableClockCacheEntry[K, V]]
 	index map[K]*LinkedListNode[*expirableClockCacheEntry[K, V]] // Provides lookup for an entry by its key.
@@ -73,9 +78,9 @@ func NewHyperClock[K comparable, V any](ctx context.Context, capacity int, tickI
 	}
 	clockCache := &HyperClock[K, V]{
 		capacity:         capacity,
-		index:            make(map[K]*LinkedListNode[*expirableClockCacheEntry[K, V]], capacity),
+		index:            make(map[K]*LinkedListNode[*expirableClockCacheEntry[K, V]]),
 		circularBuffer:   new(LinkedList[*expirableClockCacheEntry[K, V]]),
-		expiryBuckets:    make(map[time.Time]map[K]*LinkedListNode[*expirableClockCacheEntry[K, V]], capacity),
+		expiryBuckets:    make(map[time.Time]map[K]*LinkedListNode[*expirableClockCacheEntry[K, V]]),
 		tickInterval:     tickInterval,
 		reaperHand:       getTimeBucket(time.Now(), tickInterval),
 		evictionCallback: evictionCallback,
@@ -109,80 +114,118 @@ func (c *HyperClock[K, V]) addEntryToExpiryBucket(entry *LinkedListNode[*expirab
 	c.expiryBuckets[bucket][entry.Value.key] = entry
 }
 
-// Add inserts or updates a key-value pair in the cache. If the key already exists, its value and expiration are
-// updated. If the cache is full, it evicts an old entry using the CLOCK algorithm. It returns true if an eviction
-// occurred, and false otherwise.
+// Add inserts or updates a key-value pair in the cache, charging it 1. See AddWithCharge.
 func (c *HyperClock[K, V]) Add(key K, value V, ttl time.Duration) /*evictionOccurred*/ bool {
+	return c.AddWithCharge(key, value, 1 /*charge*/, ttl)
+}
+
+// AddWithCharge inserts or updates a key-value pair in the cache. If the key already exists, its value, charge and
+// expiration are updated. If the cache doesn't have room for the entry's charge, it evicts old entries using the
+// CLOCK algorithm; entries which are charged more than the whole capacity aren't cached at all. It returns true if an
+// eviction occurred, and false otherwise.
+func (c *HyperClock[K, V]) AddWithCharge(key K, value V, charge int, ttl time.Duration) /*evictionOccurred*/ bool {
 	c.mux.Lock()
 	defer c.mux.Unlock()
 
-	// Update existing entry.
+	charge = max(charge, 1) // Every entry takes up some room, so the number of entries is bounded as well.
+	// Update existing entry (if the cache still has room for it).
 	if entry, keyExists := c.index[key]; keyExists {
 		entryValue := entry.Value
-		// Remove from the old time bucket before updating.
-		delete(c.expiryBuckets[getTimeBucket(entryValue.expiresAt, c.tickInterval)], entryValue.key)
-		// Update value, mark as referenced, and reset TTL.
-		entryValue.value = value
-		entryValue.ref.Store(false)
-		entryValue.expiresAt = time.Now().Add(ttl)
-		c.addEntryToExpiryBucket(entry)
-		return false
-	}
-
-	// Add new entry (if cache is not full).
-	if c.circularBuffer.Len() < c.capacity {
-		entry := c.circularBuffer.PushBack(&expirableClockCacheEntry[K, V]{
-			key:       key,
-			value:     value,
-			expiresAt: time.Now().Add(ttl),
-		})
-		c.addEntryToExpiryBucket(entry)
-		c.index[key] = entry
-		// Initialize clock hand if it's the first element.
-		if c.hand == nil {
-			c.hand = entry
+		if int(c.usage.Load())-entryValue.charge+charge <= c.capacity {
+			// Remove from the old time bucket before updating.
+			delete(c.expiryBuckets[getTimeBucket(entryValue.expiresAt, c.tickInterval)], entryValue.key)
+			// Update value, mark as referenced, and reset TTL.
+			c.usage.Add(int64(charge - entryValue.charge))
+			entryValue.value = value
+			entryValue.charge = charge
+			entryValue.ref.Store(false)
+			entryValue.expiresAt = time.Now().Add(ttl)
+			c.addEntryToExpiryBucket(entry)
+			return false
 		}
+		// The entry has grown, so it's reinserted as a new entry, making room for it as well.
+		c.removeEntry(entry)
+	}
+	if charge > c.capacity {
 		return false
 	}
 
 	// Eviction loop (if cache is full). This loop implements the CLOCK (Second-Chance) algorithm.
-	for {
+	evictionOccurred := false
+	for int(c.usage.Load())+charge > c.capacity {
 		entry := c.hand
 		entryValue := entry.Value
 		// Find a victim: an entry that is either unreferenced OR expired.
 		if !entryValue.ref.Load() || time.Now().After(entryValue.expiresAt) {
-			// Evict this entry. Remove it from the index and its time bucket.
-			delete(c.index, entryValue.key)
-			delete(c.expiryBuckets[getTimeBucket(entryValue.expiresAt, c.tickInterval)], entryValue.key)
-			evictedKey := entryValue.key
-			evictedValue := entryValue.value
-			// Replace the evicted entry's data with the new data in the same node.
-			entryValue.key = key
-			entryValue.value = value
-			entryValue.ref.Store(false)
-			entryValue.expiresAt = time.Now().Add(ttl)
-			c.addEntryToExpiryBucket(entry)
-			c.index[key] = entry
-			// Advance the clock hand.
-			next := entry.Next()
-			if next == nil { // Wrap around to the front if at the end of the list.
-				next = c.circularBuffer.Front()
-			}
-			c.hand = next
+			// Evict this entry; this advances the clock hand as well.
+			c.removeEntry(entry)
+			evictionOccurred = true
 			if c.evictionCallback != nil {
-				c.evictionCallback(evictedKey, evictedValue)
+				c.evictionCallback(entryValue.key, entryValue.value)
 			}
-			return true
+			continue
 		}
 		// If the entry was referenced, give it a second chance by clearing its reference bit.
 		entryValue.ref.Store(false)
-		// Advance the clock hand to the next element.
-		next := entry.Next()
-		if next == nil { // Wrap around if at the end.
-			next = c.circularBuffer.Front()
+		c.hand = c.nextEntry(entry)
+	}
+
+	// Add new entry right behind the clock hand.
+	newEntry := &expirableClockCacheEntry[K, V]{
+		key:       key,
+		value:     value,
+		expiresAt: time.Now().Add(ttl),
+		charge:    charge,
+	}
+	var entry *LinkedListNode[*expirableClockCacheEntry[K, V]]
+	if c.hand == nil { // The cache is empty.
+		entry = c.circularBuffer.PushBack(newEntry)
+		c.hand = entry
+	} else {
+		entry = c.circularBuffer.InsertBefore(newEntry, c.hand)
+	}
+	c.addEntryToExpiryBucket(entry)
+	c.index[key] = entry
+	c.usage.Add(int64(charge))
+	return evictionOccurred
+}
+
+// nextEntry returns the entry after the given one in the circular buffer.
+func (c *HyperClock[K, V]) nextEntry(entry *LinkedListNode[*expirableClockCacheEntry[K, V]],
+) *LinkedListNode[*expirableClockCacheEntry[K, V]] {
+	if next := entry.Next(); next != nil {
+		return next
+	}
+	return c.circularBuffer.Front() // Wrap around if at the end.
+}
+
+// removeEntry removes the given entry from the cache, without calling the eviction callback.
+// NOTE: Caller should acquire lock.
+func (c *HyperClock[K, V]) removeEntry(entry *LinkedListNode[*expirableClockCacheEntry[K, V]]) {
+	delete(c.index, entry.Value.key)
+	delete(c.expiryBuckets[getTimeBucket(entry.Value.expiresAt, c.tickInterval)], entry.Value.key)
+	// The clock hand must not point to a removed node.
+	if c.hand == entry {
+		c.hand = c.nextEntry(entry)
+		if c.hand == entry { // The removed entry was the only one.
+			c.hand = nil
 		}
-		c.hand = next
 	}
+	c.circularBuffer.Remove(entry)
+	c.usage.Add(-int64(entry.Value.charge))
+}
+
+// Remove deletes the given key from the cache. The eviction callback is not called for removed entries.
+func (c *HyperClock[K, V]) Remove(key K) /*found*/ bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	entry, keyExists := c.index[key]
+	if !keyExists {
+		return false
+	}
+	c.removeEntry(entry)
+	return true
 }
 
 func (c *HyperClock[K, V]) Keys() []K {
@@ -210,6 +253,17 @@ func (c *HyperClock[K, V]) Purge() {
 		delete(c.expiryBuckets, key)
 	}
 	c.hand = nil
+	c.usage.Store(0)
+}
+
+// Usage returns the total charge of the entries in the cache; it doesn't take the lock, so it's cheap to poll.
+func (c *HyperClock[K, V]) Usage() int {
+	return int(c.usage.Load())
+}
+
+// Capacity returns the maximum total charge of the entries in the cache.
+func (c *HyperClock[K, V]) Capacity() int {
+	return c.capacity
 }
 
 // reaper is a background goroutine that handles entry expiration. It wakes up at a regular interval and clears an
@@ -230,18 +284,7 @@ func (c *HyperClock[K, V]) reaper(ctx context.Context, interval time.Duration) {
 					if bucket, bucketExists := c.expiryBuckets[c.reaperHand]; bucketExists {
 						// Remove all entries from the bucket that is being cleared.
 						for _, entryNode := range bucket {
-							// If the clock hand is pointing to an entry we are about to delete, we must advance it to
-							// prevent it from pointing to a removed node.
-							if c.hand == entryNode {
-								next := entryNode.Next()
-								if next == nil { // Wrap around if at the end.
-									next = c.circularBuffer.Front()
-								}
-								c.hand = next
-							}
-							// Remove the entry from circular buffer and key index.
-							delete(c.index, entryNode.Value.key)
-							c.circularBuffer.Remove(entryNode)
+							c.removeEntry(entryNode)
 						}
 						delete(c.expiryBuckets, c.reaperHand)
 					}
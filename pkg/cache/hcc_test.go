@@ -73,6 +73,76 @@ func TestHyperClock_EvictionPolicy(t *testing.T) {
 	assert.Equal(t, "four", val, "Item 4 should have the correct value")
 }
 
+func TestHyperClock_Remove(t *testing.T) {
+	ctx := context.Background()
+	evictions := 0
+	clockCache := NewHyperClock[int, string](ctx, 2, time.Second /*tickInterval*/, func(int, string) { evictions++ })
+
+	clockCache.Add(1, "one", time.Minute)
+	clockCache.Add(2, "two", time.Minute)
+	assert.True(t, clockCache.Remove(1), "Should remove an existing key")
+	assert.False(t, clockCache.Remove(1), "Should not remove a missing key")
+	_, found := clockCache.Get(1)
+	assert.False(t, found, "Removed key should not be found")
+	assert.Zero(t, evictions, "Removals should not call the eviction callback")
+
+	// The freed slot is reused without evictions, and the clock hand still works once the cache is full.
+	assert.False(t, clockCache.Add(3, "three", time.Minute), "Should not evict after a removal")
+	assert.True(t, clockCache.Add(4, "four", time.Minute), "Should evict when the cache is full again")
+	assert.Len(t, clockCache.Keys(), 2)
+
+	// Removing every key leaves an empty but usable cache.
+	for _, key := range clockCache.Keys() {
+		assert.True(t, clockCache.Remove(key))
+	}
+	assert.Empty(t, clockCache.Keys())
+	assert.False(t, clockCache.Add(5, "five", time.Minute))
+	val, found := clockCache.Get(5)
+	assert.True(t, found)
+	assert.Equal(t, "five", val)
+}
+
+func TestHyperClock_Charge(t *testing.T) {
+	ctx := context.Background()
+	var evicted []int
+	clockCache := NewHyperClock[int, string](ctx, 10, time.Second /*tickInterval*/, func(k int, _ string) {
+		evicted = append(evicted, k)
+	})
+	assert.Equal(t, 10, clockCache.Capacity())
+
+	assert.False(t, clockCache.AddWithCharge(1, "one", 3, time.Minute))
+	assert.False(t, clockCache.AddWithCharge(2, "two", 3, time.Minute))
+	assert.False(t, clockCache.AddWithCharge(3, "three", 3, time.Minute))
+	assert.Equal(t, 9, clockCache.Usage())
+	clockCache.Get(1) // Gives key 1 a second chance.
+
+	// Making room for a big entry evicts as many entries as needed.
+	assert.True(t, clockCache.AddWithCharge(4, "four", 6, time.Minute))
+	assert.Equal(t, []int{2, 3}, evicted)
+	assert.Equal(t, 9, clockCache.Usage())
+	assert.ElementsMatch(t, []int{1, 4}, clockCache.Keys())
+
+	// Updating an entry changes its charge.
+	assert.False(t, clockCache.AddWithCharge(1, "one", 1, time.Minute))
+	assert.Equal(t, 7, clockCache.Usage())
+	assert.True(t, clockCache.AddWithCharge(1, "one", 5, time.Minute), "Expected a grown entry to make room")
+	assert.Equal(t, []int{2, 3, 4}, evicted)
+	assert.Equal(t, 5, clockCache.Usage())
+
+	// Entries bigger than the whole cache aren't cached.
+	assert.False(t, clockCache.AddWithCharge(5, "five", 11, time.Minute))
+	_, found := clockCache.Get(5)
+	assert.False(t, found)
+	assert.Equal(t, 5, clockCache.Usage())
+
+	assert.True(t, clockCache.Remove(1))
+	assert.Zero(t, clockCache.Usage())
+	clockCache.Add(6, "six", time.Minute)
+	assert.Equal(t, 1, clockCache.Usage())
+	clockCache.Purge()
+	assert.Zero(t, clockCache.Usage())
+}
+
 func TestHyperClock_EvictionCallback(t *testing.T) {
 	var evictedKey int
 	var evictedValue string
@@ -125,6 +195,7 @@ func TestHyperClock_Reaper(t *testing.T) {
 	time.Sleep(70 * time.Millisecond)
 
 	// Verify items are gone by trying to Get them.
+	assert.Zero(t, clockCache.Usage(), "Expected reaped entries not to be charged")
 	_, found := clockCache.Get("key1")
 	assert.False(t, found, "Key1 should have been removed by the reaper")
 	_, found = clockCache.Get("key2")
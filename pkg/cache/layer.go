@@ -12,9 +12,17 @@ type Layer[K comparable, V any] interface {
 	// Get returns value from cache for given key and a boolean indicating whether key was found.
 	Get(key K) (V, bool)
 	// Add inserts a key-value pair into the cache with the given TTL. It returns true if an item was evicted.
+	// The entry is charged 1, so that the capacity of the cache is a number of entries.
 	Add(key K, value V, ttl time.Duration) bool
-	Keys() []K // Returns a slice of all keys currently in the cache.
-	Purge()    // Removes all items from the cache.
+	// AddWithCharge is like Add, but charges the entry against the capacity of the cache by the given amount, e.g.
+	// its size in bytes. Entries which are charged more than the whole capacity are not cached.
+	AddWithCharge(key K, value V, charge int, ttl time.Duration) bool
+	// Remove deletes the key from the cache without calling eviction callbacks. It returns true if key was found.
+	Remove(key K) bool
+	Keys() []K     // Returns a slice of all keys currently in the cache.
+	Purge()        // Removes all items from the cache.
+	Usage() int    // Returns the total charge of the entries currently in the cache.
+	Capacity() int // Returns the maximum total charge of the entries in the cache.
 }
 
 // NoOp is a cache layer that doesn't store any items.
@@ -40,6 +48,16 @@ func (n *NoOp[K, V]) Add(key K, value V, ttl time.Duration) bool {
 	return false
 }
 
+// AddWithCharge does nothing and always returns false, indicating no item was evicted.
+func (n *NoOp[K, V]) AddWithCharge(key K, value V, charge int, ttl time.Duration) bool {
+	return false
+}
+
+// Remove always returns false, as there are no keys stored.
+func (n *NoOp[K, V]) Remove(key K) bool {
+	return false
+}
+
 // Keys always returns nil, as there are no keys stored.
 func (n *NoOp[K, V]) Keys() []K {
 	return nil
@@ -47,3 +65,13 @@ func (n *NoOp[K, V]) Keys() []K {
 
 // Purge does nothing, as there are no items to remove.
 func (n *NoOp[K, V]) Purge() {}
+
+// Usage always returns zero, as there are no items stored.
+func (n *NoOp[K, V]) Usage() int {
+	return 0
+}
+
+// Capacity always returns zero, as no items can be stored.
+func (n *NoOp[K, V]) Capacity() int {
+	return 0
+}
@@ -88,3 +88,15 @@ func (l *LinkedList[V]) PushBack(v V) *LinkedListNode[V] {
 	l.size++
 	return n
 }
+
+// InsertBefore adds a new value right before the given node of the list.
+func (l *LinkedList[V]) InsertBefore(v V, mark *LinkedListNode[V]) *LinkedListNode[V] {
+	if mark.prev == nil {
+		return l.PushFront(v)
+	}
+	n := &LinkedListNode[V]{Value: v, prev: mark.prev, next: mark}
+	mark.prev.next = n
+	mark.prev = n
+	l.size++
+	return n
+}
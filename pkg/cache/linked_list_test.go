@@ -68,6 +68,15 @@ func TestLinkedList_Push(t *testing.T) {
 		list.PushBack(3)
 		assertLinkedListEqualsSlice(t, []int{1, 2, 3}, list)
 	})
+	t.Run("InsertBefore", func(t *testing.T) {
+		list := new(LinkedList[int])
+		head := list.PushBack(2)
+		tail := list.PushBack(4)
+		list.InsertBefore(3, tail)
+		assertLinkedListEqualsSlice(t, []int{2, 3, 4}, list)
+		list.InsertBefore(1, head)
+		assertLinkedListEqualsSlice(t, []int{1, 2, 3, 4}, list)
+	})
 }
 
 func TestLinkedList_Remove(t *testing.T) {
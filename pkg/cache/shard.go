@@ -117,6 +117,17 @@ func (c *Sharded[K, V]) Add(key K, value V, ttl time.Duration) /*evictionOccurre
 	return c.getShard(key).Add(key, value, ttl)
 }
 
+// AddWithCharge finds the appropriate shard for the key and adds the key-value pair with the given charge to it.
+// Each shard has its own capacity, so an entry may cause evictions while other shards have room left.
+func (c *Sharded[K, V]) AddWithCharge(key K, value V, charge int, ttl time.Duration) /*evictionOccurred*/ bool {
+	return c.getShard(key).AddWithCharge(key, value, charge, ttl)
+}
+
+// Remove finds the appropriate shard for the key and removes the key from it.
+func (c *Sharded[K, V]) Remove(key K) /*found*/ bool {
+	return c.getShard(key).Remove(key)
+}
+
 // Keys aggregates the keys from all shards into a single slice. This can be a resource-intensive operation, as it
 // requires iterating over every shard and collecting its keys.
 func (c *Sharded[K, V]) Keys() []K {
@@ -133,3 +144,21 @@ func (c *Sharded[K, V]) Purge() {
 		shard.Purge()
 	}
 }
+
+// Usage sums up the total charge of the entries in all shards.
+func (c *Sharded[K, V]) Usage() int {
+	usage := 0
+	for _, shard := range c.shards {
+		usage += shard.Usage()
+	}
+	return usage
+}
+
+// Capacity sums up the capacity of all shards.
+func (c *Sharded[K, V]) Capacity() int {
+	capacity := 0
+	for _, shard := range c.shards {
+		capacity += shard.Capacity()
+	}
+	return capacity
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"fmt"
 	"maps"
 	"slices"
@@ -32,6 +33,18 @@ func (m *fakeCache[K, V]) Add(key K, value V, _ time.Duration) bool {
 	return false
 }
 
+// AddWithCharge inserts a key-value pair into the mock cache, ignoring its charge.
+func (m *fakeCache[K, V]) AddWithCharge(key K, value V, _ int, ttl time.Duration) bool {
+	return m.Add(key, value, ttl)
+}
+
+// Remove deletes a key from the mock cache.
+func (m *fakeCache[K, V]) Remove(key K) bool {
+	_, found := m.items[key]
+	delete(m.items, key)
+	return found
+}
+
 // Keys returns all keys from the mock cache.
 func (m *fakeCache[K, V]) Keys() []K {
 	return slices.Collect(maps.Keys(m.items))
@@ -42,6 +55,16 @@ func (m *fakeCache[K, V]) Purge() {
 	m.items = make(map[K]V)
 }
 
+// Usage returns the number of items in the mock cache, as each item is charged 1.
+func (m *fakeCache[K, V]) Usage() int {
+	return len(m.items)
+}
+
+// Capacity returns the fixed capacity of the mock cache, which is never enforced.
+func (m *fakeCache[K, V]) Capacity() int {
+	return 100
+}
+
 // TestShardedCache_AddAndGet verifies the basic Add and Get functionality.
 func TestShardedCache_AddAndGet(t *testing.T) {
 	sc := NewSharded(newFakeCache[string, int], 10)
@@ -146,6 +169,33 @@ func TestShardedCache_Purge(t *testing.T) {
 	assert.False(t, found, "Expected key to be gone after purge")
 }
 
+func TestShardedCache_Remove(t *testing.T) {
+	sc := NewSharded(newFakeCache[int, string], 5)
+	sc.Add(1, "one", time.Second)
+	sc.Add(2, "two", time.Second)
+
+	assert.True(t, sc.Remove(1), "Expected existing key to be removed")
+	assert.False(t, sc.Remove(1), "Expected removed key to be missing")
+	_, found := sc.Get(1)
+	assert.False(t, found, "Expected key to be gone after remove")
+	_, found = sc.Get(2)
+	assert.True(t, found, "Expected other keys to remain")
+}
+
+// TestShardedCache_Charge verifies that charges are tracked per shard, and summed up over all shards.
+func TestShardedCache_Charge(t *testing.T) {
+	sc := NewSharded(func() Layer[int, int] {
+		return NewHyperClock[int, int](context.Background(), 100, time.Second /*tickInterval*/, nil /*evictionCallback*/)
+	}, 4)
+	assert.Equal(t, 400, sc.Capacity())
+	for i := range 10 {
+		sc.AddWithCharge(i, i, 10, time.Minute)
+	}
+	assert.Equal(t, 100, sc.Usage())
+	sc.Remove(0)
+	assert.Equal(t, 90, sc.Usage())
+}
+
 // TestShardedCache_ShardingDistribution verifies that keys are distributed across multiple shards.
 func TestShardedCache_ShardingDistribution(t *testing.T) {
 	shardCount := 10
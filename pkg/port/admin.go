@@ -0,0 +1,103 @@
+// Kiwi serves an admin HTTP endpoint next to the Redis protocol, for operators and monitoring systems:
+//   - /metrics exposes the Prometheus metrics registered with promauto.
+//   - /healthz reports whether the process is alive, and /readyz whether the storage is opened and can serve traffic.
+//   - /debug/pprof serves the Go runtime profiles, and /debug/vars the build info and uptime alongside expvars.
+
+package port
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var adminAddress = flag.String("admin_address", "0.0.0.0:6381",
+	"The ip:port to serve metrics, health checks and debug pages on; empty disables the admin server.")
+
+func init() {
+	expvar.Publish("build", expvar.Func(func() any {
+		return map[string]string{"version": utils.Version, "commit": utils.Commit, "build_time": utils.BuildTime}
+	}))
+	expvar.Publish("start_time", expvar.Func(func() any { return utils.StartTime.Format(time.RFC3339) }))
+	expvar.Publish("uptime_seconds", expvar.Func(func() any { return time.Since(utils.StartTime).Seconds() }))
+}
+
+// AdminServer is the admin HTTP server; it's not ready until SetReady is called.
+type AdminServer struct {
+	handler http.Handler
+	ready   atomic.Bool
+}
+
+// NewAdminServer creates an admin server with all its pages registered.
+func NewAdminServer() *AdminServer {
+	admin := &AdminServer{}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !admin.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/debug/pprof/", pprof.Index) // Serves the named profiles as well, e.g. /debug/pprof/heap.
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	admin.handler = mux
+	return admin
+}
+
+// SetReady marks whether the server is ready to serve traffic, e.g. after the storage is opened.
+func (a *AdminServer) SetReady(ready bool) {
+	a.ready.Store(ready)
+}
+
+// Run serves the admin pages on --admin_address until the given context is cancelled.
+func (a *AdminServer) Run(ctx context.Context) error {
+	if *adminAddress == "" {
+		slog.Info("Admin server is disabled.")
+		return nil
+	}
+
+	server := &http.Server{Addr: *adminAddress, Handler: a.handler, ReadHeaderTimeout: 10 * time.Second}
+	serverErrSignal := make(chan error, 1)
+	go func() {
+		slog.Info("Starting admin server.", "address", *adminAddress)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErrSignal <- err
+		}
+		close(serverErrSignal)
+	}()
+
+	select {
+	case <-ctx.Done():
+		a.SetReady(false)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			return fmt.Errorf("failed to shut down admin server: %w", err)
+		}
+		return nil
+	case err, failed := <-serverErrSignal:
+		if !failed { // Closed without an error.
+			return nil
+		}
+		return fmt.Errorf("admin server stopped unexpectedly: %w", err)
+	}
+}
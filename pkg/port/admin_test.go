@@ -0,0 +1,89 @@
+package port
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminServer_Pages(t *testing.T) {
+	admin := NewAdminServer()
+	get := func(path string) (int, string) {
+		recorder := httptest.NewRecorder()
+		admin.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil /*body*/))
+		return recorder.Code, recorder.Body.String()
+	}
+
+	code, _ := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code, "Expected the server not to be ready before storage is opened")
+	admin.SetReady(true)
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body := get("/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "expired_keys_total")
+
+	code, body = get("/debug/vars")
+	require.Equal(t, http.StatusOK, code)
+	var vars struct {
+		Build         map[string]string `json:"build"`
+		UptimeSeconds float64           `json:"uptime_seconds"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &vars))
+	assert.Equal(t, utils.Version, vars.Build["version"])
+	assert.Equal(t, utils.Commit, vars.Build["commit"])
+	assert.Positive(t, vars.UptimeSeconds)
+
+	code, _ = get("/debug/pprof/")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestAdminServer_Run(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0") // Finds a free port.
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	config.SetTestFlag(t, "admin_address", address)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	admin := NewAdminServer()
+	admin.SetReady(true)
+	stopped := make(chan error, 1)
+	go func() { stopped <- admin.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + address + "/readyz")
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode == http.StatusOK && string(body) == "ok\n"
+	}, 5*time.Second /*waitFor*/, 10*time.Millisecond /*tick*/)
+
+	cancel()
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the admin server to stop after its context is cancelled")
+	}
+	_, err = http.Get("http://" + address + "/healthz")
+	assert.Error(t, err)
+
+	config.SetTestFlag(t, "admin_address", "")
+	assert.NoError(t, NewAdminServer().Run(context.Background()), "Expected a disabled admin server to return")
+}
@@ -1,24 +1,181 @@
 package port
 
 import (
+	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"iter"
+	"log/slog"
+	"os"
+	"path/filepath"
 	"runtime"
 	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nobletooth/kiwi/pkg/scan"
 	"github.com/nobletooth/kiwi/pkg/storage"
 	"github.com/nobletooth/kiwi/pkg/utils"
 )
 
-var dataDir = flag.String("data_dir", "./data", "Directory to store the DB data files.")
+var (
+	dataDir   = flag.String("data_dir", "./data", "Directory to store the DB data files.")
+	databases = flag.Int("databases", 16, "The number of Redis databases, i.e. the valid range of SELECT's index.")
+)
+
+var errDbIndexOutOfRange = errors.New("DB index is out of range")
 
 // KiwiStorage is the Kiwi storage backend used by Kiwi ports, e.g. Redis.
+// Writes are serialized by the write lock, while reads of opened databases don't take the lock at all, as LSM trees
+// are safe for concurrent reads; so reads aren't blocked by writes, e.g. while a memtable is flushed.
+// Commands that run several writes at once, e.g. Redis transactions, use a locked view of the storage, see Atomically.
 type KiwiStorage struct {
-	mux sync.RWMutex
-	db  storage.KeyValueHolder
+	*storageState
+	locked bool // Set on the locked views, whose methods run under the write lock that's held by their caller.
+}
+
+// storageState is the state of a KiwiStorage, which is shared with its locked views.
+type storageState struct {
+	mux     sync.RWMutex // Protects the databases; the mapping between DBs and tables is changed under write lock.
+	openMux sync.Mutex   // Serializes lazily opening databases, which happens under read lock.
+	dir     string
+	tables  []int64                  // The table of each Redis DB, including DBs beyond `databases`.
+	dbs     []atomic.Pointer[kiwiDB] // Indexed by Redis DB; nil until the DB is used.
+	closed  atomic.Bool              // Set under write lock.
+
+	expiryStop     chan struct{} // Closed to stop the active expiry loop.
+	expiryDone     chan struct{} // Closed when the active expiry loop returns.
+	expiryStopOnce sync.Once
+
+	reclaimStop     chan struct{} // Closed to stop the member reclaim loop.
+	reclaimDone     chan struct{} // Closed when the member reclaim loop returns.
+	reclaimStopOnce sync.Once
+}
+
+// kiwiDB is a Redis DB, backed by an LSM tree.
+type kiwiDB struct {
+	lsm      *storage.LSMTree
+	expiries *expiryIndex // Protected by KiwiStorage.mux write lock.
+
+	// The cursors of member reclaim, see reclaim.go; they're protected by KiwiStorage.mux write lock.
+	orphanCursor []byte // The orphan key whose members are being reclaimed, or to scan for the next one from.
+	memberCursor []byte // The member of the orphan at orphanCursor to resume from.
+}
+
+// get returns the packed value of the given `key`, or ErrKeyNotFound.
+func (kdb *kiwiDB) get(key []byte) ([]byte, error) {
+	return kdb.lsm.Get(storageKey(key))
+}
+
+// getLive returns the unpacked value of the given `key`, or ErrKeyNotFound if it's deleted or expired at `now`.
+func (kdb *kiwiDB) getLive(key []byte, now time.Time) (unpackedValue, error) {
+	packed, err := kdb.get(key)
+	if err != nil {
+		return emptyUnpacked, err
+	}
+	unpacked, err := unpack(packed)
+	if err != nil {
+		return emptyUnpacked, err
+	}
+	if unpacked.opt.is(TombStone) || unpacked.isExpiredAt(now) {
+		return emptyUnpacked, storage.ErrKeyNotFound
+	}
+	return unpacked, nil
+}
+
+// getString returns the unpacked value of the given string `key`, see getLive; or errWrongType for other types.
+func (kdb *kiwiDB) getString(key []byte, now time.Time) (unpackedValue, error) {
+	unpacked, err := kdb.getLive(key, now)
+	if err == nil && unpacked.valueType != StringType {
+		return emptyUnpacked, errWrongType
+	}
+	return unpacked, err
+}
+
+// set writes the given value of `key`, keeping track of its expiry. The members of the collection it replaces are
+// reclaimed in the background, see replacedCollection.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) set(key []byte, value unpackedValue) error {
+	replaced, isReplaced, err := kdb.replacedCollection(key, value)
+	if err != nil {
+		return err
+	}
+	if err := kdb.lsm.Set(storageKey(key), value.pack()); err != nil {
+		return err
+	}
+	kdb.expiries.track(key, value)
+	if isReplaced {
+		return kdb.orphan(replaced)
+	}
+	return nil
+}
+
+// delete writes a tombstone for the given `key`, unless it's absent; it returns true if the key had a live value.
+// The members of collections are reclaimed in the background, even if the collection has already expired.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) delete(key []byte) (bool /*existed*/, error) {
+	replaced, isReplaced, err := kdb.replacedCollection(key, tombstoneUnpacked)
+	if err != nil {
+		return false, err
+	}
+	existed, err := kdb.lsm.Delete(storageKey(key))
+	if err != nil {
+		return false, err
+	}
+	kdb.expiries.track(key, tombstoneUnpacked)
+	if isReplaced {
+		return existed, kdb.orphan(replaced)
+	}
+	return existed, nil
+}
+
+// replacedCollection returns the collection at `key` which is about to be replaced by `value`, if any; even if it
+// has already expired, since the expiry index no longer tracks it once it's replaced. A collection isn't replaced
+// by itself, i.e. a value of the same version.
+func (kdb *kiwiDB) replacedCollection(key []byte, value unpackedValue) (collection, bool /*isReplaced*/, error) {
+	packed, err := kdb.get(key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return collection{}, false, nil
+	} else if err != nil {
+		return collection{}, false, err
+	}
+	previous, err := unpack(packed)
+	if err != nil || previous.is(TombStone) || previous.valueType == StringType {
+		return collection{}, false, nil // Values that can't be unpacked are overwritten as is.
+	}
+	replaced, err := decodeCollection(key, previous)
+	if err != nil {
+		return collection{}, false, err
+	}
+	if !value.is(TombStone) && value.valueType == previous.valueType {
+		if c, err := decodeCollection(key, value); err == nil && bytes.Equal(c.prefix, replaced.prefix) {
+			return collection{}, false, nil
+		}
+	}
+	return replaced, true, nil
+}
+
+// lastWrite returns the sequence number of the latest write to the given `key`, or zero if it has none.
+func (kdb *kiwiDB) lastWrite(key []byte) (int64, error) {
+	sequence, err := kdb.lsm.LastWrite(storageKey(key))
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	}
+	return sequence, err
+}
+
+// truncate removes every key, see storage.LSMTree.Truncate.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) truncate(async bool) error {
+	if err := kdb.lsm.Truncate(async); err != nil {
+		return err
+	}
+	kdb.expiries = newExpiryIndex()
+	kdb.orphanCursor, kdb.memberCursor = nil, nil
+	return nil
 }
 
 // NewKiwiStorage creates a new KiwiStorage with the given number of databases.
@@ -26,34 +183,114 @@ func NewKiwiStorage() (*KiwiStorage, error) {
 	if *dataDir == "" {
 		return nil, errors.New("--data_dir flag is required")
 	}
-	// TODO: Allow support for multi tables (multi Redis DBs).
-	db, err := storage.NewLSMTree(*dataDir, 1 /*table*/)
+	if *databases <= 0 {
+		return nil, fmt.Errorf("expected a positive --databases flag, got %d", *databases)
+	}
+	if err := os.MkdirAll(*dataDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	tables, escapedKeys, err := loadDatabaseMapping(*dataDir, *databases)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create db: %w", err)
+		return nil, fmt.Errorf("failed to load databases: %w", err)
+	}
+	if !escapedKeys {
+		if err := escapeRawKeys(*dataDir, tables); err != nil {
+			return nil, fmt.Errorf("failed to migrate databases: %w", err)
+		}
+		if err := saveDatabaseMapping(*dataDir, tables); err != nil {
+			return nil, fmt.Errorf("failed to migrate databases: %w", err)
+		}
 	}
 
-	store := &KiwiStorage{db: db, mux: sync.RWMutex{}}
+	store := &KiwiStorage{storageState: &storageState{dir: *dataDir, tables: tables,
+		dbs: make([]atomic.Pointer[kiwiDB], *databases)}}
+	// DB 0 is opened right away, so that broken data directories fail fast.
+	if _, err := store.database(0); err != nil {
+		return nil, fmt.Errorf("failed to create db: %w", err)
+	}
+	if *activeExpiryEnabled {
+		store.startActiveExpiry()
+	}
+	if *memberReclaimEnabled {
+		store.startMemberReclaim()
+	}
 	runtime.SetFinalizer(store, func(store *KiwiStorage) { _ = store.Close() })
 	return store, nil
 }
 
-// Get looks up the given `key` and returns its value or an error if not found.
-func (ks *KiwiStorage) Get(key []byte) ([]byte, error) {
+// lock acquires the write lock, unless it's already held by the caller of a locked view; the returned function
+// releases it.
+func (ks *KiwiStorage) lock() /*unlock*/ func() {
+	if ks.locked {
+		return func() {}
+	}
+	ks.mux.Lock()
+	return ks.mux.Unlock
+}
+
+// Atomically runs `fn` under the write lock, so that the writes of `fn` aren't interleaved with any other write.
+// `fn` gets a locked view of the storage, whose methods don't take the lock; it's only valid until `fn` returns.
+func (ks *KiwiStorage) Atomically(fn func(locked *KiwiStorage)) {
+	defer ks.lock()()
+	fn(&KiwiStorage{storageState: ks.storageState, locked: true})
+}
+
+// database returns the given Redis `db`, opening it on first use.
+// NOTE: Caller should acquire lock, either read or write.
+func (ks *KiwiStorage) database(db int) (*kiwiDB, error) {
+	if db < 0 || db >= len(ks.dbs) {
+		return nil, errDbIndexOutOfRange
+	}
+	if ks.closed.Load() {
+		return nil, errors.New("storage is closed")
+	}
+	if kdb := ks.dbs[db].Load(); kdb != nil {
+		return kdb, nil
+	}
+
+	ks.openMux.Lock()
+	defer ks.openMux.Unlock()
+	if kdb := ks.dbs[db].Load(); kdb != nil { // Opened by a concurrent command meanwhile.
+		return kdb, nil
+	}
+	lsm, err := storage.NewLSMTree(ks.dir, ks.tables[db], packedValueInspector{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open db %d: %w", db, err)
+	}
+	expiries, err := buildExpiryIndex(lsm)
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to index expiries of db %d: %w", db, err), lsm.Close())
+	}
+	kdb := &kiwiDB{lsm: lsm, expiries: expiries}
+	ks.dbs[db].Store(kdb)
+	return kdb, nil
+}
+
+// readDatabase returns the given Redis `db` for reading; only opening the database takes the lock.
+func (ks *KiwiStorage) readDatabase(db int) (*kiwiDB, error) {
+	if db >= 0 && db < len(ks.dbs) && !ks.closed.Load() {
+		if kdb := ks.dbs[db].Load(); kdb != nil {
+			return kdb, nil
+		}
+	}
+	if ks.locked {
+		return ks.database(db)
+	}
 	ks.mux.RLock()
 	defer ks.mux.RUnlock()
+	return ks.database(db)
+}
 
-	packed, err := ks.db.Get(key)
+// Get looks up the given `key` and returns its value or an error if not found.
+func (ks *KiwiStorage) Get(db int, key []byte) ([]byte, error) {
+	kdb, err := ks.readDatabase(db)
 	if err != nil {
 		return nil, err
 	}
-	unpacked, err := unpack(packed)
+	unpacked, err := kdb.getString(key, time.Now())
 	if err != nil {
 		return nil, err
 	}
-	if unpacked.opt.is(TombStone) || unpacked.isExpired() {
-		return nil, storage.ErrKeyNotFound
-	}
-
 	return unpacked.value, nil
 }
 
@@ -84,21 +321,24 @@ type SetResult struct {
 }
 
 // Set executes the given `cmd` and returns the previous value if required.
-func (ks *KiwiStorage) Set(cmd SetCommand) SetResult {
+func (ks *KiwiStorage) Set(db int, cmd SetCommand) SetResult {
 	if !slices.Contains(allExistenceChecks, cmd.existence) {
 		utils.RaiseInvariant("backend", "unknown_set_existence_constraint",
 			"Got an unknown existence constraint in the given set command.", "constraint", cmd.existence)
 		return SetResult{err: fmt.Errorf("got unknwon set constraint '%d'", cmd.existence)}
 	}
 
-	ks.mux.Lock()
-	defer ks.mux.Unlock()
+	defer ks.lock()()
 
+	kdb, err := ks.database(db)
+	if err != nil {
+		return SetResult{err: err}
+	}
 	// Check if previous key-value pair needs to be retrieved.
 	var prevValue []byte = nil
 	hasPrevValue := false
 	if cmd.existence != noCheck || cmd.keepTtl || cmd.get {
-		value, err := ks.db.Get(cmd.key)
+		value, err := kdb.get(cmd.key)
 		if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
 			return SetResult{err: fmt.Errorf("failed to get previous key: %w", err)}
 		} else if !errors.Is(err, storage.ErrKeyNotFound) {
@@ -117,6 +357,8 @@ func (ks *KiwiStorage) Set(cmd SetCommand) SetResult {
 		// Tombstones and expired keys should be treated as non-existent for NX/XX checks.
 		if unpackedPrev.is(TombStone) || unpackedPrev.isExpired() {
 			hasPrevValue = false
+		} else if cmd.get && unpackedPrev.valueType != StringType { // Only strings can be returned.
+			return SetResult{err: errWrongType}
 		}
 	}
 
@@ -136,7 +378,7 @@ func (ks *KiwiStorage) Set(cmd SetCommand) SetResult {
 		(cmd.existence == ifNotExists && !hasPrevValue) || // NX; Set only if not exists.
 		(cmd.existence == ifExists && hasPrevValue) // XX; Set only if exists.
 	if couldSet {
-		if err := ks.db.Set(cmd.key, valueToSet.pack()); err != nil {
+		if err := kdb.set(cmd.key, valueToSet); err != nil {
 			return SetResult{err: fmt.Errorf("failed to set value: %w", err)}
 		}
 	}
@@ -154,15 +396,359 @@ func (ks *KiwiStorage) Set(cmd SetCommand) SetResult {
 	return SetResult{couldSet: couldSet, err: nil}
 }
 
-func (ks *KiwiStorage) Delete(key []byte) error {
-	ks.mux.Lock()
-	defer ks.mux.Unlock()
-	_, err := ks.db.Swap(key, tombstonePacked)
-	return err
+// expiryCheck is a set of conditions that must hold before changing a key's expiry.
+type expiryCheck uint8
+
+// is returns true if any of the given checks are toggled in the current checks.
+func (c expiryCheck) is(checks expiryCheck) bool {
+	return c&checks != 0
+}
+
+const (
+	ifNoExpiry      expiryCheck = 1 << iota // NX
+	ifHasExpiry                             // XX
+	ifLaterExpiry                           // GT; keys without an expiry are considered to never expire.
+	ifEarlierExpiry                         // LT
+)
+
+type ExpireCommand struct {
+	key        []byte
+	expiryTime time.Time // The key is deleted if it's not after now.
+	checks     expiryCheck
 }
 
+// Expire sets the expiry of an existing key as specified by `cmd`, e.g. the Redis EXPIRE command; it returns false
+// if the key doesn't exist or the expiry check failed.
+func (ks *KiwiStorage) Expire(db int, cmd ExpireCommand) (bool /*updated*/, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	unpacked, err := kdb.getLive(cmd.key, now)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	hasExpiry := unpacked.is(Expirable)
+	if (cmd.checks.is(ifNoExpiry) && hasExpiry) ||
+		(cmd.checks.is(ifHasExpiry) && !hasExpiry) ||
+		(cmd.checks.is(ifLaterExpiry) && (!hasExpiry || !cmd.expiryTime.After(unpacked.expiry))) ||
+		(cmd.checks.is(ifEarlierExpiry) && hasExpiry && !cmd.expiryTime.Before(unpacked.expiry)) {
+		return false, nil
+	}
+
+	if !cmd.expiryTime.After(now) { // Same as Redis, expiring a key in the past deletes it.
+		if _, err := kdb.delete(cmd.key); err != nil {
+			return false, fmt.Errorf("failed to delete expired key: %w", err)
+		}
+		return true, nil
+	}
+	unpacked.opt |= Expirable
+	unpacked.expiry = cmd.expiryTime
+	if err := kdb.set(cmd.key, unpacked); err != nil {
+		return false, fmt.Errorf("failed to set expiry: %w", err)
+	}
+	return true, nil
+}
+
+// Persist removes the expiry of the given `key`; it returns false if the key doesn't exist or has no expiry.
+func (ks *KiwiStorage) Persist(db int, key []byte) (bool /*updated*/, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return false, err
+	}
+	unpacked, err := kdb.getLive(key, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !unpacked.is(Expirable) {
+		return false, nil
+	}
+	unpacked.opt &^= Expirable
+	unpacked.expiry = time.Time{}
+	if err := kdb.set(key, unpacked); err != nil {
+		return false, fmt.Errorf("failed to remove expiry: %w", err)
+	}
+	return true, nil
+}
+
+// Expiry returns the expiry time of the given `key`, which is zero if the key never expires; or ErrKeyNotFound.
+func (ks *KiwiStorage) Expiry(db int, key []byte) (time.Time, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return time.Time{}, err
+	}
+	unpacked, err := kdb.getLive(key, time.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return unpacked.expiry, nil
+}
+
+type GetExCommand struct {
+	key        []byte
+	expiryTime time.Time // If set, the key's expiry is replaced; the key is deleted if it's not after now.
+	persist    bool      // The Redis PERSIST option; removes the key's expiry.
+}
+
+// GetEx returns the value of the given key and updates its expiry as specified by `cmd`, e.g. the Redis GETEX
+// command; the value is returned even if the key got deleted by an expiry in the past.
+func (ks *KiwiStorage) GetEx(db int, cmd GetExCommand) ([]byte, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	unpacked, err := kdb.getString(cmd.key, now)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := unpacked
+	switch {
+	case !cmd.expiryTime.IsZero() && !cmd.expiryTime.After(now):
+		updated = tombstoneUnpacked
+	case !cmd.expiryTime.IsZero():
+		updated.opt |= Expirable
+		updated.expiry = cmd.expiryTime
+	case cmd.persist && unpacked.is(Expirable):
+		updated.opt &^= Expirable
+		updated.expiry = time.Time{}
+	default: // Nothing to update.
+		return unpacked.value, nil
+	}
+	if err := kdb.set(cmd.key, updated); err != nil {
+		return nil, fmt.Errorf("failed to update expiry: %w", err)
+	}
+	return unpacked.value, nil
+}
+
+// Exists returns true if the given `key` has a live value, i.e. it's neither deleted nor expired.
+func (ks *KiwiStorage) Exists(db int, key []byte) (bool, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return false, err
+	}
+	return kdb.lsm.Exists(storageKey(key))
+}
+
+// liveKeys returns an iterator over the live pairs with the given `prefix`, starting from `start` in ascending order.
+// If `count` is positive, at most `count` pairs (live or not) are examined, then the key to resume from is stored in
+// `next`; so a scan's work is bounded even when it runs into many deleted keys.
+func (ks *KiwiStorage) liveKeys(db int, start, prefix []byte, count int, next *[]byte) (
+	iter.Seq2[utils.BytePair, error], error) {
+	if bytes.Compare(start, prefix) < 0 {
+		start = prefix
+	}
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	pairs := scanRedisKeys(kdb.lsm, start)
+	return func(yield func(utils.BytePair, error) bool) {
+		now, examined := time.Now(), 0
+		for pair, err := range pairs {
+			if err != nil {
+				yield(utils.BytePair{}, err)
+				return
+			}
+			if !bytes.HasPrefix(pair.Key, prefix) {
+				return
+			}
+			if count > 0 && examined == count {
+				*next = pair.Key
+				return
+			}
+			examined++
+			unpacked, err := unpack(pair.Value)
+			if err != nil {
+				slog.Warn("Skipping a scanned value that can't be unpacked.", "key", pair.Key, "error", err)
+				continue
+			}
+			if unpacked.is(TombStone) || unpacked.isExpiredAt(now) {
+				continue
+			}
+			if !yield(pair, nil) {
+				return
+			}
+		}
+	}, nil
+}
+
+type ScanCommand struct {
+	start   []byte // The key to resume the scan from; nil starts from the first key.
+	pattern []byte // The Redis MATCH option as a glob pattern; nil matches every key.
+	count   int    // The Redis COUNT option; the number of keys to examine, or no limit if not positive.
+}
+
+type ScanResult struct {
+	keys [][]byte
+	next []byte // The key to resume the scan from; nil when the scan is done.
+	err  error
+}
+
+// Scan returns the live keys matching the given `cmd`, in ascending order. Only the literal prefix of the pattern is
+// scanned, and at most `count` keys are examined, so the returned batch may be empty while the scan isn't done.
+func (ks *KiwiStorage) Scan(db int, cmd ScanCommand) ScanResult {
+	var prefix []byte
+	if cmd.pattern != nil {
+		globPrefix, err := scan.GlobPrefix(cmd.pattern)
+		if err != nil {
+			return ScanResult{err: fmt.Errorf("invalid pattern %q: %w", cmd.pattern, err)}
+		}
+		prefix = globPrefix
+	}
+
+	result := ScanResult{keys: [][]byte{}}
+	livePairs, err := ks.liveKeys(db, cmd.start, prefix, cmd.count, &result.next)
+	if err != nil {
+		return ScanResult{err: err}
+	}
+	var scanErr error
+	pairs := untilError(livePairs, &scanErr)
+	if cmd.pattern != nil {
+		pairs = scan.MatchGlob(cmd.pattern, pairs)
+	}
+	for pair := range pairs {
+		result.keys = append(result.keys, pair.Key)
+	}
+	if scanErr != nil { // A partial batch would skip the rest of the keys, as the cursor moves past them.
+		return ScanResult{err: scanErr}
+	}
+	return result
+}
+
+// DBSize returns the number of live keys; it scans the whole database.
+func (ks *KiwiStorage) DBSize(db int) (int, error) {
+	pairs, err := ks.liveKeys(db, nil /*start*/, nil /*prefix*/, 0 /*count*/, nil /*next*/)
+	if err != nil {
+		return 0, err
+	}
+	size := 0
+	for _, err := range pairs {
+		if err != nil {
+			return 0, err
+		}
+		size++
+	}
+	return size, nil
+}
+
+// Delete removes the given keys and returns the number of them that had a live value, e.g. the Redis DEL command;
+// repeated keys are only counted once.
+func (ks *KiwiStorage) Delete(db int, keys ...[]byte) (int, error) {
+	defer ks.lock()()
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, key := range keys {
+		existed, err := kdb.delete(key)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete key %q: %w", key, err)
+		}
+		if existed {
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// FlushDB removes every key of the given Redis `db`; with `async`, the removed files are deleted in the background.
+func (ks *KiwiStorage) FlushDB(db int, async bool) error {
+	defer ks.lock()()
+	kdb, err := ks.database(db)
+	if err != nil {
+		return err
+	}
+	return kdb.truncate(async)
+}
+
+// FlushAll removes every key of every Redis DB, see FlushDB.
+func (ks *KiwiStorage) FlushAll(async bool) error {
+	defer ks.lock()()
+	for db := range ks.dbs {
+		// DBs that were never used have no table directory, hence nothing to flush.
+		if ks.dbs[db].Load() == nil {
+			if _, err := os.Stat(filepath.Join(ks.dir, strconv.FormatInt(ks.tables[db], 10))); errors.Is(err,
+				os.ErrNotExist) {
+				continue
+			}
+		}
+		kdb, err := ks.database(db)
+		if err != nil {
+			return err
+		}
+		if err := kdb.truncate(async); err != nil {
+			return fmt.Errorf("failed to flush db %d: %w", db, err)
+		}
+	}
+	return nil
+}
+
+// SwapDB swaps the data of the two given Redis DBs, so that connections using either DB see the other's data.
+func (ks *KiwiStorage) SwapDB(first, second int) error {
+	defer ks.lock()()
+	if first < 0 || first >= len(ks.dbs) || second < 0 || second >= len(ks.dbs) {
+		return errDbIndexOutOfRange
+	}
+	if ks.closed.Load() {
+		return errors.New("storage is closed")
+	}
+
+	tables := slices.Clone(ks.tables)
+	tables[first], tables[second] = tables[second], tables[first]
+	if err := saveDatabaseMapping(ks.dir, tables); err != nil {
+		return fmt.Errorf("failed to save database mapping: %w", err)
+	}
+	ks.tables = tables
+	firstDb, secondDb := ks.dbs[first].Load(), ks.dbs[second].Load()
+	ks.dbs[first].Store(secondDb)
+	ks.dbs[second].Store(firstDb)
+	return nil
+}
+
+// Close flushes the memtables to disk, and closes every opened database.
 func (ks *KiwiStorage) Close() error {
+	return ks.close(true /*flush*/)
+}
+
+// CloseWithoutFlush closes the storage without flushing the memtables; writes are kept in the write-ahead logs.
+func (ks *KiwiStorage) CloseWithoutFlush() error {
+	return ks.close(false /*flush*/)
+}
+
+// close closes every opened database, flushing their memtables to disk if `flush` is set.
+func (ks *KiwiStorage) close(flush bool) error {
+	ks.stopActiveExpiry() // Expiry and reclaim cycles take the lock, so the loops are stopped beforehand.
+	ks.stopMemberReclaim()
 	ks.mux.Lock()
 	defer ks.mux.Unlock()
-	return ks.db.Close()
+	if ks.closed.Load() {
+		return errors.New("storage is already closed")
+	}
+	ks.closed.Store(true)
+	var errs error
+	for db := range ks.dbs {
+		if kdb := ks.dbs[db].Load(); kdb == nil {
+			continue
+		} else if flush {
+			errs = errors.Join(errs, kdb.lsm.Close())
+		} else {
+			errs = errors.Join(errs, kdb.lsm.CloseWithoutFlush())
+		}
+	}
+	return errs
 }
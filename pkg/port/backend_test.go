@@ -2,67 +2,81 @@ package port
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/nobletooth/kiwi/pkg/config"
 	"github.com/nobletooth/kiwi/pkg/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestKiwiStorage(t *testing.T) {
 	config.SetTestFlag(t, "data_dir", t.TempDir())
 	store, err := NewKiwiStorage()
-	assert.NoError(t, err)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, store.Close()) })
 
 	t.Run("set", func(t *testing.T) {
-		assert.NoError(t, store.Set(SetCommand{key: []byte("k1"), value: []byte("v1")}).err)
-		assert.NoError(t, store.Set(SetCommand{key: []byte("k2"), value: []byte("v2")}).err)
-		assert.NoError(t, store.Set(SetCommand{key: []byte("k3"), value: []byte("v3")}).err)
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("k1"), value: []byte("v1")}).err)
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("k2"), value: []byte("v2")}).err)
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("k3"), value: []byte("v3")}).err)
 	})
 	t.Run("get_existing_key", func(t *testing.T) {
-		val, err := store.Get([]byte("k1"))
+		val, err := store.Get(0, []byte("k1"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("v1"), val)
 	})
 	t.Run("get_non_existent_key", func(t *testing.T) {
-		_, err := store.Get([]byte("non_existent"))
+		_, err := store.Get(0, []byte("non_existent"))
 		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
 	})
 	t.Run("delete_existing_key", func(t *testing.T) {
-		assert.NoError(t, store.Delete([]byte("k2")))
-		val, err := store.Get([]byte("k2"))
+		deleted, err := store.Delete(0, []byte("k2"), []byte("k2"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted, "Expected repeated keys to be counted once")
+		val, err := store.Get(0, []byte("k2"))
 		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
 		assert.Nil(t, val)
 	})
 	t.Run("delete_non_existent_key", func(t *testing.T) {
-		assert.ErrorIs(t, store.Delete([]byte("random")), storage.ErrKeyNotFound)
+		deleted, err := store.Delete(0, []byte("random"))
+		assert.NoError(t, err)
+		assert.Zero(t, deleted)
+	})
+	t.Run("delete_expired_key", func(t *testing.T) {
+		require.NoError(t, store.Set(0, SetCommand{key: []byte("expired"), value: []byte("v"),
+			expiryTime: time.Now().Add(-time.Second)}).err)
+		deleted, err := store.Delete(0, []byte("expired"), []byte("k1"))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted, "Expected expired keys not to be counted")
 	})
 	t.Run("set_expirable", func(t *testing.T) {
-		assert.NoError(t, store.Set(SetCommand{
+		assert.NoError(t, store.Set(0, SetCommand{
 			key:        []byte("kx1"),
 			value:      []byte("vx1"),
 			expiryTime: time.Now().Add(10 * time.Millisecond),
 		}).err)
-		assert.NoError(t, store.Set(SetCommand{
+		assert.NoError(t, store.Set(0, SetCommand{
 			key:        []byte("kx2"),
 			value:      []byte("vx2"),
 			expiryTime: time.Now().Add(1 * time.Hour),
 		}).err)
 		// Make sure "kx1" eventually expires.
 		assert.Eventually(t, func() bool {
-			_, err := store.Get([]byte("kx"))
+			_, err := store.Get(0, []byte("kx"))
 			return errors.Is(err, storage.ErrKeyNotFound)
 		}, time.Second, 10*time.Millisecond)
 		// Even when "kx1" expired, the "kx2" still remains since it has a really long TTL.
-		val, err := store.Get([]byte("kx2"))
+		val, err := store.Get(0, []byte("kx2"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("vx2"), val)
 	})
 
 	// Tests for SET NX (set if not exists) semantics.
 	t.Run("set_nx_on_non_existent_key", func(t *testing.T) {
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:       []byte("nx_key"),
 			value:     []byte("nx_value"),
 			existence: ifNotExists,
@@ -70,16 +84,16 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.True(t, result.couldSet, "Should set key when it doesn't exist with NX")
 
-		val, err := store.Get([]byte("nx_key"))
+		val, err := store.Get(0, []byte("nx_key"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("nx_value"), val)
 	})
 
 	t.Run("set_nx_on_existing_key", func(t *testing.T) {
 		// First, set a key.
-		assert.NoError(t, store.Set(SetCommand{key: []byte("existing_nx"), value: []byte("original")}).err)
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("existing_nx"), value: []byte("original")}).err)
 		// Try to set with NX - should fail.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:       []byte("existing_nx"),
 			value:     []byte("new_value"),
 			existence: ifNotExists,
@@ -87,7 +101,7 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.False(t, result.couldSet, "Should NOT set key when it exists with NX")
 		// Verify original value is unchanged.
-		val, err := store.Get([]byte("existing_nx"))
+		val, err := store.Get(0, []byte("existing_nx"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("original"), val)
 	})
@@ -95,9 +109,9 @@ func TestKiwiStorage(t *testing.T) {
 	// Tests for SET XX (set if exists) semantics.
 	t.Run("set_xx_on_existing_key", func(t *testing.T) {
 		// First, set a key.
-		assert.NoError(t, store.Set(SetCommand{key: []byte("existing_xx"), value: []byte("original")}).err)
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("existing_xx"), value: []byte("original")}).err)
 		// Update with XX - should succeed.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:       []byte("existing_xx"),
 			value:     []byte("updated"),
 			existence: ifExists,
@@ -105,13 +119,13 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.True(t, result.couldSet, "Should set key when it exists with XX")
 
-		val, err := store.Get([]byte("existing_xx"))
+		val, err := store.Get(0, []byte("existing_xx"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("updated"), val)
 	})
 
 	t.Run("set_xx_on_non_existent_key", func(t *testing.T) {
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:       []byte("non_existent_xx"),
 			value:     []byte("value"),
 			existence: ifExists,
@@ -120,16 +134,16 @@ func TestKiwiStorage(t *testing.T) {
 		assert.False(t, result.couldSet, "Should NOT set key when it doesn't exist with XX")
 
 		// Verify key was not set.
-		_, err := store.Get([]byte("non_existent_xx"))
+		_, err := store.Get(0, []byte("non_existent_xx"))
 		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
 	})
 
 	// Tests for SET GET option (return previous value).
 	t.Run("set_get_on_existing_key", func(t *testing.T) {
 		// First, set a key.
-		assert.NoError(t, store.Set(SetCommand{key: []byte("get_key"), value: []byte("old_value")}).err)
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("get_key"), value: []byte("old_value")}).err)
 		// Set with GET option.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:   []byte("get_key"),
 			value: []byte("new_value"),
 			get:   true,
@@ -138,13 +152,13 @@ func TestKiwiStorage(t *testing.T) {
 		assert.True(t, result.couldSet)
 		assert.True(t, result.hasPreviousValue, "Should indicate previous value exists")
 		// Verify new value is set.
-		val, err := store.Get([]byte("get_key"))
+		val, err := store.Get(0, []byte("get_key"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("new_value"), val)
 	})
 
 	t.Run("set_get_on_non_existent_key", func(t *testing.T) {
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:   []byte("get_key_new"),
 			value: []byte("value"),
 			get:   true,
@@ -158,13 +172,13 @@ func TestKiwiStorage(t *testing.T) {
 	// Tests for SET KEEPTTL option.
 	t.Run("set_keepttl_preserves_ttl", func(t *testing.T) {
 		// Set a key with expiry.
-		assert.NoError(t, store.Set(SetCommand{
+		assert.NoError(t, store.Set(0, SetCommand{
 			key:        []byte("ttl_key"),
 			value:      []byte("original"),
 			expiryTime: time.Now().Add(1 * time.Hour),
 		}).err)
 		// Update value while keeping TTL.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:     []byte("ttl_key"),
 			value:   []byte("updated"),
 			keepTtl: true,
@@ -172,13 +186,13 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.True(t, result.couldSet)
 		// Verify value is updated and key still has TTL (doesn't expire immediately).
-		val, err := store.Get([]byte("ttl_key"))
+		val, err := store.Get(0, []byte("ttl_key"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("updated"), val)
 	})
 
 	t.Run("set_keepttl_on_non_existent_key", func(t *testing.T) {
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:     []byte("no_ttl_key"),
 			value:   []byte("value"),
 			keepTtl: true,
@@ -186,16 +200,16 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.True(t, result.couldSet)
 		// Should set normally without TTL.
-		val, err := store.Get([]byte("no_ttl_key"))
+		val, err := store.Get(0, []byte("no_ttl_key"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("value"), val)
 	})
 
 	t.Run("set_keepttl_on_key_without_ttl", func(t *testing.T) {
 		// Set a key without expiry.
-		assert.NoError(t, store.Set(SetCommand{key: []byte("no_expiry"), value: []byte("original")}).err)
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("no_expiry"), value: []byte("original")}).err)
 		// Update with KEEPTTL - should not add TTL.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:     []byte("no_expiry"),
 			value:   []byte("updated"),
 			keepTtl: true,
@@ -203,14 +217,14 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.True(t, result.couldSet)
 
-		val, err := store.Get([]byte("no_expiry"))
+		val, err := store.Get(0, []byte("no_expiry"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("updated"), val)
 	})
 
 	t.Run("set_keepttl_on_expired_key", func(t *testing.T) {
 		// Set a key with very short expiry.
-		assert.NoError(t, store.Set(SetCommand{
+		assert.NoError(t, store.Set(0, SetCommand{
 			key:        []byte("expired_ttl"),
 			value:      []byte("original"),
 			expiryTime: time.Now().Add(10 * time.Millisecond),
@@ -218,7 +232,7 @@ func TestKiwiStorage(t *testing.T) {
 		// Wait for expiry.
 		time.Sleep(20 * time.Millisecond)
 		// Try to set with KEEPTTL - should act like setting a new key.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:     []byte("expired_ttl"),
 			value:   []byte("new"),
 			keepTtl: true,
@@ -226,7 +240,7 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.True(t, result.couldSet)
 		// Should be retrievable without expiry.
-		val, err := store.Get([]byte("expired_ttl"))
+		val, err := store.Get(0, []byte("expired_ttl"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("new"), val)
 	})
@@ -234,7 +248,7 @@ func TestKiwiStorage(t *testing.T) {
 	// Tests for combined options.
 	t.Run("set_nx_with_get", func(t *testing.T) {
 		// NX + GET on non-existent key - should set and return nil previous value.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:       []byte("nx_get_new"),
 			value:     []byte("value"),
 			existence: ifNotExists,
@@ -245,7 +259,7 @@ func TestKiwiStorage(t *testing.T) {
 		assert.False(t, result.hasPreviousValue)
 		assert.Nil(t, result.previousValue)
 		// NX + GET on existing key - should not set and return previous value.
-		result = store.Set(SetCommand{
+		result = store.Set(0, SetCommand{
 			key:       []byte("nx_get_new"),
 			value:     []byte("new_value"),
 			existence: ifNotExists,
@@ -258,13 +272,13 @@ func TestKiwiStorage(t *testing.T) {
 
 	t.Run("set_xx_with_keepttl", func(t *testing.T) {
 		// Set key with TTL.
-		assert.NoError(t, store.Set(SetCommand{
+		assert.NoError(t, store.Set(0, SetCommand{
 			key:        []byte("xx_ttl"),
 			value:      []byte("original"),
 			expiryTime: time.Now().Add(1 * time.Hour),
 		}).err)
 		// XX + KEEPTTL - should update and keep TTL.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:       []byte("xx_ttl"),
 			value:     []byte("updated"),
 			existence: ifExists,
@@ -273,17 +287,17 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.True(t, result.couldSet)
 
-		val, err := store.Get([]byte("xx_ttl"))
+		val, err := store.Get(0, []byte("xx_ttl"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("updated"), val)
 	})
 
 	t.Run("set_xx_with_get", func(t *testing.T) {
 		// Set initial key.
-		assert.NoError(t, store.Set(SetCommand{key: []byte("xx_get"), value: []byte("original")}).err)
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("xx_get"), value: []byte("original")}).err)
 
 		// XX + GET on existing key.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:       []byte("xx_get"),
 			value:     []byte("updated"),
 			existence: ifExists,
@@ -294,7 +308,7 @@ func TestKiwiStorage(t *testing.T) {
 		assert.True(t, result.hasPreviousValue)
 
 		// XX + GET on non-existent key.
-		result = store.Set(SetCommand{
+		result = store.Set(0, SetCommand{
 			key:       []byte("xx_get_missing"),
 			value:     []byte("value"),
 			existence: ifExists,
@@ -307,7 +321,7 @@ func TestKiwiStorage(t *testing.T) {
 
 	t.Run("set_overwrites_expired_key", func(t *testing.T) {
 		// Set key with very short expiry.
-		assert.NoError(t, store.Set(SetCommand{
+		assert.NoError(t, store.Set(0, SetCommand{
 			key:        []byte("will_expire"),
 			value:      []byte("original"),
 			expiryTime: time.Now().Add(10 * time.Millisecond),
@@ -317,7 +331,7 @@ func TestKiwiStorage(t *testing.T) {
 		time.Sleep(20 * time.Millisecond)
 
 		// Set new value.
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:   []byte("will_expire"),
 			value: []byte("new_value"),
 		})
@@ -325,34 +339,36 @@ func TestKiwiStorage(t *testing.T) {
 		assert.True(t, result.couldSet)
 
 		// Should get new value.
-		val, err := store.Get([]byte("will_expire"))
+		val, err := store.Get(0, []byte("will_expire"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("new_value"), val)
 	})
 
 	t.Run("set_after_delete", func(t *testing.T) {
 		// Set, delete, then set again.
-		assert.NoError(t, store.Set(SetCommand{key: []byte("del_set"), value: []byte("v1")}).err)
-		assert.NoError(t, store.Delete([]byte("del_set")))
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("del_set"), value: []byte("v1")}).err)
+		_, err := store.Delete(0, []byte("del_set"))
+		assert.NoError(t, err)
 
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:   []byte("del_set"),
 			value: []byte("v2"),
 		})
 		assert.NoError(t, result.err)
 		assert.True(t, result.couldSet)
 
-		val, err := store.Get([]byte("del_set"))
+		val, err := store.Get(0, []byte("del_set"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("v2"), val)
 	})
 
 	t.Run("set_nx_after_delete", func(t *testing.T) {
 		// Set, delete, then NX should succeed.
-		assert.NoError(t, store.Set(SetCommand{key: []byte("del_nx"), value: []byte("v1")}).err)
-		assert.NoError(t, store.Delete([]byte("del_nx")))
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("del_nx"), value: []byte("v1")}).err)
+		_, err := store.Delete(0, []byte("del_nx"))
+		assert.NoError(t, err)
 
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:       []byte("del_nx"),
 			value:     []byte("v2"),
 			existence: ifNotExists,
@@ -360,17 +376,18 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.True(t, result.couldSet, "NX should succeed after delete")
 
-		val, err := store.Get([]byte("del_nx"))
+		val, err := store.Get(0, []byte("del_nx"))
 		assert.NoError(t, err)
 		assert.Equal(t, []byte("v2"), val)
 	})
 
 	t.Run("set_xx_after_delete", func(t *testing.T) {
 		// Set, delete, then XX should fail.
-		assert.NoError(t, store.Set(SetCommand{key: []byte("del_xx"), value: []byte("v1")}).err)
-		assert.NoError(t, store.Delete([]byte("del_xx")))
+		assert.NoError(t, store.Set(0, SetCommand{key: []byte("del_xx"), value: []byte("v1")}).err)
+		_, err := store.Delete(0, []byte("del_xx"))
+		assert.NoError(t, err)
 
-		result := store.Set(SetCommand{
+		result := store.Set(0, SetCommand{
 			key:       []byte("del_xx"),
 			value:     []byte("v2"),
 			existence: ifExists,
@@ -378,7 +395,258 @@ func TestKiwiStorage(t *testing.T) {
 		assert.NoError(t, result.err)
 		assert.False(t, result.couldSet, "XX should fail after delete")
 
-		_, err := store.Get([]byte("del_xx"))
+		_, err = store.Get(0, []byte("del_xx"))
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+}
+
+func TestKiwiStorage_Scan(t *testing.T) {
+	config.SetTestFlag(t, "data_dir", t.TempDir())
+	config.SetTestFlag(t, "memtable_flush_size", "4") // Spread the keys over a few parts.
+	store, err := NewKiwiStorage()
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, store.Close()) })
+
+	for _, key := range []string{"user:1", "user:2", "user:3", "user:10", "item:1", "item:2", "other"} {
+		require.NoError(t, store.Set(0, SetCommand{key: []byte(key), value: []byte("v")}).err)
+	}
+	_, err = store.Delete(0, []byte("user:2"))
+	require.NoError(t, err)
+	require.NoError(t, store.Set(0, SetCommand{key: []byte("user:4"), value: []byte("v"),
+		expiryTime: time.Now().Add(-time.Second)}).err)
+
+	keys := func(result ScanResult) []string {
+		require.NoError(t, result.err)
+		var got []string
+		for _, key := range result.keys {
+			got = append(got, string(key))
+		}
+		return got
+	}
+
+	t.Run("all", func(t *testing.T) {
+		result := store.Scan(0, ScanCommand{})
+		assert.Equal(t, []string{"item:1", "item:2", "other", "user:1", "user:10", "user:3"}, keys(result))
+		assert.Nil(t, result.next)
+	})
+	t.Run("pattern", func(t *testing.T) {
+		assert.Equal(t, []string{"user:1", "user:10", "user:3"}, keys(store.Scan(0, ScanCommand{pattern: []byte("user:*")})))
+		assert.Equal(t, []string{"user:1", "user:3"}, keys(store.Scan(0, ScanCommand{pattern: []byte("user:?")})))
+		assert.Equal(t, []string{"item:2"}, keys(store.Scan(0, ScanCommand{pattern: []byte("*:2")})))
+		assert.Empty(t, keys(store.Scan(0, ScanCommand{pattern: []byte("missing*")})))
+		assert.Error(t, store.Scan(0, ScanCommand{pattern: []byte("user:[")}).err)
+	})
+	t.Run("resume", func(t *testing.T) {
+		// Deleted and expired keys count toward the examined keys, so batches may be smaller than the count.
+		var got []string
+		batches := 0
+		for cmd := (ScanCommand{pattern: []byte("user:*"), count: 2}); ; {
+			batches++
+			result := store.Scan(0, cmd)
+			got = append(got, keys(result)...)
+			if result.next == nil {
+				break
+			}
+			cmd.start = result.next
+		}
+		assert.Equal(t, []string{"user:1", "user:10", "user:3"}, got)
+		assert.Equal(t, 3, batches)
+	})
+	t.Run("exists_and_size", func(t *testing.T) {
+		for key, want := range map[string]bool{"user:1": true, "user:2": false, "user:4": false, "none": false} {
+			exists, err := store.Exists(0, []byte(key))
+			assert.NoError(t, err)
+			assert.Equal(t, want, exists, "Unexpected existence of %q", key)
+		}
+		size, err := store.DBSize(0)
+		assert.NoError(t, err)
+		assert.Equal(t, 6, size)
+	})
+	t.Run("read_errors", func(t *testing.T) {
+		config.SetTestFlag(t, "data_dir", t.TempDir())
+		failing, err := NewKiwiStorage()
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = failing.Close() }) // Fails, as the tree is already closed.
+		kdb, err := failing.database(0)
+		require.NoError(t, err)
+		require.NoError(t, kdb.lsm.Close()) // Scans of closed trees fail.
+
+		result := failing.Scan(0, ScanCommand{})
+		assert.Error(t, result.err, "Expected failed scans to be reported instead of looking done")
+		assert.Nil(t, result.keys)
+		_, err = failing.DBSize(0)
+		assert.Error(t, err)
+	})
+}
+
+func TestKiwiStorage_Databases(t *testing.T) {
+	config.SetTestFlag(t, "data_dir", t.TempDir())
+	config.SetTestFlag(t, "databases", "4")
+	store, err := NewKiwiStorage()
+	require.NoError(t, err)
+
+	get := func(db int, key string) string {
+		val, err := store.Get(db, []byte(key))
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return ""
+		}
+		require.NoError(t, err)
+		return string(val)
+	}
+	for db := range 3 {
+		require.NoError(t, store.Set(db, SetCommand{key: []byte("k"), value: []byte(fmt.Sprintf("db%d", db))}).err)
+	}
+
+	t.Run("isolation", func(t *testing.T) {
+		assert.Equal(t, "db0", get(0, "k"))
+		assert.Equal(t, "db2", get(2, "k"))
+		assert.Equal(t, "", get(3, "k"))
+		_, err := store.Get(4, []byte("k"))
+		assert.ErrorIs(t, err, errDbIndexOutOfRange)
+	})
+	t.Run("swap", func(t *testing.T) {
+		require.NoError(t, store.SwapDB(0, 1))
+		assert.Equal(t, "db1", get(0, "k"))
+		assert.Equal(t, "db0", get(1, "k"))
+		require.NoError(t, store.SwapDB(2, 3)) // DB 3 hasn't been opened yet.
+		assert.Equal(t, "", get(2, "k"))
+		assert.Equal(t, "db2", get(3, "k"))
+		assert.ErrorIs(t, store.SwapDB(0, 4), errDbIndexOutOfRange)
+	})
+	t.Run("swap_survives_restart", func(t *testing.T) {
+		require.NoError(t, store.Close())
+		store, err = NewKiwiStorage()
+		require.NoError(t, err)
+		assert.Equal(t, "db1", get(0, "k"))
+		assert.Equal(t, "db0", get(1, "k"))
+		assert.Equal(t, "db2", get(3, "k"))
+	})
+	t.Run("flush_db", func(t *testing.T) {
+		require.NoError(t, store.FlushDB(0, false /*async*/))
+		assert.Equal(t, "", get(0, "k"))
+		assert.Equal(t, "db0", get(1, "k"))
+		require.NoError(t, store.FlushDB(1, true /*async*/))
+		assert.Equal(t, "", get(1, "k"))
+	})
+	t.Run("flush_all", func(t *testing.T) {
+		require.NoError(t, store.Set(0, SetCommand{key: []byte("k"), value: []byte("v")}).err)
+		require.NoError(t, store.FlushAll(false /*async*/))
+		for db := range 4 {
+			assert.Equal(t, "", get(db, "k"))
+		}
+	})
+	assert.NoError(t, store.Close())
+}
+
+func TestKiwiStorage_ReadsDontBlockOnWrites(t *testing.T) {
+	config.SetTestFlag(t, "data_dir", t.TempDir())
+	store, err := NewKiwiStorage()
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, store.Close()) })
+	require.NoError(t, store.Set(0, SetCommand{key: []byte("k"), value: []byte("v")}).err)
+
+	store.mux.Lock() // E.g. a write which is flushing a memtable.
+	defer store.mux.Unlock()
+	read := make(chan error, 1)
+	go func() {
+		_, err := store.Get(0, []byte("k"))
+		if err == nil {
+			_, err = store.Exists(0, []byte("k"))
+		}
+		if err == nil {
+			_, err = store.DBSize(0)
+		}
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected reads not to wait for the write lock")
+	}
+}
+
+func TestKiwiStorage_Expiry(t *testing.T) {
+	config.SetTestFlag(t, "data_dir", t.TempDir())
+	store, err := NewKiwiStorage()
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, store.Close()) })
+
+	now := time.Now()
+	soon, later := now.Add(time.Hour), now.Add(2*time.Hour)
+	set := func(key string, expiry time.Time) {
+		require.NoError(t, store.Set(0, SetCommand{key: []byte(key), value: []byte("v"), expiryTime: expiry}).err)
+	}
+	expiry := func(key string) time.Time {
+		expiry, err := store.Expiry(0, []byte(key))
+		require.NoError(t, err)
+		return expiry
+	}
+	expire := func(key string, expiry time.Time, checks expiryCheck) bool {
+		updated, err := store.Expire(0, ExpireCommand{key: []byte(key), expiryTime: expiry, checks: checks})
+		require.NoError(t, err)
+		return updated
+	}
+
+	t.Run("expiry", func(t *testing.T) {
+		set("persistent", time.Time{})
+		set("volatile", soon)
+		assert.True(t, expiry("persistent").IsZero())
+		assert.True(t, expiry("volatile").Equal(soon))
+		_, err := store.Expiry(0, []byte("missing"))
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+	t.Run("expire", func(t *testing.T) {
+		set("k", time.Time{})
+		assert.True(t, expire("k", soon, 0 /*checks*/))
+		assert.True(t, expiry("k").Equal(soon))
+		val, err := store.Get(0, []byte("k"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v"), val, "Expected the value to be kept")
+		assert.False(t, expire("missing", soon, 0 /*checks*/))
+	})
+	t.Run("expire_checks", func(t *testing.T) {
+		set("k", time.Time{})
+		assert.False(t, expire("k", soon, ifHasExpiry))
+		assert.False(t, expire("k", soon, ifLaterExpiry), "Expected persistent keys to never be later")
+		assert.True(t, expire("k", later, ifEarlierExpiry), "Expected persistent keys to always be earlier")
+		assert.False(t, expire("k", soon, ifNoExpiry))
+		assert.False(t, expire("k", later, ifEarlierExpiry))
+		assert.True(t, expire("k", soon, ifEarlierExpiry))
+		assert.False(t, expire("k", soon, ifLaterExpiry))
+		assert.True(t, expire("k", later, ifHasExpiry|ifLaterExpiry))
+		assert.True(t, expiry("k").Equal(later))
+	})
+	t.Run("expire_in_the_past", func(t *testing.T) {
+		set("k", time.Time{})
+		assert.True(t, expire("k", now.Add(-time.Second), 0 /*checks*/))
+		exists, err := store.Exists(0, []byte("k"))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+	t.Run("persist", func(t *testing.T) {
+		set("k", soon)
+		updated, err := store.Persist(0, []byte("k"))
+		assert.NoError(t, err)
+		assert.True(t, updated)
+		assert.True(t, expiry("k").IsZero())
+		updated, err = store.Persist(0, []byte("k"))
+		assert.NoError(t, err)
+		assert.False(t, updated, "Expected nothing to persist")
+	})
+	t.Run("get_ex", func(t *testing.T) { // Sliding expiration.
+		set("k", soon)
+		val, err := store.GetEx(0, GetExCommand{key: []byte("k"), expiryTime: later})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v"), val)
+		assert.True(t, expiry("k").Equal(later))
+		_, err = store.GetEx(0, GetExCommand{key: []byte("k"), persist: true})
+		assert.NoError(t, err)
+		assert.True(t, expiry("k").IsZero())
+		val, err = store.GetEx(0, GetExCommand{key: []byte("k"), expiryTime: now.Add(-time.Second)})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v"), val, "Expected the value to be returned before it's deleted")
+		_, err = store.GetEx(0, GetExCommand{key: []byte("k")})
 		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
 	})
 }
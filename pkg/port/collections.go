@@ -0,0 +1,300 @@
+// Redis keys are stored as LSM keys as is, and their values are packed along with their type, see packing.go. The
+// members of collection types, e.g. the fields of hashes, are stored as their own LSM keys; so each member is read
+// and written by a point lookup, and a whole collection by a prefix scan. The collection's own key holds its
+// metadata, i.e. the version and number of its members, along with its type and expiry; so expiring or deleting it
+// hides every member at once. Lists also keep the position of their head, and their members are the big-endian
+// positions of their elements; so pushing and popping at either end is a single write. The layout of member keys is:
+//
+//	0x00 | type tag | uvarint length of the Redis key | Redis key | big-endian version | member
+//
+// Keys that start with a zero byte are internal keys, and Redis keys that start with one are escaped by another zero
+// byte; so the two never collide, and internal keys sort between the escaped Redis keys and the rest of them.
+//
+// A collection gets a new version whenever it's created, which is the next sequence number of its LSM tree; so the
+// members of a collection that was deleted, expired or overwritten never show up in a new one under the same key.
+// The members of a collection that's deleted or overwritten, e.g. by DEL, SET or active expiry, are deleted in the
+// background, see reclaim.go; so are those of one that has expired before active expiry caught up with it.
+
+package port
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/nobletooth/kiwi/pkg/utils"
+)
+
+// internalKeyPrefix is the first byte of internal keys.
+const internalKeyPrefix = 0x00
+
+var (
+	// internalKeysStart and internalKeysEnd bound the range of internal keys, i.e. [0x00 0x01, 0x01).
+	internalKeysStart = []byte{internalKeyPrefix, 0x01}
+	internalKeysEnd   = []byte{internalKeyPrefix + 1}
+
+	// memberTags maps each collection type to the tag of its member keys.
+	memberTags = map[ValueType]byte{HashType: 'h', ListType: 'l', SetType: 's', SortedSetType: 'z'}
+
+	errWrongType = errors.New("Operation against a key holding the wrong kind of value")
+)
+
+// storageKey returns the LSM key of the given Redis key, which is escaped if it starts with the internal key prefix.
+func storageKey(key []byte) []byte {
+	if len(key) > 0 && key[0] == internalKeyPrefix {
+		return append([]byte{internalKeyPrefix}, key...)
+	}
+	return key
+}
+
+// redisKey returns the Redis key of the given LSM key, or false if it's an internal key.
+func redisKey(stored []byte) ([]byte, bool /*isRedisKey*/) {
+	if len(stored) == 0 || stored[0] != internalKeyPrefix {
+		return stored, true
+	}
+	if len(stored) > 1 && stored[1] == internalKeyPrefix {
+		return stored[1:], true
+	}
+	return nil, false
+}
+
+// keyScanner scans the keys of a database, e.g. storage.LSMTree or a past view of it.
+type keyScanner interface {
+	Scan(start, end []byte) iter.Seq2[utils.BytePair, error]
+}
+
+// scanRedisKeys returns an iterator over the pairs of the Redis keys starting from `start` in ascending order, see
+// storage.LSMTree.Scan; the internal keys are skipped.
+func scanRedisKeys(keys keyScanner, start []byte) iter.Seq2[utils.BytePair, error] {
+	return func(yield func(utils.BytePair, error) bool) {
+		storedStart := storageKey(start)
+		var ranges [][2][]byte // The ranges of Redis keys, i.e. around the internal keys.
+		if bytes.Compare(storedStart, internalKeysStart) < 0 {
+			ranges = append(ranges, [2][]byte{storedStart, internalKeysStart})
+		}
+		if bytes.Compare(storedStart, internalKeysEnd) < 0 {
+			storedStart = internalKeysEnd
+		}
+		ranges = append(ranges, [2][]byte{storedStart, nil})
+		for _, keyRange := range ranges {
+			for pair, err := range keys.Scan(keyRange[0], keyRange[1]) {
+				if err != nil {
+					yield(utils.BytePair{}, err)
+					return
+				}
+				key, _ := redisKey(pair.Key)
+				if !yield(utils.BytePair{Key: key, Value: pair.Value}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// untilError returns an iterator over the values of the given scan, which stops at its first error and stores it in
+// `err`; so scans can be handed to helpers of plain iterators, e.g. scan.MatchGlob.
+func untilError[V any](values iter.Seq2[V, error], err *error) iter.Seq[V] {
+	return func(yield func(V) bool) {
+		for value, scanErr := range values {
+			if scanErr != nil {
+				*err = scanErr
+				return
+			}
+			if !yield(value) {
+				return
+			}
+		}
+	}
+}
+
+// collection is a live collection key along with its metadata.
+type collection struct {
+	value  unpackedValue // The value of the collection's key, which holds its type, metadata and expiry.
+	count  int           // The number of members.
+	prefix []byte        // The prefix of the member keys, which ends with the collection's version.
+	head   uint64        // The position of the first element of lists; the rest of the elements follow it.
+}
+
+// encode packs the metadata of the collection into its value.
+func (c *collection) encode() {
+	version := binary.BigEndian.Uint64(c.prefix[len(c.prefix)-8:])
+	meta := binary.AppendUvarint(nil, version)
+	meta = binary.AppendUvarint(meta, uint64(c.count))
+	if c.value.valueType == ListType {
+		meta = binary.AppendUvarint(meta, c.head)
+	}
+	c.value.value = meta
+}
+
+// memberKey returns the LSM key of the given member.
+func (c *collection) memberKey(member []byte) []byte {
+	return append(bytes.Clone(c.prefix), member...)
+}
+
+// memberPrefix returns the prefix of the member keys of the given collection.
+func memberPrefix(valueType ValueType, key []byte, version uint64) []byte {
+	prefix := make([]byte, 0, 2+binary.MaxVarintLen64+len(key)+8)
+	prefix = append(prefix, internalKeyPrefix, memberTags[valueType])
+	prefix = binary.AppendUvarint(prefix, uint64(len(key)))
+	prefix = append(prefix, key...)
+	return binary.BigEndian.AppendUint64(prefix, version)
+}
+
+// decodeCollection returns the collection of the given key, whose value holds its metadata.
+func decodeCollection(key []byte, value unpackedValue) (collection, error) {
+	version, n := binary.Uvarint(value.value)
+	if n <= 0 {
+		return collection{}, fmt.Errorf("invalid version of collection %q", key)
+	}
+	count, m := binary.Uvarint(value.value[n:])
+	if m <= 0 {
+		return collection{}, fmt.Errorf("invalid size of collection %q", key)
+	}
+	c := collection{value: value, count: int(count), prefix: memberPrefix(value.valueType, key, version)}
+	if value.valueType == ListType {
+		head, k := binary.Uvarint(value.value[n+m:])
+		if k <= 0 {
+			return collection{}, fmt.Errorf("invalid head of list %q", key)
+		}
+		c.head = head
+	}
+	return c, nil
+}
+
+// getCollection returns the live collection of the given type at `key`; ErrKeyNotFound if there's none, or
+// errWrongType if the key holds another type.
+func (kdb *kiwiDB) getCollection(key []byte, valueType ValueType, now time.Time) (collection, error) {
+	unpacked, err := kdb.getLive(key, now)
+	if err != nil {
+		return collection{}, err
+	}
+	if unpacked.valueType != valueType {
+		return collection{}, errWrongType
+	}
+	return decodeCollection(key, unpacked)
+}
+
+// getOrCreateCollection returns the live collection of the given type at `key`, or a new empty one with the next
+// version; it's only stored once it's saved, which deletes the members of an expired collection it replaces.
+// NOTE: Caller should acquire KiwiStorage.mux write lock, so that the version isn't taken by another collection.
+func (kdb *kiwiDB) getOrCreateCollection(key []byte, valueType ValueType, now time.Time) (collection, error) {
+	c, err := kdb.getCollection(key, valueType, now)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return kdb.newCollection(key, valueType), nil
+	}
+	return c, err
+}
+
+// newCollection returns a new empty collection of the given type at `key` with the next version, regardless of the
+// current value of the key; it's only stored once it's saved.
+// NOTE: Caller should acquire KiwiStorage.mux write lock, so that the version isn't taken by another collection.
+func (kdb *kiwiDB) newCollection(key []byte, valueType ValueType) collection {
+	version := uint64(kdb.lsm.LastSequence()) + 1
+	return collection{value: unpackedValue{valueType: valueType}, prefix: memberPrefix(valueType, key, version)}
+}
+
+// saveCollection writes the metadata of the given collection, or deletes it when it has no members left; it's
+// written on every change, so that watchers of the key see changes of its members too.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) saveCollection(key []byte, c collection) error {
+	if c.count == 0 {
+		_, err := kdb.delete(key)
+		return err
+	}
+	c.encode()
+	return kdb.set(key, c.value)
+}
+
+// getMember returns the value of the given member, or ErrKeyNotFound.
+func (kdb *kiwiDB) getMember(c collection, member []byte) ([]byte, error) {
+	packed, err := kdb.lsm.Get(c.memberKey(member))
+	if err != nil {
+		return nil, err
+	}
+	unpacked, err := unpack(packed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack member %q: %w", member, err)
+	}
+	if unpacked.is(TombStone) {
+		return nil, storage.ErrKeyNotFound
+	}
+	return unpacked.value, nil
+}
+
+// setMember writes the given member of the collection, and returns true if it's a new member; the collection's count
+// is updated, but it's up to the caller to save it.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) setMember(c *collection, member, value []byte) (bool /*added*/, error) {
+	_, err := kdb.getMember(*c, member)
+	if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return false, err
+	}
+	added := err != nil
+	if err := kdb.putMember(*c, member, value); err != nil {
+		return false, err
+	}
+	if added {
+		c.count++
+	}
+	return added, nil
+}
+
+// putMember writes the given member of the collection as is; unlike setMember, the collection's count is left alone.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) putMember(c collection, member, value []byte) error {
+	if err := kdb.lsm.Set(c.memberKey(member), unpackedValue{value: value}.pack()); err != nil {
+		return fmt.Errorf("failed to set member %q: %w", member, err)
+	}
+	return nil
+}
+
+// deleteMember deletes the given member of the collection, and returns true if it existed; the collection's count
+// is updated, but it's up to the caller to save it.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) deleteMember(c *collection, member []byte) (bool /*existed*/, error) {
+	existed, err := kdb.lsm.Delete(c.memberKey(member))
+	if err != nil {
+		return false, fmt.Errorf("failed to delete member %q: %w", member, err)
+	}
+	if existed {
+		c.count--
+	}
+	return existed, nil
+}
+
+// eraseMember writes a tombstone for the given member of the collection, which is known to exist; unlike
+// deleteMember, the collection's count is left alone.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) eraseMember(c collection, member []byte) error {
+	if err := kdb.lsm.Set(c.memberKey(member), tombstonePacked); err != nil {
+		return fmt.Errorf("failed to delete member %q: %w", member, err)
+	}
+	return nil
+}
+
+// members returns an iterator over the members of the collection, starting from `start` in ascending order.
+func (kdb *kiwiDB) members(c collection, start []byte) iter.Seq2[utils.BytePair, error] {
+	return func(yield func(utils.BytePair, error) bool) {
+		for pair, err := range kdb.lsm.Scan(c.memberKey(start), nil /*end*/) {
+			if err != nil {
+				yield(utils.BytePair{}, fmt.Errorf("failed to scan members: %w", err))
+				return
+			}
+			member, isMember := bytes.CutPrefix(pair.Key, c.prefix)
+			if !isMember {
+				return
+			}
+			unpacked, err := unpack(pair.Value)
+			if err != nil || unpacked.is(TombStone) {
+				continue
+			}
+			if !yield(utils.BytePair{Key: member, Value: unpacked.value}, nil) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package port
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageKey(t *testing.T) {
+	for _, key := range []string{"", "k", "\x00", "\x00k", "\x00\x00", "\x01"} {
+		stored := storageKey([]byte(key))
+		got, isRedisKey := redisKey(stored)
+		assert.True(t, isRedisKey, "Expected %q to be a Redis key", stored)
+		assert.Equal(t, key, string(got))
+	}
+	hash := memberPrefix(HashType, []byte("k"), 1 /*version*/)
+	_, isRedisKey := redisKey(hash)
+	assert.False(t, isRedisKey, "Expected member keys to be internal keys")
+	assert.Equal(t, -1, bytes.Compare(storageKey([]byte("\x00k")), hash), "Expected escaped keys to sort first")
+	assert.Equal(t, 1, bytes.Compare(storageKey([]byte("\x01")), hash))
+}
+
+func TestScanRedisKeys(t *testing.T) {
+	store := newTestRedisHandler(t, "\x00k", "a", "b").store
+	_, err := store.HSet(0, []byte("h"), []utils.BytePair{{Key: []byte("f"), Value: []byte("v")}})
+	require.NoError(t, err)
+	kdb, err := store.database(0)
+	require.NoError(t, err)
+
+	for start, want := range map[string][]string{"": {"\x00k", "a", "b", "h"}, "\x00": {"\x00k", "a", "b", "h"},
+		"\x00z": {"a", "b", "h"}, "b": {"b", "h"}} {
+		var keys []string
+		for pair, err := range scanRedisKeys(kdb.lsm, []byte(start)) {
+			require.NoError(t, err)
+			keys = append(keys, string(pair.Key))
+		}
+		assert.Equal(t, want, keys, "Unexpected keys from %q", start)
+	}
+}
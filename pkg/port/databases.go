@@ -0,0 +1,147 @@
+// Each Redis DB is stored in its own storage table; the DATABASES file in the data directory maps each DB number to
+// its table. Redis DB n maps to table n+1 by default, and the mapping only changes by the SWAPDB command.
+//
+// Versions before the internal keys of collections stored every Redis key as is, including the ones that start with
+// a zero byte, i.e. the internal key prefix; so their tables are migrated once by escapeRawKeys on startup.
+
+package port
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	kiwipb "github.com/nobletooth/kiwi/proto"
+)
+
+const databasesFileName = "DATABASES"
+
+// loadDatabaseMapping returns the table of each Redis DB, as stored in the DATABASES file of `dir`, along with
+// whether their keys are escaped. DBs that aren't mapped yet, e.g. when there's no such file or the `databases` flag
+// was increased, get unused tables.
+func loadDatabaseMapping(dir string, count int) ([]int64, bool /*escapedKeys*/, error) {
+	var tables []int64
+	escapedKeys := false
+	file, err := os.Open(filepath.Join(dir, databasesFileName))
+	if err == nil {
+		mapping := &kiwipb.DatabaseMapping{}
+		reader, err := storage.NewBlockReader(file)
+		if err != nil {
+			return nil, false, errors.Join(err, file.Close())
+		}
+		if _, err := reader.ReadBlock(0 /*offset*/, mapping); err != nil {
+			return nil, false, errors.Join(fmt.Errorf("failed to read database mapping: %w", err), reader.Close(),
+				file.Close())
+		}
+		if err := errors.Join(reader.Close(), file.Close()); err != nil {
+			return nil, false, err
+		}
+		tables, escapedKeys = mapping.GetTables(), mapping.GetEscapedKeys()
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, fmt.Errorf("failed to open database mapping: %w", err)
+	}
+
+	for db := len(tables); db < count; db++ {
+		table := int64(db + 1)
+		if slices.Contains(tables, table) { // Swapped into a lower DB, which was mapped before.
+			table = slices.Max(tables) + 1
+		}
+		tables = append(tables, table)
+	}
+	return tables, escapedKeys, nil
+}
+
+// saveDatabaseMapping atomically replaces the DATABASES file of `dir` with the given tables, whose keys are escaped.
+func saveDatabaseMapping(dir string, tables []int64) error {
+	path := filepath.Join(dir, databasesFileName)
+	tmpPath := path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create database mapping: %w", err)
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+	writer, err := storage.NewBlockWriter(tmpFile)
+	if err != nil {
+		return err
+	}
+	if err := writer.WriteBlock(&kiwipb.DatabaseMapping{Tables: tables, EscapedKeys: true}); err != nil {
+		return errors.Join(fmt.Errorf("failed to write database mapping: %w", err), writer.Close())
+	}
+	if err := errors.Join(writer.Sync(), writer.Close()); err != nil {
+		return fmt.Errorf("failed to sync database mapping: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace database mapping: %w", err)
+	}
+	// Sync the directory, so that the rename survives crashes.
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	return errors.Join(dirFile.Sync(), dirFile.Close())
+}
+
+// escapeRawKeys escapes the Redis keys that start with the internal key prefix in the given tables, which were
+// written by versions that stored them as is; see storageKey. Tables that don't exist yet are skipped.
+func escapeRawKeys(dir string, tables []int64) error {
+	for _, table := range tables {
+		if _, err := os.Stat(filepath.Join(dir, fmt.Sprint(table))); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to stat table %d: %w", table, err)
+		}
+		lsm, err := storage.NewLSMTree(dir, table, packedValueInspector{})
+		if err != nil {
+			return fmt.Errorf("failed to open table %d: %w", table, err)
+		}
+		escaped, err := escapeTableKeys(lsm)
+		if err := errors.Join(err, lsm.Close()); err != nil {
+			return fmt.Errorf("failed to escape the keys of table %d: %w", table, err)
+		}
+		if escaped > 0 {
+			slog.Info("Escaped keys that start with a zero byte.", "table", table, "keys", escaped)
+		}
+	}
+	return nil
+}
+
+// escapeTableKeys moves each live key of the given table that starts with the internal key prefix to its escaped
+// key, and returns the number of moved keys. Such keys are rare, so they're all read before any is written; escaping
+// one key may overwrite another, e.g. "\x00a" is escaped to "\x00\x00a", which is then escaped as well.
+// NOTE: Caller should make sure no other writes are made to the table meanwhile.
+func escapeTableKeys(lsm *storage.LSMTree) (int, error) {
+	var pairs []utils.BytePair
+	for pair, err := range lsm.Scan([]byte{internalKeyPrefix}, internalKeysEnd) {
+		if err != nil {
+			return 0, err
+		}
+		if unpacked, err := unpack(pair.Value); err == nil && unpacked.is(TombStone) {
+			continue
+		}
+		pairs = append(pairs, utils.BytePair{Key: bytes.Clone(pair.Key), Value: bytes.Clone(pair.Value)})
+	}
+
+	overwritten := make(map[string]bool, len(pairs))
+	for _, pair := range pairs {
+		escaped := storageKey(pair.Key)
+		overwritten[string(escaped)] = true
+		if err := lsm.Set(escaped, pair.Value); err != nil {
+			return 0, err
+		}
+	}
+	for _, pair := range pairs {
+		if overwritten[string(pair.Key)] {
+			continue
+		}
+		if _, err := lsm.Delete(pair.Key); err != nil {
+			return 0, err
+		}
+	}
+	return len(pairs), nil
+}
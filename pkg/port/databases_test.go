@@ -0,0 +1,61 @@
+package port
+
+import (
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabaseMapping(t *testing.T) {
+	dir := t.TempDir()
+	tables, escapedKeys, err := loadDatabaseMapping(dir, 3 /*count*/)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, tables, "Expected DB n to map to table n+1 by default")
+	assert.False(t, escapedKeys)
+
+	require.NoError(t, saveDatabaseMapping(dir, []int64{3, 2, 1}))
+	tables, escapedKeys, err = loadDatabaseMapping(dir, 2 /*count*/)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 2, 1}, tables, "Expected DBs beyond the count to keep their tables")
+	assert.True(t, escapedKeys)
+
+	// Table 4 of DB 3 was swapped into DB 0, so the new DB 3 gets an unused table.
+	require.NoError(t, saveDatabaseMapping(dir, []int64{4, 2, 3}))
+	tables, _, err = loadDatabaseMapping(dir, 5 /*count*/)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 2, 3, 5, 6}, tables)
+}
+
+func TestKiwiStorage_EscapeRawKeys(t *testing.T) {
+	dir := t.TempDir()
+	config.SetTestFlag(t, "data_dir", dir)
+	// Tables written by versions before internal keys hold the keys that start with a zero byte as is.
+	lsm, err := storage.NewLSMTree(dir, 1 /*table*/, packedValueInspector{})
+	require.NoError(t, err)
+	for _, key := range []string{"\x00a", "\x00\x00a", "\x00h", "b"} {
+		require.NoError(t, lsm.Set([]byte(key), unpackedValue{value: []byte("v" + key)}.pack()))
+	}
+	require.NoError(t, lsm.Close())
+
+	for range 2 { // Keys are only escaped once.
+		store, err := NewKiwiStorage()
+		require.NoError(t, err)
+		for _, key := range []string{"\x00a", "\x00\x00a", "\x00h", "b"} {
+			value, err := store.Get(0, []byte(key))
+			require.NoError(t, err)
+			assert.Equal(t, "v"+key, string(value))
+		}
+		kdb, err := store.database(0)
+		require.NoError(t, err)
+		for pair, err := range kdb.lsm.Scan(internalKeysStart, internalKeysEnd) {
+			require.NoError(t, err)
+			unpacked, err := unpack(pair.Value)
+			require.NoError(t, err)
+			assert.True(t, unpacked.is(TombStone), "Expected no raw keys to be left as internal keys")
+		}
+		require.NoError(t, store.Close())
+	}
+}
@@ -0,0 +1,228 @@
+// Expired keys are hidden from reads right away, but they'd keep occupying the memtable and disk until overwritten.
+// Similar to Redis, Kiwi actively deletes them in the background: each database keeps an index of its keys with an
+// expiry, ordered by their expiry time. The index is rebuilt by scanning the database when it's opened, and kept up
+// to date by every write afterward. Each active expiry cycle pops the expired keys off the index, and writes
+// tombstones for them the same way DEL does; compactions then reclaim their space.
+
+package port
+
+import (
+	"container/heap"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	activeExpiryEnabled  = flag.Bool("enable_active_expiry", true, "Delete expired keys in the background.")
+	activeExpiryInterval = flag.Duration("active_expiry_interval", 100*time.Millisecond,
+		"The interval between active expiry cycles.")
+	activeExpiryKeysPerCycle = flag.Int("active_expiry_keys_per_cycle", 200,
+		"The maximum number of expired keys deleted per database in each active expiry cycle.")
+
+	expiredKeysTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "expired_keys_total",
+		Help: "Total number of expired keys deleted by active expiry.",
+	})
+	activeExpiryCyclesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "active_expiry_cycles_total",
+		Help: "Total number of active expiry cycles.",
+	})
+)
+
+// expiryEntry is a key that expires at the given time.
+type expiryEntry struct {
+	expiry time.Time
+	key    string
+}
+
+// expiryQueue is a min-heap of expiry entries, ordered by their expiry time.
+type expiryQueue []expiryEntry // Implements heap.Interface.
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].expiry.Before(q[j].expiry) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x any)        { *q = append(*q, x.(expiryEntry)) }
+func (q *expiryQueue) Pop() any {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// expiryIndex keeps the keys of a database which have an expiry, ordered by their expiry time.
+// NOTE: Caller should acquire lock.
+type expiryIndex struct {
+	expiries map[string]time.Time // The current expiry of each key.
+	queue    expiryQueue          // May hold stale entries of keys whose expiry has changed since; they're skipped.
+}
+
+func newExpiryIndex() *expiryIndex {
+	return &expiryIndex{expiries: make(map[string]time.Time)}
+}
+
+// buildExpiryIndex scans the given database for keys with an expiry, including the already expired ones.
+func buildExpiryIndex(lsm *storage.LSMTree) (*expiryIndex, error) {
+	index := newExpiryIndex()
+	for pair, err := range scanRedisKeys(lsm, nil /*start*/) {
+		if err != nil {
+			return nil, err
+		}
+		unpacked, err := unpack(pair.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unpack value of key %q: %w", pair.Key, err)
+		}
+		index.track(pair.Key, unpacked)
+	}
+	return index, nil
+}
+
+// track updates the index with the value that's just been written for the given `key`.
+func (ei *expiryIndex) track(key []byte, value unpackedValue) {
+	if !value.is(Expirable) || value.is(TombStone) {
+		delete(ei.expiries, string(key))
+		return
+	}
+	if expiry, exists := ei.expiries[string(key)]; exists && expiry.Equal(value.expiry) {
+		return
+	}
+	ei.expiries[string(key)] = value.expiry
+	heap.Push(&ei.queue, expiryEntry{expiry: value.expiry, key: string(key)})
+	// Keys whose expiry keeps sliding leave many stale entries behind; they're dropped once they dominate the queue.
+	if len(ei.queue) > 2*len(ei.expiries)+1024 {
+		ei.queue = ei.queue[:0]
+		for key, expiry := range ei.expiries {
+			ei.queue = append(ei.queue, expiryEntry{expiry: expiry, key: key})
+		}
+		heap.Init(&ei.queue)
+	}
+}
+
+// popExpired removes up to `limit` keys which are expired at `now` from the index, and returns their entries.
+func (ei *expiryIndex) popExpired(now time.Time, limit int) []expiryEntry {
+	var entries []expiryEntry
+	for len(ei.queue) > 0 && len(entries) < limit && now.After(ei.queue[0].expiry) {
+		entry := heap.Pop(&ei.queue).(expiryEntry)
+		if expiry, exists := ei.expiries[entry.key]; !exists || !expiry.Equal(entry.expiry) {
+			continue // Stale entry.
+		}
+		delete(ei.expiries, entry.key)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// requeue puts the given popped entries back into the index, unless their keys have been tracked since.
+func (ei *expiryIndex) requeue(entries []expiryEntry) {
+	for _, entry := range entries {
+		if _, exists := ei.expiries[entry.key]; exists {
+			continue
+		}
+		ei.expiries[entry.key] = entry.expiry
+		heap.Push(&ei.queue, entry)
+	}
+}
+
+// len returns the number of keys with an expiry.
+func (ei *expiryIndex) len() int {
+	return len(ei.expiries)
+}
+
+// expireKeys deletes up to `limit` expired keys of each opened database; it returns the number of deleted keys.
+// Each database is locked separately, so that commands aren't blocked for a whole cycle.
+func (ks *KiwiStorage) expireKeys(now time.Time, limit int) (int, error) {
+	expiredKeys := 0
+	for db := range ks.dbs {
+		deleted, err := func() (int, error) {
+			ks.mux.Lock()
+			defer ks.mux.Unlock()
+			kdb := ks.dbs[db].Load()
+			if ks.closed.Load() || kdb == nil {
+				return 0, nil
+			}
+			deleted := 0
+			var failed []expiryEntry
+			var errs error
+			for _, entry := range kdb.expiries.popExpired(now, limit) {
+				isDeleted, err := kdb.expireKey([]byte(entry.key), now)
+				if err != nil {
+					// Failed keys are put back for the next cycles to retry, while the rest of the keys go on.
+					failed, errs = append(failed, entry), errors.Join(errs, err)
+				} else if isDeleted {
+					deleted++
+				}
+			}
+			kdb.expiries.requeue(failed)
+			return deleted, errs
+		}()
+		expiredKeys += deleted
+		expiredKeysTotal.Add(float64(deleted))
+		if err != nil {
+			return expiredKeys, fmt.Errorf("failed to expire keys of db %d: %w", db, err)
+		}
+	}
+	activeExpiryCyclesTotal.Inc()
+	return expiredKeys, nil
+}
+
+// expireKey deletes the given key, which the expiry index has found to be expired at `now`; it returns false if the
+// key turns out to be live or already deleted, in which case the index is updated instead.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) expireKey(key []byte, now time.Time) (bool /*deleted*/, error) {
+	// The index is kept up to date, but the stored value is checked anyway as deleting is irreversible.
+	packed, err := kdb.get(key)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to get expired key %q: %w", key, err)
+	}
+	unpacked, err := unpack(packed)
+	if err != nil {
+		return false, fmt.Errorf("failed to unpack expired key %q: %w", key, err)
+	}
+	if unpacked.is(TombStone) || !unpacked.isExpiredAt(now) {
+		kdb.expiries.track(key, unpacked)
+		return false, nil
+	}
+	if _, err := kdb.delete(key); err != nil {
+		return false, fmt.Errorf("failed to delete expired key %q: %w", key, err)
+	}
+	return true, nil
+}
+
+// startActiveExpiry starts the background active expiry loop; it's stopped by Close.
+func (ks *KiwiStorage) startActiveExpiry() {
+	ks.expiryStop, ks.expiryDone = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(ks.expiryDone)
+		ticker := time.NewTicker(*activeExpiryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ks.expiryStop:
+				return
+			case now := <-ticker.C:
+				if _, err := ks.expireKeys(now, *activeExpiryKeysPerCycle); err != nil {
+					slog.Error("Failed to expire keys.", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopActiveExpiry stops the active expiry loop and waits for the running cycle, if any.
+func (ks *KiwiStorage) stopActiveExpiry() {
+	ks.expiryStopOnce.Do(func() {
+		if ks.expiryStop == nil {
+			return
+		}
+		close(ks.expiryStop)
+		<-ks.expiryDone
+	})
+}
@@ -0,0 +1,142 @@
+package port
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpiryIndex(t *testing.T) {
+	now := time.Now()
+	expirable := func(expiry time.Time) unpackedValue {
+		return unpackedValue{opt: Expirable, value: []byte("v"), expiry: expiry}
+	}
+	index := newExpiryIndex()
+	index.track([]byte("a"), expirable(now.Add(3*time.Second)))
+	index.track([]byte("b"), expirable(now.Add(1*time.Second)))
+	index.track([]byte("c"), expirable(now.Add(2*time.Second)))
+	index.track([]byte("d"), unpackedValue{value: []byte("v")})
+	index.track([]byte("c"), expirable(now.Add(5*time.Second))) // Leaves a stale entry behind.
+	index.track([]byte("a"), tombstoneUnpacked)
+	assert.Equal(t, 2, index.len())
+
+	// popKeys returns the keys of the popped entries.
+	popKeys := func(now time.Time, limit int) []string {
+		var keys []string
+		for _, entry := range index.popExpired(now, limit) {
+			keys = append(keys, entry.key)
+		}
+		return keys
+	}
+	assert.Empty(t, popKeys(now, 10 /*limit*/))
+	assert.Equal(t, []string{"b"}, popKeys(now.Add(4*time.Second), 10 /*limit*/),
+		"Expected stale entries of updated and deleted keys to be skipped")
+	index.track([]byte("e"), expirable(now.Add(4*time.Second)))
+	assert.Equal(t, []string{"e"}, popKeys(now.Add(time.Minute), 1 /*limit*/))
+	popped := index.popExpired(now.Add(time.Minute), 1 /*limit*/)
+	index.requeue(popped)
+	assert.Equal(t, []string{"c"}, popKeys(now.Add(time.Minute), 1 /*limit*/),
+		"Expected requeued keys to be popped again")
+	assert.Zero(t, index.len())
+	assert.Empty(t, index.queue)
+}
+
+func TestKiwiStorage_ActiveExpiry(t *testing.T) {
+	config.SetTestFlag(t, "data_dir", t.TempDir())
+	config.SetTestFlag(t, "enable_active_expiry", "false") // Expiry cycles are run manually.
+	store, err := NewKiwiStorage()
+	require.NoError(t, err)
+
+	now := time.Now()
+	for _, key := range []string{"k1", "k2", "k3"} {
+		require.NoError(t, store.Set(0, SetCommand{key: []byte(key), value: []byte("v"),
+			expiryTime: now.Add(time.Second)}).err)
+	}
+	require.NoError(t, store.Set(0, SetCommand{key: []byte("persistent"), value: []byte("v")}).err)
+	require.NoError(t, store.Set(1, SetCommand{key: []byte("k1"), value: []byte("v"),
+		expiryTime: now.Add(time.Second)}).err)
+	persisted, err := store.Persist(0, []byte("k3"))
+	require.NoError(t, err)
+	require.True(t, persisted)
+
+	// storedValue returns the value of the given key as stored, regardless of its expiry.
+	storedValue := func(db int, key string) unpackedValue {
+		packed, err := store.dbs[db].Load().lsm.Get([]byte(key))
+		require.NoError(t, err)
+		unpacked, err := unpack(packed)
+		require.NoError(t, err)
+		return unpacked
+	}
+
+	expired, err := store.expireKeys(now, 10 /*limit*/)
+	require.NoError(t, err)
+	assert.Zero(t, expired, "Expected no keys to be expired yet")
+	expired, err = store.expireKeys(now.Add(time.Minute), 1 /*limit*/)
+	require.NoError(t, err)
+	assert.Equal(t, 2, expired, "Expected the limit to apply to each database")
+	assert.True(t, storedValue(0, "k1").is(TombStone))
+	assert.True(t, storedValue(1, "k1").is(TombStone))
+	assert.False(t, storedValue(0, "k3").is(TombStone), "Expected persisted keys to be kept")
+	require.NoError(t, store.Close())
+
+	// The index is rebuilt from the stored keys after reopening.
+	store, err = NewKiwiStorage()
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, store.Close()) })
+	_, err = store.database(1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.dbs[0].Load().expiries.len())
+	assert.Zero(t, store.dbs[1].Load().expiries.len())
+	expired, err = store.expireKeys(now.Add(time.Minute), 10 /*limit*/)
+	require.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assert.True(t, storedValue(0, "k2").is(TombStone))
+	_, err = store.Get(0, []byte("persistent"))
+	assert.NoError(t, err)
+
+	t.Run("failures", func(t *testing.T) {
+		config.SetTestFlag(t, "data_dir", t.TempDir())
+		store, err := NewKiwiStorage()
+		require.NoError(t, err)
+		t.Cleanup(func() { assert.NoError(t, store.Close()) })
+		for _, key := range []string{"a", "c"} {
+			require.NoError(t, store.Set(0, SetCommand{key: []byte(key), value: []byte("v"),
+				expiryTime: now.Add(time.Second)}).err)
+		}
+		// A value that can't be unpacked fails to expire.
+		kdb, err := store.database(0)
+		require.NoError(t, err)
+		require.NoError(t, kdb.lsm.Set(storageKey([]byte("b")), []byte{byte(Expirable)}))
+		kdb.expiries.track([]byte("b"), unpackedValue{opt: Expirable, expiry: now.Add(time.Second)})
+
+		expired, err := store.expireKeys(now.Add(time.Minute), 10 /*limit*/)
+		assert.Error(t, err)
+		assert.Equal(t, 2, expired, "Expected the other keys to be expired despite the failure")
+		assert.Equal(t, 1, kdb.expiries.len(), "Expected the failed key to be retried by the next cycles")
+		_, err = store.expireKeys(now.Add(time.Minute), 10 /*limit*/)
+		assert.Error(t, err)
+		assert.Equal(t, 1, kdb.expiries.len())
+	})
+	t.Run("background", func(t *testing.T) {
+		config.SetTestFlag(t, "enable_active_expiry", "true")
+		config.SetTestFlag(t, "active_expiry_interval", "10ms")
+		config.SetTestFlag(t, "data_dir", t.TempDir())
+		store, err := NewKiwiStorage()
+		require.NoError(t, err)
+		t.Cleanup(func() { assert.NoError(t, store.Close()) })
+		require.NoError(t, store.Set(0, SetCommand{key: []byte("k"), value: []byte("v"),
+			expiryTime: time.Now().Add(50 * time.Millisecond)}).err)
+		assert.Eventually(t, func() bool {
+			store.mux.RLock()
+			defer store.mux.RUnlock()
+			packed, err := store.dbs[0].Load().lsm.Get([]byte("k"))
+			return err == nil && string(packed) == string(tombstonePacked)
+		}, 5*time.Second /*waitFor*/, 10*time.Millisecond /*tick*/, "Expected the key to be deleted in background")
+		_, err = store.Get(0, []byte("k"))
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+}
@@ -0,0 +1,215 @@
+// Redis hashes map fields to values under a single key; each field is stored as its own member key, see collections.go.
+
+package port
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/scan"
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/nobletooth/kiwi/pkg/utils"
+)
+
+// HSet sets the given fields of the hash at `key`, creating it if it doesn't exist; it returns the number of fields
+// that were added, e.g. the Redis HSET command.
+func (ks *KiwiStorage) HSet(db int, key []byte, fields []utils.BytePair) (int, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	hash, err := kdb.getOrCreateCollection(key, HashType, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, field := range fields {
+		isNew, err := kdb.setMember(&hash, field.Key, field.Value)
+		if err != nil {
+			return added, err
+		}
+		if isNew {
+			added++
+		}
+	}
+	if err := kdb.saveCollection(key, hash); err != nil {
+		return added, fmt.Errorf("failed to save hash: %w", err)
+	}
+	return added, nil
+}
+
+// HGet returns the value of the given `field` of the hash at `key`, or ErrKeyNotFound.
+func (ks *KiwiStorage) HGet(db int, key, field []byte) ([]byte, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := kdb.getCollection(key, HashType, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return kdb.getMember(hash, field)
+}
+
+// HMGet returns the values of the given `fields` of the hash at `key`, in order; missing fields have nil values.
+func (ks *KiwiStorage) HMGet(db int, key []byte, fields ...[]byte) ([][]byte, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(fields))
+	hash, err := kdb.getCollection(key, HashType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return values, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for i, field := range fields {
+		value, err := kdb.getMember(hash, field)
+		if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// HDel removes the given fields of the hash at `key`, and the hash itself once it's empty; it returns the number of
+// fields that existed.
+func (ks *KiwiStorage) HDel(db int, key []byte, fields ...[]byte) (int, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	hash, err := kdb.getCollection(key, HashType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, field := range fields {
+		existed, err := kdb.deleteMember(&hash, field)
+		if err != nil {
+			return deleted, err
+		}
+		if existed {
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		return 0, nil
+	}
+	if err := kdb.saveCollection(key, hash); err != nil {
+		return deleted, fmt.Errorf("failed to save hash: %w", err)
+	}
+	return deleted, nil
+}
+
+// HGetAll returns every field of the hash at `key` along with its value, ordered by field; it's empty if the hash
+// doesn't exist.
+func (ks *KiwiStorage) HGetAll(db int, key []byte) ([]utils.BytePair, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := kdb.getCollection(key, HashType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return []utils.BytePair{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	fields := make([]utils.BytePair, 0, hash.count)
+	for pair, err := range kdb.members(hash, nil /*start*/) {
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, pair)
+	}
+	return fields, nil
+}
+
+// HScan returns the fields of the hash at `key` matching the given `cmd`, along with their values, and the field to
+// resume the scan from; same as Scan, at most `count` fields are examined.
+func (ks *KiwiStorage) HScan(db int, key []byte, cmd ScanCommand) ([]utils.BytePair, []byte /*next*/, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	hash, err := kdb.getCollection(key, HashType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return []utils.BytePair{}, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var next []byte
+	var scanErr error
+	members := untilError(kdb.members(hash, cmd.start), &scanErr)
+	pairs := func(yield func(utils.BytePair) bool) {
+		examined := 0
+		for pair := range members {
+			if cmd.count > 0 && examined == cmd.count {
+				next = pair.Key
+				return
+			}
+			examined++
+			if !yield(pair) {
+				return
+			}
+		}
+	}
+	if cmd.pattern != nil {
+		pairs = scan.MatchGlob(cmd.pattern, pairs)
+	}
+	fields := []utils.BytePair{}
+	for pair := range pairs {
+		fields = append(fields, pair)
+	}
+	if scanErr != nil {
+		return nil, nil, scanErr
+	}
+	return fields, next, nil
+}
+
+// HIncrBy adds `increment` to the integer value of the given `field` of the hash at `key`, which starts at zero if
+// it doesn't exist; it returns the new value.
+func (ks *KiwiStorage) HIncrBy(db int, key, field []byte, increment int64) (int64, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	hash, err := kdb.getOrCreateCollection(key, HashType, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	var current int64
+	value, err := kdb.getMember(hash, field)
+	if err == nil {
+		if current, err = strconv.ParseInt(string(value), 10 /*base*/, 64 /*bitSize*/); err != nil {
+			return 0, errors.New("hash value is not an integer")
+		}
+	} else if !errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, err
+	}
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		return 0, errors.New("increment or decrement would overflow")
+	}
+	current += increment
+	if _, err := kdb.setMember(&hash, field, strconv.AppendInt(nil, current, 10 /*base*/)); err != nil {
+		return 0, err
+	}
+	if err := kdb.saveCollection(key, hash); err != nil {
+		return 0, fmt.Errorf("failed to save hash: %w", err)
+	}
+	return current, nil
+}
@@ -0,0 +1,237 @@
+package port
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fieldPairs builds hash fields from alternating fields and values, e.g. "f1", "v1", "f2", "v2".
+func fieldPairs(fieldsAndValues ...string) []utils.BytePair {
+	pairs := make([]utils.BytePair, 0, len(fieldsAndValues)/2)
+	for i := 0; i+1 < len(fieldsAndValues); i += 2 {
+		pairs = append(pairs, utils.BytePair{Key: []byte(fieldsAndValues[i]), Value: []byte(fieldsAndValues[i+1])})
+	}
+	return pairs
+}
+
+// liveMembers returns the number of live member keys of every collection in the given database, once the members
+// of the replaced collections are reclaimed.
+func liveMembers(t *testing.T, store *KiwiStorage, db int) int {
+	t.Helper()
+	_, err := store.reclaimMembers(math.MaxInt)
+	require.NoError(t, err)
+	kdb, err := store.database(db)
+	require.NoError(t, err)
+	members := 0
+	for pair, err := range kdb.lsm.Scan(internalKeysStart, internalKeysEnd) {
+		require.NoError(t, err)
+		unpacked, err := unpack(pair.Value)
+		require.NoError(t, err)
+		if !unpacked.is(TombStone) {
+			members++
+		}
+	}
+	return members
+}
+
+func TestKiwiStorage_Hashes(t *testing.T) {
+	config.SetTestFlag(t, "enable_active_expiry", "false") // Expiry cycles are run manually.
+	store := newTestRedisHandler(t, "str").store
+	key := []byte("h")
+
+	added, err := store.HSet(0, key, fieldPairs("f1", "v1", "f2", "v2"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+	added, err = store.HSet(0, key, fieldPairs("f2", "v2'", "f3", "v3"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, added, "Expected existing fields to be overwritten rather than added")
+	value, err := store.HGet(0, key, []byte("f2"))
+	assert.NoError(t, err)
+	assert.Equal(t, "v2'", string(value))
+	_, err = store.HGet(0, key, []byte("missing"))
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+	values, err := store.HMGet(0, key, []byte("f1"), []byte("missing"), []byte("f3"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("v1"), nil, []byte("v3")}, values)
+	fields, err := store.HGetAll(0, key)
+	assert.NoError(t, err)
+	assert.Equal(t, fieldPairs("f1", "v1", "f2", "v2'", "f3", "v3"), fields)
+
+	incremented, err := store.HIncrBy(0, key, []byte("n"), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), incremented)
+	incremented, err = store.HIncrBy(0, key, []byte("n"), -7)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(-2), incremented)
+	_, err = store.HIncrBy(0, key, []byte("f1"), 1)
+	assert.Error(t, err, "Expected non-integer values to fail")
+	_, err = store.HIncrBy(0, key, []byte("n"), math.MinInt64)
+	assert.Error(t, err, "Expected overflows to fail")
+
+	deleted, err := store.HDel(0, key, []byte("f1"), []byte("missing"), []byte("n"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	deleted, err = store.HDel(0, key, []byte("f2"), []byte("f3"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, deleted)
+	exists, err := store.Exists(0, key)
+	require.NoError(t, err)
+	assert.False(t, exists, "Expected hashes to be deleted with their last field")
+
+	t.Run("wrong_type", func(t *testing.T) {
+		_, err := store.HSet(0, []byte("str"), fieldPairs("f", "v"))
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.HGetAll(0, []byte("str"))
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.HSet(0, []byte("hash"), fieldPairs("f", "v"))
+		require.NoError(t, err)
+		_, err = store.Get(0, []byte("hash"))
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.GetEx(0, GetExCommand{key: []byte("hash")})
+		assert.ErrorIs(t, err, errWrongType)
+		result := store.Set(0, SetCommand{key: []byte("hash"), value: []byte("v"), get: true})
+		assert.ErrorIs(t, result.err, errWrongType)
+	})
+	t.Run("delete", func(t *testing.T) {
+		_, err := store.HSet(0, key, fieldPairs("f1", "v1", "f2", "v2"))
+		require.NoError(t, err)
+		before := liveMembers(t, store, 0)
+		deleted, err := store.Delete(0, key)
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		assert.Equal(t, before-2, liveMembers(t, store, 0), "Expected the fields to be deleted with the hash")
+		_, err = store.HSet(0, key, fieldPairs("f2", "new"))
+		require.NoError(t, err)
+		fields, err := store.HGetAll(0, key)
+		assert.NoError(t, err)
+		assert.Equal(t, fieldPairs("f2", "new"), fields)
+	})
+	t.Run("expiry", func(t *testing.T) {
+		_, err := store.HSet(0, []byte("e"), fieldPairs("f1", "v1", "f2", "v2"))
+		require.NoError(t, err)
+		expiry := time.Now().Add(10 * time.Millisecond)
+		updated, err := store.Expire(0, ExpireCommand{key: []byte("e"), expiryTime: expiry})
+		require.NoError(t, err)
+		require.True(t, updated)
+		time.Sleep(20 * time.Millisecond)
+		_, err = store.HGet(0, []byte("e"), []byte("f1"))
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+		fields, err := store.HGetAll(0, []byte("e"))
+		assert.NoError(t, err)
+		assert.Empty(t, fields)
+
+		before := liveMembers(t, store, 0)
+		_, err = store.expireKeys(time.Now(), 10 /*limit*/)
+		require.NoError(t, err)
+		assert.Equal(t, before-2, liveMembers(t, store, 0), "Expected active expiry to delete the fields")
+	})
+	t.Run("overwrite", func(t *testing.T) {
+		before := liveMembers(t, store, 0)
+		_, err := store.HSet(0, []byte("o"), fieldPairs("f1", "v1", "f2", "v2"))
+		require.NoError(t, err)
+		require.NoError(t, store.Set(0, SetCommand{key: []byte("o"), value: []byte("v")}).err)
+		assert.Equal(t, before, liveMembers(t, store, 0), "Expected SET to delete the fields of the hash it overwrites")
+
+		_, err = store.HSet(0, []byte("o"), fieldPairs("f1", "v1"))
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.Delete(0, []byte("o"))
+		require.NoError(t, err)
+		_, err = store.HSet(0, []byte("o"), fieldPairs("f1", "v1", "f2", "v2"))
+		require.NoError(t, err)
+		expiry := time.Now().Add(10 * time.Millisecond)
+		updated, err := store.Expire(0, ExpireCommand{key: []byte("o"), expiryTime: expiry})
+		require.NoError(t, err)
+		require.True(t, updated)
+		time.Sleep(20 * time.Millisecond)
+		_, err = store.HSet(0, []byte("o"), fieldPairs("f3", "v3"))
+		require.NoError(t, err)
+		assert.Equal(t, before+1, liveMembers(t, store, 0),
+			"Expected the fields of the expired hash to be deleted once it's written again")
+		fields, err := store.HGetAll(0, []byte("o"))
+		assert.NoError(t, err)
+		assert.Equal(t, fieldPairs("f3", "v3"), fields)
+	})
+}
+
+func TestKiwiStorage_HScan(t *testing.T) {
+	store := newTestRedisHandler(t).store
+	key := []byte("h")
+	for i := range 10 {
+		_, err := store.HSet(0, key, fieldPairs("f"+strconv.Itoa(i), "v"))
+		require.NoError(t, err)
+	}
+	_, err := store.HSet(0, key, fieldPairs("other", "v"))
+	require.NoError(t, err)
+
+	var scanned []string
+	cmd := ScanCommand{pattern: []byte("f*"), count: 4}
+	for {
+		fields, next, err := store.HScan(0, key, cmd)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(fields), 4)
+		for _, field := range fields {
+			scanned = append(scanned, string(field.Key))
+		}
+		if next == nil {
+			break
+		}
+		cmd.start = next
+	}
+	assert.Equal(t, []string{"f0", "f1", "f2", "f3", "f4", "f5", "f6", "f7", "f8", "f9"}, scanned)
+
+	fields, next, err := store.HScan(0, []byte("missing"), ScanCommand{})
+	assert.NoError(t, err)
+	assert.Empty(t, fields)
+	assert.Nil(t, next)
+}
+
+func TestRedisHandler_Hashes(t *testing.T) {
+	handler := newTestRedisHandler(t, "str")
+	session := &redisSession{}
+	do := func(command string, args ...string) RedisOutput {
+		return handler.handle(session, newTestRedisCommand(command, args...))
+	}
+
+	assert.Equal(t, 2, *do("HSET", "h", "f1", "v1", "f2", "v2").writeInt)
+	assert.NotNil(t, do("HSET", "h", "f1").err)
+	assert.Equal(t, "v1", string(do("HGET", "h", "f1").writeBytes))
+	assert.True(t, do("HGET", "h", "missing").writeNil)
+	hmget := do("HMGET", "h", "f2", "missing")
+	require.Len(t, hmget.writeArray, 2)
+	assert.Equal(t, "v2", string(hmget.writeArray[0].writeBytes))
+	assert.True(t, hmget.writeArray[1].writeNil)
+	assert.Equal(t, []string{"f1", "v1", "f2", "v2"}, bulkStrings(t, do("HGETALL", "h")))
+	assert.Equal(t, 3, *do("HINCRBY", "h", "n", "3").writeInt)
+	assert.NotNil(t, do("HINCRBY", "h", "f1", "1").err)
+	assert.NotNil(t, do("HINCRBY", "h", "n", "x").err)
+	assert.Equal(t, 1, *do("HDEL", "h", "n").writeInt)
+
+	hscan := do("HSCAN", "h", "0", "MATCH", "*2")
+	require.Nil(t, hscan.err)
+	require.Len(t, hscan.writeArray, 2)
+	assert.Equal(t, scanCursorDone, string(hscan.writeArray[0].writeBytes))
+	assert.Equal(t, []string{"f2", "v2"}, bulkStrings(t, hscan.writeArray[1]))
+
+	// Fields are internal keys, so they never show up as Redis keys.
+	assert.Equal(t, 2, *do("DBSIZE").writeInt)
+	assert.Equal(t, []string{"h", "str"}, bulkStrings(t, do("KEYS", "*")))
+
+	wrongType := do("HGET", "str", "f1")
+	require.NotNil(t, wrongType.err)
+	assert.Regexp(t, "^WRONGTYPE ", *wrongType.err)
+	wrongType = do("GET", "h")
+	require.NotNil(t, wrongType.err)
+	assert.Regexp(t, "^WRONGTYPE ", *wrongType.err)
+
+	assert.Equal(t, 1, *do("DEL", "h").writeInt)
+	assert.Empty(t, bulkStrings(t, do("HGETALL", "h")))
+	assert.Equal(t, 0, *do("EXISTS", "h").writeInt)
+}
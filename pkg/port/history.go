@@ -0,0 +1,225 @@
+// Past values of Redis keys are kept for the history retention window of their table, see storage/history.go; so a
+// key can be read as of a past time, its recent changes can be listed, and the keys with a prefix can be restored to
+// what they were at a past time, e.g. to undo a bad batch of writes. Collections are restored along with their
+// members, which are versioned like any other LSM key.
+
+package port
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
+)
+
+// GetAsOf returns the value of the given string `key` as of the given time; ErrKeyNotFound if it didn't exist then,
+// errWrongType if it held another type, or storage.ErrBeyondHistory if the time is before the retention window.
+func (ks *KiwiStorage) GetAsOf(db int, key []byte, t time.Time) ([]byte, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	packed, err := kdb.lsm.GetAsOf(storageKey(key), t)
+	if err != nil {
+		return nil, err
+	}
+	unpacked, err := unpack(packed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack value of key %q: %w", key, err)
+	}
+	if unpacked.is(TombStone) || unpacked.isExpiredAt(t) {
+		return nil, storage.ErrKeyNotFound
+	}
+	if unpacked.valueType != StringType {
+		return nil, errWrongType
+	}
+	return unpacked.value, nil
+}
+
+// KeyRevision is a past value of a Redis key.
+type KeyRevision struct {
+	commitTime time.Time     // Zero for the values written before commit times.
+	value      unpackedValue // A tombstone for deletes.
+}
+
+// History returns up to `count` retained values of the given `key`, newest first; every retained value if `count`
+// isn't positive. Returns ErrKeyNotFound if the key has none, see storage.LSMTree.History.
+func (ks *KiwiStorage) History(db int, key []byte, count int) ([]KeyRevision, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := kdb.lsm.History(storageKey(key), count)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]KeyRevision, len(revisions))
+	for i, revision := range revisions {
+		unpacked, err := unpack(revision.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unpack value of key %q: %w", key, err)
+		}
+		history[i] = KeyRevision{commitTime: revision.CommitTime, value: unpacked}
+	}
+	return history, nil
+}
+
+// restoreBatchKeys is the number of keys that RestorePrefix scans for each batch, under the write lock.
+const restoreBatchKeys = 128
+
+// RestorePrefix restores the keys with the given non-empty `prefix` to their values as of the given time, along with
+// the members of collections; the keys which didn't exist then, or have expired since, are deleted. It returns the
+// number of keys that were changed. Keys are restored in batches, in key order, and the write lock is released between
+// the batches so that other commands don't wait for the whole restore; so a key written meanwhile is only restored if
+// its batch hasn't been restored yet.
+func (ks *KiwiStorage) RestorePrefix(db int, prefix []byte, t time.Time) (int, error) {
+	if len(prefix) == 0 {
+		return 0, errors.New("expected a non-empty prefix")
+	}
+	changed, start := 0, prefix
+	for start != nil {
+		var restored int
+		var err error
+		restored, start, err = ks.restoreBatch(db, prefix, start, t)
+		changed += restored
+		if err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// restoreBatch restores the keys with the given `prefix` from `start` on, up to about restoreBatchKeys of them; see
+// RestorePrefix. It returns the number of keys that were changed, and where the next batch starts, or nil if there
+// are no keys left.
+func (ks *KiwiStorage) restoreBatch(db int, prefix, start []byte, t time.Time) (int, []byte /*next*/, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, nil, err
+	}
+	view, err := kdb.lsm.AsOf(t)
+	if err != nil {
+		return 0, nil, err
+	}
+	// The keys are collected beforehand, as restoring them changes the current keys. Each scan stops after a batch of
+	// keys, and the batch ends at the last key that both scans got to.
+	past := make(map[string]unpackedValue)
+	var keys [][]byte
+	var last []byte // The last key of the batch; nil if it goes on to the end of the prefix.
+	for pair, err := range scanRedisKeys(view, start) {
+		if err != nil {
+			return 0, nil, err
+		}
+		if !bytes.HasPrefix(pair.Key, prefix) {
+			break
+		}
+		if len(keys) == restoreBatchKeys {
+			last = keys[len(keys)-1]
+			break
+		}
+		unpacked, err := unpack(pair.Value)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to unpack value of key %q: %w", pair.Key, err)
+		}
+		keys = append(keys, pair.Key)
+		if !unpacked.is(TombStone) && !unpacked.isExpiredAt(t) {
+			past[string(pair.Key)] = unpacked
+		}
+	}
+	var current [][]byte
+	for pair, err := range scanRedisKeys(kdb.lsm, start) {
+		if err != nil {
+			return 0, nil, err
+		}
+		if !bytes.HasPrefix(pair.Key, prefix) || (last != nil && bytes.Compare(pair.Key, last) > 0) {
+			break
+		}
+		if len(current) == restoreBatchKeys {
+			last = current[len(current)-1]
+			break
+		}
+		current = append(current, pair.Key)
+	}
+	keys = append(keys, current...)
+	slices.SortFunc(keys, bytes.Compare)
+	keys = slices.CompactFunc(keys, bytes.Equal)
+
+	now, changed := time.Now(), 0
+	for _, key := range keys {
+		if last != nil && bytes.Compare(key, last) > 0 {
+			break
+		}
+		value, existed := past[string(key)]
+		var restored bool
+		if !existed || value.isExpiredAt(now) {
+			restored, err = kdb.delete(key)
+		} else {
+			restored, err = kdb.restore(view, key, value, now)
+		}
+		if err != nil {
+			return changed, nil, fmt.Errorf("failed to restore key %q: %w", key, err)
+		}
+		if restored {
+			changed++
+		}
+	}
+	if last == nil {
+		return changed, nil, nil
+	}
+	return changed, append(bytes.Clone(last), 0x00), nil // Right after the last key.
+}
+
+// restore writes the given past value of `key`, and returns false if it's a string which already has the value. The
+// current value is deleted beforehand, along with the members of its collection; a restored collection gets a new
+// version, and its members are copied from the given view.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) restore(view *storage.PastView, key []byte, value unpackedValue, now time.Time) (bool, error) {
+	current, err := kdb.get(key)
+	if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return false, err
+	}
+	if value.valueType == StringType && bytes.Equal(current, value.pack()) {
+		return false, nil
+	}
+	if _, err := kdb.delete(key); err != nil {
+		return false, err
+	}
+	if value.valueType == StringType {
+		return true, kdb.set(key, value)
+	}
+
+	pastCollection, err := decodeCollection(key, value)
+	if err != nil {
+		return false, err
+	}
+	c, err := kdb.getOrCreateCollection(key, value.valueType, now)
+	if err != nil {
+		return false, err
+	}
+	for pair, err := range view.ScanPrefix(pastCollection.prefix) {
+		if err != nil {
+			return false, err
+		}
+		unpacked, err := unpack(pair.Value)
+		if err != nil {
+			return false, fmt.Errorf("failed to unpack member %q: %w", pair.Key, err)
+		}
+		if unpacked.is(TombStone) {
+			continue
+		}
+		if err := kdb.putMember(c, pair.Key[len(pastCollection.prefix):], unpacked.value); err != nil {
+			return false, err
+		}
+	}
+	// The members are counted as they were, since some types store more than one key per member, e.g. sorted sets.
+	c.value, c.count, c.head = value, pastCollection.count, pastCollection.head
+	if err := kdb.saveCollection(key, c); err != nil {
+		return false, fmt.Errorf("failed to save collection: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,217 @@
+package port
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pastTime returns the current time, after sleeping for a bit so that it's apart from the writes around it.
+func pastTime() time.Time {
+	time.Sleep(2 * time.Millisecond)
+	defer time.Sleep(2 * time.Millisecond)
+	return time.Now()
+}
+
+func TestKiwiStorage_GetAsOf(t *testing.T) {
+	config.SetTestFlag(t, "history_retention", "1h")
+	store := newTestRedisHandler(t).store
+	key := []byte("k")
+	set := func(key []byte, value string, expiry time.Time) {
+		t.Helper()
+		require.NoError(t, store.Set(0, SetCommand{key: key, value: []byte(value), expiryTime: expiry}).err)
+	}
+
+	beforeWrites := pastTime()
+	set(key, "v1", time.Time{})
+	afterV1 := pastTime()
+	set(key, "v2", time.Time{})
+	afterV2 := pastTime()
+	_, err := store.Delete(0, key)
+	require.NoError(t, err)
+	afterDelete := pastTime()
+
+	for at, want := range map[time.Time]string{afterV1: "v1", afterV2: "v2"} {
+		value, err := store.GetAsOf(0, key, at)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(value))
+	}
+	for _, at := range []time.Time{beforeWrites, afterDelete} {
+		_, err := store.GetAsOf(0, key, at)
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+	}
+	_, err = store.GetAsOf(0, key, time.Now().Add(-2*time.Hour))
+	assert.ErrorIs(t, err, storage.ErrBeyondHistory)
+
+	history, err := store.History(0, key, 0 /*count*/)
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.True(t, history[0].value.is(TombStone))
+	assert.Equal(t, "v2", string(history[1].value.value))
+	assert.Equal(t, "v1", string(history[2].value.value))
+	assert.True(t, history[2].commitTime.After(beforeWrites) && history[2].commitTime.Before(afterV1))
+
+	t.Run("expiry", func(t *testing.T) {
+		set([]byte("e"), "v", time.Now().Add(10*time.Millisecond))
+		beforeExpiry := pastTime()
+		time.Sleep(20 * time.Millisecond)
+		value, err := store.GetAsOf(0, []byte("e"), beforeExpiry)
+		assert.NoError(t, err)
+		assert.Equal(t, "v", string(value))
+		_, err = store.GetAsOf(0, []byte("e"), time.Now())
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+	})
+	t.Run("wrong_type", func(t *testing.T) {
+		_, err := store.HSet(0, []byte("h"), fieldPairs("f", "v"))
+		require.NoError(t, err)
+		_, err = store.GetAsOf(0, []byte("h"), pastTime())
+		assert.ErrorIs(t, err, errWrongType)
+	})
+}
+
+func TestKiwiStorage_RestorePrefix(t *testing.T) {
+	config.SetTestFlag(t, "history_retention", "1h")
+	store := newTestRedisHandler(t).store
+	set := func(key, value string) {
+		t.Helper()
+		require.NoError(t, store.Set(0, SetCommand{key: []byte(key), value: []byte(value)}).err)
+	}
+	get := func(key string) string {
+		t.Helper()
+		value, err := store.Get(0, []byte(key))
+		if err != nil {
+			return err.Error()
+		}
+		return string(value)
+	}
+
+	set("app:s", "v1")
+	_, err := store.HSet(0, []byte("app:h"), fieldPairs("f1", "v1", "f2", "v2"))
+	require.NoError(t, err)
+	_, err = store.Push(0, []byte("app:l"), false /*left*/, []byte("a"), []byte("b"), []byte("c"))
+	require.NoError(t, err)
+	set("other", "x1")
+	restorePoint := pastTime()
+
+	set("app:s", "v2")
+	_, err = store.HSet(0, []byte("app:h"), fieldPairs("f1", "changed", "f3", "v3"))
+	require.NoError(t, err)
+	_, err = store.HDel(0, []byte("app:h"), []byte("f2"))
+	require.NoError(t, err)
+	_, err = store.Pop(0, []byte("app:l"), true /*left*/, 1 /*count*/)
+	require.NoError(t, err)
+	_, err = store.Push(0, []byte("app:l"), false /*left*/, []byte("d"))
+	require.NoError(t, err)
+	set("app:new", "v")
+	set("other", "x2")
+
+	changed, err := store.RestorePrefix(0, []byte("app:"), restorePoint)
+	require.NoError(t, err)
+	assert.Equal(t, 4, changed)
+	assert.Equal(t, "v1", get("app:s"))
+	fields, err := store.HGetAll(0, []byte("app:h"))
+	require.NoError(t, err)
+	assert.Equal(t, fieldPairs("f1", "v1", "f2", "v2"), fields)
+	elements, err := store.LRange(0, []byte("app:l"), 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, elements)
+	assert.Equal(t, storage.ErrKeyNotFound.Error(), get("app:new"))
+	assert.Equal(t, "x2", get("other"), "Expected keys without the prefix to be left alone")
+	assert.Equal(t, 5, liveMembers(t, store, 0), "Expected the members of the replaced collections to be deleted")
+
+	// Restored keys are regular writes, so they can be restored back.
+	changed, err = store.RestorePrefix(0, []byte("app:s"), time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, changed, "Expected strings which already have their past value to be left alone")
+	_, err = store.RestorePrefix(0, []byte("app:"), time.Now().Add(-2*time.Hour))
+	assert.ErrorIs(t, err, storage.ErrBeyondHistory)
+	_, err = store.RestorePrefix(0, nil /*prefix*/, restorePoint)
+	assert.Error(t, err, "Expected restoring every key to be refused")
+}
+
+func TestKiwiStorage_RestorePrefixBatches(t *testing.T) {
+	config.SetTestFlag(t, "history_retention", "1h")
+	store := newTestRedisHandler(t).store
+	set := func(key string) {
+		t.Helper()
+		require.NoError(t, store.Set(0, SetCommand{key: []byte(key), value: []byte("v")}).err)
+	}
+
+	// The past and the current keys interleave, so that the scans of each batch end at different keys.
+	const keys = 3*restoreBatchKeys + 1
+	for i := range keys {
+		set(fmt.Sprintf("p:%04d:past", i))
+	}
+	restorePoint := pastTime()
+	for i := range keys {
+		set(fmt.Sprintf("p:%04d:new", i))
+		if i%3 == 0 {
+			_, err := store.Delete(0, []byte(fmt.Sprintf("p:%04d:past", i)))
+			require.NoError(t, err)
+		}
+	}
+
+	changed, err := store.RestorePrefix(0, []byte("p:"), restorePoint)
+	require.NoError(t, err)
+	assert.Equal(t, keys+(keys+2)/3, changed)
+	for i := range keys {
+		_, err := store.Get(0, []byte(fmt.Sprintf("p:%04d:past", i)))
+		assert.NoError(t, err, "Expected key %d to be restored", i)
+		_, err = store.Get(0, []byte(fmt.Sprintf("p:%04d:new", i)))
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound, "Expected key %d to be deleted", i)
+	}
+}
+
+func TestRedisHandler_Kiwi(t *testing.T) {
+	config.SetTestFlag(t, "history_retention", "1h")
+	handler := newTestRedisHandler(t, "k")
+	session := &redisSession{}
+	do := func(command string, args ...string) RedisOutput {
+		return handler.handle(session, newTestRedisCommand(command, args...))
+	}
+	millis := func(t time.Time) string { return strconv.FormatInt(t.UnixMilli(), 10) }
+
+	assert.Equal(t, 1, *do("HSET", "h", "f", "v").writeInt)
+	written := pastTime()
+	assert.Equal(t, 1, *do("DEL", "k").writeInt)
+	deleted := pastTime()
+
+	assert.Equal(t, "v", string(do("KIWI", "GETASOF", "k", millis(written)).writeBytes))
+	assert.True(t, do("KIWI", "getasof", "k", millis(deleted)).writeNil)
+	wrongType := do("KIWI", "GETASOF", "h", millis(written))
+	require.NotNil(t, wrongType.err)
+	assert.Regexp(t, "^WRONGTYPE ", *wrongType.err)
+	assert.NotNil(t, do("KIWI", "GETASOF", "k", "0").err, "Expected times beyond the history to fail")
+	assert.NotNil(t, do("KIWI", "GETASOF", "k").err)
+	assert.NotNil(t, do("KIWI", "GETASOF", "k", "x").err)
+
+	history := do("KIWI", "HISTORY", "k")
+	require.Nil(t, history.err)
+	require.Len(t, history.writeArray, 2)
+	deleteEntry, setEntry := history.writeArray[0].writeArray, history.writeArray[1].writeArray
+	require.Len(t, deleteEntry, 3)
+	assert.GreaterOrEqual(t, *deleteEntry[0].writeInt, int(written.UnixMilli()))
+	assert.Equal(t, "none", string(deleteEntry[1].writeBytes))
+	assert.True(t, deleteEntry[2].writeNil)
+	require.Len(t, setEntry, 3)
+	assert.LessOrEqual(t, *setEntry[0].writeInt, *deleteEntry[0].writeInt)
+	assert.Equal(t, "string", string(setEntry[1].writeBytes))
+	assert.Equal(t, "v", string(setEntry[2].writeBytes))
+	assert.Len(t, do("KIWI", "HISTORY", "k", "COUNT", "1").writeArray, 1)
+	assert.Equal(t, "hash", string(do("KIWI", "HISTORY", "h").writeArray[0].writeArray[1].writeBytes))
+	assert.True(t, do("KIWI", "HISTORY", "h").writeArray[0].writeArray[2].writeNil)
+	assert.Empty(t, do("KIWI", "HISTORY", "missing").writeArray)
+	assert.NotNil(t, do("KIWI", "HISTORY", "k", "COUNT", "0").err)
+	assert.NotNil(t, do("KIWI", "HISTORY", "k", "LIMIT", "1").err)
+
+	assert.Equal(t, 1, *do("KIWI", "RESTORE", "k", millis(written)).writeInt)
+	assert.Equal(t, "v", string(do("GET", "k").writeBytes))
+	assert.NotNil(t, do("KIWI", "UNKNOWN").err)
+	assert.NotNil(t, do("KIWI").err)
+}
@@ -0,0 +1,216 @@
+// Redis lists are sequences of elements that are pushed and popped at either end; each element is stored as its own
+// member key under its position, see collections.go. New lists start from the middle of the positions, so that they
+// can grow in both directions.
+
+package port
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
+)
+
+// initialListHead is the position of the first element of new lists.
+const initialListHead = 1 << 63
+
+// listPosition returns the member of the element at the given position, which sorts in the order of positions.
+func listPosition(position uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, position)
+}
+
+// listRange normalizes the given Redis range, whose negative indexes count from the end of a list of `length`
+// elements; it returns false if the range is empty.
+func listRange(start, stop, length int) (int, int, bool /*nonEmpty*/) {
+	if start < 0 {
+		start = max(start+length, 0)
+	}
+	if stop < 0 {
+		stop += length
+	}
+	stop = min(stop, length-1)
+	return start, stop, start <= stop
+}
+
+// Push adds the given elements to the head of the list at `key`, or its tail unless `left`, creating it if it doesn't
+// exist; it returns the length of the list, e.g. the Redis LPUSH and RPUSH commands.
+func (ks *KiwiStorage) Push(db int, key []byte, left bool, elements ...[]byte) (int, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	list, err := kdb.getOrCreateCollection(key, ListType, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if list.count == 0 {
+		list.head = initialListHead
+	}
+	for _, element := range elements {
+		position := list.head + uint64(list.count)
+		if left {
+			list.head--
+			position = list.head
+		}
+		if err := kdb.putMember(list, listPosition(position), element); err != nil {
+			return 0, err
+		}
+		list.count++
+	}
+	if err := kdb.saveCollection(key, list); err != nil {
+		return 0, fmt.Errorf("failed to save list: %w", err)
+	}
+	return list.count, nil
+}
+
+// Pop removes up to `count` elements from the head of the list at `key`, or its tail unless `left`, and returns them
+// in the order they're removed; the list is deleted once it's empty. Returns ErrKeyNotFound if the list doesn't exist.
+func (ks *KiwiStorage) Pop(db int, key []byte, left bool, count int) ([][]byte, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return nil, err
+	}
+	list, err := kdb.getCollection(key, ListType, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	elements := make([][]byte, 0, min(count, list.count))
+	for len(elements) < count && list.count > 0 {
+		position := list.head + uint64(list.count) - 1
+		if left {
+			position = list.head
+		}
+		element, err := kdb.getMember(list, listPosition(position))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get element %d: %w", position-list.head, err)
+		}
+		if err := kdb.eraseMember(list, listPosition(position)); err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		if left {
+			list.head++
+		}
+		list.count--
+	}
+	if len(elements) == 0 {
+		return elements, nil
+	}
+	if err := kdb.saveCollection(key, list); err != nil {
+		return nil, fmt.Errorf("failed to save list: %w", err)
+	}
+	return elements, nil
+}
+
+// LRange returns the elements of the list at `key` from `start` to `stop` inclusive, whose negative indexes count
+// from the end of the list; it's empty if the list doesn't exist.
+func (ks *KiwiStorage) LRange(db int, key []byte, start, stop int) ([][]byte, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	elements := [][]byte{}
+	list, err := kdb.getCollection(key, ListType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return elements, nil
+	} else if err != nil {
+		return nil, err
+	}
+	start, stop, nonEmpty := listRange(start, stop, list.count)
+	if !nonEmpty {
+		return elements, nil
+	}
+	for pair, err := range kdb.members(list, listPosition(list.head+uint64(start))) {
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, pair.Value)
+		if len(elements) == stop-start+1 {
+			break
+		}
+	}
+	return elements, nil
+}
+
+// LIndex returns the element at the given `index` of the list at `key`, where negative indexes count from the end of
+// the list; or ErrKeyNotFound if the index is out of range.
+func (ks *KiwiStorage) LIndex(db int, key []byte, index int) ([]byte, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	list, err := kdb.getCollection(key, ListType, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 {
+		index += list.count
+	}
+	if index < 0 || index >= list.count {
+		return nil, storage.ErrKeyNotFound
+	}
+	return kdb.getMember(list, listPosition(list.head+uint64(index)))
+}
+
+// LLen returns the length of the list at `key`, which is zero if it doesn't exist.
+func (ks *KiwiStorage) LLen(db int, key []byte) (int, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return 0, err
+	}
+	list, err := kdb.getCollection(key, ListType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return list.count, nil
+}
+
+// LTrim keeps the elements of the list at `key` from `start` to `stop` inclusive, see LRange, and removes the rest;
+// the list is deleted if none is kept.
+func (ks *KiwiStorage) LTrim(db int, key []byte, start, stop int) error {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return err
+	}
+	list, err := kdb.getCollection(key, ListType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	start, stop, nonEmpty := listRange(start, stop, list.count)
+	if !nonEmpty {
+		_, err := kdb.delete(key)
+		return err
+	}
+	// Only the removed elements are visited, as trimming capped lists usually removes a few of them.
+	for index := range start {
+		if err := kdb.eraseMember(list, listPosition(list.head+uint64(index))); err != nil {
+			return err
+		}
+	}
+	for index := stop + 1; index < list.count; index++ {
+		if err := kdb.eraseMember(list, listPosition(list.head+uint64(index))); err != nil {
+			return err
+		}
+	}
+	if start == 0 && stop == list.count-1 {
+		return nil
+	}
+	list.head += uint64(start)
+	list.count = stop - start + 1
+	if err := kdb.saveCollection(key, list); err != nil {
+		return fmt.Errorf("failed to save list: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package port
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListRange(t *testing.T) {
+	for _, testCase := range []struct {
+		start, stop, wantStart, wantStop int
+		wantNonEmpty                     bool
+	}{
+		{start: 0, stop: -1, wantStart: 0, wantStop: 4, wantNonEmpty: true},
+		{start: 1, stop: 2, wantStart: 1, wantStop: 2, wantNonEmpty: true},
+		{start: -3, stop: 100, wantStart: 2, wantStop: 4, wantNonEmpty: true},
+		{start: -100, stop: 0, wantStart: 0, wantStop: 0, wantNonEmpty: true},
+		{start: 3, stop: 1, wantNonEmpty: false},
+		{start: 5, stop: 10, wantNonEmpty: false},
+		{start: 0, stop: -6, wantNonEmpty: false},
+	} {
+		start, stop, nonEmpty := listRange(testCase.start, testCase.stop, 5 /*length*/)
+		assert.Equal(t, testCase.wantNonEmpty, nonEmpty, "Unexpected range of [%d, %d]", testCase.start, testCase.stop)
+		if testCase.wantNonEmpty {
+			assert.Equal(t, testCase.wantStart, start)
+			assert.Equal(t, testCase.wantStop, stop)
+		}
+	}
+}
+
+func TestKiwiStorage_Lists(t *testing.T) {
+	store := newTestRedisHandler(t, "str").store
+	key := []byte("l")
+	// lrange returns the elements of the list as strings.
+	lrange := func(start, stop int) []string {
+		t.Helper()
+		elements, err := store.LRange(0, key, start, stop)
+		require.NoError(t, err)
+		values := make([]string, len(elements))
+		for i, element := range elements {
+			values[i] = string(element)
+		}
+		return values
+	}
+
+	length, err := store.Push(0, key, false /*left*/, []byte("c"), []byte("d"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, length)
+	length, err = store.Push(0, key, true /*left*/, []byte("b"), []byte("a"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, length)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, lrange(0, -1))
+	assert.Equal(t, []string{"b", "c"}, lrange(1, -2))
+	assert.Empty(t, lrange(3, 1))
+	element, err := store.LIndex(0, key, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, "d", string(element))
+	_, err = store.LIndex(0, key, 4)
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	popped, err := store.Pop(0, key, true /*left*/, 1 /*count*/)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a")}, popped)
+	popped, err = store.Pop(0, key, false /*left*/, 2 /*count*/)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("d"), []byte("c")}, popped)
+	length, err = store.LLen(0, key)
+	require.NoError(t, err)
+	assert.Equal(t, 1, length)
+	popped, err = store.Pop(0, key, true /*left*/, 10 /*count*/)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("b")}, popped)
+	_, err = store.Pop(0, key, true /*left*/, 1 /*count*/)
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound, "Expected lists to be deleted with their last element")
+	length, err = store.LLen(0, key)
+	require.NoError(t, err)
+	assert.Zero(t, length)
+
+	t.Run("capped", func(t *testing.T) {
+		before := liveMembers(t, store, 0)
+		for i := range 10 {
+			_, err := store.Push(0, key, true /*left*/, []byte(strconv.Itoa(i)))
+			require.NoError(t, err)
+			require.NoError(t, store.LTrim(0, key, 0, 2))
+		}
+		assert.Equal(t, []string{"9", "8", "7"}, lrange(0, -1))
+		assert.Equal(t, before+3, liveMembers(t, store, 0), "Expected trimmed elements to be deleted")
+		require.NoError(t, store.LTrim(0, key, -2, -1))
+		assert.Equal(t, []string{"8", "7"}, lrange(0, -1))
+		require.NoError(t, store.LTrim(0, key, 5, 10))
+		exists, err := store.Exists(0, key)
+		require.NoError(t, err)
+		assert.False(t, exists, "Expected lists to be deleted when nothing is kept")
+		assert.Equal(t, before, liveMembers(t, store, 0))
+	})
+	t.Run("wrong_type", func(t *testing.T) {
+		_, err := store.Push(0, []byte("str"), true /*left*/, []byte("v"))
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.LLen(0, []byte("str"))
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.HSet(0, []byte("hash"), fieldPairs("f", "v"))
+		require.NoError(t, err)
+		_, err = store.LRange(0, []byte("hash"), 0, -1)
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.HGet(0, []byte("list"), []byte("f"))
+		assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+		_, err = store.Push(0, []byte("list"), true /*left*/, []byte("v"))
+		require.NoError(t, err)
+		_, err = store.HGet(0, []byte("list"), []byte("f"))
+		assert.ErrorIs(t, err, errWrongType)
+	})
+}
+
+func TestRedisHandler_Lists(t *testing.T) {
+	handler := newTestRedisHandler(t, "str")
+	session := &redisSession{}
+	do := func(command string, args ...string) RedisOutput {
+		return handler.handle(session, newTestRedisCommand(command, args...))
+	}
+
+	assert.Equal(t, 3, *do("RPUSH", "l", "a", "b", "c").writeInt)
+	assert.Equal(t, 4, *do("LPUSH", "l", "z").writeInt)
+	assert.Equal(t, []string{"z", "a", "b", "c"}, bulkStrings(t, do("LRANGE", "l", "0", "-1")))
+	assert.NotNil(t, do("LRANGE", "l", "0", "x").err)
+	assert.Equal(t, "b", string(do("LINDEX", "l", "2").writeBytes))
+	assert.True(t, do("LINDEX", "l", "10").writeNil)
+	assert.Equal(t, 4, *do("LLEN", "l").writeInt)
+	assert.Equal(t, "z", string(do("LPOP", "l").writeBytes))
+	assert.Equal(t, []string{"c", "b"}, bulkStrings(t, do("RPOP", "l", "2")))
+	assert.NotNil(t, do("RPOP", "l", "-1").err)
+	assert.Equal(t, "OK", string(do("LTRIM", "l", "1", "-1").writeBytes))
+	assert.True(t, do("LPOP", "l").writeNil)
+	assert.True(t, do("LPOP", "l", "2").writeNil)
+	assert.Equal(t, 0, *do("LLEN", "l").writeInt)
+
+	wrongType := do("LPUSH", "str", "a")
+	require.NotNil(t, wrongType.err)
+	assert.Regexp(t, "^WRONGTYPE ", *wrongType.err)
+}
@@ -2,13 +2,19 @@
 // 1) Tombstone: A marker indicating that the key has been deleted.
 // 2) Regular  : A normal key-value pair without expiration.
 // 3) Expirable: A key-value pair with an expiration time.
+//
+// The options are packed into the low bits of the first byte, and the Redis type of the value into its high bits;
+// values packed before types have zero high bits, i.e. they're strings.
 
 package port
 
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"time"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
 )
 
 // Opts represents options for storing a key-value pair.
@@ -28,6 +34,31 @@ const (
 	Expirable
 )
 
+// optsMask masks the options out of the first byte of a packed value, the rest of which is its type.
+const optsMask = 0x0f
+
+// ValueType is the Redis type of a value.
+type ValueType uint8
+
+const (
+	StringType    ValueType = iota
+	HashType                // The value holds the metadata of a hash, whose fields are stored as their own keys.
+	ListType                // The value holds the metadata of a list, whose elements are stored as their own keys.
+	SetType                 // The value holds the metadata of a set, whose members are stored as their own keys.
+	SortedSetType           // The value holds the metadata of a sorted set, whose members are stored as their own keys.
+)
+
+// valueTypeNames maps each type to its name, as in the replies of the Redis TYPE command.
+var valueTypeNames = map[ValueType]string{
+	StringType: "string", HashType: "hash", ListType: "list", SetType: "set", SortedSetType: "zset"}
+
+func (t ValueType) String() string {
+	if name, known := valueTypeNames[t]; known {
+		return name
+	}
+	return fmt.Sprintf("type(%d)", uint8(t))
+}
+
 var (
 	tombstoneUnpacked = unpackedValue{opt: TombStone}
 	tombstonePacked   = tombstoneUnpacked.pack()
@@ -36,9 +67,10 @@ var (
 
 // unpackedValue represents a value that has been unpacked from the storage format.
 type unpackedValue struct {
-	opt    Opts
-	value  []byte
-	expiry time.Time
+	opt       Opts
+	valueType ValueType
+	value     []byte
+	expiry    time.Time
 }
 
 // unpack deserializes the packed byte slice into an unpackedValue struct.
@@ -46,7 +78,7 @@ func unpack(packed []byte) (unpackedValue, error) {
 	if len(packed) == 0 {
 		return emptyUnpacked, errors.New("value is emptyUnpacked")
 	}
-	opt := Opts(packed[0])
+	opt, valueType := Opts(packed[0]&optsMask), ValueType(packed[0]>>4)
 	if opt.is(TombStone) {
 		return tombstoneUnpacked, nil
 	}
@@ -64,7 +96,7 @@ func unpack(packed []byte) (unpackedValue, error) {
 		value = packed[1:]
 	}
 
-	return unpackedValue{opt: opt, value: value, expiry: expiryTime}, nil
+	return unpackedValue{opt: opt, valueType: valueType, value: value, expiry: expiryTime}, nil
 }
 
 // packs serializes the options and the value into a single byte slice.
@@ -74,7 +106,7 @@ func (uv unpackedValue) pack() []byte {
 		outputSize += 8 // 8 bytes for the expiry time.
 	}
 	buffer := make([]byte, outputSize)
-	buffer[0] = byte(uv.opt)
+	buffer[0] = byte(uv.opt) | byte(uv.valueType)<<4
 	if uv.opt.is(TombStone) {
 		return buffer
 	}
@@ -87,9 +119,33 @@ func (uv unpackedValue) pack() []byte {
 }
 
 func (uv unpackedValue) isExpired() bool {
-	return uv.opt.is(Expirable) && !uv.expiry.IsZero() && time.Now().After(uv.expiry)
+	return uv.isExpiredAt(time.Now())
+}
+
+func (uv unpackedValue) isExpiredAt(now time.Time) bool {
+	return uv.opt.is(Expirable) && !uv.expiry.IsZero() && now.After(uv.expiry)
 }
 
 func (uv unpackedValue) is(opt Opts) bool {
 	return uv.opt.is(opt)
 }
+
+// packedValueInspector lets the storage layer tell tombstones and expired values apart, e.g. to drop them during
+// compactions.
+type packedValueInspector struct{} // Implements storage.ValueInspector.
+
+var _ storage.ValueInspector = packedValueInspector{}
+
+// IsLive returns false for tombstones and expired values; values that can't be unpacked are kept to be safe.
+func (packedValueInspector) IsLive(value []byte, now time.Time) bool {
+	unpacked, err := unpack(value)
+	if err != nil {
+		return true
+	}
+	return !unpacked.is(TombStone) && !unpacked.isExpiredAt(now)
+}
+
+// Tombstone returns the packed tombstone, which is written for deleted keys.
+func (packedValueInspector) Tombstone() []byte {
+	return tombstonePacked
+}
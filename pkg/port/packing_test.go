@@ -33,6 +33,17 @@ func TestUnpackedValue(t *testing.T) {
 			unpacked:        unpackedValue{opt: Expirable, value: []byte("v"), expiry: time.Now().Add(1 * time.Hour)},
 			shouldBeExpired: false, // Will expire in 1 hour.
 		},
+		{
+			name:            "hash",
+			unpacked:        unpackedValue{valueType: HashType, value: []byte("meta")},
+			shouldBeExpired: false,
+		},
+		{
+			name: "expirable_hash",
+			unpacked: unpackedValue{opt: Expirable, valueType: HashType, value: []byte("meta"),
+				expiry: time.Now().Add(-1 * time.Hour)},
+			shouldBeExpired: true,
+		},
 	} {
 		t.Run(testCase.name, func(t *testing.T) {
 			packed := testCase.unpacked.pack()
@@ -40,6 +51,7 @@ func TestUnpackedValue(t *testing.T) {
 			assert.NoError(t, err)
 			if testCase.unpacked.opt.is(Expirable) {
 				assert.Equal(t, testCase.unpacked.opt, unpackedAgain.opt)
+				assert.Equal(t, testCase.unpacked.valueType, unpackedAgain.valueType)
 				assert.Equal(t, testCase.unpacked.value, unpackedAgain.value)
 				assert.Equal(t, testCase.unpacked.expiry.UnixNano(), unpackedAgain.expiry.UnixNano())
 			} else {
@@ -0,0 +1,220 @@
+// Deleting or overwriting a collection only replaces its metadata key, which hides its members right away as their
+// keys hold the version of the collection, see collections.go. Deleting the member keys along with it would take a
+// write per member under the storage lock, e.g. blocking every command while a hash of a million fields is deleted;
+// so they're reclaimed in the background instead. The replaced collection is recorded by an orphan key, i.e. an
+// internal key holding the prefix of its member keys, which is written right after the collection is replaced. Each
+// reclaim cycle deletes a bounded number of members of the orphans of each database, and then the orphan keys whose
+// members are gone. Orphan keys are stored along with the rest of the keys, so reclaiming goes on where it left off
+// when the database is opened again.
+
+package port
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	memberReclaimEnabled = flag.Bool("enable_member_reclaim", true,
+		"Delete the members of deleted or overwritten collections in the background.")
+	memberReclaimInterval = flag.Duration("member_reclaim_interval", 100*time.Millisecond,
+		"The interval between member reclaim cycles.")
+	memberReclaimKeysPerCycle = flag.Int("member_reclaim_keys_per_cycle", 1000,
+		"The maximum number of member keys deleted per database in each member reclaim cycle.")
+
+	reclaimedMembersTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "reclaimed_members_total",
+		Help: "Total number of member keys of deleted or overwritten collections deleted in the background.",
+	})
+)
+
+// orphanKeysPrefix is the prefix of orphan keys, which are followed by the member prefix of their collection; its tag
+// differs from the tags of member keys, so the two never collide.
+var orphanKeysPrefix = []byte{internalKeyPrefix, 'o'}
+
+// orphanKey returns the orphan key of the given collection.
+func orphanKey(c collection) []byte {
+	return append(bytes.Clone(orphanKeysPrefix), c.prefix...)
+}
+
+// orphan records the given collection, which has just been deleted or replaced, so that its members are reclaimed
+// in the background.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) orphan(c collection) error {
+	if err := kdb.lsm.Set(orphanKey(c), emptyUnpacked.pack()); err != nil {
+		return fmt.Errorf("failed to record the members of the replaced collection: %w", err)
+	}
+	return nil
+}
+
+// reclaimMembers deletes up to `limit` members of the orphaned collections of each opened database; it returns the
+// number of deleted members. Each database is locked separately, same as expireKeys.
+func (ks *KiwiStorage) reclaimMembers(limit int) (int, error) {
+	reclaimed := 0
+	for db := range ks.dbs {
+		deleted, err := func() (int, error) {
+			ks.mux.Lock()
+			defer ks.mux.Unlock()
+			kdb := ks.dbs[db].Load()
+			if ks.closed.Load() || kdb == nil {
+				return 0, nil
+			}
+			return kdb.reclaimMembers(limit, time.Now())
+		}()
+		reclaimed += deleted
+		reclaimedMembersTotal.Add(float64(deleted))
+		if err != nil {
+			return reclaimed, fmt.Errorf("failed to reclaim members of db %d: %w", db, err)
+		}
+	}
+	return reclaimed, nil
+}
+
+// reclaimMembers deletes up to `limit` members of the orphaned collections, along with the orphan keys whose members
+// are all deleted; it returns the number of deleted members. The scans resume from the cursors of the database, so
+// that the tombstones of the previous cycles aren't scanned over again; orphans written before the cursor since are
+// found once the scan wraps around.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) reclaimMembers(limit int, now time.Time) (int, error) {
+	deleted, wrapped := 0, false
+	for deleted < limit {
+		key, err := kdb.nextOrphan()
+		if err != nil {
+			return deleted, err
+		}
+		if key == nil {
+			if wrapped || kdb.orphanCursor == nil {
+				return deleted, nil
+			}
+			kdb.orphanCursor, kdb.memberCursor, wrapped = nil, nil, true
+			continue
+		}
+		if !bytes.Equal(key, kdb.orphanCursor) { // The member cursor belongs to another orphan.
+			kdb.orphanCursor, kdb.memberCursor = key, nil
+		}
+
+		orphaned := collection{prefix: key[len(orphanKeysPrefix):]}
+		if live, err := kdb.isLiveCollection(orphaned.prefix, now); err != nil {
+			return deleted, err
+		} else if !live {
+			erased, done, err := kdb.eraseMembers(orphaned, limit-deleted)
+			deleted += erased
+			if err != nil || !done {
+				return deleted, err
+			}
+		}
+		if err := kdb.lsm.Set(key, tombstonePacked); err != nil {
+			return deleted, fmt.Errorf("failed to delete orphan %q: %w", key, err)
+		}
+		kdb.orphanCursor, kdb.memberCursor = append(key, 0x00), nil // Right after the orphan.
+	}
+	return deleted, nil
+}
+
+// nextOrphan returns the first orphan key from the orphan cursor on, or nil if there's none.
+func (kdb *kiwiDB) nextOrphan() ([]byte, error) {
+	start := kdb.orphanCursor
+	if start == nil {
+		start = orphanKeysPrefix
+	}
+	for pair, err := range kdb.lsm.Scan(start, nil /*end*/) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan orphans: %w", err)
+		}
+		if !bytes.HasPrefix(pair.Key, orphanKeysPrefix) {
+			return nil, nil
+		}
+		if unpacked, err := unpack(pair.Value); err == nil && !unpacked.is(TombStone) {
+			return pair.Key, nil
+		}
+	}
+	return nil, nil
+}
+
+// isLiveCollection returns true if the collection of the given member prefix is still live at `now`, in which case
+// its orphan key is stale and its members are kept. Prefixes that can't be parsed are never live.
+func (kdb *kiwiDB) isLiveCollection(prefix []byte, now time.Time) (bool, error) {
+	if len(prefix) < 2 {
+		return false, nil
+	}
+	length, n := binary.Uvarint(prefix[2:])
+	if n <= 0 || uint64(len(prefix)-2-n) != length+8 {
+		return false, nil
+	}
+	key := prefix[2+n : len(prefix)-8]
+	unpacked, err := kdb.getLive(key, now)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if memberTags[unpacked.valueType] != prefix[1] {
+		return false, nil
+	}
+	c, err := decodeCollection(key, unpacked)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(c.prefix, prefix), nil
+}
+
+// eraseMembers deletes up to `limit` members of the orphaned collection from the member cursor on; it returns the
+// number of deleted members, and true once none are left.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) eraseMembers(orphaned collection, limit int) (int, bool /*done*/, error) {
+	erased := 0
+	for pair, err := range kdb.members(orphaned, kdb.memberCursor) {
+		if err != nil {
+			return erased, false, err
+		}
+		if erased == limit {
+			kdb.memberCursor = bytes.Clone(pair.Key)
+			return erased, false, nil
+		}
+		if err := kdb.eraseMember(orphaned, pair.Key); err != nil {
+			return erased, false, err
+		}
+		erased++
+	}
+	return erased, true, nil
+}
+
+// startMemberReclaim starts the background member reclaim loop; it's stopped by Close.
+func (ks *KiwiStorage) startMemberReclaim() {
+	ks.reclaimStop, ks.reclaimDone = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(ks.reclaimDone)
+		ticker := time.NewTicker(*memberReclaimInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ks.reclaimStop:
+				return
+			case <-ticker.C:
+				if _, err := ks.reclaimMembers(*memberReclaimKeysPerCycle); err != nil {
+					slog.Error("Failed to reclaim the members of replaced collections.", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopMemberReclaim stops the member reclaim loop and waits for the running cycle, if any.
+func (ks *KiwiStorage) stopMemberReclaim() {
+	ks.reclaimStopOnce.Do(func() {
+		if ks.reclaimStop == nil {
+			return
+		}
+		close(ks.reclaimStop)
+		<-ks.reclaimDone
+	})
+}
@@ -0,0 +1,79 @@
+package port
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKiwiStorage_ReclaimMembers(t *testing.T) {
+	config.SetTestFlag(t, "enable_member_reclaim", "false") // Reclaim cycles are run manually.
+	store := newTestRedisHandler(t).store
+	kdb, err := store.database(0)
+	require.NoError(t, err)
+
+	// storedKeys returns the number of live member and orphan keys, without reclaiming any.
+	storedKeys := func() (members, orphans int) {
+		for pair, err := range kdb.lsm.Scan(internalKeysStart, internalKeysEnd) {
+			require.NoError(t, err)
+			unpacked, err := unpack(pair.Value)
+			require.NoError(t, err)
+			if unpacked.is(TombStone) {
+				continue
+			}
+			if bytes.HasPrefix(pair.Key, orphanKeysPrefix) {
+				orphans++
+			} else {
+				members++
+			}
+		}
+		return members, orphans
+	}
+	hset := func(key string, fields int) {
+		for i := range fields {
+			_, err := store.HSet(0, []byte(key), fieldPairs("f"+strconv.Itoa(i), "v"))
+			require.NoError(t, err)
+		}
+	}
+
+	hset("g", 5)
+	hset("h", 10)
+	deleted, err := store.Delete(0, []byte("g"), []byte("h"))
+	require.NoError(t, err)
+	require.Equal(t, 2, deleted)
+	members, orphans := storedKeys()
+	assert.Equal(t, 15, members, "Expected the members to be kept until they're reclaimed")
+	assert.Equal(t, 2, orphans)
+	fields, err := store.HGetAll(0, []byte("h"))
+	require.NoError(t, err)
+	assert.Empty(t, fields, "Expected the members of deleted hashes to be hidden")
+
+	reclaimed, err := store.reclaimMembers(4 /*limit*/)
+	require.NoError(t, err)
+	assert.Equal(t, 4, reclaimed)
+	hset("h", 3) // A new hash under the same key isn't reclaimed along with the old one.
+	hset("a", 2)
+	require.NoError(t, store.Set(0, SetCommand{key: []byte("a"), value: []byte("v")}).err)
+	for range 3 {
+		reclaimed, err = store.reclaimMembers(4 /*limit*/)
+		require.NoError(t, err)
+		assert.Equal(t, 4, reclaimed, "Expected cycles to resume where the previous ones stopped")
+	}
+	reclaimed, err = store.reclaimMembers(4 /*limit*/)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reclaimed, "Expected orphans behind the cursor to be found once it wraps around")
+	reclaimed, err = store.reclaimMembers(4 /*limit*/)
+	require.NoError(t, err)
+	assert.Zero(t, reclaimed)
+
+	members, orphans = storedKeys()
+	assert.Equal(t, 3, members)
+	assert.Zero(t, orphans, "Expected the orphans to be deleted along with their members")
+	fields, err = store.HGetAll(0, []byte("h"))
+	require.NoError(t, err)
+	assert.Equal(t, fieldPairs("f0", "v", "f1", "v", "f2", "v"), fields)
+}
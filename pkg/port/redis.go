@@ -3,20 +3,65 @@ package port
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"math"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/tidwall/redcon"
 )
 
-var address = flag.String("address", "0.0.0.0:6380", "The ip:port to listen on for Redis protocol.")
+var (
+	address = flag.String("address", "0.0.0.0:6380", "The ip:port to listen on for Redis protocol.")
+
+	redisCommandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_commands_total",
+		Help: "Total number of handled Redis commands.",
+	}, []string{"command"})
+	redisCommandErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_command_errors_total",
+		Help: "Total number of Redis commands which failed, by the class of their error, e.g. ERR.",
+	}, []string{"command", "class"})
+	redisCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "redis_command_duration_seconds",
+		Help:    "Time spent on handling each Redis command.",
+		Buckets: prometheus.ExponentialBuckets(0.000_01 /*start*/, 4 /*factor*/, 10 /*count*/),
+	}, []string{"command"})
+	redisConnectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "redis_connected_clients",
+		Help: "Number of currently connected Redis clients.",
+	})
+	redisAcceptedConnections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "redis_accepted_connections_total",
+		Help: "Total number of accepted Redis connections.",
+	})
+	redisClosedConnections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "redis_closed_connections_total",
+		Help: "Total number of closed Redis connections.",
+	})
+	redisReceivedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "redis_received_bytes_total",
+		Help: "Total number of bytes of received Redis commands.",
+	})
+	redisSentBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "redis_sent_bytes_total",
+		Help: "Total number of bytes of sent Redis replies.",
+	})
+)
+
+// unknownCommandError prefixes the error of commands that Kiwi doesn't support.
+const unknownCommandError = "ERR unknown command"
 
 // RedisCommand represents a Redis command with its arguments.
 type RedisCommand struct {
@@ -27,11 +72,12 @@ type RedisCommand struct {
 
 // RedisOutput conforms to a real Redis server output on non pub / sub commands.
 type RedisOutput struct {
-	closeConnection bool    // Closes the connection if true.
-	writeNil        bool    // Writes a nil value if true.
-	err             *string // Error to return if set.
-	writeInt        *int    // Writes an integer value if set.
-	writeBytes      []byte  // Writes a string value if set.
+	closeConnection bool          // Closes the connection if true.
+	writeNil        bool          // Writes a nil value if true.
+	err             *string       // Error to return if set.
+	writeInt        *int          // Writes an integer value if set.
+	writeBytes      []byte        // Writes a string value if set.
+	writeArray      []RedisOutput // Writes an array of the given outputs if non-nil.
 }
 
 func closeRedisConnection(msg string) RedisOutput {
@@ -56,9 +102,67 @@ func writeRedisString(str string) RedisOutput {
 
 func writeRedisError(err error) RedisOutput {
 	msg := "ERR " + err.Error()
+	if errors.Is(err, errWrongType) {
+		msg = "WRONGTYPE " + err.Error()
+	}
 	return RedisOutput{err: &msg}
 }
 
+func writeRedisArray(items ...RedisOutput) RedisOutput {
+	if items == nil {
+		items = []RedisOutput{} // An empty array, rather than a nil value.
+	}
+	return RedisOutput{writeArray: items}
+}
+
+func writeRedisBytesArray(items [][]byte) RedisOutput {
+	outputs := make([]RedisOutput, len(items))
+	for i, item := range items {
+		outputs[i] = writeRedisBytes(item)
+	}
+	return writeRedisArray(outputs...)
+}
+
+// redisOutputSize returns the number of bytes that the given `output` takes when encoded in RESP.
+func redisOutputSize(output RedisOutput) int {
+	// Each reply is a type byte, followed by its header and data, and ends with CRLF.
+	switch {
+	case output.writeNil:
+		return len("$-1\r\n")
+	case output.err != nil:
+		return 1 + len(*output.err) + 2
+	case output.writeInt != nil:
+		return 1 + len(strconv.Itoa(*output.writeInt)) + 2
+	case output.writeArray != nil:
+		size := 1 + len(strconv.Itoa(len(output.writeArray))) + 2
+		for _, item := range output.writeArray {
+			size += redisOutputSize(item)
+		}
+		return size
+	default:
+		return 1 + len(strconv.Itoa(len(output.writeBytes))) + 2 + len(output.writeBytes) + 2
+	}
+}
+
+// writeRedisOutput writes the given `output` to the connection, except for closing it.
+func writeRedisOutput(conn redcon.Conn, output RedisOutput) {
+	switch {
+	case output.writeNil:
+		conn.WriteNull()
+	case output.err != nil:
+		conn.WriteError(*output.err)
+	case output.writeInt != nil:
+		conn.WriteInt(*output.writeInt)
+	case output.writeArray != nil:
+		conn.WriteArray(len(output.writeArray))
+		for _, item := range output.writeArray {
+			writeRedisOutput(conn, item)
+		}
+	default:
+		conn.WriteBulk(output.writeBytes)
+	}
+}
+
 // SET command:
 
 // parseSetCommand parses an inline-style Redis SET command.
@@ -132,12 +236,12 @@ func upperBytes(b []byte) []byte {
 	return []byte(strings.ToUpper(string(b)))
 }
 
-func handleSetCommand(cmd RedisCommand, store *KiwiStorage) RedisOutput {
+func handleSetCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
 	setCommand, err := parseSetCommand(cmd.raw, time.Now())
 	if err != nil {
 		return writeRedisError(err)
 	}
-	setResult := store.Set(setCommand)
+	setResult := store.Set(session.db, setCommand)
 	if setResult.err != nil {
 		return writeRedisError(setResult.err)
 	}
@@ -150,9 +254,769 @@ func handleSetCommand(cmd RedisCommand, store *KiwiStorage) RedisOutput {
 	return writeRedisString("OK")
 }
 
+// SCAN command:
+
+// scanCursorDone is the cursor that starts a new scan, and is returned when a scan is done.
+const scanCursorDone = "0"
+
+// defaultScanCount is the number of keys that SCAN examines when no COUNT is given, same as Redis.
+const defaultScanCount = 10
+
+// encodeScanCursor returns an opaque cursor that resumes a scan from the given key; scans keep no server-side state.
+func encodeScanCursor(next []byte) []byte {
+	if next == nil {
+		return []byte(scanCursorDone)
+	}
+	return []byte(base64.RawURLEncoding.EncodeToString(next))
+}
+
+// decodeScanCursor returns the key to resume a scan from, or nil for a new scan.
+func decodeScanCursor(cursor []byte) ([]byte, error) {
+	if string(cursor) == scanCursorDone {
+		return nil, nil
+	}
+	next, err := base64.RawURLEncoding.DecodeString(string(cursor))
+	if err != nil || len(next) == 0 {
+		return nil, errors.New("invalid cursor")
+	}
+	return next, nil
+}
+
+// parseScanCommand parses the arguments of a Redis SCAN command: cursor [MATCH pattern] [COUNT count]
+func parseScanCommand(args [][]byte) (ScanCommand, error) {
+	if len(args) < 1 {
+		return ScanCommand{}, errors.New("wrong number of arguments for 'scan' command")
+	}
+	start, err := decodeScanCursor(args[0])
+	if err != nil {
+		return ScanCommand{}, err
+	}
+	cmd := ScanCommand{start: start, count: defaultScanCount}
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return ScanCommand{}, errors.New("syntax error")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			cmd.pattern = args[i+1]
+		case "COUNT":
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return ScanCommand{}, errors.New("value is not an integer or out of range")
+			}
+			if count < 1 {
+				return ScanCommand{}, errors.New("syntax error")
+			}
+			cmd.count = count
+		default:
+			return ScanCommand{}, errors.New("syntax error")
+		}
+	}
+	return cmd, nil
+}
+
+func handleScanCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	scanCommand, err := parseScanCommand(cmd.args)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	scanResult := store.Scan(session.db, scanCommand)
+	if scanResult.err != nil {
+		return writeRedisError(scanResult.err)
+	}
+	return writeRedisArray(writeRedisBytes(encodeScanCursor(scanResult.next)), writeRedisBytesArray(scanResult.keys))
+}
+
+// Expiry commands:
+
+// expiryArg describes how the time argument of an expiry command or option is interpreted.
+type expiryArg struct {
+	unit     time.Duration // Either a second or a millisecond.
+	absolute bool          // Whether the argument is a unix timestamp, or relative to now.
+}
+
+// expireCommands maps the EXPIRE command family to their time arguments.
+var expireCommands = map[string]expiryArg{
+	"EXPIRE":    {unit: time.Second},
+	"PEXPIRE":   {unit: time.Millisecond},
+	"EXPIREAT":  {unit: time.Second, absolute: true},
+	"PEXPIREAT": {unit: time.Millisecond, absolute: true},
+}
+
+// getExOptions maps the expiry options of GETEX to their time arguments.
+var getExOptions = map[string]expiryArg{
+	"EX":   {unit: time.Second},
+	"PX":   {unit: time.Millisecond},
+	"EXAT": {unit: time.Second, absolute: true},
+	"PXAT": {unit: time.Millisecond, absolute: true},
+}
+
+// parseExpiryNumber parses the time argument of an expiry command or option, e.g. 10 in EXPIRE key 10.
+func parseExpiryNumber(arg []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(arg), 10 /*base*/, 64 /*bitSize*/)
+	if err != nil {
+		return 0, errors.New("value is not an integer or out of range")
+	}
+	return n, nil
+}
+
+// expiryTime converts the time argument `n` of the given expiry `command` to an absolute time.
+func expiryTime(command string, n int64, arg expiryArg, now time.Time) (time.Time, error) {
+	invalidErr := fmt.Errorf("invalid expire time in '%s' command", strings.ToLower(command))
+	if n > math.MaxInt64/int64(arg.unit) || n < math.MinInt64/int64(arg.unit) {
+		return time.Time{}, invalidErr
+	}
+	nanos := n * int64(arg.unit)
+	if arg.absolute {
+		return time.Unix(0, nanos).UTC(), nil
+	}
+	if (nanos > 0 && now.UnixNano() > math.MaxInt64-nanos) || (nanos < 0 && now.UnixNano() < math.MinInt64-nanos) {
+		return time.Time{}, invalidErr
+	}
+	return now.Add(time.Duration(nanos)), nil
+}
+
+// parseExpireCommand parses the Redis EXPIRE command family: EXPIRE key time [NX|XX|GT|LT]
+func parseExpireCommand(cmd RedisCommand, now time.Time) (ExpireCommand, error) {
+	if len(cmd.args) < 2 {
+		return ExpireCommand{}, fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command))
+	}
+	n, err := parseExpiryNumber(cmd.args[1])
+	if err != nil {
+		return ExpireCommand{}, err
+	}
+	expiry, err := expiryTime(cmd.command, n, expireCommands[cmd.command], now)
+	if err != nil {
+		return ExpireCommand{}, err
+	}
+
+	expireCommand := ExpireCommand{key: cmd.args[0], expiryTime: expiry}
+	for _, option := range cmd.args[2:] {
+		switch strings.ToUpper(string(option)) {
+		case "NX":
+			expireCommand.checks |= ifNoExpiry
+		case "XX":
+			expireCommand.checks |= ifHasExpiry
+		case "GT":
+			expireCommand.checks |= ifLaterExpiry
+		case "LT":
+			expireCommand.checks |= ifEarlierExpiry
+		default:
+			return ExpireCommand{}, fmt.Errorf("Unsupported option %s", option)
+		}
+	}
+	if expireCommand.checks.is(ifNoExpiry) && expireCommand.checks.is(ifHasExpiry|ifLaterExpiry|ifEarlierExpiry) {
+		return ExpireCommand{}, errors.New("NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if expireCommand.checks.is(ifLaterExpiry) && expireCommand.checks.is(ifEarlierExpiry) {
+		return ExpireCommand{}, errors.New("GT and LT options at the same time are not compatible")
+	}
+	return expireCommand, nil
+}
+
+func handleExpireCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	expireCommand, err := parseExpireCommand(cmd, time.Now())
+	if err != nil {
+		return writeRedisError(err)
+	}
+	updated, err := store.Expire(session.db, expireCommand)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	if updated {
+		return writeRedisInt(1)
+	}
+	return writeRedisInt(0)
+}
+
+// handleTtlCommand handles TTL, PTTL, EXPIRETIME and PEXPIRETIME; which return -2 for missing keys and -1 for keys
+// without an expiry. Seconds are rounded, same as Redis.
+func handleTtlCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) != 1 {
+		return writeRedisError(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command)))
+	}
+	expiry, err := store.Expiry(session.db, cmd.args[0])
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return writeRedisInt(-2)
+	} else if err != nil {
+		return writeRedisError(err)
+	}
+	if expiry.IsZero() {
+		return writeRedisInt(-1)
+	}
+
+	switch cmd.command {
+	case "TTL":
+		return writeRedisInt(int((time.Until(expiry).Milliseconds() + 500) / 1000))
+	case "PTTL":
+		return writeRedisInt(int(time.Until(expiry).Milliseconds()))
+	case "EXPIRETIME":
+		return writeRedisInt(int((expiry.UnixMilli() + 500) / 1000))
+	default: // PEXPIRETIME
+		return writeRedisInt(int(expiry.UnixMilli()))
+	}
+}
+
+// parseGetExCommand parses the Redis GETEX command: GETEX key [EX s|PX ms|EXAT s|PXAT ms|PERSIST]
+func parseGetExCommand(args [][]byte, now time.Time) (GetExCommand, error) {
+	if len(args) < 1 {
+		return GetExCommand{}, errors.New("wrong number of arguments for 'getex' command")
+	}
+	getExCommand := GetExCommand{key: args[0]}
+	switch options := args[1:]; {
+	case len(options) == 0:
+	case len(options) == 1 && strings.ToUpper(string(options[0])) == "PERSIST":
+		getExCommand.persist = true
+	case len(options) == 2:
+		arg, exists := getExOptions[strings.ToUpper(string(options[0]))]
+		if !exists {
+			return GetExCommand{}, errors.New("syntax error")
+		}
+		n, err := parseExpiryNumber(options[1])
+		if err != nil {
+			return GetExCommand{}, err
+		}
+		expiry, err := expiryTime("GETEX", n, arg, now)
+		if err != nil || n <= 0 {
+			return GetExCommand{}, errors.New("invalid expire time in 'getex' command")
+		}
+		getExCommand.expiryTime = expiry
+	default:
+		return GetExCommand{}, errors.New("syntax error")
+	}
+	return getExCommand, nil
+}
+
+func handleGetExCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	getExCommand, err := parseGetExCommand(cmd.args, time.Now())
+	if err != nil {
+		return writeRedisError(err)
+	}
+	if value, err := store.GetEx(session.db, getExCommand); errors.Is(err, storage.ErrKeyNotFound) {
+		return writeRedisNil()
+	} else if err != nil {
+		return writeRedisError(err)
+	} else {
+		return writeRedisBytes(value)
+	}
+}
+
+// Hash commands:
+
+// writeRedisPairs returns the given pairs as a flat array of keys and values, e.g. the reply of HGETALL.
+func writeRedisPairs(pairs []utils.BytePair) RedisOutput {
+	outputs := make([]RedisOutput, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		outputs = append(outputs, writeRedisBytes(pair.Key), writeRedisBytes(pair.Value))
+	}
+	return writeRedisArray(outputs...)
+}
+
+func handleHSetCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) < 3 || len(cmd.args)%2 != 1 {
+		return writeRedisError(errors.New("wrong number of arguments for 'hset' command"))
+	}
+	fields := make([]utils.BytePair, 0, len(cmd.args)/2)
+	for i := 1; i < len(cmd.args); i += 2 {
+		fields = append(fields, utils.BytePair{Key: cmd.args[i], Value: cmd.args[i+1]})
+	}
+	added, err := store.HSet(session.db, cmd.args[0], fields)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisInt(added)
+}
+
+func handleHScanCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) < 2 {
+		return writeRedisError(errors.New("wrong number of arguments for 'hscan' command"))
+	}
+	scanCommand, err := parseScanCommand(cmd.args[1:])
+	if err != nil {
+		return writeRedisError(err)
+	}
+	fields, next, err := store.HScan(session.db, cmd.args[0], scanCommand)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisArray(writeRedisBytes(encodeScanCursor(next)), writeRedisPairs(fields))
+}
+
+func handleHIncrByCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) != 3 {
+		return writeRedisError(errors.New("wrong number of arguments for 'hincrby' command"))
+	}
+	increment, err := strconv.ParseInt(string(cmd.args[2]), 10 /*base*/, 64 /*bitSize*/)
+	if err != nil {
+		return writeRedisError(errors.New("value is not an integer or out of range"))
+	}
+	value, err := store.HIncrBy(session.db, cmd.args[0], cmd.args[1], increment)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisInt(int(value))
+}
+
+// List commands:
+
+// parseListIndexes parses the start and stop indexes of list commands, e.g. LRANGE key start stop.
+func parseListIndexes(args [][]byte) (int, int, error) {
+	start, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return 0, 0, errors.New("value is not an integer or out of range")
+	}
+	stop, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return 0, 0, errors.New("value is not an integer or out of range")
+	}
+	return start, stop, nil
+}
+
+func handlePushCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) < 2 {
+		return writeRedisError(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command)))
+	}
+	length, err := store.Push(session.db, cmd.args[0], cmd.command == "LPUSH", cmd.args[1:]...)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisInt(length)
+}
+
+// handlePopCommand handles LPOP and RPOP; without a count, a single element is returned rather than an array.
+func handlePopCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) < 1 || len(cmd.args) > 2 {
+		return writeRedisError(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command)))
+	}
+	count := 1
+	if len(cmd.args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(string(cmd.args[1])); err != nil || count < 0 {
+			return writeRedisError(errors.New("value is out of range, must be positive"))
+		}
+	}
+	elements, err := store.Pop(session.db, cmd.args[0], cmd.command == "LPOP", count)
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return writeRedisNil()
+	} else if err != nil {
+		return writeRedisError(err)
+	}
+	if len(cmd.args) == 2 {
+		return writeRedisBytesArray(elements)
+	}
+	return writeRedisBytes(elements[0])
+}
+
+// Set commands:
+
+// setOperations maps the set algebra commands to their operations; their *STORE variants store the result instead.
+var setOperations = map[string]SetOperation{"SUNION": SetUnion, "SINTER": SetInter, "SDIFF": SetDiff}
+
+func handleSScanCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) < 2 {
+		return writeRedisError(errors.New("wrong number of arguments for 'sscan' command"))
+	}
+	scanCommand, err := parseScanCommand(cmd.args[1:])
+	if err != nil {
+		return writeRedisError(err)
+	}
+	members, next, err := store.SScan(session.db, cmd.args[0], scanCommand)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisArray(writeRedisBytes(encodeScanCursor(next)), writeRedisBytesArray(members))
+}
+
+// handleSetAlgebraCommand handles SUNION, SINTER, SDIFF and their *STORE variants, whose first argument is the key
+// to store the result at.
+func handleSetAlgebraCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	name, isStore := strings.CutSuffix(cmd.command, "STORE")
+	if len(cmd.args) < 1 || (isStore && len(cmd.args) < 2) {
+		return writeRedisError(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command)))
+	}
+	if isStore {
+		count, err := store.SCombineStore(session.db, setOperations[name], cmd.args[0], cmd.args[1:]...)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(count)
+	}
+	members, err := store.SCombine(session.db, setOperations[name], cmd.args...)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisBytesArray(members)
+}
+
+// Sorted set commands:
+
+// parseScore parses a score of a sorted set member, e.g. of ZADD; "inf" and "-inf" are the infinities.
+func parseScore(arg []byte) (float64, error) {
+	score, err := strconv.ParseFloat(string(arg), 64 /*bitSize*/)
+	if err != nil || math.IsNaN(score) {
+		return 0, errors.New("value is not a valid float")
+	}
+	return score, nil
+}
+
+// parseScoreBound parses the minimum or maximum of a range of scores, which is exclusive if it starts with '('.
+func parseScoreBound(arg []byte) (scoreBound, error) {
+	exclusive := bytes.HasPrefix(arg, []byte("("))
+	if exclusive {
+		arg = arg[1:]
+	}
+	score, err := parseScore(arg)
+	if err != nil {
+		return scoreBound{}, errors.New("min or max is not a float")
+	}
+	return scoreBound{score: score, exclusive: exclusive}, nil
+}
+
+// parseLexBound parses the minimum or maximum of a lexicographical range, which is either "-", "+", or a member that
+// starts with '[' if it's inclusive, or '(' if it's exclusive.
+func parseLexBound(arg []byte) (lexBound, error) {
+	switch {
+	case string(arg) == "-":
+		return lexBound{infinity: -1}, nil
+	case string(arg) == "+":
+		return lexBound{infinity: 1}, nil
+	case len(arg) > 0 && (arg[0] == '[' || arg[0] == '('):
+		return lexBound{member: arg[1:], exclusive: arg[0] == '('}, nil
+	}
+	return lexBound{}, errors.New("min or max not valid string range item")
+}
+
+func parseZAddCommand(args [][]byte) (ZAddCommand, error) {
+	zadd := ZAddCommand{key: args[0]}
+	var nx, xx, gt, lt bool
+	i := 1
+options:
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx, zadd.existence = true, ifNotExists
+		case "XX":
+			xx, zadd.existence = true, ifExists
+		case "GT":
+			gt, zadd.update = true, greaterScore
+		case "LT":
+			lt, zadd.update = true, lessScore
+		case "CH":
+			zadd.changed = true
+		default:
+			break options
+		}
+	}
+	if nx && xx {
+		return ZAddCommand{}, errors.New("XX and NX options at the same time are not compatible")
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return ZAddCommand{}, errors.New("GT, LT, and/or NX options at the same time are not compatible")
+	}
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return ZAddCommand{}, errors.New("syntax error")
+	}
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := parseScore(pairs[j])
+		if err != nil {
+			return ZAddCommand{}, err
+		}
+		zadd.members = append(zadd.members, ZMember{member: pairs[j+1], score: score})
+	}
+	return zadd, nil
+}
+
+// zrangeCommands maps the range commands to the options of ZRANGE which they imply, e.g. ZREVRANGEBYSCORE is
+// ZRANGE ... BYSCORE REV.
+var zrangeCommands = map[string]ZRangeCommand{
+	"ZRANGE": {by: byRank}, "ZREVRANGE": {by: byRank, rev: true},
+	"ZRANGEBYSCORE": {by: byScore}, "ZREVRANGEBYSCORE": {by: byScore, rev: true},
+	"ZRANGEBYLEX": {by: byLex}, "ZREVRANGEBYLEX": {by: byLex, rev: true},
+}
+
+// parseZRangeCommand parses ZRANGE and the range commands it subsumes, see zrangeCommands; same as Redis, reversed
+// ranges of scores or members take the maximum before the minimum.
+func parseZRangeCommand(cmd RedisCommand) (ZRangeCommand, error) {
+	if len(cmd.args) < 3 {
+		return ZRangeCommand{}, fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command))
+	}
+	zrange := zrangeCommands[cmd.command]
+	zrange.key, zrange.count = cmd.args[0], -1
+	hasLimit := false
+	for i := 3; i < len(cmd.args); i++ {
+		option := strings.ToUpper(string(cmd.args[i]))
+		switch {
+		case option == "BYSCORE" && cmd.command == "ZRANGE":
+			zrange.by = byScore
+		case option == "BYLEX" && cmd.command == "ZRANGE":
+			zrange.by = byLex
+		case option == "REV" && cmd.command == "ZRANGE":
+			zrange.rev = true
+		case option == "WITHSCORES":
+			zrange.withScores = true
+		case option == "LIMIT" && i+2 < len(cmd.args):
+			offset, err := strconv.Atoi(string(cmd.args[i+1]))
+			if err != nil {
+				return ZRangeCommand{}, errors.New("value is not an integer or out of range")
+			}
+			count, err := strconv.Atoi(string(cmd.args[i+2]))
+			if err != nil {
+				return ZRangeCommand{}, errors.New("value is not an integer or out of range")
+			}
+			if offset < 0 { // Same as Redis, negative offsets return nothing.
+				offset, count = 0, 0
+			}
+			zrange.offset, zrange.count, hasLimit = offset, count, true
+			i += 2
+		default:
+			return ZRangeCommand{}, errors.New("syntax error")
+		}
+	}
+	if hasLimit && zrange.by == byRank {
+		return ZRangeCommand{}, errors.New(
+			"syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if zrange.withScores && zrange.by == byLex {
+		return ZRangeCommand{}, errors.New("syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	lower, upper := cmd.args[1], cmd.args[2]
+	if zrange.rev && zrange.by != byRank {
+		lower, upper = upper, lower
+	}
+	var err error
+	switch zrange.by {
+	case byRank:
+		zrange.start, zrange.stop, err = parseListIndexes(cmd.args[1:3])
+	case byScore:
+		if zrange.minScore, err = parseScoreBound(lower); err == nil {
+			zrange.maxScore, err = parseScoreBound(upper)
+		}
+	case byLex:
+		if zrange.minLex, err = parseLexBound(lower); err == nil {
+			zrange.maxLex, err = parseLexBound(upper)
+		}
+	}
+	if err != nil {
+		return ZRangeCommand{}, err
+	}
+	return zrange, nil
+}
+
+// writeRedisZMembers returns the given sorted set members as an array, which has their scores after each of them if
+// `withScores`, e.g. the reply of ZRANGE.
+func writeRedisZMembers(members []ZMember, withScores bool) RedisOutput {
+	outputs := make([]RedisOutput, 0, 2*len(members))
+	for _, member := range members {
+		outputs = append(outputs, writeRedisBytes(member.member))
+		if withScores {
+			outputs = append(outputs, writeRedisBytes(formatScore(member.score)))
+		}
+	}
+	return writeRedisArray(outputs...)
+}
+
+func handleZAddCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) < 3 {
+		return writeRedisError(errors.New("wrong number of arguments for 'zadd' command"))
+	}
+	zadd, err := parseZAddCommand(cmd.args)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	added, err := store.ZAdd(session.db, zadd)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisInt(added)
+}
+
+func handleZRangeCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	zrange, err := parseZRangeCommand(cmd)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	members, err := store.ZRange(session.db, zrange)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisZMembers(members, zrange.withScores)
+}
+
+// zremRangeCommands maps the commands which remove ranges of sorted sets to the commands of their ranges.
+var zremRangeCommands = map[string]string{
+	"ZREMRANGEBYRANK": "ZRANGE", "ZREMRANGEBYSCORE": "ZRANGEBYSCORE", "ZREMRANGEBYLEX": "ZRANGEBYLEX",
+}
+
+// handleZRemRangeCommand handles ZREMRANGEBYRANK, ZREMRANGEBYSCORE and ZREMRANGEBYLEX, see zremRangeCommands.
+func handleZRemRangeCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) != 3 {
+		return writeRedisError(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command)))
+	}
+	zrange, err := parseZRangeCommand(RedisCommand{command: zremRangeCommands[cmd.command], args: cmd.args})
+	if err != nil {
+		return writeRedisError(err)
+	}
+	removed, err := store.ZRemRange(session.db, zrange)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisInt(removed)
+}
+
+// handleZPopCommand handles ZPOPMIN and ZPOPMAX, whose replies are arrays of members and their scores.
+func handleZPopCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if len(cmd.args) < 1 || len(cmd.args) > 2 {
+		return writeRedisError(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command)))
+	}
+	count := 1
+	if len(cmd.args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(string(cmd.args[1])); err != nil || count < 0 {
+			return writeRedisError(errors.New("value is out of range, must be positive"))
+		}
+	}
+	members, err := store.ZPop(session.db, cmd.args[0], cmd.command == "ZPOPMAX", count)
+	if err != nil {
+		return writeRedisError(err)
+	}
+	return writeRedisZMembers(members, true /*withScores*/)
+}
+
+// History commands:
+
+// parseUnixMillis parses a unix time in milliseconds, e.g. the time argument of KIWI GETASOF.
+func parseUnixMillis(arg []byte) (time.Time, error) {
+	millis, err := strconv.ParseInt(string(arg), 10 /*base*/, 64 /*bitSize*/)
+	if err != nil {
+		return time.Time{}, errors.New("value is not an integer or out of range")
+	}
+	return time.UnixMilli(millis), nil
+}
+
+// writeRedisRevision returns the given revision as an array of its commit time in unix milliseconds (zero if it's
+// unknown), its type ("none" for deletes) and its value, which is nil unless it's a string.
+func writeRedisRevision(revision KeyRevision) RedisOutput {
+	commitTime := 0
+	if !revision.commitTime.IsZero() {
+		commitTime = int(revision.commitTime.UnixMilli())
+	}
+	valueType, value := revision.value.valueType.String(), writeRedisNil()
+	if revision.value.is(TombStone) {
+		valueType = "none"
+	} else if revision.value.valueType == StringType {
+		value = writeRedisBytes(revision.value.value)
+	}
+	return writeRedisArray(writeRedisInt(commitTime), writeRedisString(valueType), value)
+}
+
+// handleKiwiCommand handles the subcommands of KIWI, which are specific to Kiwi:
+//   - GETASOF key unix-ms: The value of a string key as of a past time within the history retention window, or nil.
+//   - HISTORY key [COUNT count]: The retained values of a key, newest first; see writeRedisRevision.
+//   - RESTORE prefix unix-ms: Restores the keys with the prefix to their values as of a past time, and returns the
+//     number of keys that were changed.
+func handleKiwiCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	subcommand, args := strings.ToUpper(string(cmd.args[0])), cmd.args[1:]
+	wrongArgsErr := fmt.Errorf("wrong number of arguments for 'kiwi|%s' command", strings.ToLower(subcommand))
+	switch subcommand {
+	case "GETASOF":
+		if len(args) != 2 {
+			return writeRedisError(wrongArgsErr)
+		}
+		at, err := parseUnixMillis(args[1])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		if value, err := store.GetAsOf(session.db, args[0], at); errors.Is(err, storage.ErrKeyNotFound) {
+			return writeRedisNil()
+		} else if err != nil {
+			return writeRedisError(err)
+		} else {
+			return writeRedisBytes(value)
+		}
+	case "HISTORY":
+		if len(args) != 1 && len(args) != 3 {
+			return writeRedisError(wrongArgsErr)
+		}
+		count := 0
+		if len(args) == 3 {
+			if !strings.EqualFold(string(args[1]), "COUNT") {
+				return writeRedisError(errors.New("syntax error"))
+			}
+			var err error
+			if count, err = strconv.Atoi(string(args[2])); err != nil || count < 1 {
+				return writeRedisError(errors.New("value is out of range, must be positive"))
+			}
+		}
+		history, err := store.History(session.db, args[0], count)
+		if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+			return writeRedisError(err)
+		}
+		revisions := make([]RedisOutput, len(history))
+		for i, revision := range history {
+			revisions[i] = writeRedisRevision(revision)
+		}
+		return writeRedisArray(revisions...)
+	case "RESTORE":
+		if len(args) != 2 {
+			return writeRedisError(wrongArgsErr)
+		}
+		at, err := parseUnixMillis(args[1])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		changed, err := store.RestorePrefix(session.db, args[0], at)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(changed)
+	default:
+		return writeRedisError(fmt.Errorf("unknown subcommand '%s'", cmd.args[0]))
+	}
+}
+
+// Database commands:
+
+// parseDbIndex parses the index of a Redis DB, e.g. the argument of SELECT.
+func parseDbIndex(arg []byte) (int, error) {
+	db, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, errors.New("value is not an integer or out of range")
+	}
+	return db, nil
+}
+
+// parseFlushMode parses the optional [ASYNC|SYNC] argument of FLUSHDB and FLUSHALL; returns true for ASYNC.
+func parseFlushMode(command string, args [][]byte) (bool /*async*/, error) {
+	if len(args) > 1 {
+		return false, fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(command))
+	}
+	if len(args) == 0 {
+		return false, nil
+	}
+	switch strings.ToUpper(string(args[0])) {
+	case "ASYNC":
+		return true, nil
+	case "SYNC":
+		return false, nil
+	default:
+		return false, errors.New("syntax error")
+	}
+}
+
+// redisSession holds the state of a single client connection.
+type redisSession struct {
+	db          int               // The selected Redis DB; changed by the SELECT command.
+	transaction *redisTransaction // Set between MULTI and EXEC or DISCARD.
+	watched     []watchedKey      // The keys watched by WATCH, until EXEC, DISCARD or UNWATCH.
+}
+
 // RedisHandler handles Redis commands using a Kiwi backend.
 type RedisHandler struct {
-	store *KiwiStorage
+	store            *KiwiStorage
+	tracker          commandTracker
+	shutdownRequests chan shutdownRequest // Receives the SHUTDOWN commands.
 }
 
 // NewRedisHandler creates a new RedisHandler.
@@ -160,48 +1024,442 @@ func NewRedisHandler(store *KiwiStorage) (*RedisHandler, error) {
 	if store == nil {
 		return nil, errors.New("expected a non-nil store")
 	}
-	return &RedisHandler{store: store}, nil
+	return &RedisHandler{store: store, shutdownRequests: make(chan shutdownRequest, 1)}, nil
+}
+
+// handle handles the given command, or queues it if the session is in a transaction.
+func (rh *RedisHandler) handle(session *redisSession, cmd RedisCommand) RedisOutput {
+	switch cmd.command {
+	case "MULTI":
+		return handleMultiCommand(session)
+	case "EXEC":
+		return rh.handleExecCommand(session)
+	case "DISCARD":
+		return handleDiscardCommand(session)
+	case "WATCH":
+		return handleWatchCommand(session, cmd, rh.store)
+	case "QUIT": // Closes the connection right away, even in a transaction.
+	default:
+		if session.transaction != nil {
+			return session.transaction.queue(cmd)
+		}
+	}
+	return rh.run(session, cmd, rh.store)
 }
 
-func (rh *RedisHandler) handle(cmd RedisCommand) RedisOutput {
+// run runs the given command on the given store, which is a locked view in transactions.
+func (rh *RedisHandler) run(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
 	switch cmd.command {
 	case "PING":
 		return writeRedisString("PONG")
 	case "QUIT":
 		return closeRedisConnection("OK")
+	case "SHUTDOWN":
+		request, err := parseShutdownCommand(cmd.args)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		select {
+		case rh.shutdownRequests <- request:
+		default: // The server is already shutting down.
+		}
+		return closeRedisConnection("OK")
 	case "SET":
 		if len(cmd.args) != 2 {
 			return writeRedisError(errors.New("ERR wrong number of arguments for 'SET' command"))
 		}
-		return handleSetCommand(cmd, rh.store)
+		return handleSetCommand(session, cmd, store)
 	case "GET":
 		if len(cmd.args) != 1 {
 			return writeRedisError(errors.New("wrong number of arguments for 'get' command"))
 		}
 		key := cmd.args[0]
-		if value, err := rh.store.Get(key); errors.Is(err, storage.ErrKeyNotFound) {
+		if value, err := store.Get(session.db, key); errors.Is(err, storage.ErrKeyNotFound) {
 			return writeRedisNil()
 		} else if err != nil {
 			return writeRedisError(err)
 		} else {
 			return writeRedisBytes(value)
 		}
-	case "DEL":
+	case "DEL", "UNLINK": // Kiwi never frees memory when deleting keys, so UNLINK is the same as DEL.
 		if len(cmd.args) < 1 {
-			return writeRedisError(errors.New("wrong number of arguments for 'DEL' command"))
+			return writeRedisError(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command)))
 		}
-		deletedCount := 0
-		for _, key := range cmd.args {
-			if err := rh.store.Delete(key); err == nil {
-				deletedCount++
-			}
+		deletedCount, err := store.Delete(session.db, cmd.args...)
+		if err != nil {
+			return writeRedisError(err)
 		}
 		return writeRedisInt(deletedCount)
+	case "EXISTS":
+		if len(cmd.args) < 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'exists' command"))
+		}
+		existingCount := 0
+		for _, key := range cmd.args { // Repeated keys are counted multiple times, same as Redis.
+			if exists, err := store.Exists(session.db, key); err != nil {
+				return writeRedisError(err)
+			} else if exists {
+				existingCount++
+			}
+		}
+		return writeRedisInt(existingCount)
+	case "SCAN":
+		return handleScanCommand(session, cmd, store)
+	case "KEYS":
+		if len(cmd.args) != 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'keys' command"))
+		}
+		scanResult := store.Scan(session.db, ScanCommand{pattern: cmd.args[0]})
+		if scanResult.err != nil {
+			return writeRedisError(scanResult.err)
+		}
+		return writeRedisBytesArray(scanResult.keys)
+	case "DBSIZE":
+		if len(cmd.args) != 0 {
+			return writeRedisError(errors.New("wrong number of arguments for 'dbsize' command"))
+		}
+		size, err := store.DBSize(session.db)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(size)
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		return handleExpireCommand(session, cmd, store)
+	case "TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME":
+		return handleTtlCommand(session, cmd, store)
+	case "PERSIST":
+		if len(cmd.args) != 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'persist' command"))
+		}
+		updated, err := store.Persist(session.db, cmd.args[0])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		if updated {
+			return writeRedisInt(1)
+		}
+		return writeRedisInt(0)
+	case "GETEX":
+		return handleGetExCommand(session, cmd, store)
+	case "SELECT":
+		if len(cmd.args) != 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'select' command"))
+		}
+		db, err := parseDbIndex(cmd.args[0])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		if db < 0 || db >= *databases {
+			return writeRedisError(errDbIndexOutOfRange)
+		}
+		session.db = db
+		return writeRedisString("OK")
+	case "SWAPDB":
+		if len(cmd.args) != 2 {
+			return writeRedisError(errors.New("wrong number of arguments for 'swapdb' command"))
+		}
+		first, err := parseDbIndex(cmd.args[0])
+		if err != nil {
+			return writeRedisError(errors.New("invalid first DB index"))
+		}
+		second, err := parseDbIndex(cmd.args[1])
+		if err != nil {
+			return writeRedisError(errors.New("invalid second DB index"))
+		}
+		if err := store.SwapDB(first, second); err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisString("OK")
+	case "FLUSHDB", "FLUSHALL":
+		async, err := parseFlushMode(cmd.command, cmd.args)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		if cmd.command == "FLUSHDB" {
+			err = store.FlushDB(session.db, async)
+		} else {
+			err = store.FlushAll(async)
+		}
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisString("OK")
+	case "UNWATCH":
+		if len(cmd.args) != 0 {
+			return writeRedisError(errors.New("wrong number of arguments for 'unwatch' command"))
+		}
+		session.watched = nil
+		return writeRedisString("OK")
+	case "HSET":
+		return handleHSetCommand(session, cmd, store)
+	case "HGET":
+		if len(cmd.args) != 2 {
+			return writeRedisError(errors.New("wrong number of arguments for 'hget' command"))
+		}
+		if value, err := store.HGet(session.db, cmd.args[0], cmd.args[1]); errors.Is(err, storage.ErrKeyNotFound) {
+			return writeRedisNil()
+		} else if err != nil {
+			return writeRedisError(err)
+		} else {
+			return writeRedisBytes(value)
+		}
+	case "HMGET":
+		if len(cmd.args) < 2 {
+			return writeRedisError(errors.New("wrong number of arguments for 'hmget' command"))
+		}
+		values, err := store.HMGet(session.db, cmd.args[0], cmd.args[1:]...)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		outputs := make([]RedisOutput, len(values))
+		for i, value := range values {
+			if value == nil {
+				outputs[i] = writeRedisNil()
+			} else {
+				outputs[i] = writeRedisBytes(value)
+			}
+		}
+		return writeRedisArray(outputs...)
+	case "HDEL":
+		if len(cmd.args) < 2 {
+			return writeRedisError(errors.New("wrong number of arguments for 'hdel' command"))
+		}
+		deleted, err := store.HDel(session.db, cmd.args[0], cmd.args[1:]...)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(deleted)
+	case "HGETALL":
+		if len(cmd.args) != 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'hgetall' command"))
+		}
+		fields, err := store.HGetAll(session.db, cmd.args[0])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisPairs(fields)
+	case "HSCAN":
+		return handleHScanCommand(session, cmd, store)
+	case "HINCRBY":
+		return handleHIncrByCommand(session, cmd, store)
+	case "LPUSH", "RPUSH":
+		return handlePushCommand(session, cmd, store)
+	case "LPOP", "RPOP":
+		return handlePopCommand(session, cmd, store)
+	case "LRANGE":
+		if len(cmd.args) != 3 {
+			return writeRedisError(errors.New("wrong number of arguments for 'lrange' command"))
+		}
+		start, stop, err := parseListIndexes(cmd.args[1:])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		elements, err := store.LRange(session.db, cmd.args[0], start, stop)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisBytesArray(elements)
+	case "LINDEX":
+		if len(cmd.args) != 2 {
+			return writeRedisError(errors.New("wrong number of arguments for 'lindex' command"))
+		}
+		index, err := strconv.Atoi(string(cmd.args[1]))
+		if err != nil {
+			return writeRedisError(errors.New("value is not an integer or out of range"))
+		}
+		if element, err := store.LIndex(session.db, cmd.args[0], index); errors.Is(err, storage.ErrKeyNotFound) {
+			return writeRedisNil()
+		} else if err != nil {
+			return writeRedisError(err)
+		} else {
+			return writeRedisBytes(element)
+		}
+	case "LLEN":
+		if len(cmd.args) != 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'llen' command"))
+		}
+		length, err := store.LLen(session.db, cmd.args[0])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(length)
+	case "LTRIM":
+		if len(cmd.args) != 3 {
+			return writeRedisError(errors.New("wrong number of arguments for 'ltrim' command"))
+		}
+		start, stop, err := parseListIndexes(cmd.args[1:])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		if err := store.LTrim(session.db, cmd.args[0], start, stop); err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisString("OK")
+	case "SADD", "SREM":
+		if len(cmd.args) < 2 {
+			return writeRedisError(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command)))
+		}
+		update := store.SAdd
+		if cmd.command == "SREM" {
+			update = store.SRem
+		}
+		changed, err := update(session.db, cmd.args[0], cmd.args[1:]...)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(changed)
+	case "SISMEMBER":
+		if len(cmd.args) != 2 {
+			return writeRedisError(errors.New("wrong number of arguments for 'sismember' command"))
+		}
+		isMember, err := store.SIsMember(session.db, cmd.args[0], cmd.args[1])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		if isMember {
+			return writeRedisInt(1)
+		}
+		return writeRedisInt(0)
+	case "SMEMBERS":
+		if len(cmd.args) != 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'smembers' command"))
+		}
+		members, err := store.SMembers(session.db, cmd.args[0])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisBytesArray(members)
+	case "SCARD":
+		if len(cmd.args) != 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'scard' command"))
+		}
+		count, err := store.SCard(session.db, cmd.args[0])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(count)
+	case "SSCAN":
+		return handleSScanCommand(session, cmd, store)
+	case "SUNION", "SINTER", "SDIFF", "SUNIONSTORE", "SINTERSTORE", "SDIFFSTORE":
+		return handleSetAlgebraCommand(session, cmd, store)
+	case "ZADD":
+		return handleZAddCommand(session, cmd, store)
+	case "ZINCRBY":
+		if len(cmd.args) != 3 {
+			return writeRedisError(errors.New("wrong number of arguments for 'zincrby' command"))
+		}
+		increment, err := parseScore(cmd.args[1])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		score, err := store.ZIncrBy(session.db, cmd.args[0], cmd.args[2], increment)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisBytes(formatScore(score))
+	case "ZREM":
+		if len(cmd.args) < 2 {
+			return writeRedisError(errors.New("wrong number of arguments for 'zrem' command"))
+		}
+		removed, err := store.ZRem(session.db, cmd.args[0], cmd.args[1:]...)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(removed)
+	case "ZSCORE":
+		if len(cmd.args) != 2 {
+			return writeRedisError(errors.New("wrong number of arguments for 'zscore' command"))
+		}
+		if score, err := store.ZScore(session.db, cmd.args[0], cmd.args[1]); errors.Is(err, storage.ErrKeyNotFound) {
+			return writeRedisNil()
+		} else if err != nil {
+			return writeRedisError(err)
+		} else {
+			return writeRedisBytes(formatScore(score))
+		}
+	case "ZCARD":
+		if len(cmd.args) != 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'zcard' command"))
+		}
+		count, err := store.ZCard(session.db, cmd.args[0])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(count)
+	case "ZCOUNT":
+		if len(cmd.args) != 3 {
+			return writeRedisError(errors.New("wrong number of arguments for 'zcount' command"))
+		}
+		lower, err := parseScoreBound(cmd.args[1])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		upper, err := parseScoreBound(cmd.args[2])
+		if err != nil {
+			return writeRedisError(err)
+		}
+		count, err := store.ZCount(session.db, cmd.args[0], lower, upper)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(count)
+	case "ZRANK", "ZREVRANK":
+		if len(cmd.args) != 2 {
+			return writeRedisError(fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command)))
+		}
+		rank, err := store.ZRank(session.db, cmd.args[0], cmd.args[1], cmd.command == "ZREVRANK")
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			return writeRedisNil()
+		} else if err != nil {
+			return writeRedisError(err)
+		}
+		return writeRedisInt(rank)
+	case "ZRANGE", "ZREVRANGE", "ZRANGEBYSCORE", "ZREVRANGEBYSCORE", "ZRANGEBYLEX", "ZREVRANGEBYLEX":
+		return handleZRangeCommand(session, cmd, store)
+	case "ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREMRANGEBYLEX":
+		return handleZRemRangeCommand(session, cmd, store)
+	case "ZPOPMIN", "ZPOPMAX":
+		return handleZPopCommand(session, cmd, store)
+	case "KIWI":
+		if len(cmd.args) < 1 {
+			return writeRedisError(errors.New("wrong number of arguments for 'kiwi' command"))
+		}
+		return handleKiwiCommand(session, cmd, store)
 	default:
-		return writeRedisError(fmt.Errorf("unknown command '%s'", cmd.command))
+		msg := fmt.Sprintf("%s '%s'", unknownCommandError, cmd.command)
+		return RedisOutput{err: &msg}
 	}
 }
 
+// serve handles the given command, recording its metrics. The command is tracked as in-flight until the returned
+// `done` is called, i.e. after its output is written; when the server is shutting down, the command is rejected.
+func (rh *RedisHandler) serve(session *redisSession, cmd RedisCommand) (RedisOutput, func() /*done*/) {
+	startTime := time.Now()
+	var output RedisOutput
+	done := func() {}
+	if rh.tracker.begin() {
+		output, done = rh.handle(session, cmd), rh.tracker.end
+	} else {
+		msg := shuttingDownError
+		output = RedisOutput{err: &msg}
+	}
+	duration := time.Since(startTime)
+
+	command := cmd.command
+	if output.err != nil && strings.HasPrefix(*output.err, unknownCommandError) {
+		command = "unknown" // Clients may send anything, which would blow up the number of label values.
+	}
+	redisCommandsTotal.WithLabelValues(command).Inc()
+	redisCommandDuration.WithLabelValues(command).Observe(duration.Seconds())
+	if output.err != nil {
+		class, _, _ := strings.Cut(*output.err, " ")
+		redisCommandErrors.WithLabelValues(command, class).Inc()
+	}
+	redisReceivedBytes.Add(float64(len(cmd.raw)))
+	redisSentBytes.Add(float64(redisOutputSize(output)))
+	return output, done
+}
+
 // RunRedisServer starts a Redis protocol server that interacts with the provided KeyValueHolder storage.
 func RunRedisServer(ctx context.Context, store *KiwiStorage) error {
 	if *address == "" {
@@ -223,7 +1481,13 @@ func RunRedisServer(ctx context.Context, store *KiwiStorage) error {
 				args:    cmd.Args[1:],                         // Exclude the command itself.
 				raw:     cmd.Raw,
 			}
-			output := redisHandler.handle(redisCmd)
+			session, ok := conn.Context().(*redisSession)
+			if !ok {
+				session = &redisSession{}
+				conn.SetContext(session)
+			}
+			output, done := redisHandler.serve(session, redisCmd)
+			defer done()
 			if output.closeConnection {
 				conn.WriteBulk(output.writeBytes)
 				if err := conn.Close(); err != nil {
@@ -231,48 +1495,58 @@ func RunRedisServer(ctx context.Context, store *KiwiStorage) error {
 				}
 				return
 			}
-			if output.writeNil {
-				conn.WriteNull()
-				return
-			}
-			if output.err != nil {
-				conn.WriteError(*output.err)
-				return
-			}
-			if output.writeInt != nil {
-				conn.WriteInt(*output.writeInt)
-				return
+			writeRedisOutput(conn, output)
+			// Connections are closed right after in-flight commands are drained, so their output is flushed here.
+			if redisHandler.tracker.isDraining() {
+				if err := redcon.BaseWriter(conn).Flush(); err != nil {
+					slog.Error("Failed to flush the output of a command.", "error", err)
+				}
 			}
-			conn.WriteBulk(output.writeBytes)
 		},
 		/*accept*/ func(conn redcon.Conn) bool {
 			slog.Info("Accepting connection.", "addr", conn.NetConn().RemoteAddr().String())
+			conn.SetContext(&redisSession{}) // Each connection starts on DB 0.
+			redisAcceptedConnections.Inc()
+			redisConnectedClients.Inc()
 			return true // Accept all connections.
 		},
 		/*close*/ func(conn redcon.Conn, err error) {
 			// TODO: handle connection errors if needed.
+			redisClosedConnections.Inc()
+			redisConnectedClients.Dec()
 		})
 
-	serverErrSignal := make(chan error, 1)
+	listener, err := net.Listen("tcp", *address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", *address, err)
+	}
+	drainingListener := newDrainingListener(listener)
+	serverErrSignal, serverDone := make(chan error, 1), make(chan struct{})
 	go func() {
+		defer close(serverDone)
 		slog.Info("Starting Redis server.", "address", *address)
-		if err := redisServer.ListenAndServe(); err != nil {
+		if err := redisServer.Serve(drainingListener); err != nil {
 			serverErrSignal <- err
 		}
-		close(serverErrSignal)
 	}()
 
+	request := shutdownRequest{save: true}
 	select {
 	case <-ctx.Done():
 		slog.Info("Server context cancelled", "err", ctx.Err())
-		serverErr := redisServer.Close()
-		storeErr := store.Close()
-		if exitErr := errors.Join(serverErr, storeErr); exitErr != nil {
-			return fmt.Errorf("failed to close kiwi: %w", exitErr)
+	case request = <-redisHandler.shutdownRequests:
+		slog.Info("Received SHUTDOWN command.", "save", request.save)
+	case <-serverDone:
+		err := errors.New("server stopped serving")
+		select {
+		case err = <-serverErrSignal:
+		default:
 		}
-	case err := <-serverErrSignal:
-		return fmt.Errorf("redis server stopped unexpectedly: %w", err)
+		return errors.Join(fmt.Errorf("redis server stopped unexpectedly: %w", err), store.Close())
 	}
 
+	if err := shutdownRedisServer(drainingListener, serverDone, &redisHandler.tracker, store, request); err != nil {
+		return fmt.Errorf("failed to shut down kiwi gracefully: %w", err)
+	}
 	return nil // Exited with no errors.
 }
@@ -0,0 +1,228 @@
+package port
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRedisHandler returns a handler over a fresh store with the given keys set.
+func newTestRedisHandler(t *testing.T, keys ...string) *RedisHandler {
+	t.Helper()
+	config.SetTestFlag(t, "data_dir", t.TempDir())
+	store, err := NewKiwiStorage()
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, store.Close()) })
+	for _, key := range keys {
+		require.NoError(t, store.Set(0, SetCommand{key: []byte(key), value: []byte("v")}).err)
+	}
+	handler, err := NewRedisHandler(store)
+	require.NoError(t, err)
+	return handler
+}
+
+// newTestRedisCommand builds a command from its arguments, e.g. "SCAN", "0".
+func newTestRedisCommand(command string, args ...string) RedisCommand {
+	cmd := RedisCommand{command: command, raw: []byte(strings.Join(append([]string{command}, args...), " "))}
+	for _, arg := range args {
+		cmd.args = append(cmd.args, []byte(arg))
+	}
+	return cmd
+}
+
+// bulkStrings returns the bulk strings of an array output.
+func bulkStrings(t *testing.T, output RedisOutput) []string {
+	t.Helper()
+	require.Nil(t, output.err)
+	require.NotNil(t, output.writeArray, "Expected an array output")
+	values := make([]string, len(output.writeArray))
+	for i, item := range output.writeArray {
+		values[i] = string(item.writeBytes)
+	}
+	return values
+}
+
+func TestRedisHandler_Scan(t *testing.T) {
+	handler := newTestRedisHandler(t, "a:1", "a:2", "a:3", "b:1", "b:2")
+	session := &redisSession{}
+
+	t.Run("cursor_round_trip", func(t *testing.T) {
+		var keys []string
+		cursor := scanCursorDone
+		for {
+			output := handler.handle(session, newTestRedisCommand("SCAN", cursor, "MATCH", "a:*", "COUNT", "2"))
+			require.Nil(t, output.err)
+			require.Len(t, output.writeArray, 2)
+			keys = append(keys, bulkStrings(t, output.writeArray[1])...)
+			cursor = string(output.writeArray[0].writeBytes)
+			if cursor == scanCursorDone {
+				break
+			}
+		}
+		assert.Equal(t, []string{"a:1", "a:2", "a:3"}, keys)
+	})
+	t.Run("default_count", func(t *testing.T) {
+		output := handler.handle(session, newTestRedisCommand("SCAN", "0"))
+		require.Len(t, output.writeArray, 2)
+		assert.Equal(t, scanCursorDone, string(output.writeArray[0].writeBytes))
+		assert.Equal(t, []string{"a:1", "a:2", "a:3", "b:1", "b:2"}, bulkStrings(t, output.writeArray[1]))
+	})
+	t.Run("invalid_arguments", func(t *testing.T) {
+		for _, args := range [][]string{{}, {"not-a-cursor!"}, {"0", "COUNT", "0"}, {"0", "COUNT", "x"},
+			{"0", "MATCH"}, {"0", "TYPE", "string"}} {
+			assert.NotNil(t, handler.handle(session, newTestRedisCommand("SCAN", args...)).err, "Expected an error for %q", args)
+		}
+	})
+}
+
+func TestRedisHandler_Keys(t *testing.T) {
+	handler := newTestRedisHandler(t, "a:1", "a:2", "b:1")
+	session := &redisSession{}
+	require.Nil(t, handler.handle(session, newTestRedisCommand("DEL", "a:2")).err)
+
+	assert.Equal(t, []string{"a:1", "b:1"}, bulkStrings(t, handler.handle(session, newTestRedisCommand("KEYS", "*"))))
+	assert.Equal(t, []string{"a:1"}, bulkStrings(t, handler.handle(session, newTestRedisCommand("KEYS", "a*"))))
+	assert.Empty(t, bulkStrings(t, handler.handle(session, newTestRedisCommand("KEYS", "c*"))))
+	assert.Equal(t, 2, *handler.handle(session, newTestRedisCommand("DBSIZE")).writeInt)
+	assert.Equal(t, 3, *handler.handle(session, newTestRedisCommand("EXISTS", "a:1", "a:1", "a:2", "b:1", "c")).writeInt)
+}
+
+func TestRedisHandler_Databases(t *testing.T) {
+	config.SetTestFlag(t, "databases", "2")
+	handler := newTestRedisHandler(t, "k")
+	first, second := &redisSession{}, &redisSession{}
+	get := func(session *redisSession) RedisOutput {
+		return handler.handle(session, newTestRedisCommand("GET", "k"))
+	}
+
+	assert.NotNil(t, handler.handle(first, newTestRedisCommand("SELECT", "2")).err, "Expected DB 2 to be out of range")
+	assert.NotNil(t, handler.handle(first, newTestRedisCommand("SELECT", "x")).err)
+	require.Nil(t, handler.handle(second, newTestRedisCommand("SELECT", "1")).err)
+	assert.True(t, get(second).writeNil, "Expected the selection to be per connection")
+	assert.Equal(t, "v", string(get(first).writeBytes))
+
+	require.Nil(t, handler.handle(first, newTestRedisCommand("SWAPDB", "0", "1")).err)
+	assert.True(t, get(first).writeNil)
+	assert.Equal(t, "v", string(get(second).writeBytes))
+	assert.NotNil(t, handler.handle(first, newTestRedisCommand("SWAPDB", "0", "x")).err)
+
+	assert.NotNil(t, handler.handle(second, newTestRedisCommand("FLUSHDB", "LATER")).err)
+	require.Nil(t, handler.handle(second, newTestRedisCommand("FLUSHDB", "ASYNC")).err)
+	assert.True(t, get(second).writeNil)
+	require.Nil(t, handler.handle(first, newTestRedisCommand("SET", "k", "v")).err)
+	require.Nil(t, handler.handle(second, newTestRedisCommand("FLUSHALL")).err)
+	assert.True(t, get(first).writeNil)
+}
+
+func TestRedisHandler_Expiry(t *testing.T) {
+	handler := newTestRedisHandler(t, "k", "other")
+	session := &redisSession{}
+	integer := func(command string, args ...string) int {
+		output := handler.handle(session, newTestRedisCommand(command, args...))
+		require.Nil(t, output.err, "Unexpected error for %s %q", command, args)
+		require.NotNil(t, output.writeInt)
+		return *output.writeInt
+	}
+
+	assert.Equal(t, -2, integer("TTL", "missing"))
+	assert.Equal(t, -1, integer("TTL", "k"))
+	assert.Equal(t, -1, integer("PEXPIRETIME", "k"))
+	assert.Equal(t, 0, integer("EXPIRE", "missing", "10"))
+	assert.Equal(t, 1, integer("EXPIRE", "k", "100"))
+	assert.Equal(t, 100, integer("TTL", "k"))
+	assert.InDelta(t, 100_000, integer("PTTL", "k"), 1_000)
+	assert.InDelta(t, time.Now().Unix()+100, integer("EXPIRETIME", "k"), 1)
+	assert.Equal(t, 0, integer("EXPIRE", "k", "50", "GT"))
+	assert.Equal(t, 1, integer("PEXPIRE", "k", "50000", "lt"))
+	assert.Equal(t, 50, integer("TTL", "k"))
+	assert.Equal(t, 1, integer("EXPIREAT", "k", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10), "XX", "GT"))
+	assert.InDelta(t, 3600, integer("TTL", "k"), 1)
+	assert.Equal(t, 1, integer("PERSIST", "k"))
+	assert.Equal(t, 0, integer("PERSIST", "k"))
+	assert.Equal(t, -1, integer("TTL", "k"))
+
+	for _, args := range [][]string{{"k"}, {"k", "x"}, {"k", "10", "NX", "XX"}, {"k", "10", "GT", "LT"},
+		{"k", "10", "YY"}, {"k", "9223372036854775807"}} {
+		assert.NotNil(t, handler.handle(session, newTestRedisCommand("EXPIRE", args...)).err,
+			"Expected an error for %q", args)
+	}
+
+	t.Run("getex", func(t *testing.T) {
+		assert.Equal(t, "v", string(handler.handle(session, newTestRedisCommand("GETEX", "k", "EX", "20")).writeBytes))
+		assert.Equal(t, 20, integer("TTL", "k"))
+		assert.Equal(t, "v", string(handler.handle(session, newTestRedisCommand("GETEX", "k", "PERSIST")).writeBytes))
+		assert.Equal(t, -1, integer("TTL", "k"))
+		assert.True(t, handler.handle(session, newTestRedisCommand("GETEX", "missing")).writeNil)
+		for _, args := range [][]string{{"k", "EX", "0"}, {"k", "EX"}, {"k", "PERSIST", "EX", "1"}, {"k", "KEEPTTL"}} {
+			assert.NotNil(t, handler.handle(session, newTestRedisCommand("GETEX", args...)).err,
+				"Expected an error for %q", args)
+		}
+	})
+	t.Run("expire_in_the_past_deletes", func(t *testing.T) {
+		assert.Equal(t, 1, integer("PEXPIREAT", "other", "1"))
+		assert.Equal(t, -2, integer("TTL", "other"))
+		assert.Equal(t, 0, integer("EXISTS", "other"))
+	})
+}
+
+func TestRedisHandler_Delete(t *testing.T) {
+	handler := newTestRedisHandler(t, "a", "b", "c")
+	session := &redisSession{}
+	count := func(command string, args ...string) int {
+		output := handler.handle(session, newTestRedisCommand(command, args...))
+		require.Nil(t, output.err)
+		require.NotNil(t, output.writeInt)
+		return *output.writeInt
+	}
+
+	assert.Equal(t, 1, count("DEL", "a", "a", "missing"), "Expected repeated keys to be deleted once")
+	assert.Equal(t, 2, count("UNLINK", "a", "b", "c"))
+	assert.Zero(t, count("EXISTS", "a", "b", "c"))
+	assert.Zero(t, count("DEL", "a", "b"), "Expected deleted keys not to be counted")
+	assert.NotNil(t, handler.handle(session, newTestRedisCommand("UNLINK")).err)
+}
+
+func TestRedisHandler_Metrics(t *testing.T) {
+	handler := newTestRedisHandler(t, "k")
+	session := &redisSession{}
+	commands := func(command string) float64 { return testutil.ToFloat64(redisCommandsTotal.WithLabelValues(command)) }
+	getsBefore, unknownBefore := commands("GET"), commands("unknown")
+	errorsBefore := testutil.ToFloat64(redisCommandErrors.WithLabelValues("GET", "ERR"))
+	sentBefore, receivedBefore := testutil.ToFloat64(redisSentBytes), testutil.ToFloat64(redisReceivedBytes)
+
+	cmd := newTestRedisCommand("GET", "k")
+	output, done := handler.serve(session, cmd)
+	done()
+	assert.Equal(t, "v", string(output.writeBytes))
+	for _, cmd := range []RedisCommand{newTestRedisCommand("GET"), newTestRedisCommand("NOT-A-COMMAND", "k")} {
+		_, done := handler.serve(session, cmd)
+		done()
+	}
+
+	assert.Equal(t, 2.0, commands("GET")-getsBefore)
+	assert.Equal(t, 1.0, commands("unknown")-unknownBefore, "Expected unknown commands to share a label")
+	assert.Equal(t, 1.0, testutil.ToFloat64(redisCommandErrors.WithLabelValues("GET", "ERR"))-errorsBefore)
+	assert.Greater(t, testutil.ToFloat64(redisReceivedBytes)-receivedBefore, float64(len(cmd.raw)))
+	assert.Greater(t, testutil.ToFloat64(redisSentBytes)-sentBefore, float64(redisOutputSize(output)))
+}
+
+func TestRedisOutputSize(t *testing.T) {
+	msg := "ERR oops"
+	for expected, output := range map[string]RedisOutput{
+		"$-1\r\n":                 writeRedisNil(),
+		"-ERR oops\r\n":           {err: &msg},
+		":-42\r\n":                writeRedisInt(-42),
+		"$5\r\nhello\r\n":         writeRedisString("hello"),
+		"*2\r\n:1\r\n$1\r\na\r\n": writeRedisArray(writeRedisInt(1), writeRedisString("a")),
+		"*0\r\n":                  writeRedisArray(),
+		"*1\r\n*1\r\n$0\r\n\r\n":  writeRedisArray(writeRedisArray(writeRedisString(""))),
+	} {
+		assert.Equal(t, len(expected), redisOutputSize(output), "Unexpected size of %q", expected)
+	}
+}
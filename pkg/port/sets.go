@@ -0,0 +1,354 @@
+// Redis sets are unordered collections of unique members; each member is stored as its own member key with an empty
+// value, see collections.go. So membership checks are point lookups, which skip most parts by their bloom filters,
+// and the members of a set are scanned in ascending order. Intersections, unions and differences merge the member
+// scans of their sets on the fly, so the sets are never loaded in memory as a whole.
+
+package port
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/scan"
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/nobletooth/kiwi/pkg/utils"
+)
+
+// SetOperation is an operation of the set algebra, e.g. the Redis SINTER command.
+type SetOperation uint8
+
+const (
+	SetUnion SetOperation = iota // The members of any of the sets.
+	SetInter                     // The members of every set.
+	SetDiff                      // The members of the first set which aren't members of the rest of them.
+)
+
+// setHead is a member pulled from the member scan of one of the sets of an operation.
+type setHead struct {
+	member []byte
+	set    int // The index of the set among the operands.
+}
+
+// compareSetHeads orders set heads by member, and then by the index of their set.
+func compareSetHeads(a, b setHead) int {
+	if c := bytes.Compare(a.member, b.member); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.set, b.set)
+}
+
+// combineSets returns an iterator over the result of the given operation on the given member scans, in ascending
+// order. The scans are merged by scan.MultiHead, so only one member of each of them is held at a time; and since
+// every member of a set is unique, the number of sets which have a member is the number of its heads.
+func combineSets(op SetOperation, sets []iter.Seq[utils.BytePair]) (iter.Seq[[]byte], error) {
+	sequences := make([]iter.Seq[utils.Pair[setHead, struct{}]], len(sets))
+	for i, members := range sets {
+		sequences[i] = func(yield func(utils.Pair[setHead, struct{}]) bool) {
+			for pair := range members {
+				if !yield(utils.Pair[setHead, struct{}]{Key: setHead{member: pair.Key, set: i}}) {
+					return
+				}
+			}
+		}
+	}
+	merged, err := scan.MultiHead(compareSetHeads, sequences)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge sets: %w", err)
+	}
+
+	return func(yield func([]byte) bool) {
+		var member []byte
+		found, inFirst, sets := false, false, 0
+		// emit yields the current member if it's in the result; returns false once the iteration is stopped.
+		emit := func() bool {
+			if (op == SetInter && sets < len(sequences)) || (op == SetDiff && (!inFirst || sets > 1)) {
+				return true
+			}
+			return yield(member)
+		}
+		for pair := range merged {
+			if found && bytes.Equal(pair.Key.member, member) {
+				sets++
+				continue
+			}
+			if found && !emit() {
+				return
+			}
+			// Heads of the same member are ordered by set, so its first head tells whether the first set has it.
+			found, member, inFirst, sets = true, pair.Key.member, pair.Key.set == 0, 1
+		}
+		if found {
+			emit()
+		}
+	}, nil
+}
+
+// combine returns an iterator over the result of the given operation on the sets at `keys`, see combineSets; keys
+// which don't exist are empty sets, while keys holding other types fail with errWrongType, same as Redis.
+func (kdb *kiwiDB) combine(op SetOperation, keys [][]byte, now time.Time) (iter.Seq2[[]byte, error], error) {
+	sets := make([]iter.Seq[utils.BytePair], len(keys))
+	scanErrs := make([]error, len(keys))
+	isEmpty := false // Whether the result is known to be empty without merging the sets.
+	for i, key := range keys {
+		set, err := kdb.getCollection(key, SetType, now)
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			isEmpty = isEmpty || op == SetInter || (op == SetDiff && i == 0)
+			sets[i] = func(yield func(utils.BytePair) bool) {}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sets[i] = untilError(kdb.members(set, nil /*start*/), &scanErrs[i])
+	}
+	if isEmpty {
+		return func(yield func([]byte, error) bool) {}, nil
+	}
+	combined, err := combineSets(op, sets)
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func([]byte, error) bool) {
+		for member := range combined {
+			// A failed scan would change the result, e.g. the members of a failed set would be left in a difference.
+			if err := errors.Join(scanErrs...); err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(member, nil) {
+				return
+			}
+		}
+		if err := errors.Join(scanErrs...); err != nil {
+			yield(nil, err)
+		}
+	}, nil
+}
+
+// SAdd adds the given members to the set at `key`, creating it if it doesn't exist; it returns the number of members
+// that were added, e.g. the Redis SADD command.
+func (ks *KiwiStorage) SAdd(db int, key []byte, members ...[]byte) (int, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	set, err := kdb.getOrCreateCollection(key, SetType, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, member := range members {
+		// Unlike hash fields, existing members have nothing to update; so they're left alone.
+		if _, err := kdb.getMember(set, member); err == nil {
+			continue
+		} else if !errors.Is(err, storage.ErrKeyNotFound) {
+			return added, err
+		}
+		if err := kdb.putMember(set, member, nil /*value*/); err != nil {
+			return added, err
+		}
+		set.count++
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	if err := kdb.saveCollection(key, set); err != nil {
+		return added, fmt.Errorf("failed to save set: %w", err)
+	}
+	return added, nil
+}
+
+// SRem removes the given members of the set at `key`, and the set itself once it's empty; it returns the number of
+// members that existed.
+func (ks *KiwiStorage) SRem(db int, key []byte, members ...[]byte) (int, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	set, err := kdb.getCollection(key, SetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		existed, err := kdb.deleteMember(&set, member)
+		if err != nil {
+			return removed, err
+		}
+		if existed {
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := kdb.saveCollection(key, set); err != nil {
+		return removed, fmt.Errorf("failed to save set: %w", err)
+	}
+	return removed, nil
+}
+
+// SIsMember returns true if `member` is a member of the set at `key`.
+func (ks *KiwiStorage) SIsMember(db int, key, member []byte) (bool, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return false, err
+	}
+	set, err := kdb.getCollection(key, SetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if _, err := kdb.getMember(set, member); errors.Is(err, storage.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SCard returns the number of members of the set at `key`, which is zero if it doesn't exist.
+func (ks *KiwiStorage) SCard(db int, key []byte) (int, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return 0, err
+	}
+	set, err := kdb.getCollection(key, SetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return set.count, nil
+}
+
+// SMembers returns every member of the set at `key` in ascending order; it's empty if the set doesn't exist.
+func (ks *KiwiStorage) SMembers(db int, key []byte) ([][]byte, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	set, err := kdb.getCollection(key, SetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return [][]byte{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	members := make([][]byte, 0, set.count)
+	for pair, err := range kdb.members(set, nil /*start*/) {
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, pair.Key)
+	}
+	return members, nil
+}
+
+// SScan returns the members of the set at `key` matching the given `cmd`, and the member to resume the scan from;
+// same as Scan, at most `count` members are examined.
+func (ks *KiwiStorage) SScan(db int, key []byte, cmd ScanCommand) ([][]byte, []byte /*next*/, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	set, err := kdb.getCollection(key, SetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return [][]byte{}, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	var next []byte
+	var scanErr error
+	members := untilError(kdb.members(set, cmd.start), &scanErr)
+	pairs := func(yield func(utils.BytePair) bool) {
+		examined := 0
+		for pair := range members {
+			if cmd.count > 0 && examined == cmd.count {
+				next = pair.Key
+				return
+			}
+			examined++
+			if !yield(pair) {
+				return
+			}
+		}
+	}
+	if cmd.pattern != nil {
+		pairs = scan.MatchGlob(cmd.pattern, pairs)
+	}
+	matched := [][]byte{}
+	for pair := range pairs {
+		matched = append(matched, pair.Key)
+	}
+	if scanErr != nil {
+		return nil, nil, scanErr
+	}
+	return matched, next, nil
+}
+
+// SCombine returns the result of the given operation on the sets at `keys` in ascending order, e.g. the Redis SINTER
+// command; keys which don't exist are empty sets.
+func (ks *KiwiStorage) SCombine(db int, op SetOperation, keys ...[]byte) ([][]byte, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	members, err := kdb.combine(op, keys, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	result := [][]byte{}
+	for member, err := range members {
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, member)
+	}
+	return result, nil
+}
+
+// SCombineStore stores the result of the given operation on the sets at `keys` as a set at `dest`, which is
+// overwritten regardless of its type, or deleted if the result is empty; it returns the number of members of the
+// result, e.g. the Redis SINTERSTORE command.
+func (ks *KiwiStorage) SCombineStore(db int, op SetOperation, dest []byte, keys ...[]byte) (int, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	members, err := kdb.combine(op, keys, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	// The result gets a new version, so the members of `dest` are scanned as they were, even if it's one of the sets.
+	result := kdb.newCollection(dest, SetType)
+	for member, err := range members {
+		if err != nil { // The members written so far are unreachable, as the result is never saved.
+			return 0, errors.Join(err, kdb.orphan(result))
+		}
+		if err := kdb.putMember(result, member, nil /*value*/); err != nil {
+			return 0, err
+		}
+		result.count++
+	}
+	if _, err := kdb.delete(dest); err != nil {
+		return 0, fmt.Errorf("failed to delete the destination: %w", err)
+	}
+	if err := kdb.saveCollection(dest, result); err != nil {
+		return 0, fmt.Errorf("failed to save set: %w", err)
+	}
+	return result.count, nil
+}
@@ -0,0 +1,250 @@
+package port
+
+import (
+	"iter"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// byteSlices converts the given strings to byte slices, e.g. the members of a set.
+func byteSlices(strs ...string) [][]byte {
+	converted := make([][]byte, len(strs))
+	for i, str := range strs {
+		converted[i] = []byte(str)
+	}
+	return converted
+}
+
+func TestCombineSets(t *testing.T) {
+	members := func(members ...string) iter.Seq[utils.BytePair] {
+		pairs := make([]utils.BytePair, len(members))
+		for i, member := range members {
+			pairs[i] = utils.BytePair{Key: []byte(member)}
+		}
+		return slices.Values(pairs)
+	}
+	for _, testCase := range []struct {
+		op   SetOperation
+		sets [][]string
+		want []string
+	}{
+		{op: SetUnion, sets: [][]string{{"a", "c"}, {"b", "c", "d"}, {}}, want: []string{"a", "b", "c", "d"}},
+		{op: SetInter, sets: [][]string{{"a", "b", "c"}, {"b", "c", "d"}, {"c", "b"}}, want: []string{"b", "c"}},
+		{op: SetInter, sets: [][]string{{"a", "b"}, {}}, want: nil},
+		{op: SetDiff, sets: [][]string{{"a", "b", "c", "e"}, {"b"}, {"c", "d"}}, want: []string{"a", "e"}},
+		{op: SetDiff, sets: [][]string{{"a", "b"}, {"a", "b"}}, want: nil},
+		{op: SetDiff, sets: [][]string{{}, {"a"}}, want: nil},
+		{op: SetInter, sets: [][]string{{"", "a"}, {"", "b"}}, want: []string{""}},
+	} {
+		sets := make([]iter.Seq[utils.BytePair], len(testCase.sets))
+		for i, set := range testCase.sets {
+			slices.Sort(set)
+			sets[i] = members(set...)
+		}
+		combined, err := combineSets(testCase.op, sets)
+		require.NoError(t, err)
+		var got []string
+		for member := range combined {
+			got = append(got, string(member))
+		}
+		assert.Equal(t, testCase.want, got, "Unexpected result of %d on %q", testCase.op, testCase.sets)
+	}
+	_, err := combineSets(SetUnion, nil /*sets*/)
+	assert.Error(t, err)
+}
+
+func TestKiwiStorage_Sets(t *testing.T) {
+	config.SetTestFlag(t, "enable_active_expiry", "false") // Expiry cycles are run manually.
+	store := newTestRedisHandler(t, "str").store
+	key := []byte("s")
+
+	added, err := store.SAdd(0, key, byteSlices("b", "a", "c", "a")...)
+	require.NoError(t, err)
+	assert.Equal(t, 3, added, "Expected duplicate members to be added once")
+	added, err = store.SAdd(0, key, byteSlices("c", "d")...)
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	count, err := store.SCard(0, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+	members, err := store.SMembers(0, key)
+	assert.NoError(t, err)
+	assert.Equal(t, byteSlices("a", "b", "c", "d"), members)
+	for member, want := range map[string]bool{"a": true, "d": true, "missing": false} {
+		isMember, err := store.SIsMember(0, key, []byte(member))
+		assert.NoError(t, err)
+		assert.Equal(t, want, isMember, "Unexpected membership of %q", member)
+	}
+	isMember, err := store.SIsMember(0, []byte("missing"), []byte("a"))
+	assert.NoError(t, err)
+	assert.False(t, isMember)
+
+	removed, err := store.SRem(0, key, byteSlices("a", "missing", "b")...)
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	count, err = store.SCard(0, key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	removed, err = store.SRem(0, key, byteSlices("c", "d")...)
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	exists, err := store.Exists(0, key)
+	require.NoError(t, err)
+	assert.False(t, exists, "Expected sets to be deleted with their last member")
+	assert.Zero(t, liveMembers(t, store, 0))
+
+	t.Run("wrong_type", func(t *testing.T) {
+		_, err := store.SAdd(0, []byte("str"), []byte("a"))
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.SIsMember(0, []byte("str"), []byte("a"))
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.HSet(0, []byte("hash"), fieldPairs("f", "v"))
+		require.NoError(t, err)
+		_, err = store.SMembers(0, []byte("hash"))
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.SCombine(0, SetInter, []byte("missing"), []byte("hash"))
+		assert.ErrorIs(t, err, errWrongType, "Expected every key to be checked, even if the result is known to be empty")
+	})
+	t.Run("expiry", func(t *testing.T) {
+		_, err := store.SAdd(0, []byte("e"), byteSlices("a", "b")...)
+		require.NoError(t, err)
+		updated, err := store.Expire(0, ExpireCommand{key: []byte("e"), expiryTime: time.Now().Add(10 * time.Millisecond)})
+		require.NoError(t, err)
+		require.True(t, updated)
+		time.Sleep(20 * time.Millisecond)
+		isMember, err := store.SIsMember(0, []byte("e"), []byte("a"))
+		assert.NoError(t, err)
+		assert.False(t, isMember)
+
+		before := liveMembers(t, store, 0)
+		_, err = store.expireKeys(time.Now(), 10 /*limit*/)
+		require.NoError(t, err)
+		assert.Equal(t, before-2, liveMembers(t, store, 0), "Expected active expiry to delete the members")
+	})
+}
+
+func TestKiwiStorage_SCombine(t *testing.T) {
+	store := newTestRedisHandler(t, "str").store
+	for key, members := range map[string][]string{"s1": {"a", "b", "c", "d"}, "s2": {"c", "d", "e"}, "s3": {"d", "f"}} {
+		_, err := store.SAdd(0, []byte(key), byteSlices(members...)...)
+		require.NoError(t, err)
+	}
+	combine := func(op SetOperation, keys ...string) []string {
+		t.Helper()
+		members, err := store.SCombine(0, op, byteSlices(keys...)...)
+		require.NoError(t, err)
+		return bulkMembers(members)
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f"}, combine(SetUnion, "s1", "s2", "s3", "missing"))
+	assert.Equal(t, []string{"d"}, combine(SetInter, "s1", "s2", "s3"))
+	assert.Equal(t, []string{"c", "d"}, combine(SetInter, "s1", "s2"))
+	assert.Empty(t, combine(SetInter, "s1", "missing"))
+	assert.Equal(t, []string{"a", "b"}, combine(SetDiff, "s1", "s2", "missing"))
+	assert.Empty(t, combine(SetDiff, "missing", "s1"))
+
+	count, err := store.SCombineStore(0, SetUnion, []byte("str"), byteSlices("s2", "s3")...)
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	members, err := store.SMembers(0, []byte("str"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d", "e", "f"}, bulkMembers(members), "Expected the destination to be overwritten")
+
+	// The destination may be one of the sets, whose old members are deleted.
+	before := liveMembers(t, store, 0)
+	count, err = store.SCombineStore(0, SetDiff, []byte("s1"), byteSlices("s1", "s2")...)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"a", "b"}, combine(SetUnion, "s1"))
+	assert.Equal(t, before-2, liveMembers(t, store, 0))
+
+	count, err = store.SCombineStore(0, SetInter, []byte("s1"), byteSlices("s1", "s3")...)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	_, err = store.Get(0, []byte("s1"))
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound, "Expected empty results to delete the destination")
+}
+
+// bulkMembers converts the given members to strings.
+func bulkMembers(members [][]byte) []string {
+	strs := make([]string, len(members))
+	for i, member := range members {
+		strs[i] = string(member)
+	}
+	return strs
+}
+
+func TestKiwiStorage_SScan(t *testing.T) {
+	store := newTestRedisHandler(t).store
+	key := []byte("s")
+	for i := range 10 {
+		_, err := store.SAdd(0, key, []byte("m"+strconv.Itoa(i)))
+		require.NoError(t, err)
+	}
+	_, err := store.SAdd(0, key, []byte("other"))
+	require.NoError(t, err)
+
+	var scanned []string
+	cmd := ScanCommand{pattern: []byte("m*"), count: 4}
+	for {
+		members, next, err := store.SScan(0, key, cmd)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(members), 4)
+		scanned = append(scanned, bulkMembers(members)...)
+		if next == nil {
+			break
+		}
+		cmd.start = next
+	}
+	assert.Equal(t, []string{"m0", "m1", "m2", "m3", "m4", "m5", "m6", "m7", "m8", "m9"}, scanned)
+}
+
+func TestRedisHandler_Sets(t *testing.T) {
+	handler := newTestRedisHandler(t, "str")
+	session := &redisSession{}
+	do := func(command string, args ...string) RedisOutput {
+		return handler.handle(session, newTestRedisCommand(command, args...))
+	}
+
+	assert.Equal(t, 3, *do("SADD", "s1", "a", "b", "c").writeInt)
+	assert.Equal(t, 3, *do("SADD", "s2", "b", "c", "d").writeInt)
+	assert.NotNil(t, do("SADD", "s1").err)
+	assert.Equal(t, 1, *do("SISMEMBER", "s1", "a").writeInt)
+	assert.Equal(t, 0, *do("SISMEMBER", "s1", "d").writeInt)
+	assert.Equal(t, 3, *do("SCARD", "s1").writeInt)
+	assert.Equal(t, 0, *do("SCARD", "missing").writeInt)
+	assert.Equal(t, []string{"a", "b", "c"}, bulkStrings(t, do("SMEMBERS", "s1")))
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, bulkStrings(t, do("SUNION", "s1", "s2")))
+	assert.Equal(t, []string{"b", "c"}, bulkStrings(t, do("SINTER", "s1", "s2")))
+	assert.Equal(t, []string{"a"}, bulkStrings(t, do("SDIFF", "s1", "s2")))
+	assert.Equal(t, 1, *do("SDIFFSTORE", "d", "s2", "s1").writeInt)
+	assert.Equal(t, []string{"d"}, bulkStrings(t, do("SMEMBERS", "d")))
+	assert.Equal(t, 2, *do("SINTERSTORE", "d", "s1", "s2").writeInt)
+	assert.Equal(t, 4, *do("SUNIONSTORE", "d", "s1", "s2").writeInt)
+	assert.NotNil(t, do("SUNIONSTORE", "d").err)
+
+	sscan := do("SSCAN", "s1", "0", "MATCH", "[ab]")
+	require.Nil(t, sscan.err)
+	require.Len(t, sscan.writeArray, 2)
+	assert.Equal(t, scanCursorDone, string(sscan.writeArray[0].writeBytes))
+	assert.Equal(t, []string{"a", "b"}, bulkStrings(t, sscan.writeArray[1]))
+
+	assert.Equal(t, 2, *do("SREM", "s1", "a", "b", "missing").writeInt)
+	assert.Equal(t, []string{"c"}, bulkStrings(t, do("SMEMBERS", "s1")))
+
+	wrongType := do("SADD", "str", "a")
+	require.NotNil(t, wrongType.err)
+	assert.Regexp(t, "^WRONGTYPE ", *wrongType.err)
+	wrongType = do("SUNION", "s1", "str")
+	require.NotNil(t, wrongType.err)
+	assert.Regexp(t, "^WRONGTYPE ", *wrongType.err)
+}
@@ -0,0 +1,178 @@
+// Kiwi shuts down gracefully, either when its context is cancelled (e.g. on SIGTERM) or on the SHUTDOWN command:
+//  1. The listener stops accepting connections, while the accepted ones are kept open.
+//  2. In-flight commands are given --shutdown_timeout to finish; new commands are rejected with a SHUTDOWN error.
+//  3. The remaining connections are closed, and the storage is closed; unless the SHUTDOWN command was given NOSAVE,
+//     memtables are flushed to disk, so that the write-ahead logs don't have to be replayed on the next start.
+
+package port
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var shutdownTimeout = flag.Duration("shutdown_timeout", 10*time.Second,
+	"The maximum time to wait for in-flight commands to finish when shutting down.")
+
+// shuttingDownError is returned for the commands received while the server is shutting down.
+const shuttingDownError = "SHUTDOWN Kiwi is shutting down"
+
+// errShutdownTimeout is returned when in-flight commands don't finish within --shutdown_timeout.
+var errShutdownTimeout = errors.New("in-flight commands didn't finish before the shutdown timeout")
+
+// shutdownRequest asks the server to shut down, e.g. by the SHUTDOWN command.
+type shutdownRequest struct {
+	save bool // Whether to flush memtables to disk.
+}
+
+// parseShutdownCommand parses the arguments of SHUTDOWN [NOSAVE|SAVE].
+func parseShutdownCommand(args [][]byte) (shutdownRequest, error) {
+	request := shutdownRequest{save: true}
+	if len(args) > 1 {
+		return request, errors.New("syntax error")
+	}
+	if len(args) == 1 {
+		switch strings.ToUpper(string(args[0])) {
+		case "SAVE":
+		case "NOSAVE":
+			request.save = false
+		default:
+			return request, errors.New("syntax error")
+		}
+	}
+	return request, nil
+}
+
+// commandTracker keeps track of in-flight commands, so that the server can wait for them when shutting down.
+type commandTracker struct {
+	mux      sync.RWMutex
+	draining bool
+	inFlight sync.WaitGroup
+}
+
+// begin marks the start of a command; it returns false if the server is shutting down, and the command is rejected.
+// Accepted commands should call end when they're done.
+func (ct *commandTracker) begin() bool {
+	ct.mux.RLock()
+	defer ct.mux.RUnlock()
+	if ct.draining {
+		return false
+	}
+	ct.inFlight.Add(1)
+	return true
+}
+
+// isDraining returns whether the server is shutting down.
+func (ct *commandTracker) isDraining() bool {
+	ct.mux.RLock()
+	defer ct.mux.RUnlock()
+	return ct.draining
+}
+
+// end marks the end of a command.
+func (ct *commandTracker) end() {
+	ct.inFlight.Done()
+}
+
+// drain rejects new commands, and waits up to `timeout` for the in-flight ones; it returns false on timeout.
+func (ct *commandTracker) drain(timeout time.Duration) bool {
+	ct.mux.Lock()
+	ct.draining = true
+	ct.mux.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ct.inFlight.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// drainingListener is a listener which can stop accepting connections without closing the accepted ones.
+// Redcon closes every connection as soon as its listener fails, so the failure is held back until release is called.
+type drainingListener struct {
+	net.Listener
+	stopOnce    sync.Once
+	stopping    chan struct{} // Closed when the listener stops accepting connections.
+	releaseOnce sync.Once
+	released    chan struct{} // Closed when the accepted connections can be closed.
+}
+
+func newDrainingListener(listener net.Listener) *drainingListener {
+	return &drainingListener{Listener: listener, stopping: make(chan struct{}), released: make(chan struct{})}
+}
+
+// Accept waits for the next connection; after stopAccepting, it blocks until release is called.
+func (dl *drainingListener) Accept() (net.Conn, error) {
+	conn, err := dl.Listener.Accept()
+	if err != nil {
+		select {
+		case <-dl.stopping:
+			<-dl.released
+		default:
+		}
+	}
+	return conn, err
+}
+
+// stopAccepting closes the underlying listener, so that new connections are refused.
+func (dl *drainingListener) stopAccepting() error {
+	var err error
+	dl.stopOnce.Do(func() {
+		close(dl.stopping)
+		err = dl.Listener.Close()
+	})
+	return err
+}
+
+// release lets the server close the accepted connections, after the listener has stopped accepting them.
+func (dl *drainingListener) release() {
+	dl.releaseOnce.Do(func() { close(dl.released) })
+}
+
+// Close stops accepting connections, and releases the accepted ones.
+func (dl *drainingListener) Close() error {
+	err := dl.stopAccepting()
+	dl.release()
+	return err
+}
+
+// shutdownRedisServer shuts down the server gracefully, as described above. `serverDone` is closed when the server
+// has stopped serving, i.e. when every connection is closed.
+func shutdownRedisServer(listener *drainingListener, serverDone <-chan struct{}, tracker *commandTracker,
+	store *KiwiStorage, request shutdownRequest) error {
+	slog.Info("Shutting down Kiwi.", "timeout", *shutdownTimeout, "save", request.save)
+	startTime := time.Now()
+	var errs error
+	if err := listener.stopAccepting(); err != nil {
+		errs = fmt.Errorf("failed to stop accepting connections: %w", err)
+	}
+	if !tracker.drain(*shutdownTimeout) {
+		errs = errors.Join(errs, errShutdownTimeout)
+	}
+	listener.release()
+	<-serverDone
+	slog.Info("Closed Redis connections.", "duration", time.Since(startTime))
+
+	closeStore := store.Close
+	if !request.save {
+		closeStore = store.CloseWithoutFlush
+	}
+	if err := closeStore(); err != nil {
+		errs = errors.Join(errs, fmt.Errorf("failed to close storage: %w", err))
+	}
+	return errs
+}
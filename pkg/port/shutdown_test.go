@@ -0,0 +1,128 @@
+package port
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseShutdownCommand(t *testing.T) {
+	for args, save := range map[string]bool{"": true, "SAVE": true, "nosave": false} {
+		request, err := parseShutdownCommand(newTestRedisCommand("SHUTDOWN", strings.Fields(args)...).args)
+		assert.NoError(t, err)
+		assert.Equal(t, save, request.save, "Unexpected save mode for %q", args)
+	}
+	for _, args := range [][]string{{"NOW"}, {"SAVE", "NOSAVE"}} {
+		_, err := parseShutdownCommand(newTestRedisCommand("SHUTDOWN", args...).args)
+		assert.Error(t, err, "Expected an error for %q", args)
+	}
+}
+
+func TestCommandTracker(t *testing.T) {
+	tracker := &commandTracker{}
+	require.True(t, tracker.begin())
+	assert.False(t, tracker.drain(10*time.Millisecond), "Expected draining to time out with in-flight commands")
+	assert.True(t, tracker.isDraining())
+	assert.False(t, tracker.begin(), "Expected new commands to be rejected while draining")
+	tracker.end()
+	assert.True(t, tracker.drain(time.Second))
+
+	handler := newTestRedisHandler(t)
+	require.True(t, handler.tracker.drain(time.Second))
+	output, done := handler.serve(&redisSession{}, newTestRedisCommand("PING"))
+	done()
+	require.NotNil(t, output.err)
+	assert.Equal(t, shuttingDownError, *output.err)
+}
+
+// redisClient is a minimal RESP client for tests.
+type redisClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// do sends the given inline command, and returns the first line of its reply.
+func (rc *redisClient) do(t *testing.T, args ...string) string {
+	t.Helper()
+	_, err := rc.conn.Write([]byte(strings.Join(args, " ") + "\r\n"))
+	require.NoError(t, err)
+	line, err := rc.reader.ReadString('\n')
+	require.NoError(t, err)
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+func TestRunRedisServer_Shutdown(t *testing.T) {
+	config.SetTestFlag(t, "data_dir", t.TempDir())
+	config.SetTestFlag(t, "enable_active_expiry", "false")
+	listener, err := net.Listen("tcp", "127.0.0.1:0") // Finds a free port.
+	require.NoError(t, err)
+	config.SetTestFlag(t, "address", listener.Addr().String())
+	require.NoError(t, listener.Close())
+	parts := func() []string {
+		parts, err := filepath.Glob(filepath.Join(*dataDir, "*", "*.sst"))
+		require.NoError(t, err)
+		return parts
+	}
+
+	// runServer runs the server in background, and returns a connected client.
+	runServer := func(ctx context.Context, keys ...string) (*redisClient, <-chan error) {
+		store, err := NewKiwiStorage()
+		require.NoError(t, err)
+		for _, key := range keys {
+			require.NoError(t, store.Set(0, SetCommand{key: []byte(key), value: []byte("v")}).err)
+		}
+		stopped := make(chan error, 1)
+		go func() { stopped <- RunRedisServer(ctx, store) }()
+		var conn net.Conn
+		require.Eventually(t, func() bool {
+			conn, err = net.Dial("tcp", *address)
+			return err == nil
+		}, 5*time.Second /*waitFor*/, 10*time.Millisecond /*tick*/)
+		t.Cleanup(func() { _ = conn.Close() })
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second))) // Fails the test, rather than hanging.
+		return &redisClient{conn: conn, reader: bufio.NewReader(conn)}, stopped
+	}
+	// waitStopped waits for the server to return.
+	waitStopped := func(stopped <-chan error) {
+		select {
+		case err := <-stopped:
+			assert.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the server to stop")
+		}
+	}
+
+	t.Run("shutdown_nosave", func(t *testing.T) {
+		client, stopped := runServer(context.Background(), "k1", "k2")
+		assert.Equal(t, ":1", client.do(t, "EXISTS", "k1"))
+		assert.Equal(t, "-ERR syntax error", client.do(t, "SHUTDOWN", "LATER"))
+		assert.Equal(t, "$2", client.do(t, "SHUTDOWN", "NOSAVE"))
+		waitStopped(stopped)
+		assert.Empty(t, parts(), "Expected memtables not to be flushed")
+		_, err := net.Dial("tcp", *address)
+		assert.Error(t, err, "Expected new connections to be refused")
+	})
+	t.Run("context_cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		client, stopped := runServer(ctx)
+		assert.Equal(t, ":2", client.do(t, "EXISTS", "k1", "k2"),
+			"Expected writes to be recovered from the write-ahead log")
+		assert.Equal(t, ":1", client.do(t, "DEL", "k1"))
+		cancel()
+		waitStopped(stopped)
+		assert.NotEmpty(t, parts(), "Expected memtables to be flushed")
+
+		client, stopped = runServer(context.Background())
+		assert.Equal(t, ":1", client.do(t, "EXISTS", "k1", "k2"))
+		assert.Equal(t, "$2", client.do(t, "SHUTDOWN"))
+		waitStopped(stopped)
+	})
+}
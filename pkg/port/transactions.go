@@ -0,0 +1,198 @@
+// Redis transactions run a batch of commands at once: the commands between MULTI and EXEC are queued per connection,
+// and EXEC runs them all under the storage write lock, so no other write is interleaved with them. Reads of other
+// connections don't take the lock, so they may see a part of a transaction's writes while it runs.
+//
+// Same as Redis, commands are only checked for being known and their number of arguments when they're queued; a
+// failed check discards the transaction on EXEC with an EXECABORT error, while the errors of the commands that ran are
+// returned in the EXEC reply.
+//
+// WATCH makes the next transaction optimistic: the sequence number of the latest write of each watched key is noted,
+// and EXEC is aborted with a nil reply if any of them has been written since, e.g. set, deleted or flushed; keys that
+// expire meanwhile and databases that are swapped count as changed as well.
+
+package port
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
+)
+
+// execAbortError is returned by EXEC when a command failed to be queued.
+const execAbortError = "EXECABORT Transaction discarded because of previous errors."
+
+// redisCommandArity maps the commands that Kiwi supports to their number of arguments including the command name, same
+// as Redis; a negative arity is the minimum number of arguments.
+var redisCommandArity = map[string]int{
+	"PING": -1, "QUIT": -1, "SHUTDOWN": -1,
+	"SET": -3, "GET": 2, "DEL": -2, "UNLINK": -2, "EXISTS": -2,
+	"SCAN": -2, "KEYS": 2, "DBSIZE": 1,
+	"EXPIRE": -3, "PEXPIRE": -3, "EXPIREAT": -3, "PEXPIREAT": -3,
+	"TTL": 2, "PTTL": 2, "EXPIRETIME": 2, "PEXPIRETIME": 2, "PERSIST": 2, "GETEX": -2,
+	"SELECT": 2, "SWAPDB": 3, "FLUSHDB": -1, "FLUSHALL": -1,
+	"HSET": -4, "HGET": 3, "HMGET": -3, "HDEL": -3, "HGETALL": 2, "HSCAN": -3, "HINCRBY": 4,
+	"LPUSH": -3, "RPUSH": -3, "LPOP": -2, "RPOP": -2, "LRANGE": 4, "LINDEX": 3, "LLEN": 2, "LTRIM": 4,
+	"SADD": -3, "SREM": -3, "SISMEMBER": 3, "SMEMBERS": 2, "SCARD": 2, "SSCAN": -3,
+	"SUNION": -2, "SINTER": -2, "SDIFF": -2, "SUNIONSTORE": -3, "SINTERSTORE": -3, "SDIFFSTORE": -3,
+	"ZADD": -4, "ZINCRBY": 4, "ZREM": -3, "ZSCORE": 3, "ZCARD": 2, "ZCOUNT": 4, "ZRANK": 3, "ZREVRANK": 3,
+	"ZRANGE": -4, "ZREVRANGE": -4, "ZRANGEBYSCORE": -4, "ZREVRANGEBYSCORE": -4, "ZRANGEBYLEX": -4, "ZREVRANGEBYLEX": -4,
+	"ZREMRANGEBYRANK": 4, "ZREMRANGEBYSCORE": 4, "ZREMRANGEBYLEX": 4, "ZPOPMIN": -2, "ZPOPMAX": -2,
+	"KIWI":  -2,
+	"MULTI": 1, "EXEC": 1, "DISCARD": 1, "WATCH": -2, "UNWATCH": 1,
+}
+
+// checkArity returns an error if the given command is unknown, or has a wrong number of arguments.
+func checkArity(cmd RedisCommand) error {
+	arity, exists := redisCommandArity[cmd.command]
+	if !exists {
+		return fmt.Errorf("unknown command '%s'", cmd.command)
+	}
+	if args := len(cmd.args) + 1; (arity > 0 && args != arity) || (arity < 0 && args < -arity) {
+		return fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(cmd.command))
+	}
+	return nil
+}
+
+// clone returns a deep copy of the command, as its arguments are only valid until it's handled.
+func (cmd RedisCommand) clone() RedisCommand {
+	args := make([][]byte, len(cmd.args))
+	for i, arg := range cmd.args {
+		args[i] = bytes.Clone(arg)
+	}
+	return RedisCommand{command: cmd.command, raw: bytes.Clone(cmd.raw), args: args}
+}
+
+// redisTransaction holds the commands queued between MULTI and EXEC.
+type redisTransaction struct {
+	commands []RedisCommand
+	failed   bool // Set when a command fails to be queued; the transaction is discarded on EXEC.
+}
+
+// queue adds the given command to the transaction, unless it fails the arity check.
+func (tx *redisTransaction) queue(cmd RedisCommand) RedisOutput {
+	if err := checkArity(cmd); err != nil {
+		tx.failed = true
+		return writeRedisError(err)
+	}
+	tx.commands = append(tx.commands, cmd.clone())
+	return writeRedisString("QUEUED")
+}
+
+// watchedKey is the state of a key when it was watched, see KiwiStorage.Watch.
+type watchedKey struct {
+	db       int
+	key      []byte
+	lsm      *storage.LSMTree // The tree of the DB when the key was watched; SWAPDB replaces it.
+	sequence int64            // Sequence number of the latest write of the key, or zero if it had none.
+	expiry   time.Time        // The expiry of the key if it was live, as expiring counts as a change; otherwise zero.
+}
+
+// Watch returns the current state of the given `key`, which tells whether the key has changed later on.
+func (ks *KiwiStorage) Watch(db int, key []byte) (watchedKey, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return watchedKey{}, err
+	}
+	watched := watchedKey{db: db, key: bytes.Clone(key), lsm: kdb.lsm}
+	// The sequence number is read first, so a concurrent write is caught by the later checks at worst.
+	if watched.sequence, err = kdb.lastWrite(key); err != nil {
+		return watchedKey{}, err
+	}
+	unpacked, err := kdb.getLive(key, time.Now())
+	if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return watchedKey{}, err
+	} else if err == nil && unpacked.is(Expirable) {
+		watched.expiry = unpacked.expiry
+	}
+	return watched, nil
+}
+
+// isModified returns true if the given watched key has been written, or has expired, since it was watched.
+// NOTE: Caller should acquire lock, so that the key doesn't change before the caller is done.
+func (ks *KiwiStorage) isModified(watched watchedKey, now time.Time) (bool, error) {
+	kdb, err := ks.database(watched.db)
+	if err != nil {
+		return false, err
+	}
+	if kdb.lsm != watched.lsm || (!watched.expiry.IsZero() && now.After(watched.expiry)) {
+		return true, nil
+	}
+	sequence, err := kdb.lastWrite(watched.key)
+	if err != nil {
+		return false, err
+	}
+	return sequence != watched.sequence, nil
+}
+
+// handleMultiCommand starts a transaction; the following commands are queued until EXEC or DISCARD.
+func handleMultiCommand(session *redisSession) RedisOutput {
+	if session.transaction != nil {
+		return writeRedisError(errors.New("MULTI calls can not be nested"))
+	}
+	session.transaction = &redisTransaction{}
+	return writeRedisString("OK")
+}
+
+// handleDiscardCommand discards the queued commands, and unwatches every key.
+func handleDiscardCommand(session *redisSession) RedisOutput {
+	if session.transaction == nil {
+		return writeRedisError(errors.New("DISCARD without MULTI"))
+	}
+	session.transaction, session.watched = nil, nil
+	return writeRedisString("OK")
+}
+
+func handleWatchCommand(session *redisSession, cmd RedisCommand, store *KiwiStorage) RedisOutput {
+	if session.transaction != nil {
+		return writeRedisError(errors.New("WATCH inside MULTI is not allowed"))
+	}
+	if len(cmd.args) < 1 {
+		return writeRedisError(errors.New("wrong number of arguments for 'watch' command"))
+	}
+	for _, key := range cmd.args {
+		watched, err := store.Watch(session.db, key)
+		if err != nil {
+			return writeRedisError(err)
+		}
+		session.watched = append(session.watched, watched)
+	}
+	return writeRedisString("OK")
+}
+
+// handleExecCommand runs the queued commands atomically, unless a watched key has changed; either way, the
+// transaction is over and every key is unwatched.
+func (rh *RedisHandler) handleExecCommand(session *redisSession) RedisOutput {
+	if session.transaction == nil {
+		return writeRedisError(errors.New("EXEC without MULTI"))
+	}
+	transaction, watched := session.transaction, session.watched
+	session.transaction, session.watched = nil, nil
+	if transaction.failed {
+		msg := execAbortError
+		return RedisOutput{err: &msg}
+	}
+
+	var output RedisOutput
+	rh.store.Atomically(func(locked *KiwiStorage) {
+		now := time.Now()
+		for _, key := range watched {
+			if modified, err := locked.isModified(key, now); err != nil {
+				output = writeRedisError(err)
+				return
+			} else if modified {
+				output = writeRedisNil()
+				return
+			}
+		}
+		outputs := make([]RedisOutput, 0, len(transaction.commands))
+		for _, cmd := range transaction.commands {
+			outputs = append(outputs, rh.run(session, cmd, locked))
+		}
+		output = writeRedisArray(outputs...)
+	})
+	return output
+}
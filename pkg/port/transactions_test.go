@@ -0,0 +1,136 @@
+package port
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckArity(t *testing.T) {
+	for _, args := range [][]string{{"GET", "k"}, {"DEL", "a", "b"}, {"PING"}, {"PING", "hello"}, {"SWAPDB", "0", "1"}} {
+		assert.NoError(t, checkArity(newTestRedisCommand(args[0], args[1:]...)), "Unexpected error for %q", args)
+	}
+	for _, args := range [][]string{{"GET"}, {"GET", "a", "b"}, {"DEL"}, {"SET", "k"}, {"DBSIZE", "x"}, {"NOPE"}} {
+		assert.Error(t, checkArity(newTestRedisCommand(args[0], args[1:]...)), "Expected an error for %q", args)
+	}
+}
+
+func TestRedisHandler_Transactions(t *testing.T) {
+	handler := newTestRedisHandler(t, "k")
+	session := &redisSession{}
+	do := func(command string, args ...string) RedisOutput {
+		return handler.handle(session, newTestRedisCommand(command, args...))
+	}
+	get := func(key string) RedisOutput {
+		return handler.handle(&redisSession{}, newTestRedisCommand("GET", key))
+	}
+
+	t.Run("exec", func(t *testing.T) {
+		require.Nil(t, do("MULTI").err)
+		assert.NotNil(t, do("MULTI").err, "Expected nested transactions to fail")
+		assert.NotNil(t, do("WATCH", "k").err, "Expected watching keys in transactions to fail")
+		cmd := newTestRedisCommand("SET", "a", "1")
+		assert.Equal(t, "QUEUED", string(handler.handle(session, cmd).writeBytes))
+		cmd.args[1][0] = '2' // Queued commands are copied, as redcon reuses its buffers.
+		assert.Equal(t, "QUEUED", string(do("GET", "a").writeBytes))
+		assert.Equal(t, "QUEUED", string(do("EXPIRE", "a", "x").writeBytes), "Expected values to be checked on EXEC")
+		assert.True(t, get("a").writeNil, "Expected queued commands not to run before EXEC")
+
+		output := do("EXEC")
+		require.Nil(t, output.err)
+		require.Len(t, output.writeArray, 3)
+		assert.Equal(t, "OK", string(output.writeArray[0].writeBytes))
+		assert.Equal(t, "1", string(output.writeArray[1].writeBytes))
+		assert.NotNil(t, output.writeArray[2].err)
+		assert.Equal(t, "1", string(get("a").writeBytes))
+		assert.NotNil(t, do("EXEC").err, "Expected EXEC without MULTI to fail")
+	})
+	t.Run("exec_abort", func(t *testing.T) {
+		require.Nil(t, do("MULTI").err)
+		assert.Equal(t, "QUEUED", string(do("SET", "b", "1").writeBytes))
+		assert.NotNil(t, do("SET", "b").err)
+		assert.NotNil(t, do("NOPE").err)
+		output := do("EXEC")
+		require.NotNil(t, output.err)
+		assert.Equal(t, execAbortError, *output.err)
+		assert.True(t, get("b").writeNil)
+	})
+	t.Run("discard", func(t *testing.T) {
+		assert.NotNil(t, do("DISCARD").err, "Expected DISCARD without MULTI to fail")
+		require.Nil(t, do("WATCH", "k").err)
+		require.Nil(t, do("MULTI").err)
+		assert.Equal(t, "QUEUED", string(do("SET", "c", "1").writeBytes))
+		require.Nil(t, do("DISCARD").err)
+		assert.Empty(t, session.watched)
+		assert.True(t, get("c").writeNil)
+		assert.True(t, do("GET", "c").writeNil, "Expected commands to run right away after DISCARD")
+	})
+	t.Run("select", func(t *testing.T) {
+		require.Nil(t, do("MULTI").err)
+		do("SELECT", "1")
+		do("SET", "d", "1")
+		do("SELECT", "0")
+		require.Nil(t, do("EXEC").err)
+		assert.True(t, get("d").writeNil)
+		other := &redisSession{db: 1}
+		assert.Equal(t, "1", string(handler.handle(other, newTestRedisCommand("GET", "d")).writeBytes))
+	})
+}
+
+func TestRedisHandler_Watch(t *testing.T) {
+	handler := newTestRedisHandler(t, "k", "other")
+	session, other := &redisSession{}, &redisSession{}
+	// transact watches the given key, lets the other connection run the given command, and then sets the key in a
+	// transaction; it returns true if the transaction ran.
+	transact := func(key string, command string, args ...string) bool {
+		t.Helper()
+		require.Nil(t, handler.handle(session, newTestRedisCommand("WATCH", key)).err)
+		if command != "" {
+			require.Nil(t, handler.handle(other, newTestRedisCommand(command, args...)).err)
+		}
+		require.Nil(t, handler.handle(session, newTestRedisCommand("MULTI")).err)
+		handler.handle(session, newTestRedisCommand("SET", key, "mine"))
+		output := handler.handle(session, newTestRedisCommand("EXEC"))
+		require.Nil(t, output.err)
+		assert.Empty(t, session.watched, "Expected EXEC to unwatch every key")
+		return !output.writeNil
+	}
+
+	assert.True(t, transact("k", ""))
+	assert.True(t, transact("k", "SET", "other", "v"), "Expected writes of other keys to be ignored")
+	assert.True(t, transact("missing", "DEL", "missing"), "Expected deleting missing keys to be ignored")
+	assert.False(t, transact("k", "SET", "k", "theirs"))
+	assert.Equal(t, "theirs", string(handler.handle(session, newTestRedisCommand("GET", "k")).writeBytes))
+	assert.False(t, transact("k", "EXPIRE", "k", "100"))
+	assert.False(t, transact("k", "DEL", "k"))
+	assert.False(t, transact("new", "SET", "new", "v"))
+	assert.False(t, transact("new", "FLUSHDB"))
+	assert.False(t, transact("k", "SWAPDB", "0", "1"))
+
+	require.Nil(t, handler.handle(session, newTestRedisCommand("WATCH", "k")).err)
+	require.Nil(t, handler.handle(other, newTestRedisCommand("SET", "k", "theirs")).err)
+	require.Nil(t, handler.handle(session, newTestRedisCommand("UNWATCH")).err)
+	require.Nil(t, handler.handle(session, newTestRedisCommand("MULTI")).err)
+	assert.False(t, handler.handle(session, newTestRedisCommand("EXEC")).writeNil, "Expected UNWATCH to unwatch keys")
+}
+
+func TestKiwiStorage_Watch(t *testing.T) {
+	store := newTestRedisHandler(t).store
+	expiry := time.Now().Add(time.Hour)
+	require.NoError(t, store.Set(0, SetCommand{key: []byte("k"), value: []byte("v"), expiryTime: expiry}).err)
+	watched, err := store.Watch(0, []byte("k"))
+	require.NoError(t, err)
+	assert.WithinDuration(t, expiry, watched.expiry, time.Millisecond)
+
+	modified, err := store.isModified(watched, time.Now())
+	assert.NoError(t, err)
+	assert.False(t, modified)
+	modified, err = store.isModified(watched, expiry.Add(time.Second))
+	assert.NoError(t, err)
+	assert.True(t, modified, "Expected keys that expire after being watched to count as modified")
+
+	_, err = store.Watch(*databases, []byte("k"))
+	assert.ErrorIs(t, err, errDbIndexOutOfRange)
+}
@@ -0,0 +1,581 @@
+// Redis sorted sets are sets whose members are ordered by their scores, and then by the members themselves; each
+// member is stored as two member keys, see collections.go:
+//
+//	's' | member                 -> score
+//	'i' | encoded score | member -> empty
+//
+// The score keys map the members to their scores, so score lookups are point lookups; and since they're ordered by
+// member, lexicographical ranges are range scans over them. The index keys are ordered the same as the sorted set, so
+// ranges by score or rank are range scans over them. Scores are encoded into eight big-endian bytes which sort in the
+// order of the scores, including the infinities; so a range of scores is a range of index keys.
+
+package port
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/storage"
+)
+
+const (
+	zsetScoreSpace = 's' // The tag of score keys, which map the members to their scores.
+	zsetIndexSpace = 'i' // The tag of index keys, which order the members by their scores.
+)
+
+// encodeScore returns an encoding of the given score which sorts in the order of scores: the sign bit of positive
+// scores is flipped, so they sort after the negative ones, and every bit of negative scores, so bigger magnitudes sort
+// first. Negative zero is encoded as zero, as they're equal scores.
+func encodeScore(score float64) uint64 {
+	if score == 0 {
+		score = 0 // Drops the sign of negative zero.
+	}
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		return ^bits
+	}
+	return bits | 1<<63
+}
+
+// decodeScore returns the score of the given encoding, see encodeScore.
+func decodeScore(encoded uint64) float64 {
+	if encoded&(1<<63) != 0 {
+		return math.Float64frombits(encoded &^ (1 << 63))
+	}
+	return math.Float64frombits(^encoded)
+}
+
+// formatScore formats the given score the way Redis replies with scores, e.g. "1.5" or "-inf".
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
+	}
+	return strconv.AppendFloat(nil, score, 'g', -1 /*prec*/, 64 /*bitSize*/)
+}
+
+// zsetScoreKey returns the member of the score key of the given sorted set member.
+func zsetScoreKey(member []byte) []byte {
+	return append([]byte{zsetScoreSpace}, member...)
+}
+
+// zsetIndexKey returns the member of the index key of the given sorted set member.
+func zsetIndexKey(score float64, member []byte) []byte {
+	key := binary.BigEndian.AppendUint64([]byte{zsetIndexSpace}, encodeScore(score))
+	return append(key, member...)
+}
+
+// ZMember is a member of a sorted set along with its score.
+type ZMember struct {
+	member []byte
+	score  float64
+}
+
+// scoreBound is the minimum or maximum of a range of scores, e.g. "(1.5" of ZRANGEBYSCORE.
+type scoreBound struct {
+	score     float64
+	exclusive bool
+}
+
+// lexBound is the minimum or maximum of a lexicographical range of members, e.g. "[a" of ZRANGEBYLEX.
+type lexBound struct {
+	member    []byte
+	exclusive bool
+	infinity  int // -1 for "-", i.e. before every member, and 1 for "+", i.e. after every member; 0 otherwise.
+}
+
+// zrangeBy is the kind of range of a ZRangeCommand.
+type zrangeBy uint8
+
+const (
+	byRank  zrangeBy = iota
+	byScore          // BYSCORE
+	byLex            // BYLEX
+)
+
+type ZRangeCommand struct {
+	key            []byte
+	by             zrangeBy
+	start, stop    int // The ranks of byRank ranges, inclusive; negative ranks count from the end.
+	minScore       scoreBound
+	maxScore       scoreBound
+	minLex, maxLex lexBound
+	rev            bool // The Redis REV option; the ranks of byRank ranges count from the end as well.
+	offset, count  int  // The Redis LIMIT option of byScore and byLex ranges; a negative count means no limit.
+	withScores     bool // The Redis WITHSCORES option; only used in replies.
+}
+
+// scoreKeys returns the range of index keys [start, end) of the given range of scores, or false if it's empty.
+func scoreKeys(lower, upper scoreBound) ([]byte, []byte, bool /*nonEmpty*/) {
+	start, end := encodeScore(lower.score), encodeScore(upper.score)
+	// The encodings of scores are consecutive numbers, so the next one starts right after every key of a score.
+	if lower.exclusive {
+		start++
+	}
+	if !upper.exclusive {
+		end++
+	}
+	if start >= end {
+		return nil, nil, false
+	}
+	return binary.BigEndian.AppendUint64([]byte{zsetIndexSpace}, start),
+		binary.BigEndian.AppendUint64([]byte{zsetIndexSpace}, end), true
+}
+
+// lexKeys returns the range of score keys [start, end) of the given lexicographical range, or false if it's empty;
+// nil bounds are open.
+func lexKeys(lower, upper lexBound) ([]byte, []byte, bool /*nonEmpty*/) {
+	if lower.infinity > 0 || upper.infinity < 0 {
+		return nil, nil, false
+	}
+	var start, end []byte
+	// Appending a zero byte to a key makes the smallest key after it.
+	if lower.infinity == 0 {
+		start = zsetScoreKey(lower.member)
+		if lower.exclusive {
+			start = append(start, 0)
+		}
+	}
+	if upper.infinity == 0 {
+		end = zsetScoreKey(upper.member)
+		if !upper.exclusive {
+			end = append(end, 0)
+		}
+	}
+	if start != nil && end != nil && bytes.Compare(start, end) >= 0 {
+		return nil, nil, false
+	}
+	return start, end, true
+}
+
+// zscan returns an iterator over the members of the sorted set whose keys of the given space are within
+// [start, end), in the order of the keys, or in reverse if `reverse` is set; nil bounds are open, i.e. the start or
+// end of the space.
+func zscan(scanner storage.RangeScanner, z collection, space byte, start, end []byte,
+	reverse bool) iter.Seq2[ZMember, error] {
+	if start == nil {
+		start = []byte{space}
+	}
+	if end == nil {
+		end = []byte{space + 1}
+	}
+	pairs := scanner.Scan(z.memberKey(start), z.memberKey(end))
+	if reverse {
+		pairs = scanner.ReverseScan(z.memberKey(start), z.memberKey(end))
+	}
+	return func(yield func(ZMember, error) bool) {
+		for pair, err := range pairs {
+			if err != nil {
+				yield(ZMember{}, fmt.Errorf("failed to scan sorted set: %w", err))
+				return
+			}
+			key := pair.Key[len(z.prefix)+1:]
+			unpacked, err := unpack(pair.Value)
+			if err != nil || unpacked.is(TombStone) {
+				continue
+			}
+			var zmember ZMember
+			if space == zsetIndexSpace {
+				if len(key) < 8 {
+					continue
+				}
+				zmember = ZMember{member: key[8:], score: decodeScore(binary.BigEndian.Uint64(key))}
+			} else {
+				if len(unpacked.value) != 8 {
+					continue
+				}
+				zmember = ZMember{member: key, score: decodeScore(binary.BigEndian.Uint64(unpacked.value))}
+			}
+			if !yield(zmember, nil) {
+				return
+			}
+		}
+	}
+}
+
+// zscore returns the score of the given member of the sorted set, or ErrKeyNotFound.
+func (kdb *kiwiDB) zscore(z collection, member []byte) (float64, error) {
+	value, err := kdb.getMember(z, zsetScoreKey(member))
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, fmt.Errorf("invalid score of member %q", member)
+	}
+	return decodeScore(binary.BigEndian.Uint64(value)), nil
+}
+
+// zadd sets the score of the given member of the sorted set, whose current score is `previous` if it `exists`; the
+// sorted set's count is updated, but it's up to the caller to save it.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) zadd(z *collection, member []byte, score, previous float64, exists bool) error {
+	if exists {
+		if encodeScore(previous) == encodeScore(score) {
+			return nil
+		}
+		if err := kdb.eraseMember(*z, zsetIndexKey(previous, member)); err != nil {
+			return err
+		}
+	}
+	if err := kdb.putMember(*z, zsetIndexKey(score, member), nil /*value*/); err != nil {
+		return err
+	}
+	encoded := binary.BigEndian.AppendUint64(nil, encodeScore(score))
+	if err := kdb.putMember(*z, zsetScoreKey(member), encoded); err != nil {
+		return err
+	}
+	if !exists {
+		z.count++
+	}
+	return nil
+}
+
+// zrem removes the given member of the sorted set, which is known to exist with the given score; the sorted set's
+// count is updated, but it's up to the caller to save it.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) zrem(z *collection, member ZMember) error {
+	if err := kdb.eraseMember(*z, zsetIndexKey(member.score, member.member)); err != nil {
+		return err
+	}
+	if err := kdb.eraseMember(*z, zsetScoreKey(member.member)); err != nil {
+		return err
+	}
+	z.count--
+	return nil
+}
+
+// zrange returns the members of the sorted set within the range of the given `cmd`, in its order.
+func (kdb *kiwiDB) zrange(z collection, cmd ZRangeCommand) ([]ZMember, error) {
+	var members iter.Seq2[ZMember, error]
+	offset, count := cmd.offset, cmd.count
+	switch cmd.by {
+	case byRank:
+		start, stop, nonEmpty := listRange(cmd.start, cmd.stop, z.count)
+		if !nonEmpty {
+			return []ZMember{}, nil
+		}
+		// Ranks aren't stored, so the index keys before the range are skipped; from the end of the sorted set if `rev`.
+		members, offset, count = zscan(kdb.lsm, z, zsetIndexSpace, nil, nil, cmd.rev), start, stop-start+1
+	case byScore:
+		start, end, nonEmpty := scoreKeys(cmd.minScore, cmd.maxScore)
+		if !nonEmpty {
+			return []ZMember{}, nil
+		}
+		members = zscan(kdb.lsm, z, zsetIndexSpace, start, end, cmd.rev)
+	case byLex:
+		start, end, nonEmpty := lexKeys(cmd.minLex, cmd.maxLex)
+		if !nonEmpty {
+			return []ZMember{}, nil
+		}
+		members = zscan(kdb.lsm, z, zsetScoreSpace, start, end, cmd.rev)
+	}
+	result := []ZMember{}
+	for member, err := range members {
+		if err != nil {
+			return nil, err
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if count >= 0 && len(result) == count {
+			break
+		}
+		result = append(result, member)
+	}
+	return result, nil
+}
+
+// zsetUpdate is the kind of update of a ZAddCommand.
+type zsetUpdate uint8
+
+const (
+	anyScore     zsetUpdate = iota
+	greaterScore            // GT; existing members are only updated to greater scores, new members are still added.
+	lessScore               // LT; existing members are only updated to less scores, new members are still added.
+)
+
+type ZAddCommand struct {
+	key       []byte
+	members   []ZMember
+	existence existenceCheck // The Redis NX and XX options.
+	update    zsetUpdate
+	changed   bool // The Redis CH option; if true, updated members are counted along with the added ones.
+}
+
+// ZAdd sets the scores of the given members of the sorted set at `key` as specified by `cmd`, creating it if it
+// doesn't exist, e.g. the Redis ZADD command; it returns the number of members that were added.
+func (ks *KiwiStorage) ZAdd(db int, cmd ZAddCommand) (int, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	z, err := kdb.getOrCreateCollection(cmd.key, SortedSetType, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	added, updated := 0, 0
+	for _, member := range cmd.members {
+		previous, err := kdb.zscore(z, member.member)
+		if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+			return 0, err
+		}
+		exists := err == nil
+		if (exists && cmd.existence == ifNotExists) || (!exists && cmd.existence == ifExists) ||
+			(exists && cmd.update == greaterScore && member.score <= previous) ||
+			(exists && cmd.update == lessScore && member.score >= previous) {
+			continue
+		}
+		if err := kdb.zadd(&z, member.member, member.score, previous, exists); err != nil {
+			return 0, err
+		}
+		if !exists {
+			added++
+		} else if encodeScore(previous) != encodeScore(member.score) {
+			updated++
+		}
+	}
+	if added+updated > 0 {
+		if err := kdb.saveCollection(cmd.key, z); err != nil {
+			return 0, fmt.Errorf("failed to save sorted set: %w", err)
+		}
+	}
+	if cmd.changed {
+		return added + updated, nil
+	}
+	return added, nil
+}
+
+// ZIncrBy adds `increment` to the score of the given `member` of the sorted set at `key`, which starts at zero if it
+// doesn't exist; it returns the new score.
+func (ks *KiwiStorage) ZIncrBy(db int, key, member []byte, increment float64) (float64, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	z, err := kdb.getOrCreateCollection(key, SortedSetType, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	previous, err := kdb.zscore(z, member)
+	if err != nil && !errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, err
+	}
+	score := previous + increment
+	if math.IsNaN(score) {
+		return 0, errors.New("resulting score is not a number (NaN)")
+	}
+	if err := kdb.zadd(&z, member, score, previous, err == nil); err != nil {
+		return 0, err
+	}
+	if err := kdb.saveCollection(key, z); err != nil {
+		return 0, fmt.Errorf("failed to save sorted set: %w", err)
+	}
+	return score, nil
+}
+
+// ZRem removes the given members of the sorted set at `key`, and the sorted set itself once it's empty; it returns
+// the number of members that existed.
+func (ks *KiwiStorage) ZRem(db int, key []byte, members ...[]byte) (int, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	z, err := kdb.getCollection(key, SortedSetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		score, err := kdb.zscore(z, member)
+		if errors.Is(err, storage.ErrKeyNotFound) {
+			continue
+		} else if err != nil {
+			return removed, err
+		}
+		if err := kdb.zrem(&z, ZMember{member: member, score: score}); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	if err := kdb.saveCollection(key, z); err != nil {
+		return removed, fmt.Errorf("failed to save sorted set: %w", err)
+	}
+	return removed, nil
+}
+
+// ZScore returns the score of the given `member` of the sorted set at `key`, or ErrKeyNotFound.
+func (ks *KiwiStorage) ZScore(db int, key, member []byte) (float64, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return 0, err
+	}
+	z, err := kdb.getCollection(key, SortedSetType, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return kdb.zscore(z, member)
+}
+
+// ZCard returns the number of members of the sorted set at `key`, which is zero if it doesn't exist.
+func (ks *KiwiStorage) ZCard(db int, key []byte) (int, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return 0, err
+	}
+	z, err := kdb.getCollection(key, SortedSetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return z.count, nil
+}
+
+// ZCount returns the number of members of the sorted set at `key` whose scores are within the given range.
+func (ks *KiwiStorage) ZCount(db int, key []byte, lower, upper scoreBound) (int, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return 0, err
+	}
+	z, err := kdb.getCollection(key, SortedSetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	start, end, nonEmpty := scoreKeys(lower, upper)
+	if !nonEmpty {
+		return 0, nil
+	}
+	count := 0
+	for _, err := range zscan(kdb.lsm, z, zsetIndexSpace, start, end, false /*reverse*/) {
+		if err != nil {
+			return 0, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ZRank returns the rank of the given `member` of the sorted set at `key`, i.e. the number of members before it; or
+// after it if `rev`. Returns ErrKeyNotFound if the member doesn't exist.
+func (ks *KiwiStorage) ZRank(db int, key, member []byte, rev bool) (int, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return 0, err
+	}
+	z, err := kdb.getCollection(key, SortedSetType, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	score, err := kdb.zscore(z, member)
+	if err != nil {
+		return 0, err
+	}
+	// The members are counted from the end which the rank counts from, so it takes as long as the rank is.
+	indexKey := zsetIndexKey(score, member)
+	members := zscan(kdb.lsm, z, zsetIndexSpace, nil /*start*/, indexKey, false /*reverse*/)
+	if rev {
+		members = zscan(kdb.lsm, z, zsetIndexSpace, append(indexKey, 0), nil /*end*/, true /*reverse*/)
+	}
+	rank := 0
+	for _, err := range members {
+		if err != nil {
+			return 0, err
+		}
+		rank++
+	}
+	return rank, nil
+}
+
+// ZRange returns the members of the sorted set at `key` within the range of the given `cmd`, along with their scores,
+// e.g. the Redis ZRANGE command; it's empty if the sorted set doesn't exist.
+func (ks *KiwiStorage) ZRange(db int, cmd ZRangeCommand) ([]ZMember, error) {
+	kdb, err := ks.readDatabase(db)
+	if err != nil {
+		return nil, err
+	}
+	z, err := kdb.getCollection(cmd.key, SortedSetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return []ZMember{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return kdb.zrange(z, cmd)
+}
+
+// ZRemRange removes the members of the sorted set at `key` within the range of the given `cmd`, and the sorted set
+// itself once it's empty, e.g. the Redis ZREMRANGEBYSCORE command; it returns the number of removed members.
+func (ks *KiwiStorage) ZRemRange(db int, cmd ZRangeCommand) (int, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return 0, err
+	}
+	removed, err := kdb.zremRange(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+// ZPop removes up to `count` members with the lowest scores of the sorted set at `key`, or the highest scores if
+// `highest`, and returns them in the order they're removed, e.g. the Redis ZPOPMIN command; the sorted set is
+// deleted once it's empty.
+func (ks *KiwiStorage) ZPop(db int, key []byte, highest bool, count int) ([]ZMember, error) {
+	defer ks.lock()()
+
+	kdb, err := ks.database(db)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return []ZMember{}, nil
+	}
+	return kdb.zremRange(ZRangeCommand{key: key, by: byRank, start: 0, stop: count - 1, rev: highest})
+}
+
+// zremRange removes the members of the sorted set within the range of the given `cmd`, and returns them.
+// NOTE: Caller should acquire KiwiStorage.mux write lock.
+func (kdb *kiwiDB) zremRange(cmd ZRangeCommand) ([]ZMember, error) {
+	z, err := kdb.getCollection(cmd.key, SortedSetType, time.Now())
+	if errors.Is(err, storage.ErrKeyNotFound) {
+		return []ZMember{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	members, err := kdb.zrange(z, cmd)
+	if err != nil || len(members) == 0 {
+		return members, err
+	}
+	for _, member := range members {
+		if err := kdb.zrem(&z, member); err != nil {
+			return nil, err
+		}
+	}
+	if err := kdb.saveCollection(cmd.key, z); err != nil {
+		return nil, fmt.Errorf("failed to save sorted set: %w", err)
+	}
+	return members, nil
+}
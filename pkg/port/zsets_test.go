@@ -0,0 +1,281 @@
+package port
+
+import (
+	"math"
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zmembers builds sorted set members from alternating members and scores, e.g. "a", 1, "b", 2.
+func zmembers(membersAndScores ...any) []ZMember {
+	members := make([]ZMember, 0, len(membersAndScores)/2)
+	for i := 0; i+1 < len(membersAndScores); i += 2 {
+		score, isFloat := membersAndScores[i+1].(float64)
+		if !isFloat {
+			score = float64(membersAndScores[i+1].(int))
+		}
+		members = append(members, ZMember{member: []byte(membersAndScores[i].(string)), score: score})
+	}
+	return members
+}
+
+func TestEncodeScore(t *testing.T) {
+	scores := []float64{math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64, 0,
+		math.SmallestNonzeroFloat64, 1, 1.5, math.MaxFloat64, math.Inf(1)}
+	for i, score := range scores {
+		assert.Equal(t, score, decodeScore(encodeScore(score)))
+		if i > 0 {
+			assert.Less(t, encodeScore(scores[i-1]), encodeScore(score), "Expected %v to sort before %v",
+				scores[i-1], score)
+		}
+	}
+	assert.Equal(t, encodeScore(0), encodeScore(math.Copysign(0, -1)), "Expected negative zero to equal zero")
+	for score, want := range map[float64]string{1: "1", -2.5: "-2.5", math.Inf(1): "inf", math.Inf(-1): "-inf"} {
+		assert.Equal(t, want, string(formatScore(score)))
+	}
+}
+
+func TestKiwiStorage_SortedSets(t *testing.T) {
+	store := newTestRedisHandler(t, "str").store
+	key := []byte("z")
+
+	added, err := store.ZAdd(0, ZAddCommand{key: key, members: zmembers("b", 2, "a", 1, "c", 3)})
+	require.NoError(t, err)
+	assert.Equal(t, 3, added)
+	added, err = store.ZAdd(0, ZAddCommand{key: key, members: zmembers("a", 5, "d", 4), changed: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, added, "Expected updated members to be counted with CH")
+	for _, testCase := range []struct {
+		cmd  ZAddCommand
+		want map[string]float64
+	}{
+		{cmd: ZAddCommand{members: zmembers("a", 0, "e", 0), existence: ifNotExists},
+			want: map[string]float64{"a": 5, "e": 0}},
+		{cmd: ZAddCommand{members: zmembers("a", 0, "f", 0), existence: ifExists}, want: map[string]float64{"a": 0}},
+		{cmd: ZAddCommand{members: zmembers("a", 6, "b", 1), update: greaterScore}, want: map[string]float64{"a": 6, "b": 2}},
+		{cmd: ZAddCommand{members: zmembers("a", 7, "b", 1), update: lessScore}, want: map[string]float64{"a": 6, "b": 1}},
+	} {
+		testCase.cmd.key = key
+		_, err := store.ZAdd(0, testCase.cmd)
+		require.NoError(t, err)
+		for member, want := range testCase.want {
+			score, err := store.ZScore(0, key, []byte(member))
+			assert.NoError(t, err)
+			assert.Equal(t, want, score, "Unexpected score of %q after %+v", member, testCase.cmd)
+		}
+	}
+	_, err = store.ZScore(0, key, []byte("f"))
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	score, err := store.ZIncrBy(0, key, []byte("e"), 2.5)
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, score)
+	score, err = store.ZIncrBy(0, key, []byte("new"), math.Inf(-1))
+	require.NoError(t, err)
+	assert.Equal(t, math.Inf(-1), score)
+	_, err = store.ZIncrBy(0, key, []byte("new"), math.Inf(1))
+	assert.Error(t, err, "Expected NaN scores to fail")
+
+	// The members are ordered by score: new (-inf), b (1), e (2.5), c (3), d (4), a (6).
+	count, err := store.ZCard(0, key)
+	require.NoError(t, err)
+	assert.Equal(t, 6, count)
+	for member, want := range map[string]int{"new": 0, "e": 2, "a": 5} {
+		rank, err := store.ZRank(0, key, []byte(member), false /*rev*/)
+		assert.NoError(t, err)
+		assert.Equal(t, want, rank, "Unexpected rank of %q", member)
+		rank, err = store.ZRank(0, key, []byte(member), true /*rev*/)
+		assert.NoError(t, err)
+		assert.Equal(t, 5-want, rank, "Unexpected reverse rank of %q", member)
+	}
+	_, err = store.ZRank(0, key, []byte("missing"), false /*rev*/)
+	assert.ErrorIs(t, err, storage.ErrKeyNotFound)
+
+	removed, err := store.ZRem(0, key, []byte("new"), []byte("missing"), []byte("e"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, 8, liveMembers(t, store, 0), "Expected each member to have a score key and an index key")
+	removed, err = store.ZRem(0, key, []byte("a"), []byte("b"), []byte("c"), []byte("d"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, removed)
+	exists, err := store.Exists(0, key)
+	require.NoError(t, err)
+	assert.False(t, exists, "Expected sorted sets to be deleted with their last member")
+	assert.Zero(t, liveMembers(t, store, 0))
+
+	t.Run("wrong_type", func(t *testing.T) {
+		_, err := store.ZAdd(0, ZAddCommand{key: []byte("str"), members: zmembers("a", 1)})
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.ZRange(0, ZRangeCommand{key: []byte("str"), count: -1})
+		assert.ErrorIs(t, err, errWrongType)
+		_, err = store.SAdd(0, []byte("set"), []byte("a"))
+		require.NoError(t, err)
+		_, err = store.ZScore(0, []byte("set"), []byte("a"))
+		assert.ErrorIs(t, err, errWrongType)
+	})
+	t.Run("restore", func(t *testing.T) {
+		config.SetTestFlag(t, "history_retention", "1h")
+		store := newTestRedisHandler(t).store
+		_, err := store.ZAdd(0, ZAddCommand{key: []byte("z"), members: zmembers("a", 1, "b", 2)})
+		require.NoError(t, err)
+		restorePoint := pastTime()
+		_, err = store.ZAdd(0, ZAddCommand{key: []byte("z"), members: zmembers("a", 3, "c", 0)})
+		require.NoError(t, err)
+
+		_, err = store.RestorePrefix(0, []byte("z"), restorePoint)
+		require.NoError(t, err)
+		members, err := store.ZRange(0, ZRangeCommand{key: []byte("z"), start: 0, stop: -1, count: -1})
+		require.NoError(t, err)
+		assert.Equal(t, zmembers("a", 1, "b", 2), members)
+		count, err := store.ZCard(0, []byte("z"))
+		require.NoError(t, err)
+		assert.Equal(t, 2, count, "Expected the members to be counted once despite their two keys")
+	})
+}
+
+func TestKiwiStorage_ZRange(t *testing.T) {
+	store := newTestRedisHandler(t).store
+	key := []byte("z")
+	_, err := store.ZAdd(0, ZAddCommand{key: key, members: zmembers("a", 1, "b", 2, "c", 2, "d", 3, "e", math.Inf(1),
+		"f", -1.5)})
+	require.NoError(t, err)
+	inclusive := func(score float64) scoreBound { return scoreBound{score: score} }
+	exclusive := func(score float64) scoreBound { return scoreBound{score: score, exclusive: true} }
+	member := func(member string, exclusive bool) lexBound {
+		return lexBound{member: []byte(member), exclusive: exclusive}
+	}
+	names := func(members []ZMember) []string {
+		var names []string
+		for _, member := range members {
+			names = append(names, string(member.member))
+		}
+		return names
+	}
+
+	for _, testCase := range []struct {
+		cmd  ZRangeCommand
+		want []string
+	}{
+		{cmd: ZRangeCommand{start: 0, stop: -1, count: -1}, want: []string{"f", "a", "b", "c", "d", "e"}},
+		{cmd: ZRangeCommand{start: 1, stop: 2, count: -1}, want: []string{"a", "b"}},
+		{cmd: ZRangeCommand{start: 0, stop: 1, rev: true, count: -1}, want: []string{"e", "d"}},
+		{cmd: ZRangeCommand{start: -2, stop: 10, count: -1}, want: []string{"d", "e"}},
+		{cmd: ZRangeCommand{start: 3, stop: 1, count: -1}, want: nil},
+		{cmd: ZRangeCommand{by: byScore, minScore: inclusive(1), maxScore: inclusive(2), count: -1},
+			want: []string{"a", "b", "c"}},
+		{cmd: ZRangeCommand{by: byScore, minScore: exclusive(1), maxScore: exclusive(3), count: -1},
+			want: []string{"b", "c"}},
+		{cmd: ZRangeCommand{by: byScore, minScore: inclusive(math.Inf(-1)), maxScore: exclusive(1), count: -1},
+			want: []string{"f"}},
+		{cmd: ZRangeCommand{by: byScore, minScore: exclusive(3), maxScore: inclusive(math.Inf(1)), count: -1},
+			want: []string{"e"}},
+		{cmd: ZRangeCommand{by: byScore, minScore: exclusive(2), maxScore: exclusive(2), count: -1}, want: nil},
+		{cmd: ZRangeCommand{by: byScore, minScore: inclusive(-10), maxScore: inclusive(10), rev: true, offset: 1,
+			count: 2}, want: []string{"c", "b"}},
+		{cmd: ZRangeCommand{by: byScore, minScore: inclusive(-10), maxScore: inclusive(10), offset: 4, count: -1},
+			want: []string{"d"}},
+		{cmd: ZRangeCommand{by: byLex, minLex: member("b", false), maxLex: member("d", true), count: -1},
+			want: []string{"b", "c"}},
+		{cmd: ZRangeCommand{by: byLex, minLex: member("b", true), maxLex: lexBound{infinity: 1}, count: -1},
+			want: []string{"c", "d", "e", "f"}},
+		{cmd: ZRangeCommand{by: byLex, minLex: lexBound{infinity: -1}, maxLex: member("b", false), rev: true,
+			count: -1}, want: []string{"b", "a"}},
+		{cmd: ZRangeCommand{by: byLex, minLex: lexBound{infinity: 1}, maxLex: lexBound{infinity: 1}, count: -1},
+			want: nil},
+	} {
+		testCase.cmd.key = key
+		members, err := store.ZRange(0, testCase.cmd)
+		require.NoError(t, err)
+		assert.Equal(t, testCase.want, names(members), "Unexpected range of %+v", testCase.cmd)
+	}
+	members, err := store.ZRange(0, ZRangeCommand{key: key, start: 0, stop: 1, count: -1})
+	require.NoError(t, err)
+	assert.Equal(t, zmembers("f", -1.5, "a", 1), members, "Expected the members along with their scores")
+	members, err = store.ZRange(0, ZRangeCommand{key: []byte("missing"), start: 0, stop: -1, count: -1})
+	require.NoError(t, err)
+	assert.Empty(t, members)
+
+	count, err := store.ZCount(0, key, inclusive(2), inclusive(math.Inf(1)))
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	popped, err := store.ZPop(0, key, false /*highest*/, 2)
+	require.NoError(t, err)
+	assert.Equal(t, zmembers("f", -1.5, "a", 1), popped)
+	popped, err = store.ZPop(0, key, true /*highest*/, 1)
+	require.NoError(t, err)
+	assert.Equal(t, zmembers("e", math.Inf(1)), popped)
+	removed, err := store.ZRemRange(0, ZRangeCommand{key: key, by: byScore, minScore: inclusive(2),
+		maxScore: exclusive(3), count: -1})
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	members, err = store.ZRange(0, ZRangeCommand{key: key, start: 0, stop: -1, count: -1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d"}, names(members))
+	popped, err = store.ZPop(0, key, true /*highest*/, 10)
+	require.NoError(t, err)
+	assert.Len(t, popped, 1)
+	popped, err = store.ZPop(0, key, true /*highest*/, 1)
+	require.NoError(t, err)
+	assert.Empty(t, popped)
+}
+
+func TestRedisHandler_SortedSets(t *testing.T) {
+	handler := newTestRedisHandler(t, "str")
+	session := &redisSession{}
+	do := func(command string, args ...string) RedisOutput {
+		return handler.handle(session, newTestRedisCommand(command, args...))
+	}
+
+	assert.Equal(t, 3, *do("ZADD", "z", "1", "a", "2", "b", "+inf", "c").writeInt)
+	assert.Equal(t, 1, *do("ZADD", "z", "CH", "GT", "3", "a", "0", "b").writeInt)
+	assert.Equal(t, 0, *do("ZADD", "z", "NX", "10", "a").writeInt)
+	assert.NotNil(t, do("ZADD", "z", "NX", "XX", "1", "a").err)
+	assert.NotNil(t, do("ZADD", "z", "NX", "GT", "1", "a").err)
+	assert.NotNil(t, do("ZADD", "z", "1").err)
+	assert.NotNil(t, do("ZADD", "z", "nan", "a").err)
+	assert.Equal(t, "3", string(do("ZSCORE", "z", "a").writeBytes))
+	assert.True(t, do("ZSCORE", "z", "missing").writeNil)
+	assert.Equal(t, "-1.5", string(do("ZINCRBY", "z", "-1.5", "d").writeBytes))
+	assert.Equal(t, 4, *do("ZCARD", "z").writeInt)
+	assert.Equal(t, 3, *do("ZCOUNT", "z", "(-1.5", "inf").writeInt)
+	assert.Equal(t, 1, *do("ZRANK", "z", "b").writeInt)
+	assert.Equal(t, 0, *do("ZREVRANK", "z", "c").writeInt)
+	assert.True(t, do("ZRANK", "z", "missing").writeNil)
+
+	// The members are ordered by score: d (-1.5), b (2), a (3), c (inf).
+	assert.Equal(t, []string{"d", "b", "a", "c"}, bulkStrings(t, do("ZRANGE", "z", "0", "-1")))
+	assert.Equal(t, []string{"c", "inf", "a", "3"}, bulkStrings(t, do("ZRANGE", "z", "0", "1", "REV", "WITHSCORES")))
+	assert.Equal(t, []string{"b", "a"}, bulkStrings(t, do("ZRANGE", "z", "(-1.5", "3", "BYSCORE")))
+	assert.Equal(t, []string{"b", "d"},
+		bulkStrings(t, do("ZRANGE", "z", "3", "-inf", "BYSCORE", "REV", "LIMIT", "1", "2")))
+	assert.Equal(t, []string{"b", "2"}, bulkStrings(t, do("ZRANGEBYSCORE", "z", "0", "(3", "WITHSCORES")))
+	assert.Equal(t, []string{"c", "a"}, bulkStrings(t, do("ZREVRANGEBYSCORE", "z", "+inf", "3")))
+	assert.Equal(t, []string{"a", "b"}, bulkStrings(t, do("ZRANGE", "z", "-", "(c", "BYLEX")))
+	assert.Equal(t, []string{"d", "c"}, bulkStrings(t, do("ZREVRANGEBYLEX", "z", "+", "[c")))
+	assert.Equal(t, []string{"c", "a"}, bulkStrings(t, do("ZREVRANGE", "z", "0", "1")))
+	assert.Empty(t, bulkStrings(t, do("ZRANGE", "z", "0", "-1", "BYSCORE", "LIMIT", "-1", "1")))
+	assert.NotNil(t, do("ZRANGE", "z", "0", "-1", "LIMIT", "0", "1").err, "Expected LIMIT to require BYSCORE or BYLEX")
+	assert.NotNil(t, do("ZRANGE", "z", "a", "b", "BYLEX").err)
+	assert.NotNil(t, do("ZRANGE", "z", "-", "+", "BYLEX", "WITHSCORES").err)
+	assert.NotNil(t, do("ZRANGE", "z", "x", "1", "BYSCORE").err)
+	assert.NotNil(t, do("ZRANGEBYSCORE", "z", "0", "1", "REV").err)
+
+	assert.Equal(t, []string{"d", "-1.5"}, bulkStrings(t, do("ZPOPMIN", "z")))
+	assert.Equal(t, []string{"c", "inf", "a", "3"}, bulkStrings(t, do("ZPOPMAX", "z", "2")))
+	assert.NotNil(t, do("ZPOPMAX", "z", "-1").err)
+	assert.Equal(t, 3, *do("ZADD", "z", "1", "x", "2", "y", "3", "z").writeInt)
+	assert.Equal(t, 1, *do("ZREM", "z", "y", "missing").writeInt)
+	assert.Equal(t, 1, *do("ZREMRANGEBYRANK", "z", "0", "0").writeInt)
+	assert.Equal(t, 1, *do("ZREMRANGEBYSCORE", "z", "2", "(3").writeInt)
+	assert.Equal(t, 1, *do("ZREMRANGEBYLEX", "z", "[z", "+").writeInt)
+	assert.Equal(t, 0, *do("EXISTS", "z").writeInt)
+
+	wrongType := do("ZADD", "str", "1", "a")
+	require.NotNil(t, wrongType.err)
+	assert.Regexp(t, "^WRONGTYPE ", *wrongType.err)
+}
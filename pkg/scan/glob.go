@@ -26,3 +26,14 @@ func MatchGlob(pattern []byte, pairs iter.Seq[utils.BytePair]) iter.Seq[utils.By
 		}
 	}
 }
+
+// GlobPrefix returns the literal prefix of the given glob pattern, i.e. every matching key starts with it; so range
+// scans can be narrowed down to a prefix scan before matching. Returns an error if the pattern is invalid.
+func GlobPrefix(pattern []byte) ([]byte, error) {
+	parsedPattern, err := glob.Parse(string(pattern))
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ /*isWholePattern*/ := parsedPattern.Head().FixedPrefix()
+	return []byte(prefix), nil
+}
@@ -74,3 +74,24 @@ func TestMatchGlob(t *testing.T) {
 		})
 	}
 }
+
+func TestGlobPrefix(t *testing.T) {
+	for _, testCase := range []struct {
+		glob     string
+		expected string
+	}{
+		{glob: "*", expected: ""},
+		{glob: "key", expected: "key"},
+		{glob: "key*", expected: "key"},
+		{glob: "user:?:name", expected: "user:"},
+		{glob: "user:[ab]*", expected: "user:"},
+		{glob: `a\*b*`, expected: "a*b"},
+	} {
+		prefix, err := GlobPrefix([]byte(testCase.glob))
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.expected, string(prefix), "Unexpected prefix for %q", testCase.glob)
+	}
+
+	_, err := GlobPrefix([]byte("key[")) // Unterminated character class.
+	assert.Error(t, err)
+}
@@ -1,5 +1,7 @@
 // Kiwi caches block reads to reduce IO operations for frequently accessed data blocks.
 // Cache is enabled by default but users may decide to disable the cache or adjust its capacity.
+// Blocks vary in size, so each cached block is charged by its size in bytes and the capacity is a number of bytes,
+// which is split evenly between the shards of the cache.
 
 package storage
 
@@ -14,6 +16,7 @@ import (
 	kiwipb "github.com/nobletooth/kiwi/proto"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
@@ -22,8 +25,8 @@ var (
 	sharedCache   *BlockCache
 
 	cacheEnabled  = flag.Bool("enable_block_cache", true, "Enable the shared block cache.")
-	cacheCapacity = flag.Int("block_cache_capacity", 5,
-		"The maximum number of blocks to keep in the shared block cache; 0 or negative disables the cache.")
+	cacheCapacity = flag.Int("block_cache_capacity", 64<<20,
+		"The maximum total size in bytes of blocks in the shared block cache; 0 or negative disables the cache.")
 	cacheShardCount = flag.Int("block_cache_shard_count", runtime.NumCPU(),
 		"The number of shards to keep in the block cache; 0 or negative disables the cache.")
 	cacheTtl = flag.Duration("block_cache_ttl", 5*time.Minute,
@@ -43,6 +46,16 @@ var (
 		Name: "block_cache_evicted_keys_total",
 		Help: "Total number of block cache evictions.",
 	})
+	// The usage is read when metrics are collected, so that it's neither stale after the reaper drops expired blocks,
+	// nor summed up over every shard on the read path.
+	cacheBytes = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "block_cache_bytes",
+		Help: "Total size in bytes of the blocks in the shared block cache.",
+	}, func() float64 { return float64(getSharedCache().internalCache.Usage()) })
+	cacheCapacityBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "block_cache_capacity_bytes",
+		Help: "The maximum total size in bytes of the blocks in the shared block cache.",
+	})
 )
 
 // dbCacheKey is the cache key for a data block in the BlockCache.
@@ -63,7 +76,7 @@ type BlockCache struct {
 func newBlockCache() *BlockCache {
 	// newCache builds a new hyper clock cache according to configured flags.
 	newCache := func() cache.Layer[dbCacheKey, *kiwipb.DataBlock] {
-		return cache.NewHyperClock(context.Background(), *cacheCapacity, *cacheTickInterval,
+		return cache.NewHyperClock(context.Background(), max(*cacheCapacity / *cacheShardCount, 1), *cacheTickInterval,
 			func(k dbCacheKey, v *kiwipb.DataBlock) {
 				cacheEvictedBlocks.Inc()
 				cacheEvictedKeys.Add(float64(len(v.Keys)))
@@ -80,6 +93,7 @@ func newBlockCache() *BlockCache {
 		}
 	}
 
+	cacheCapacityBytes.Set(float64(cacheLayer.Capacity()))
 	return &BlockCache{internalCache: cacheLayer}
 }
 
@@ -94,7 +108,13 @@ func (p *BlockCache) Get(table, ssTableId, offset int64) (*kiwipb.DataBlock, boo
 	return db, found
 }
 
-// Set adds a data block to the cache.
+// Set adds a data block to the cache, charged by its encoded size.
 func (p *BlockCache) Set(table, ssTableId, offset int64, block *kiwipb.DataBlock) {
-	p.internalCache.Add(dbCacheKey{table: table, ssTableId: ssTableId, offset: offset}, block, *cacheTtl)
+	p.internalCache.AddWithCharge(dbCacheKey{table: table, ssTableId: ssTableId, offset: offset}, block,
+		proto.Size(block), *cacheTtl)
+}
+
+// Remove drops a data block from the cache, e.g. when its part is replaced by a compaction.
+func (p *BlockCache) Remove(table, ssTableId, offset int64) {
+	p.internalCache.Remove(dbCacheKey{table: table, ssTableId: ssTableId, offset: offset})
 }
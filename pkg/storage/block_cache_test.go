@@ -9,6 +9,7 @@ import (
 	"github.com/nobletooth/kiwi/pkg/config"
 	kiwipb "github.com/nobletooth/kiwi/proto"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestGetSharedCache(t *testing.T) {
@@ -55,3 +56,26 @@ func TestNewBlockCache(t *testing.T) {
 		assert.True(t, isNoOp, "Expected no op cache")
 	})
 }
+
+func TestBlockCache_Capacity(t *testing.T) {
+	config.SetTestFlag(t, "block_cache_shard_count", "1")
+	config.SetTestFlag(t, "block_cache_capacity", "100")
+	blockCache := newBlockCache()
+	assert.Equal(t, 100, blockCache.internalCache.Capacity())
+
+	block := &kiwipb.DataBlock{Keys: [][]byte{[]byte("key")}, Values: [][]byte{make([]byte, 30)}}
+	blockSize := proto.Size(block)
+	blockCache.Set(1 /*table*/, 1 /*ssTableId*/, 0 /*offset*/, block)
+	blockCache.Set(1 /*table*/, 1 /*ssTableId*/, 1 /*offset*/, block)
+	assert.Equal(t, 2*blockSize, blockCache.internalCache.Usage(), "Expected blocks to be charged by their size")
+	blockCache.Set(1 /*table*/, 1 /*ssTableId*/, 2 /*offset*/, block)
+	assert.Equal(t, 2*blockSize, blockCache.internalCache.Usage(), "Expected the capacity to be in bytes")
+	_, found := blockCache.Get(1 /*table*/, 1 /*ssTableId*/, 2 /*offset*/)
+	assert.True(t, found)
+
+	blockCache.Set(1 /*table*/, 1 /*ssTableId*/, 3 /*offset*/, &kiwipb.DataBlock{Values: [][]byte{make([]byte, 100)}})
+	_, found = blockCache.Get(1 /*table*/, 1 /*ssTableId*/, 3 /*offset*/)
+	assert.False(t, found, "Expected blocks bigger than the cache not to be cached")
+	blockCache.Remove(1 /*table*/, 1 /*ssTableId*/, 2 /*offset*/)
+	assert.Equal(t, blockSize, blockCache.internalCache.Usage())
+}
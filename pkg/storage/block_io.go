@@ -1,6 +1,11 @@
 // Kiwi parts are stored as multiple blocks in a single file. Each block is a protobuf message prefixed by
 // its size as a fixed 8-byte little-endian integer. Multiple blocks are concatenated together to form a complete file.
 // This file provides utilities to read and write these blocks efficiently w/ buffers.
+// The highest byte of the size prefix holds the block format version, so that blocks are self-describing:
+//   - Version 0: The size prefix is directly followed by the message; written by older Kiwi versions.
+//   - Version 1: The size prefix is followed by a 4-byte little-endian CRC32-C checksum of the size prefix and the
+//     message, which is verified on reads to detect corrupted blocks. This is the only version written now.
+//     The second-highest byte of the size prefix holds the codec which the message is compressed with, see codec.go.
 
 package storage
 
@@ -8,10 +13,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"flag"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"runtime"
 	"sync"
+	"sync/atomic"
 
 	"github.com/nobletooth/kiwi/pkg/utils"
 	"google.golang.org/protobuf/proto"
@@ -20,12 +28,27 @@ import (
 // defaultBufferSize matches the typical OS page size to reduce the number of sys calls.
 const defaultBufferSize = 4096
 
+const (
+	blockSizeLength     = 8  // The size prefix, including the block version.
+	blockChecksumLength = 4  // The CRC32-C checksum of checksummed blocks.
+	blockVersionShift   = 56 // The block version is stored in the highest byte of the size prefix.
+	blockCodecShift     = 48 // The block codec is stored in the second-highest byte of the size prefix.
+
+	legacyBlockVersion      = 0 // Only has the size prefix.
+	checksummedBlockVersion = 1 // Has a checksum after the size prefix.
+
+	blockMaxSize = 1 << 32 // Any bigger block size is considered a corruption.
+)
+
+var verifyBlockChecksums = flag.Bool("verify_block_checksums", true,
+	"Verify the checksum of each block read from disk; cached blocks were verified when they were read.")
+
 // bufferPool allows reusing buffers both in BlockReader & BlockWriter to reduce allocations.
 var bufferPool = sync.Pool{New: func() any { return bytes.NewBuffer(make([]byte, 0, defaultBufferSize)) }}
 
-// getBlockSize calculates the size of a protobuf message when stored on disk as a block.
+// getBlockSize calculates the size of a protobuf message when stored on disk as an uncompressed block.
 func getBlockSize(block proto.Message) int64 {
-	return int64(proto.Size(block) + 8)
+	return int64(proto.Size(block) + blockSizeLength + blockChecksumLength)
 }
 
 // BlockWriter allows writing protobuf blocks to a block file.
@@ -89,26 +112,89 @@ func (bw *BlockWriter) writeBytes(p []byte) (flushed int, err error) {
 	return flushed, nil
 }
 
-// WriteBlock writes a proto.Message block with its size to the underlying writer.
-func (bw *BlockWriter) WriteBlock(msg proto.Message) error {
+// encodeBlock returns the on-disk form of a proto.Message block, compressed with the given codec if it's worth it.
+func encodeBlock(msg proto.Message, codec Codec) ([]byte, error) {
 	if msg == nil {
-		return errors.New("cannot create block from nil proto message")
+		return nil, errors.New("cannot create block from nil proto message")
 	}
 
 	block, err := proto.Marshal(msg)
 	if err != nil {
-		return fmt.Errorf("failed to marshal message: %w", err)
+		return nil, fmt.Errorf("failed to marshal message: %w", err)
+	}
+	codec, block, err = compressBlock(codec, block)
+	if err != nil {
+		return nil, err
 	}
 
-	// For each block, writeBytes its size as a fixed 8-byte little-endian integer followed by the block data.
-	// This allows the reader to know how many bytes to read for each block.
-	blockSizeBinary := make([]byte, 8)
-	binary.LittleEndian.PutUint64(blockSizeBinary, uint64(len(block)))
-	if _, err := bw.writeBytes(blockSizeBinary); err != nil {
-		return fmt.Errorf("failed to writeBytes block size: %w", err)
+	// For each block, writeBytes its size, codec and version as a fixed 8-byte little-endian integer and its
+	// checksum, followed by the block data. This allows the reader to know how many bytes to read for each block.
+	encoded := make([]byte, blockSizeLength+blockChecksumLength, blockSizeLength+blockChecksumLength+len(block))
+	binary.LittleEndian.PutUint64(encoded,
+		uint64(len(block))|uint64(codec)<<blockCodecShift|checksummedBlockVersion<<blockVersionShift)
+	checksum := crc32.Update(crc32.Checksum(encoded[:blockSizeLength], crc32cTable), crc32cTable, block)
+	binary.LittleEndian.PutUint32(encoded[blockSizeLength:], checksum)
+	return append(encoded, block...), nil
+}
+
+// WriteBlock writes an uncompressed proto.Message block with its size to the underlying writer.
+func (bw *BlockWriter) WriteBlock(msg proto.Message) error {
+	block, err := encodeBlock(msg, CodecNone)
+	if err != nil {
+		return err
 	}
+	return bw.writeEncodedBlock(block)
+}
+
+// writeEncodedBlock writes a block returned by encodeBlock to the underlying writer.
+func (bw *BlockWriter) writeEncodedBlock(block []byte) error {
 	if _, err := bw.writeBytes(block); err != nil {
-		return fmt.Errorf("failed to writeBytes block data: %w", err)
+		return fmt.Errorf("failed to writeBytes block: %w", err)
+	}
+	return nil
+}
+
+// copyFrom copies the blocks read from `reader` as is to the underlying writer, after the buffered bytes; e.g. blocks
+// which were encoded beforehand and spilled to a temporary file.
+func (bw *BlockWriter) copyFrom(reader io.Reader) error {
+	bw.mux.Lock()
+	defer bw.mux.Unlock()
+	if bw.closed {
+		return errors.New("block writer is closed")
+	}
+
+	if bw.buffer != nil && bw.buffer.Len() > 0 {
+		if _, err := bw.writer.Write(bw.buffer.Bytes()); err != nil {
+			return err
+		}
+		bw.buffer.Reset()
+	}
+	// Files are copied by the kernel where it's supported, see os.File.ReadFrom.
+	if _, err := io.Copy(bw.writer, reader); err != nil {
+		return fmt.Errorf("failed to copy blocks: %w", err)
+	}
+
+	return nil
+}
+
+// Sync flushes the buffered bytes to the underlying writer and makes them durable, if the writer supports syncing.
+func (bw *BlockWriter) Sync() error {
+	bw.mux.Lock()
+	defer bw.mux.Unlock()
+	if bw.closed {
+		return errors.New("block writer is closed")
+	}
+
+	if bw.buffer != nil && bw.buffer.Len() > 0 {
+		if _, err := bw.writer.Write(bw.buffer.Bytes()); err != nil {
+			return err
+		}
+		bw.buffer.Reset()
+	}
+	if syncer, canSync := bw.writer.(interface{ Sync() error }); canSync {
+		if err := syncer.Sync(); err != nil {
+			return fmt.Errorf("failed to sync block writer: %w", err)
+		}
 	}
 
 	return nil
@@ -131,9 +217,9 @@ func (bw *BlockWriter) Close() error {
 		return errors.New("block writer is already closed")
 	}
 
-	// Flush any remaining bytes in the buffer.
-	if remaining := bw.buffer.Bytes(); len(remaining) > 0 {
-		if _, err := bw.writer.Write(remaining); err != nil {
+	// Flush any remaining bytes in the buffer; there's no buffer when nothing was written.
+	if bw.buffer != nil && bw.buffer.Len() > 0 {
+		if _, err := bw.writer.Write(bw.buffer.Bytes()); err != nil {
 			return err
 		}
 	}
@@ -146,12 +232,11 @@ func (bw *BlockWriter) Close() error {
 	return nil
 }
 
-// BlockReader allows reading protobuf blocks from a block file.
+// BlockReader allows reading protobuf blocks from a block file. Reads only use ReadAt, so they're safe to run
+// concurrently, e.g. on an *os.File.
 type BlockReader struct {
-	mux    sync.Mutex // Protects the reader and closed flag.
-	closed bool
+	closed atomic.Bool
 	reader io.ReaderAt
-	buffer *bytes.Buffer
 }
 
 // NewBlockReader is the constructor for BlockReader.
@@ -159,30 +244,51 @@ func NewBlockReader(reader io.ReaderAt) (*BlockReader, error) {
 	if reader == nil {
 		return nil, errors.New("expected non-nil reader")
 	}
-	br := &BlockReader{mux: sync.Mutex{}, reader: reader, closed: false}
+	br := &BlockReader{reader: reader}
 	// Call Close when the object is garbage collected.
 	runtime.SetFinalizer(br, func(br *BlockReader) { _ = br.Close() })
 	return br, nil
 }
 
-// ReadBlock reads a proto.Message block from the given offset.
+// ReadBlock reads a proto.Message block from the given offset. It returns io.EOF when the offset is at the end of
+// the file, and ErrCorruption when the block is malformed; along with io.ErrUnexpectedEOF if it's cut short.
 func (br *BlockReader) ReadBlock(offset int64, msg proto.Message) (int64 /*nextOffset*/, error) {
-	br.mux.Lock()
-	defer br.mux.Unlock()
-
-	if br.closed {
+	if br.closed.Load() {
 		return 0, errors.New("block reader is closed")
 	}
 
-	// Read the block size (8 bytes, little-endian).
-	sizeBuf := make([]byte, 8)
-	if _, err := br.reader.ReadAt(sizeBuf, offset); err != nil {
+	// Read the block size and version (8 bytes, little-endian).
+	header := make([]byte, blockSizeLength+blockChecksumLength)
+	if readBytes, err := br.reader.ReadAt(header[:blockSizeLength], offset); err != nil {
+		if errors.Is(err, io.EOF) && readBytes > 0 {
+			return 0, fmt.Errorf("%w: truncated block size at offset %d: %w", ErrCorruption, offset,
+				io.ErrUnexpectedEOF)
+		}
 		return 0, fmt.Errorf("failed to read block size: %w", err)
 	}
+	sizePrefix := binary.LittleEndian.Uint64(header[:blockSizeLength])
+	version, blockSize := sizePrefix>>blockVersionShift, int64(sizePrefix&(1<<blockVersionShift-1))
+	headerSize, codec := int64(blockSizeLength), CodecNone
+	switch version {
+	case legacyBlockVersion:
+	case checksummedBlockVersion:
+		headerSize += blockChecksumLength
+		codec, blockSize = Codec(blockSize>>blockCodecShift), blockSize&(1<<blockCodecShift-1)
+		if _, err := br.reader.ReadAt(header[blockSizeLength:], offset+blockSizeLength); errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("%w: truncated block checksum at offset %d: %w", ErrCorruption, offset,
+				io.ErrUnexpectedEOF)
+		} else if err != nil {
+			return 0, fmt.Errorf("failed to read block checksum: %w", err)
+		}
+	default:
+		return 0, fmt.Errorf("%w: unknown block version %d at offset %d", ErrCorruption, version, offset)
+	}
+	if blockSize > blockMaxSize {
+		return 0, fmt.Errorf("%w: block size %d at offset %d is too big", ErrCorruption, blockSize, offset)
+	}
 
 	// Read the block data.
-	blockSize := int64(binary.LittleEndian.Uint64(sizeBuf))
-	sectionReader := io.NewSectionReader(br.reader, offset+8, blockSize)
+	sectionReader := io.NewSectionReader(br.reader, offset+headerSize, blockSize)
 	blockBuffer := bufferPool.Get().(*bytes.Buffer)
 	defer func() {
 		blockBuffer.Reset()
@@ -193,36 +299,35 @@ func (br *BlockReader) ReadBlock(offset int64, msg proto.Message) (int64 /*nextO
 		return 0, fmt.Errorf("failed to read block data: %w", err)
 	}
 	if readBytes != blockSize {
-		utils.RaiseInvariant("block", "incomplete_read", "Read an incomplete block.",
-			"expected", blockSize, "actual", readBytes)
-		return 0, fmt.Errorf("incomplete block read: expected %d bytes, got %d bytes", blockSize, readBytes)
+		return 0, fmt.Errorf("%w: truncated block at offset %d, expected %d bytes, got %d bytes: %w", ErrCorruption,
+			offset, blockSize, readBytes, io.ErrUnexpectedEOF)
+	}
+	if version == checksummedBlockVersion && *verifyBlockChecksums {
+		checksum := crc32.Update(crc32.Checksum(header[:blockSizeLength], crc32cTable), crc32cTable,
+			blockBuffer.Bytes())
+		if want := binary.LittleEndian.Uint32(header[blockSizeLength:]); checksum != want {
+			return 0, fmt.Errorf("%w: block checksum mismatch at offset %d, expected %08x, got %08x",
+				ErrCorruption, offset, want, checksum)
+		}
+	}
+
+	block, err := decompressBlock(codec, blockBuffer.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to decompress %s block at offset %d: %v", ErrCorruption, codec, offset, err)
 	}
 
-	// Unmarshal data block.
-	if err := proto.Unmarshal(blockBuffer.Bytes(), msg); err != nil {
-		return 0, fmt.Errorf("failed to unmarshal block data: %w", err)
+	// Unmarshal data block; legacy blocks don't have checksums, so this is their only sanity check.
+	if err := proto.Unmarshal(block, msg); err != nil {
+		return 0, fmt.Errorf("%w: failed to unmarshal block data at offset %d: %v", ErrCorruption, offset, err)
 	}
 
-	return offset + 8 + readBytes /*nextOffset*/, nil
+	return offset + headerSize + readBytes /*nextOffset*/, nil
 }
 
 // Close releases resources used by the BlockReader.
 func (br *BlockReader) Close() error {
-	br.mux.Lock()
-	defer func() {
-		// Give back the buffer to the pool.
-		if br.buffer != nil {
-			br.buffer.Reset()
-			bufferPool.Put(br.buffer)
-			br.buffer = nil
-		}
-		br.closed = true
-		br.mux.Unlock()
-	}()
-
-	if br.closed {
+	if !br.closed.CompareAndSwap(false, true) {
 		return errors.New("block reader is already closed")
 	}
-
 	return nil
 }
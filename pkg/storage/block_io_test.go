@@ -1,12 +1,14 @@
 package storage
 
 import (
+	"encoding/binary"
 	"errors"
 	"io"
 	"os"
 	"path"
 	"testing"
 
+	"github.com/nobletooth/kiwi/pkg/config"
 	kiwipb "github.com/nobletooth/kiwi/proto"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -17,11 +19,11 @@ func TestGetBlockSize(t *testing.T) {
 	t.Run("non-nil", func(t *testing.T) {
 		record := &kiwipb.TestRecord{Id: 123, Name: "test_record"}
 		size := getBlockSize(record)
-		assert.Equal(t, int64(23), size, "Expected block size to be length prefix + proto size")
+		assert.Equal(t, int64(27), size, "Expected block size to be length prefix + checksum + proto size")
 	})
 	t.Run("nil", func(t *testing.T) {
 		size := getBlockSize(nil /*block*/)
-		assert.Equal(t, int64(8), size, "Expected block size to be just the length prefix")
+		assert.Equal(t, int64(12), size, "Expected block size to be just the length prefix + checksum")
 	})
 }
 
@@ -61,8 +63,8 @@ func TestBlockStorage(t *testing.T) {
 			} else {
 				require.NoError(t, err)
 			}
-			// Each block should be 8 bytes (length prefix) + the size of the proto message.
-			assert.Equal(t, int64(8+proto.Size(expected[messageIdx])), nextOffset-offset)
+			// Each block should be 8 bytes (length prefix) + 4 bytes (checksum) + the size of the proto message.
+			assert.Equal(t, int64(12+proto.Size(expected[messageIdx])), nextOffset-offset)
 			got = append(got, msg)
 			offset = nextOffset
 			messageIdx++
@@ -71,3 +73,80 @@ func TestBlockStorage(t *testing.T) {
 	require.Equal(t, len(expected), len(got), "Expected both slices to have the same length")
 	assert.EqualExportedValues(t, expected, got)
 }
+
+func TestBlockReader_Corruption(t *testing.T) {
+	record := &kiwipb.TestRecord{Id: 123, Name: "test_record"}
+	var blocks []byte
+	{ // Write a single block.
+		filePath := path.Join(t.TempDir(), "test.block")
+		file, err := os.Create(filePath)
+		require.NoError(t, err)
+		writer, err := NewBlockWriter(file)
+		require.NoError(t, err)
+		require.NoError(t, writer.WriteBlock(record))
+		require.NoError(t, writer.Close())
+		blocks, err = os.ReadFile(filePath)
+		require.NoError(t, err)
+	}
+	// read reads the first block of the given bytes.
+	read := func(blocks []byte) (*kiwipb.TestRecord, int64, error) {
+		filePath := path.Join(t.TempDir(), "test.block")
+		require.NoError(t, os.WriteFile(filePath, blocks, 0o644))
+		file, err := os.Open(filePath)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = file.Close() })
+		reader, err := NewBlockReader(file)
+		require.NoError(t, err)
+		msg := &kiwipb.TestRecord{}
+		nextOffset, err := reader.ReadBlock(0 /*offset*/, msg)
+		return msg, nextOffset, err
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		msg, nextOffset, err := read(blocks)
+		require.NoError(t, err)
+		assert.EqualExportedValues(t, record, msg)
+		assert.Equal(t, int64(len(blocks)), nextOffset)
+	})
+	t.Run("legacy", func(t *testing.T) { // Written by older Kiwi versions, without a checksum.
+		data, err := proto.Marshal(record)
+		require.NoError(t, err)
+		legacy := binary.LittleEndian.AppendUint64(nil, uint64(len(data)))
+		msg, nextOffset, err := read(append(legacy, data...))
+		require.NoError(t, err)
+		assert.EqualExportedValues(t, record, msg)
+		assert.Equal(t, int64(8+len(data)), nextOffset)
+	})
+	t.Run("bit_flip", func(t *testing.T) {
+		for _, index := range []int{3, 9, len(blocks) - 1} { // In the size, checksum and data.
+			corrupted := append([]byte(nil), blocks...)
+			corrupted[index] ^= 0x10
+			_, _, err := read(corrupted)
+			assert.ErrorIs(t, err, ErrCorruption, "Expected a bit flip at %d to be detected", index)
+		}
+	})
+	t.Run("unknown_version", func(t *testing.T) {
+		corrupted := append([]byte(nil), blocks...)
+		corrupted[7] = 0xff
+		_, _, err := read(corrupted)
+		assert.ErrorIs(t, err, ErrCorruption)
+	})
+	t.Run("truncated", func(t *testing.T) {
+		for _, size := range []int{4, 10, len(blocks) - 1} {
+			_, _, err := read(blocks[:size])
+			assert.ErrorIs(t, err, ErrCorruption)
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		}
+		_, _, err := read(nil)
+		assert.ErrorIs(t, err, io.EOF, "Expected empty files to end cleanly")
+		assert.NotErrorIs(t, err, ErrCorruption)
+	})
+	t.Run("skip_verification", func(t *testing.T) {
+		config.SetTestFlag(t, "verify_block_checksums", "false")
+		corrupted := append([]byte(nil), blocks...)
+		corrupted[9] ^= 0x10 // Only the checksum is broken.
+		msg, _, err := read(corrupted)
+		require.NoError(t, err)
+		assert.EqualExportedValues(t, record, msg)
+	})
+}
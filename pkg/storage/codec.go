@@ -0,0 +1,157 @@
+// Data blocks are optionally compressed on disk with one of the supported codecs. The codec of each block is
+// recorded in its frame (see block_io.go), so that parts written with different codecs, e.g. after changing the
+// configured codec of a table, stay readable. Blocks which don't shrink enough are stored uncompressed.
+// Blocks are decompressed when they're read from disk, so the block cache only holds decompressed blocks.
+
+package storage
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+var (
+	blockCompression = flag.String("block_compression", "none",
+		"The codec of data blocks: none, snappy, lz4 or zstd.")
+	blockCompressionTables = flag.String("block_compression_tables", "",
+		"Per table codecs of data blocks, overriding --block_compression; e.g. '1:zstd,2:none'.")
+	blockCompressionMinRatio = flag.Float64("block_compression_min_ratio", 1.125,
+		"The minimum ratio of uncompressed to compressed size for a data block to be stored compressed.")
+)
+
+// Codec is the compression algorithm of a block.
+type Codec uint8
+
+const (
+	CodecNone Codec = iota
+	CodecSnappy
+	CodecLZ4
+	CodecZstd
+)
+
+var codecNames = map[Codec]string{CodecNone: "none", CodecSnappy: "snappy", CodecLZ4: "lz4", CodecZstd: "zstd"}
+
+func (c Codec) String() string {
+	if name, known := codecNames[c]; known {
+		return name
+	}
+	return fmt.Sprintf("codec(%d)", uint8(c))
+}
+
+// ParseCodec returns the codec with the given name, e.g. zstd.
+func ParseCodec(name string) (Codec, error) {
+	for codec, codecName := range codecNames {
+		if strings.EqualFold(name, codecName) {
+			return codec, nil
+		}
+	}
+	return CodecNone, fmt.Errorf("unknown block codec %q", name)
+}
+
+// tableCodec returns the configured codec of the given table's data blocks.
+func tableCodec(table int64) (Codec, error) {
+	if *blockCompressionTables != "" {
+		for _, entry := range strings.Split(*blockCompressionTables, ",") {
+			tableId, codecName, found := strings.Cut(strings.TrimSpace(entry), ":")
+			if !found {
+				return CodecNone, fmt.Errorf("expected a table:codec pair in --block_compression_tables, got %q",
+					entry)
+			}
+			id, err := strconv.ParseInt(tableId, 10, 64)
+			if err != nil {
+				return CodecNone, fmt.Errorf("invalid table in --block_compression_tables: %w", err)
+			}
+			if id == table {
+				return ParseCodec(codecName)
+			}
+		}
+	}
+	return ParseCodec(*blockCompression)
+}
+
+var (
+	// zstd encoders and decoders are safe for concurrent use with EncodeAll and DecodeAll, so they're shared.
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) { return zstd.NewWriter(nil) })
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderMaxMemory(blockMaxSize))
+	})
+)
+
+// compressBlock compresses the given block data with the given codec. It returns the codec that was actually used,
+// which is CodecNone when the data doesn't shrink by --block_compression_min_ratio.
+func compressBlock(codec Codec, data []byte) (Codec, []byte, error) {
+	var compressed []byte
+	switch codec {
+	case CodecNone:
+		return CodecNone, data, nil
+	case CodecSnappy:
+		compressed = snappy.Encode(nil, data)
+	case CodecLZ4:
+		// LZ4 blocks don't record their uncompressed size, so it's prefixed to the compressed data.
+		compressed = binary.AppendUvarint(nil, uint64(len(data)))
+		buffer := make([]byte, lz4.CompressBlockBound(len(data)))
+		n, err := lz4.CompressBlock(data, buffer, nil /*hashTable*/)
+		if err != nil {
+			return CodecNone, nil, fmt.Errorf("failed to compress block with lz4: %w", err)
+		}
+		if n == 0 { // Incompressible.
+			return CodecNone, data, nil
+		}
+		compressed = append(compressed, buffer[:n]...)
+	case CodecZstd:
+		encoder, err := zstdEncoder()
+		if err != nil {
+			return CodecNone, nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		compressed = encoder.EncodeAll(data, nil)
+	default:
+		return CodecNone, nil, fmt.Errorf("unknown block codec %d", codec)
+	}
+	if len(compressed) == 0 || float64(len(data))/float64(len(compressed)) < *blockCompressionMinRatio {
+		return CodecNone, data, nil
+	}
+	return codec, compressed, nil
+}
+
+// decompressBlock decompresses the given block data, which was compressed with the given codec.
+func decompressBlock(codec Codec, data []byte) ([]byte, error) {
+	switch codec {
+	case CodecNone:
+		return data, nil
+	case CodecSnappy:
+		if size, err := snappy.DecodedLen(data); err != nil || size > blockMaxSize {
+			return nil, fmt.Errorf("invalid snappy block size %d: %w", size, err)
+		}
+		return snappy.Decode(nil, data)
+	case CodecLZ4:
+		size, n := binary.Uvarint(data)
+		if n <= 0 || size > blockMaxSize {
+			return nil, fmt.Errorf("invalid lz4 block size %d", size)
+		}
+		decompressed := make([]byte, size)
+		read, err := lz4.UncompressBlock(data[n:], decompressed)
+		if err != nil {
+			return nil, err
+		}
+		if read != len(decompressed) {
+			return nil, fmt.Errorf("expected %d decompressed bytes, got %d bytes", len(decompressed), read)
+		}
+		return decompressed, nil
+	case CodecZstd:
+		decoder, err := zstdDecoder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+		}
+		return decoder.DecodeAll(data, nil)
+	default:
+		return nil, fmt.Errorf("unknown block codec %d", codec)
+	}
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCodec(t *testing.T) {
+	for codec, name := range codecNames {
+		parsed, err := ParseCodec(strings.ToUpper(name))
+		assert.NoError(t, err)
+		assert.Equal(t, codec, parsed)
+		assert.Equal(t, name, codec.String())
+	}
+	_, err := ParseCodec("gzip")
+	assert.Error(t, err)
+}
+
+func TestTableCodec(t *testing.T) {
+	config.SetTestFlag(t, "block_compression", "snappy")
+	config.SetTestFlag(t, "block_compression_tables", "1:zstd, 3:none")
+	for table, want := range map[int64]Codec{1: CodecZstd, 2: CodecSnappy, 3: CodecNone} {
+		codec, err := tableCodec(table)
+		assert.NoError(t, err)
+		assert.Equal(t, want, codec, "Unexpected codec of table %d", table)
+	}
+	for _, invalid := range []string{"1", "x:zstd", "1:gzip"} {
+		config.SetTestFlag(t, "block_compression_tables", invalid)
+		_, err := tableCodec(1)
+		assert.Error(t, err, "Expected %q to be invalid", invalid)
+	}
+}
+
+func TestCompressBlock(t *testing.T) {
+	compressible := bytes.Repeat([]byte(`{"name":"kiwi","kind":"fruit"},`), 100)
+	incompressible := []byte("a short block")
+	for _, codec := range []Codec{CodecSnappy, CodecLZ4, CodecZstd} {
+		t.Run(codec.String(), func(t *testing.T) {
+			used, compressed, err := compressBlock(codec, compressible)
+			require.NoError(t, err)
+			assert.Equal(t, codec, used)
+			assert.Less(t, len(compressed), len(compressible)/4)
+			decompressed, err := decompressBlock(codec, compressed)
+			require.NoError(t, err)
+			assert.Equal(t, compressible, decompressed)
+
+			used, compressed, err = compressBlock(codec, incompressible)
+			require.NoError(t, err)
+			assert.Equal(t, CodecNone, used, "Expected blocks which don't shrink enough to be stored as is")
+			assert.Equal(t, incompressible, compressed)
+
+			_, err = decompressBlock(codec, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSSTable_Compression(t *testing.T) {
+	var pairs []utils.BytePair
+	for i := range 100 {
+		pairs = append(pairs, utils.BytePair{Key: []byte(fmt.Sprintf("user:%03d", i)),
+			Value: []byte(fmt.Sprintf(`{"id":%d,"name":"user","email":"user@example.com","active":true}`, i))})
+	}
+	sizes := make(map[Codec]int64)
+	for _, codec := range []Codec{CodecNone, CodecSnappy, CodecLZ4, CodecZstd} {
+		path := filepath.Join(t.TempDir(), "1", "1.sst")
+		require.NoError(t, writeSSTable(partInfo{id: 1, codec: codec}, path, unversioned(pairs)))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		sizes[codec] = info.Size()
+
+		sst, err := NewSSTable(path)
+		require.NoError(t, err)
+		for _, pair := range pairs {
+			val, err := sst.Get(pair.Key)
+			assert.NoError(t, err)
+			assert.Equal(t, pair.Value, val)
+		}
+		require.NoError(t, sst.Close())
+	}
+	for _, codec := range []Codec{CodecSnappy, CodecLZ4, CodecZstd} {
+		assert.Less(t, sizes[codec], sizes[CodecNone], "Expected %s parts to be smaller", codec)
+	}
+}
+
+func TestLSMTree_Compression(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "block_compression", "zstd")
+	dataDir := t.TempDir()
+	lsm, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	value := strings.Repeat("compressible ", 20)
+	for i := range 10 {
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(value)))
+	}
+	require.NoError(t, lsm.Close())
+
+	// Parts stay readable after switching the codec of the table.
+	config.SetTestFlag(t, "block_compression_tables", "1:lz4")
+	lsm, err = NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	assert.Equal(t, CodecLZ4, lsm.codec)
+	for i := range 10 {
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i+10)), []byte(value)))
+	}
+	for i := range 20 {
+		val, err := lsm.Get([]byte("k" + strconv.Itoa(i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(value), val)
+	}
+
+	config.SetTestFlag(t, "block_compression_tables", "1:gzip")
+	_, err = NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	assert.Error(t, err)
+}
@@ -0,0 +1,377 @@
+// Every memtable flush adds a part to the head of a table's part chain, so without compactions lookups would get
+// slower over time and overwritten, deleted or expired values would never leave the disk. Compactions merge a
+// contiguous run of the chain into a single part, keeping only the latest version of each key, along with the older
+// versions that live snapshots can see, see snapshot.go.
+//
+// Parts are organized in levels: level 0 holds the flushed memtables, and each higher level holds a single part
+// which is the merged result of the lower ones. Levels never decrease from the head to the tail of the chain.
+// A compaction is triggered when either:
+//   - Level 0 has too many parts; they're merged into level 1.
+//   - A level gets bigger than its size budget; it's merged into the next level.
+//   - Too many keys of the table are dead (tombstoned or expired) and droppable; the whole chain is merged into one
+//     part.
+//
+// Dead values are only dropped when the oldest part is merged too, as they may still shadow older live values; so
+// are the oldest versions of a key that are dead, e.g. the tombstone that a snapshot sees after a delete.
+// The merged part gets a fresh ID and replaces its inputs by committing a single manifest edit; the inputs of a
+// compaction interrupted after the commit (or the output of one interrupted before it) are no longer referenced by
+// the manifest, and get removed when the table is opened again.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"iter"
+	"log/slog"
+	"math"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/scan"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	kiwipb "github.com/nobletooth/kiwi/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	compactionEnabled  = flag.Bool("enable_compaction", true, "Merge table parts in the background.")
+	compactionInterval = flag.Duration("compaction_interval", 30*time.Second,
+		"The interval between background checks for compactions; flushes trigger a check as well.")
+	compactionLevel0Parts = flag.Int("compaction_level0_parts", 4,
+		"The number of level 0 parts that triggers merging them into level 1; 0 or negative disables the trigger.")
+	compactionLevelBaseBytes = flag.Int64("compaction_level_base_bytes", 64<<20, /*64 MiB*/
+		"The size in bytes of level 1 that triggers merging it into level 2; 0 or negative disables the trigger.")
+	compactionLevelMultiplier = flag.Int("compaction_level_multiplier", 10,
+		"The size ratio between each two consecutive levels.")
+	compactionDeadKeysRatio = flag.Float64("compaction_dead_keys_ratio", 0.5,
+		"The ratio of dead keys in (0.0, 1.0] that triggers merging all parts of a table into one; 0 or negative"+
+			" disables the trigger.")
+
+	compactionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "compactions_total",
+		Help: "Total number of finished compactions.",
+	}, []string{"trigger" /* level0_parts | level_bytes | dead_keys_ratio */})
+	compactionReadBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "compaction_read_bytes_total",
+		Help: "Total number of bytes read from the input parts of compactions.",
+	})
+	compactionWrittenBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "compaction_written_bytes_total",
+		Help: "Total number of bytes written to the output parts of compactions.",
+	})
+	compactionDroppedKeys = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "compaction_dropped_keys_total",
+		Help: "Total number of tombstoned or expired keys dropped by compactions.",
+	})
+	compactionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "compaction_duration_seconds",
+		Help:    "Time spent on each compaction.",
+		Buckets: prometheus.ExponentialBuckets(0.01 /*start*/, 4 /*factor*/, 8 /*count*/),
+	})
+)
+
+// Compaction triggers, used as metric labels.
+const (
+	triggerLevel0Parts   = "level0_parts"
+	triggerLevelBytes    = "level_bytes"
+	triggerDeadKeysRatio = "dead_keys_ratio"
+)
+
+// compaction describes a contiguous run of the part chain that should be merged into a single part.
+type compaction struct {
+	trigger string
+	inputs  []*SSTable // Newest first, i.e. in the order of lookups.
+	level   int32      // The level of the merged part.
+	bottom  bool       // True when the oldest part of the chain is an input, so dead values can be dropped.
+}
+
+// levelMaxBytes returns the size budget of the given level (>= 1), or math.MaxInt64 when the trigger is disabled.
+func levelMaxBytes(level int32) int64 {
+	if *compactionLevelBaseBytes <= 0 {
+		return math.MaxInt64
+	}
+	multiplier := int64(max(*compactionLevelMultiplier, 1))
+	maxBytes := *compactionLevelBaseBytes
+	for range level - 1 {
+		if maxBytes > math.MaxInt64/multiplier {
+			return math.MaxInt64
+		}
+		maxBytes *= multiplier
+	}
+	return maxBytes
+}
+
+// levelEnd returns the index right after the run of parts starting at `start` that have the same level.
+func levelEnd(parts []*SSTable, start int) int {
+	end := start
+	for end < len(parts) && parts[end].header.GetLevel() == parts[start].header.GetLevel() {
+		end++
+	}
+	return end
+}
+
+// pickCompaction returns the most urgent compaction of the given parts (newest first), or nil if there's none.
+func pickCompaction(parts []*SSTable) *compaction {
+	if len(parts) == 0 {
+		return nil
+	}
+
+	// Too many flushed parts slow down lookups the most, so they're merged first.
+	if level0End := levelEnd(parts, 0); parts[0].header.GetLevel() == 0 &&
+		*compactionLevel0Parts > 0 && level0End >= *compactionLevel0Parts {
+		end := level0End
+		if end < len(parts) && parts[end].header.GetLevel() == 1 {
+			end = levelEnd(parts, end)
+		}
+		return &compaction{trigger: triggerLevel0Parts, inputs: parts[:end], level: 1, bottom: end == len(parts)}
+	}
+
+	// Levels over their size budget are pushed down to the next level.
+	for start := 0; start < len(parts); {
+		level := parts[start].header.GetLevel()
+		end := levelEnd(parts, start)
+		if level == 0 { // Level 0 is bounded by its number of parts instead.
+			start = end
+			continue
+		}
+		levelBytes := int64(0)
+		for _, sst := range parts[start:end] {
+			levelBytes += sst.size
+		}
+		if levelBytes > levelMaxBytes(level) {
+			if end < len(parts) && parts[end].header.GetLevel() == level+1 {
+				end = levelEnd(parts, end)
+			}
+			return &compaction{trigger: triggerLevelBytes, inputs: parts[start:end], level: level + 1,
+				bottom: end == len(parts)}
+		}
+		start = end
+	}
+
+	// Reclaim the space of dead keys by merging the whole chain; a single compacted part has already dropped every
+	// dead key it could, when it was merged as the oldest part.
+	if *compactionDeadKeysRatio > 0 && (len(parts) > 1 || parts[0].header.GetLevel() == 0) {
+		totalKeys, deadKeys, maxLevel := int64(0), int64(0), int32(1)
+		for _, sst := range parts {
+			totalKeys += sst.header.GetNumKeys()
+			deadKeys += sst.header.GetNumDeadKeys()
+			maxLevel = max(maxLevel, sst.header.GetLevel())
+		}
+		if totalKeys > 0 && float64(deadKeys)/float64(totalKeys) >= *compactionDeadKeysRatio {
+			return &compaction{trigger: triggerDeadKeysRatio, inputs: parts, level: maxLevel, bottom: true}
+		}
+	}
+
+	return nil
+}
+
+// isDroppable returns true if the given version, being the oldest one of its key in a part, is dead at the history
+// horizon; so a compaction of the oldest part can drop it, as reads of the past can't see it either. Only these are
+// counted as the dead keys of parts, since the dead versions that are kept for snapshots or history can't be dropped
+// by merging the whole chain again.
+func (l *LSMTree) isDroppable(oldest internalPair, now time.Time) bool {
+	return l.inspector != nil && !l.inspector.IsLive(oldest.Value, now.Add(-l.retention))
+}
+
+// maybeCompact runs the most urgent compaction, if any; it returns false when there was nothing to compact.
+func (l *LSMTree) maybeCompact() (bool /*compacted*/, error) {
+	version := l.acquireVersion() // Keeps the inputs open while they're merged.
+	if version == nil {
+		return false, nil
+	}
+	defer version.unref()
+
+	c := pickCompaction(version.parts)
+	if c == nil {
+		return false, nil
+	}
+	return true, l.compact(c)
+}
+
+// compact merges the inputs of the given compaction into a single part and swaps it into the part chain.
+// NOTE: Only one compaction may run at a time, as inputs are not protected against concurrent compactions.
+func (l *LSMTree) compact(c *compaction) error {
+	startTime := time.Now()
+	inputIds := make([]int64, len(c.inputs))
+	for i, input := range c.inputs {
+		inputIds[i] = input.header.GetId()
+	}
+	partId, prevPartId := l.manifest.allocateId(), c.inputs[len(c.inputs)-1].header.GetPrevPart()
+
+	// Merge the inputs; newer parts come first and have a higher priority among the versions with equal internal
+	// keys, i.e. the ones written before sequence numbers.
+	readErrs := make([]error, len(c.inputs))
+	sequences := make([]iter.Seq[internalPair], len(c.inputs))
+	readBytes := int64(0)
+	for i, sst := range c.inputs {
+		sequences[i] = sst.allPairs(&readErrs[i])
+		readBytes += sst.size
+	}
+	merged, err := scan.MultiHead(compareInternalKeys, sequences)
+	if err != nil {
+		return fmt.Errorf("failed to merge compaction inputs: %w", err)
+	}
+	// The merged versions are written as they come, so that the whole table is never held in memory; the inputs
+	// bound the number of versions of the output, which the bloom filter is sized for.
+	expectedKeys := int64(0)
+	for _, sst := range c.inputs {
+		expectedKeys += sst.header.GetNumKeys()
+	}
+	path := partPath(l.dir, partId)
+	writer, err := newPartWriter(partInfo{id: partId, prevId: prevPartId, level: c.level, codec: l.codec}, path,
+		int(expectedKeys))
+	if err != nil {
+		return fmt.Errorf("failed to create compacted sstable: %w", err)
+	}
+	defer writer.discard()
+	now := time.Now()
+
+	var versions []internalPair // The versions of the current key, newest first.
+	keys, deadKeys, droppedKeys := 0, int64(0), 0
+	// writeVersions writes the versions of the current key; its oldest versions are dropped while they're dead, if
+	// nothing older is left to be shadowed. Otherwise, the oldest one is left for a compaction of the oldest part.
+	writeVersions := func() error {
+		for c.bottom && len(versions) > 0 && l.isDroppable(versions[len(versions)-1], now) {
+			versions = versions[:len(versions)-1]
+			droppedKeys++
+		}
+		if len(versions) > 0 && l.isDroppable(versions[len(versions)-1], now) {
+			deadKeys++
+		}
+		for _, version := range versions {
+			if err := writer.add(version); err != nil {
+				return fmt.Errorf("failed to write compacted sstable: %w", err)
+			}
+		}
+		keys += len(versions)
+		versions = versions[:0]
+		return nil
+	}
+	for pair := range retainVersions(merged, l.snapshotSequences(), l.historyCutoff(now)) {
+		if len(versions) > 0 && !bytes.Equal(versions[0].Key.key, pair.Key.key) {
+			if err := writeVersions(); err != nil {
+				return err
+			}
+		}
+		versions = append(versions, pair)
+	}
+	if err := writeVersions(); err != nil {
+		return err
+	}
+	if err := errors.Join(readErrs...); err != nil {
+		return fmt.Errorf("failed to read compaction inputs: %w", err)
+	}
+	writer.part.deadKeys = deadKeys
+	if err := writer.finish(); err != nil {
+		return fmt.Errorf("failed to write compacted sstable: %w", err)
+	}
+	sst, err := NewSSTable(path)
+	if err != nil {
+		return fmt.Errorf("failed to load compacted sstable %s: %w", path, err)
+	}
+	minSequence, maxSequence := l.manifest.sequenceRange(inputIds)
+	edit := &kiwipb.ManifestEdit{
+		AddedParts:   []*kiwipb.PartMeta{newPartMeta(sst.header, minSequence, maxSequence)},
+		RemovedParts: inputIds,
+	}
+	if err := l.manifest.commit(edit); err != nil {
+		return errors.Join(fmt.Errorf("failed to commit compacted part %d: %w", partId, err), sst.Close())
+	}
+
+	// Flushes may have added newer parts in the meantime, but the inputs are still a contiguous run of parts.
+	l.versionMux.Lock()
+	parts := l.currentParts()
+	if start := slices.Index(parts, c.inputs[0]); start >= 0 && start+len(c.inputs) <= len(parts) &&
+		slices.Equal(parts[start:start+len(c.inputs)], c.inputs) {
+		l.installVersion(slices.Concat(parts[:start], []*SSTable{sst}, parts[start+len(c.inputs):]))
+	} else {
+		utils.RaiseInvariant("compaction", "inputs_moved", "Compaction inputs are no longer a run of live parts.",
+			"dir", l.dir, "part", partId)
+	}
+	l.versionMux.Unlock()
+	// Lookups holding older versions may cache the blocks of the inputs again, until the inputs are closed.
+	for _, input := range c.inputs {
+		input.evictCachedBlocks()
+	}
+
+	var cleanupErr error
+	for _, input := range c.inputs { // In-flight scans keep reading the removed files until they're done.
+		cleanupErr = errors.Join(cleanupErr, input.retire(), os.Remove(input.file.Name()))
+	}
+	cleanupErr = errors.Join(cleanupErr, syncDir(l.dir))
+
+	compactionsTotal.WithLabelValues(c.trigger).Inc()
+	compactionReadBytes.Add(float64(readBytes))
+	compactionWrittenBytes.Add(float64(sst.size))
+	compactionDroppedKeys.Add(float64(droppedKeys))
+	compactionDuration.Observe(time.Since(startTime).Seconds())
+	slog.Info("Compacted table parts.", "dir", l.dir, "trigger", c.trigger, "inputs", len(c.inputs),
+		"part", partId, "level", c.level, "keys", keys, "droppedKeys", droppedKeys,
+		"readBytes", readBytes, "writtenBytes", sst.size, "duration", time.Since(startTime))
+	if cleanupErr != nil { // Leftovers aren't referenced by the manifest and get removed when the tree is opened again.
+		return fmt.Errorf("failed to clean up compacted parts: %w", cleanupErr)
+	}
+	return nil
+}
+
+// startCompactions starts the background compaction loop; it's stopped by Close.
+func (l *LSMTree) startCompactions() {
+	l.compactionStop, l.compactionDone = make(chan struct{}), make(chan struct{})
+	l.compactionPoke = make(chan struct{}, 1)
+	go l.compactionLoop(*compactionInterval)
+}
+
+// pokeCompactions wakes up the compaction loop without blocking, if it's running.
+func (l *LSMTree) pokeCompactions() {
+	if l.compactionPoke == nil {
+		return
+	}
+	select {
+	case l.compactionPoke <- struct{}{}:
+	default: // Already poked.
+	}
+}
+
+// stopCompactions stops the compaction loop and waits for the running compaction, if any.
+func (l *LSMTree) stopCompactions() {
+	if l.compactionStop == nil {
+		return
+	}
+	close(l.compactionStop)
+	<-l.compactionDone
+	l.compactionStop, l.compactionPoke = nil, nil
+}
+
+// compactionLoop runs compactions until there's nothing left to compact, whenever it's poked or ticked.
+func (l *LSMTree) compactionLoop(interval time.Duration) {
+	defer close(l.compactionDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.compactionStop:
+			return
+		case <-ticker.C:
+		case <-l.compactionPoke:
+		}
+		// A compaction may trigger another one, e.g. when a level overflows after merging the lower level into it.
+		for {
+			select {
+			case <-l.compactionStop:
+				return
+			default:
+			}
+			if compacted, err := l.maybeCompact(); err != nil {
+				slog.Error("Failed to compact table parts.", "dir", l.dir, "error", err)
+				break
+			} else if !compacted {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	kiwipb "github.com/nobletooth/kiwi/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deadValue is the value that testInspector treats as a tombstone.
+var deadValue = []byte("<dead>")
+
+type testInspector struct{} // Implements ValueInspector.
+
+func (testInspector) IsLive(value []byte, _ time.Time) bool { return !bytes.Equal(value, deadValue) }
+func (testInspector) Tombstone() []byte                     { return deadValue }
+
+// listParts returns the IDs of the .sst files in the given directory.
+func listParts(t *testing.T, dir string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*.sst"))
+	require.NoError(t, err)
+	parts := make([]string, len(matches))
+	for i, match := range matches {
+		parts[i] = filepath.Base(match)
+	}
+	return parts
+}
+
+func TestPickCompaction(t *testing.T) {
+	config.SetTestFlag(t, "compaction_level0_parts", "3")
+	config.SetTestFlag(t, "compaction_level_base_bytes", "100")
+	config.SetTestFlag(t, "compaction_level_multiplier", "10")
+	config.SetTestFlag(t, "compaction_dead_keys_ratio", "0.5")
+
+	// part makes a fake part with the given level and size, having 10 keys.
+	part := func(id int64, level int32, size int64, deadKeys int64) *SSTable {
+		header := &kiwipb.PartHeader{Id: id, Level: level, NumKeys: 10, NumDeadKeys: deadKeys}
+		return &SSTable{size: size, header: header}
+	}
+	ids := func(c *compaction) []int64 {
+		var partIds []int64
+		for _, sst := range c.inputs {
+			partIds = append(partIds, sst.header.GetId())
+		}
+		return partIds
+	}
+
+	t.Run("nothing_to_compact", func(t *testing.T) {
+		assert.Nil(t, pickCompaction(nil))
+		assert.Nil(t, pickCompaction([]*SSTable{part(5, 0, 10, 0), part(4, 0, 10, 0), part(3, 1, 50, 0)}))
+		assert.Nil(t, pickCompaction([]*SSTable{part(5, 1, 100, 0), part(4, 2, 1000, 0), part(3, 3, 10000, 0)}))
+	})
+	t.Run("level0_parts", func(t *testing.T) {
+		c := pickCompaction([]*SSTable{part(6, 0, 10, 0), part(5, 0, 10, 0), part(4, 0, 10, 0),
+			part(3, 1, 50, 0), part(2, 2, 500, 0)})
+		require.NotNil(t, c)
+		assert.Equal(t, triggerLevel0Parts, c.trigger)
+		assert.Equal(t, []int64{6, 5, 4, 3}, ids(c))
+		assert.Equal(t, int32(1), c.level)
+		assert.False(t, c.bottom)
+	})
+	t.Run("level_bytes", func(t *testing.T) {
+		c := pickCompaction([]*SSTable{part(6, 0, 10, 0), part(5, 1, 50, 0), part(4, 2, 1001, 0),
+			part(3, 3, 500, 0)})
+		require.NotNil(t, c)
+		assert.Equal(t, triggerLevelBytes, c.trigger)
+		assert.Equal(t, []int64{4, 3}, ids(c))
+		assert.Equal(t, int32(3), c.level)
+		assert.True(t, c.bottom)
+	})
+	t.Run("level_bytes_without_next_level", func(t *testing.T) {
+		c := pickCompaction([]*SSTable{part(5, 1, 101, 0), part(4, 3, 500, 0)})
+		require.NotNil(t, c)
+		assert.Equal(t, []int64{5}, ids(c))
+		assert.Equal(t, int32(2), c.level)
+		assert.False(t, c.bottom)
+	})
+	t.Run("dead_keys_ratio", func(t *testing.T) {
+		c := pickCompaction([]*SSTable{part(5, 0, 10, 10), part(4, 2, 50, 0)})
+		require.NotNil(t, c)
+		assert.Equal(t, triggerDeadKeysRatio, c.trigger)
+		assert.Equal(t, []int64{5, 4}, ids(c))
+		assert.Equal(t, int32(2), c.level)
+		assert.True(t, c.bottom)
+
+		assert.Nil(t, pickCompaction([]*SSTable{part(4, 2, 50, 10)}),
+			"Expected a single compacted part not to be merged again")
+		c = pickCompaction([]*SSTable{part(4, 0, 50, 10)})
+		require.NotNil(t, c)
+		assert.Equal(t, triggerDeadKeysRatio, c.trigger)
+	})
+}
+
+func TestLSMTree_Compaction(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false") // Compactions are run manually.
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "compaction_level0_parts", "2")
+	config.SetTestFlag(t, "compaction_dead_keys_ratio", "0")
+	dataDir := t.TempDir()
+	lsm, err := NewLSMTree(dataDir, 1 /*table*/, testInspector{})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+
+	// Part 1 has k0..k9, part 2 overwrites the even keys and deletes the odd ones.
+	for i := range 10 {
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	for i := range 10 {
+		value := []byte(fmt.Sprintf("v%d*", i))
+		if i%2 == 1 {
+			value = deadValue
+		}
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), value))
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 2)
+	assert.Equal(t, int64(5), lsm.currentParts()[0].header.GetNumDeadKeys())
+
+	compacted, err := lsm.maybeCompact()
+	require.NoError(t, err)
+	require.True(t, compacted)
+	// Parts 1 and 2 were flushed from logs 1 and 2, and log 3 is live; so the merged part gets ID 4.
+	assert.Equal(t, []string{"4.sst"}, listParts(t, filepath.Join(dataDir, "1")))
+	require.Len(t, lsm.currentParts(), 1)
+	assert.Equal(t, int64(4), lsm.currentParts()[0].header.GetId())
+	assert.Equal(t, int64(0), lsm.currentParts()[0].header.GetPrevPart())
+	assert.Equal(t, int32(1), lsm.currentParts()[0].header.GetLevel())
+	assert.Equal(t, int64(5), lsm.currentParts()[0].header.GetNumKeys(), "Expected dead keys to be dropped")
+	for i := range 10 {
+		val, err := lsm.Get([]byte("k" + strconv.Itoa(i)))
+		if i%2 == 1 {
+			assert.ErrorIs(t, err, ErrKeyNotFound)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, []byte(fmt.Sprintf("v%d*", i)), val)
+		}
+	}
+
+	// A single level 1 part doesn't need any compactions.
+	compacted, err = lsm.maybeCompact()
+	require.NoError(t, err)
+	assert.False(t, compacted)
+
+	// New parts chain after the compacted one and keep the dead values until the oldest part is merged.
+	for i := range 10 {
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), deadValue))
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 2)
+	assert.Equal(t, int64(4), lsm.currentParts()[0].header.GetPrevPart())
+	config.SetTestFlag(t, "compaction_level_base_bytes", "1")
+	compacted, err = lsm.maybeCompact() // Level 1 is over its budget, so it's pushed to level 2.
+	require.NoError(t, err)
+	require.True(t, compacted)
+	assert.Equal(t, int32(2), lsm.currentParts()[1].header.GetLevel())
+	config.SetTestFlag(t, "compaction_level_base_bytes", strconv.Itoa(64<<20))
+	config.SetTestFlag(t, "compaction_level0_parts", "1")
+	compacted, err = lsm.maybeCompact() // Merges the new level 0 part into level 1, which isn't the bottom.
+	require.NoError(t, err)
+	require.True(t, compacted)
+	require.Len(t, lsm.currentParts(), 2)
+	assert.Equal(t, int32(1), lsm.currentParts()[0].header.GetLevel())
+	assert.Equal(t, int64(10), lsm.currentParts()[0].header.GetNumDeadKeys())
+	for i := range 10 {
+		val, err := lsm.Get([]byte("k" + strconv.Itoa(i)))
+		assert.NoError(t, err)
+		assert.Equal(t, deadValue, val)
+	}
+}
+
+func TestLSMTree_CompactionOfRetainedDeadKeys(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false") // Compactions are run manually.
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "compaction_level0_parts", "0")
+	config.SetTestFlag(t, "compaction_dead_keys_ratio", "0.25")
+
+	for _, retained := range []string{"history", "snapshot"} {
+		t.Run(retained, func(t *testing.T) {
+			if retained == "history" {
+				config.SetTestFlag(t, "history_retention", "1h")
+			}
+			lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, testInspector{})
+			require.NoError(t, err)
+			t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+
+			// Part 1 has k0..k9 and part 2 deletes them, which history or a snapshot can still see.
+			for i := range 10 {
+				require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte("v")))
+			}
+			if retained == "snapshot" {
+				snapshot, err := lsm.Snapshot()
+				require.NoError(t, err)
+				t.Cleanup(snapshot.Release)
+			}
+			for i := range 10 {
+				require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), deadValue))
+			}
+			require.NoError(t, lsm.waitForFlushes())
+			require.Len(t, lsm.currentParts(), 2)
+			assert.Equal(t, int64(10), lsm.currentParts()[0].header.GetNumDeadKeys())
+
+			compacted, err := lsm.maybeCompact()
+			require.NoError(t, err)
+			require.True(t, compacted)
+			require.Len(t, lsm.currentParts(), 1)
+			assert.Equal(t, int64(20), lsm.currentParts()[0].header.GetNumKeys(), "Expected the versions to be kept")
+			assert.Zero(t, lsm.currentParts()[0].header.GetNumDeadKeys(),
+				"Expected tombstones that shadow retained versions not to be counted")
+
+			// Live keys on top of the compacted part don't trigger merging the retained tombstones again.
+			for i := range 10 {
+				require.NoError(t, lsm.Set([]byte("n"+strconv.Itoa(i)), []byte("v")))
+			}
+			require.NoError(t, lsm.waitForFlushes())
+			require.Len(t, lsm.currentParts(), 2)
+			compacted, err = lsm.maybeCompact()
+			require.NoError(t, err)
+			assert.False(t, compacted)
+		})
+	}
+}
+
+func TestLSMTree_BackgroundCompaction(t *testing.T) {
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	// Every flushed part is merged, as a compaction may pick up several of them at once.
+	config.SetTestFlag(t, "compaction_level0_parts", "1")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, testInspector{})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+
+	for i := range 40 {
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	assert.Eventually(t, func() bool {
+		return len(lsm.currentParts()) == 1
+	}, 5*time.Second /*waitFor*/, 10*time.Millisecond /*tick*/, "Expected flushed parts to be merged")
+	for i := range 40 {
+		val, err := lsm.Get([]byte("k" + strconv.Itoa(i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("v%d", i)), val)
+	}
+}
+
+func TestNewLSMTree_InterruptedCompaction(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	dataDir := t.TempDir()
+	tableDir := filepath.Join(dataDir, "1")
+	// Parts 1 and 2 were merged into part 3, but the process crashed before removing them.
+	for id := int64(1); id <= 2; id++ {
+		path := filepath.Join(tableDir, fmt.Sprintf("%d.sst", id))
+		require.NoError(t, writeSSTable(partInfo{id: id, prevId: id - 1}, path,
+			unversioned([]utils.BytePair{{Key: []byte("k"), Value: []byte(fmt.Sprintf("v%d", id))}})))
+	}
+	require.NoError(t, writeSSTable(partInfo{id: 3, prevId: 0, level: 1}, filepath.Join(tableDir, "3.sst"),
+		unversioned([]utils.BytePair{{Key: []byte("k"), Value: []byte("v3")}})))
+
+	lsm, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	assert.Equal(t, []string{"3.sst"}, listParts(t, tableDir))
+	assert.Len(t, lsm.currentParts(), 1)
+	val, err := lsm.Get([]byte("k"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v3"), val)
+
+	_, err = os.Stat(filepath.Join(tableDir, "1.sst"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -1,15 +1,33 @@
-// To store key-value pairs in the data block section, we first apply a prefix compression on it and each prefix
-// group is then stored as a separate DataBlock entry in the file.
+// To store key-value pairs in the data block section, the sorted pairs are split into data blocks of about the same
+// size, so that each point lookup reads and unmarshals a bounded amount of data; the versions of a key stay in the
+// same block, so that each lookup reads a single block. The common prefix of the keys in each block is then stripped
+// off, and stored once in the skip index of the part.
 
 package storage
 
 import (
+	"bytes"
+	"flag"
 	"slices"
 
 	"github.com/nobletooth/kiwi/pkg/utils"
 	kiwipb "github.com/nobletooth/kiwi/proto"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
+var dataBlockSize = flag.Int("data_block_size", 4096,
+	"The target size in bytes of each data block before compression; a bigger key-value pair gets its own block.")
+
+// getDataBlockSize returns the target data block size, falling back to the default on invalid values.
+func getDataBlockSize() int {
+	if *dataBlockSize > 0 {
+		return *dataBlockSize
+	}
+	utils.RaiseInvariant("compression", "invalid_data_block_size",
+		"Data block size must be positive. Using default value 4096.", "providedSize", *dataBlockSize)
+	return 4096
+}
+
 // lcpLen returns the length of the longest common prefix of keys k1 and k2.
 func lcpLen(k1, k2 []byte) int {
 	biggerSize := len(k1)
@@ -25,84 +43,73 @@ func lcpLen(k1, k2 []byte) int {
 	return longestCommon
 }
 
-// compressDataBlocks splits a sorted list of keys into optimal prefixed blocks.
-// Each block stores one shared prefix and per-key suffixes, minimizing total bytes.
-// Tie-breaker: on equal savings prefer fewer blocks (i.e., longer blocks).
-func compressDataBlocks(pairs []utils.BytePair) ([] /*prefix*/ []byte, []*kiwipb.DataBlock) {
-	pairsNum := len(pairs)
-	if pairsNum == 0 {
-		return nil, nil
+// encodedPairSize returns the size of the given pair as encoded in a DataBlock, with its whole key; which is an upper
+// bound of its size with the block prefix stripped.
+func encodedPairSize(pair internalPair) int {
+	size := protowire.SizeTag(1) + protowire.SizeBytes(len(pair.Key.key)) +
+		protowire.SizeTag(2) + protowire.SizeBytes(len(pair.Value))
+	if sequence := pair.Key.sequence; sequence != 0 { // Packed, so the tag is only written once.
+		size += protowire.SizeVarint(uint64(sequence))
 	}
-
-	// Precompute adjacent LCP: lcpNext[i] = LCP(keys[i], keys[i+1]).
-	lcpNext := make([]int, pairsNum-1)
-	for i := 0; i+1 < pairsNum; i++ {
-		lcpNext[i] = lcpLen(pairs[i].Key, pairs[i+1].Key)
+	if timestamp := pair.Key.timestamp; timestamp != 0 {
+		size += protowire.SizeVarint(uint64(timestamp))
 	}
+	return size
+}
 
-	// DP for maximum total savings with tie-break on block count.
-	// Savings of a block [i..j] = (j - i) * min(lcpNext[i..j-1]), singleton => 0.
-	dpSave := make([]int, pairsNum+1)   // Best savings from i to end.
-	dpBlocks := make([]int, pairsNum+1) // Min blocks when achieving dpSave[i].
-	end := make([]int, pairsNum)        // Chosen j for block starting at index i.
-
-	for i := pairsNum - 1; i >= 0; i-- {
-		bestSave := -1
-		bestBlocks := int(^uint(0) >> 1) // max int
-		bestJ := i
+// endsDataBlock returns true if `next` should start a new data block after the pairs of the current one, whose
+// encoded size is `size`; a block only exceeds the size when it has a single key, as the versions of a key are kept
+// in the same block.
+func endsDataBlock(block []internalPair, size int, next internalPair, blockSize int) bool {
+	return len(block) > 0 && size+encodedPairSize(next) > blockSize &&
+		!bytes.Equal(next.Key.key, block[len(block)-1].Key.key)
+}
 
-		minL := int(^uint(0) >> 1) // Running minimum of lcpNext.
-		for j := i; j < pairsNum; j++ {
-			var blockSave int
-			if j == i {
-				blockSave = 0
-			} else {
-				if l := lcpNext[j-1]; l < minL {
-					minL = l
-				}
-				blockSave = (j - i) * minL
-			}
-			// candSave = total saved bytes if we choose current block [i..j],
-			// plus the optimal savings for the remainder starting at j+1.
-			candSave := blockSave + dpSave[j+1]
-			// candBlocks = total number of blocks if we choose [i..j] (that's 1),
-			// plus the number of blocks used for the remainder (dpBlocks[j+1]).
-			// Used only to break ties in favor of fewer blocks.
-			candBlocks := 1 + dpBlocks[j+1]
-			if candSave > bestSave || (candSave == bestSave && candBlocks < bestBlocks) {
-				bestSave = candSave
-				bestBlocks = candBlocks
-				bestJ = j
-			}
+// newDataBlock encodes the given pairs, in internal key order, as a data block; it returns the common prefix of their
+// keys as well, which is stripped off the keys of the block.
+func newDataBlock(pairs []internalPair) ([]byte /*prefix*/, *kiwipb.DataBlock) {
+	// Since the keys are sorted, the common prefix of the first and last keys is shared by the whole block.
+	prefixLength := lcpLen(pairs[0].Key.key, pairs[len(pairs)-1].Key.key)
+	db := &kiwipb.DataBlock{
+		Keys:   make([][]byte, len(pairs)),
+		Values: make([][]byte, len(pairs)),
+	}
+	// Sequence numbers are left out when they're all zero, e.g. when compacting the parts of older Kiwi versions.
+	if slices.ContainsFunc(pairs, func(pair internalPair) bool { return pair.Key.sequence != 0 }) {
+		db.Sequences = make([]int64, len(pairs))
+	}
+	// So are commit times, e.g. for the versions written before them.
+	if slices.ContainsFunc(pairs, func(pair internalPair) bool { return pair.Key.timestamp != 0 }) {
+		db.Timestamps = make([]int64, len(pairs))
+	}
+	for i, pair := range pairs {
+		db.Keys[i] = pair.Key.key[prefixLength:] // Suffix.
+		db.Values[i] = pair.Value
+		if db.Sequences != nil {
+			db.Sequences[i] = pair.Key.sequence
+		}
+		if db.Timestamps != nil {
+			db.Timestamps[i] = pair.Key.timestamp
 		}
-		dpSave[i] = bestSave
-		dpBlocks[i] = bestBlocks
-		end[i] = bestJ
 	}
+	return pairs[0].Key.key[:prefixLength], db
+}
 
-	// Reconstruct blocks.
+// compressDataBlocks splits a list of pairs in internal key order into blocks of about `blockSize` bytes, as encoded
+// in a DataBlock, see endsDataBlock. Each block stores one shared prefix and per-key suffixes.
+func compressDataBlocks(pairs []internalPair, blockSize int) ([] /*prefix*/ []byte, []*kiwipb.DataBlock) {
 	var prefixes [][]byte
 	var blocks []*kiwipb.DataBlock
-	for i := 0; i < pairsNum; {
-		j := end[i]
-		// LCP_all(i..j).
-		predixLength := 0
-		if j > i {
-			predixLength = slices.Min(lcpNext[i:j])
-		}
-		// Construct compressed data block.
-		prefix := pairs[i].Key[:predixLength]
-		db := &kiwipb.DataBlock{
-			Keys:   make([][]byte, j-i+1),
-			Values: make([][]byte, j-i+1),
-		}
-		for k := i; k <= j; k++ {
-			db.Keys[k-i] = pairs[k].Key[predixLength:] // Suffix.
-			db.Values[k-i] = pairs[k].Value
+	for start := 0; start < len(pairs); {
+		end, size := start, 0
+		for end < len(pairs) && !endsDataBlock(pairs[start:end], size, pairs[end], blockSize) {
+			size += encodedPairSize(pairs[end])
+			end++
 		}
+		prefix, db := newDataBlock(pairs[start:end])
 		prefixes = append(prefixes, prefix)
 		blocks = append(blocks, db)
-		i = j + 1
+		start = end
 	}
 
 	return prefixes, blocks
@@ -1,83 +1,111 @@
 package storage
 
 import (
+	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/nobletooth/kiwi/pkg/utils"
 	kiwipb "github.com/nobletooth/kiwi/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestCompressDataBlocks(t *testing.T) {
 	for _, testCase := range []struct {
 		name           string
 		pairs          []utils.BytePair
+		blockSize      int
 		expectedPrefix [][]byte
 		expectedBlocks []*kiwipb.DataBlock
 	}{
 		{
 			name:           "empty",
 			pairs:          nil,
+			blockSize:      100,
 			expectedPrefix: nil,
 			expectedBlocks: nil,
 		},
 		{ // There's no reason to split a single pair into multiple blocks.
 			name:           "single pair",
 			pairs:          []utils.BytePair{{Key: []byte{1, 2, 3}, Value: []byte{4, 5, 6}}},
-			expectedPrefix: [][]byte{{}},
-			expectedBlocks: []*kiwipb.DataBlock{{Keys: [][]byte{{1, 2, 3}}, Values: [][]byte{{4, 5, 6}}}},
+			blockSize:      100,
+			expectedPrefix: [][]byte{{1, 2, 3}},
+			expectedBlocks: []*kiwipb.DataBlock{{Keys: [][]byte{{}}, Values: [][]byte{{4, 5, 6}}}},
+		},
+		{ // Pairs bigger than the block size get their own block.
+			name:           "oversized pairs",
+			pairs:          []utils.BytePair{{Key: []byte{1, 2}, Value: []byte{4, 5}}, {Key: []byte{1, 3}, Value: []byte{6}}},
+			blockSize:      1,
+			expectedPrefix: [][]byte{{1, 2}, {1, 3}},
+			expectedBlocks: []*kiwipb.DataBlock{
+				{Keys: [][]byte{{}}, Values: [][]byte{{4, 5}}},
+				{Keys: [][]byte{{}}, Values: [][]byte{{6}}},
+			},
 		},
 		{
-			name: "multiple pairs with common prefix",
+			name: "multiple blocks with common prefix",
 			pairs: []utils.BytePair{
-				// Two following keys share prefix {1, 2}.
+				// Each pair takes 8 bytes, so two of them fit in a block.
 				{Key: []byte{1, 2, 3}, Value: []byte{4}},
 				{Key: []byte{1, 2, 4}, Value: []byte{5}},
-				// The next two don't share any prefix.
 				{Key: []byte{1, 3, 5}, Value: []byte{6}},
 				{Key: []byte{2, 3, 4}, Value: []byte{7}},
+				{Key: []byte{2, 3, 5}, Value: []byte{8}},
 			},
-			expectedPrefix: [][]byte{{1, 2}, {}},
+			blockSize:      17,
+			expectedPrefix: [][]byte{{1, 2}, {}, {2, 3, 5}},
 			expectedBlocks: []*kiwipb.DataBlock{
 				{Keys: [][]byte{{3}, {4}}, Values: [][]byte{{4}, {5}}},
 				{Keys: [][]byte{{1, 3, 5}, {2, 3, 4}}, Values: [][]byte{{6}, {7}}},
+				{Keys: [][]byte{{}}, Values: [][]byte{{8}}},
 			},
 		},
-		{ // Both {1,2,3} and {1,2} are common prefixes, but we should pick the shorter one (counter-intuitive).
+		{ // The prefix is the longest one shared by every key of the block.
 			name: "non-trivial common prefix",
 			pairs: []utils.BytePair{
-				// Following keys share prefix {1, 2, 3}.
 				{Key: []byte{1, 2, 3, 4}, Value: []byte{4}},
 				{Key: []byte{1, 2, 3, 5}, Value: []byte{5}},
-				{Key: []byte{1, 2, 3, 6}, Value: []byte{5}},
-				// Following keys share prefix {1, 2}.
-				{Key: []byte{1, 2, 1}, Value: []byte{1}},
-				{Key: []byte{1, 2, 2}, Value: []byte{2}},
-				{Key: []byte{1, 2, 3}, Value: []byte{3}},
-				{Key: []byte{1, 2, 4}, Value: []byte{4}},
-				{Key: []byte{1, 2, 5}, Value: []byte{5}},
-				{Key: []byte{1, 2, 6}, Value: []byte{6}},
-				{Key: []byte{1, 2, 7}, Value: []byte{7}},
-				{Key: []byte{1, 2, 8}, Value: []byte{8}},
-				{Key: []byte{1, 2, 9}, Value: []byte{9}},
+				{Key: []byte{1, 2, 4}, Value: []byte{6}},
 			},
+			blockSize:      100,
 			expectedPrefix: [][]byte{{1, 2}},
 			expectedBlocks: []*kiwipb.DataBlock{
-				{ // The first block has prefix {1,2}, so we strip it from keys.
-					Keys:   [][]byte{{3, 4}, {3, 5}, {3, 6}, {1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}, {9}},
-					Values: [][]byte{{4}, {5}, {5}, {1}, {2}, {3}, {4}, {5}, {6}, {7}, {8}, {9}},
-				},
+				{Keys: [][]byte{{3, 4}, {3, 5}, {4}}, Values: [][]byte{{4}, {5}, {6}}},
 			},
 		},
 	} {
 		t.Run(testCase.name, func(t *testing.T) {
-			prefixes, blocks := compressDataBlocks(testCase.pairs)
+			prefixes, blocks := compressDataBlocks(unversioned(testCase.pairs), testCase.blockSize)
 			assert.Equal(t, testCase.expectedPrefix, prefixes)
 			assert.Equal(t, testCase.expectedBlocks, blocks)
 		})
 	}
 }
 
+func TestCompressDataBlocks_BlockSize(t *testing.T) {
+	// Keys sharing a prefix used to end up in a single block, no matter how many they were.
+	var pairs []utils.BytePair
+	for i := range 1000 {
+		pairs = append(pairs, utils.BytePair{Key: []byte(fmt.Sprintf("user:%04d", i)), Value: make([]byte, 100)})
+	}
+	const blockSize = 4096
+	prefixes, blocks := compressDataBlocks(unversioned(pairs), blockSize)
+	require.Len(t, prefixes, len(blocks))
+	assert.Greater(t, len(blocks), 25)
+	keys := 0
+	for i, block := range blocks {
+		assert.LessOrEqual(t, proto.Size(block), blockSize)
+		if i < len(blocks)-1 {
+			assert.Greater(t, proto.Size(block), blockSize/2, "Expected blocks to be filled up")
+		}
+		assert.True(t, bytes.HasPrefix(prefixes[i], []byte("user:")))
+		keys += len(block.GetKeys())
+	}
+	assert.Equal(t, len(pairs), keys)
+}
+
 func TestLcpLen(t *testing.T) {
 	for _, testCase := range []struct {
 		name     string
@@ -0,0 +1,313 @@
+// Memtables are flushed to disk in the background, so that writes don't wait for SSTables to be built:
+//   - A full memtable is frozen into an immutable memtable along with its write-ahead log, and a fresh memtable (and
+//     log) takes the writes. Immutable memtables stay visible to lookups and scans until their part replaces them.
+//   - A background flusher writes the immutable memtables to disk, oldest first, so that the part chain keeps the
+//     order of writes. Each flush commits its part along with the next live log, then removes the flushed log.
+//   - When the flusher falls behind, each write is slowed down by --write_slowdown_delay once
+//     --write_slowdown_immutable_memtables are pending, and writes stall when --max_immutable_memtables are pending,
+//     until a flush finishes; they fail while flushes are failing instead. Writes are throttled before they're
+//     logged, so a write that fails is never applied, and one that's applied never fails.
+//
+// The logs of immutable memtables are replayed into immutable memtables when the tree is opened again.
+
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/utils"
+	kiwipb "github.com/nobletooth/kiwi/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	maxImmutableMemTables = flag.Int("max_immutable_memtables", 4,
+		"The maximum number of full memtables waiting to be flushed per table; writes stall when it's reached.")
+	writeSlowdownImmutableMemTables = flag.Int("write_slowdown_immutable_memtables", 2,
+		"The number of full memtables waiting to be flushed per table that slows down writes; 0 or negative"+
+			" disables slowdowns.")
+	writeSlowdownDelay = flag.Duration("write_slowdown_delay", time.Millisecond,
+		"The delay added to each write while writes are slowed down.")
+
+	memTableFlushDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "memtable_flush_duration_seconds",
+		Help:    "Time spent on each memtable flush.",
+		Buckets: prometheus.ExponentialBuckets(0.001 /*start*/, 4 /*factor*/, 8 /*count*/),
+	})
+	memTableFlushedBytes = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "memtable_flushed_bytes_total",
+		Help: "Total number of bytes written to the parts of flushed memtables.",
+	})
+	immutableMemTables = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "immutable_memtables",
+		Help: "Number of full memtables waiting to be flushed, across all tables.",
+	})
+	writeSlowdowns = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "write_slowdowns_total",
+		Help: "Total number of writes delayed because memtable flushes fell behind.",
+	})
+	writeStalls = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "write_stalls_total",
+		Help: "Total number of writes stalled until a memtable flush finished.",
+	})
+	writeStallDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "write_stall_duration_seconds",
+		Help:    "Time spent by each stalled write waiting for memtable flushes.",
+		Buckets: prometheus.ExponentialBuckets(0.001 /*start*/, 4 /*factor*/, 8 /*count*/),
+	})
+)
+
+// flushRetryInterval is the delay before retrying a failed background flush.
+const flushRetryInterval = time.Second
+
+// immutableMemTable is a full memtable which is read-only while it waits to be flushed.
+type immutableMemTable struct {
+	memTable                 *MemTable
+	wal                      *WAL  // Removed once the memtable is flushed.
+	walId                    int64 // Also the ID of the part that the memtable is flushed to.
+	minSequence, maxSequence int64 // The range of sequence numbers in the memtable.
+}
+
+// throttleWrite delays the write when memtable flushes fall behind. NOTE: Caller should serialize writes.
+func (l *LSMTree) throttleWrite() {
+	if *writeSlowdownImmutableMemTables <= 0 {
+		return
+	}
+	l.memMux.RLock()
+	pending := len(l.immutables)
+	l.memMux.RUnlock()
+	if pending >= *writeSlowdownImmutableMemTables {
+		writeSlowdowns.Inc()
+		time.Sleep(*writeSlowdownDelay)
+	}
+}
+
+// freezeIfRoom freezes the full memtable right after the write that filled it up, so that it's flushed in the
+// background; unless the flush queue has no room for it, which leaves it to the next write, see makeRoomForWrite.
+// The write is already applied, so failures leave the memtable to the next write as well. NOTE: Caller should
+// serialize writes.
+func (l *LSMTree) freezeIfRoom() {
+	l.memMux.RLock()
+	hasRoom := len(l.immutables) < max(*maxImmutableMemTables, 1)
+	l.memMux.RUnlock()
+	if !hasRoom {
+		return
+	}
+	if err := l.freezeMemTable(); err != nil {
+		slog.Warn("Failed to freeze the full memtable; retrying on the next write.", "dir", l.dir, "error", err)
+		return
+	}
+	l.pokeFlushes()
+}
+
+// makeRoomForWrite throttles the next write, and freezes the memtable if it's still full, see freezeIfRoom. It
+// happens before the write is logged, so that a refused write is never applied: it stalls until there's room for the
+// memtable in the flush queue, and fails if the background flushes are failing meanwhile; so the memtables held in
+// memory are bounded. NOTE: Caller should serialize writes.
+func (l *LSMTree) makeRoomForWrite() error {
+	l.throttleWrite()
+	if !l.memTableFull {
+		return nil
+	}
+	l.memMux.Lock()
+	if len(l.immutables) >= max(*maxImmutableMemTables, 1) {
+		writeStalls.Inc()
+		startTime := time.Now()
+		slog.Warn("Stalling writes until a memtable is flushed.", "dir", l.dir, "pending", len(l.immutables))
+		for len(l.immutables) >= max(*maxImmutableMemTables, 1) && l.flushErr == nil {
+			l.flushCond.Wait()
+		}
+		writeStallDuration.Observe(time.Since(startTime).Seconds())
+	}
+	var flushErr error
+	if len(l.immutables) >= max(*maxImmutableMemTables, 1) {
+		flushErr = l.flushErr
+	}
+	l.memMux.Unlock()
+	if flushErr != nil {
+		return fmt.Errorf("failed to flush memtables: %w", flushErr)
+	}
+
+	if err := l.freezeMemTable(); err != nil {
+		return err
+	}
+	l.pokeFlushes()
+	return nil
+}
+
+// freezeMemTable moves the memtable to the flush queue, and opens a fresh one along with its write-ahead log.
+// NOTE: Caller should serialize writes.
+func (l *LSMTree) freezeMemTable() error {
+	if l.memTableMinSequence == 0 { // Nothing was written to the memtable.
+		return nil
+	}
+	nextWalId := l.manifest.allocateId()
+	nextWal, err := OpenWAL(walPath(l.dir, nextWalId))
+	if err != nil {
+		return fmt.Errorf("failed to open the next wal: %w", err)
+	}
+	immutable := &immutableMemTable{memTable: l.memTable, wal: l.wal, walId: l.walId,
+		minSequence: l.memTableMinSequence, maxSequence: l.lastSequence}
+	l.memMux.Lock()
+	l.immutables = slices.Insert(l.immutables, 0, immutable)
+	l.memTable = NewMemTable()
+	l.wal, l.walId = nextWal, nextWalId
+	l.memMux.Unlock()
+	l.memTableMinSequence, l.memTableFull = 0, false
+	immutableMemTables.Inc()
+	return nil
+}
+
+// flushImmutable flushes the oldest immutable memtable to disk, and replaces it with the flushed part; it returns
+// false when there's nothing to flush. NOTE: Only one flush may run at a time, i.e. the flusher or Close.
+func (l *LSMTree) flushImmutable() (bool /*flushed*/, error) {
+	l.memMux.RLock()
+	if len(l.immutables) == 0 {
+		l.memMux.RUnlock()
+		return false, nil
+	}
+	immutable := l.immutables[len(l.immutables)-1]
+	l.memMux.RUnlock()
+
+	startTime := time.Now()
+	pairs := slices.Collect(retainVersions(immutable.memTable.Pairs(), l.snapshotSequences(),
+		l.historyCutoff(startTime)))
+	l.versionMux.Lock()
+	defer l.versionMux.Unlock()
+	partId, prevPartId := immutable.walId, int64(0)
+	if parts := l.currentParts(); len(parts) > 0 {
+		prevPartId = parts[0].header.GetId()
+	}
+	tablePath := partPath(l.dir, partId)
+	deadKeys := int64(0)
+	for i, pair := range pairs { // Counts the oldest version of each key, see isDroppable.
+		if (i+1 == len(pairs) || !bytes.Equal(pairs[i+1].Key.key, pair.Key.key)) && l.isDroppable(pair, startTime) {
+			deadKeys++
+		}
+	}
+	part := partInfo{id: partId, prevId: prevPartId, level: 0, deadKeys: deadKeys, codec: l.codec}
+	if err := writeSSTable(part, tablePath, pairs); err != nil {
+		return false, fmt.Errorf("failed to write sstable to disk: %v", err)
+	}
+	sst, err := NewSSTable(tablePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to load newly created sstable %s: %v", tablePath, err)
+	}
+	if sst.header.GetId() != partId || sst.header.GetPrevPart() != prevPartId {
+		utils.RaiseInvariant("lsm", "invalid_part_ids", "Created sstable has invalid part ids.", "table", tablePath)
+		return false, errors.Join(fmt.Errorf("newly created sstable %s has invalid part ids: got (%d<-%d), "+
+			"want (%d<-%d)", tablePath, sst.header.GetPrevPart(), sst.header.GetId(), prevPartId, partId), sst.Close())
+	}
+
+	// The part is committed along with the next live write-ahead log, i.e. the log of the next immutable memtable or
+	// the memtable; until then, the flushed log stays live and the part is an orphan, which is removed when the tree
+	// is opened again. Memtables frozen meanwhile only add newer logs, so the next live log stays live.
+	l.memMux.RLock()
+	nextLiveWalId := l.walId
+	if len(l.immutables) > 1 {
+		nextLiveWalId = l.immutables[len(l.immutables)-2].walId
+	}
+	l.memMux.RUnlock()
+	edit := &kiwipb.ManifestEdit{
+		AddedParts:   []*kiwipb.PartMeta{newPartMeta(sst.header, immutable.minSequence, immutable.maxSequence)},
+		LogNumber:    nextLiveWalId,
+		LastSequence: immutable.maxSequence,
+	}
+	if err := l.manifest.commit(edit); err != nil {
+		return false, errors.Join(fmt.Errorf("failed to commit flushed part %d: %w", partId, err), sst.Close())
+	}
+	if err := immutable.wal.Remove(); err != nil { // Leftover logs are removed when the tree is opened again.
+		slog.Warn("Failed to remove the write-ahead log of a flushed memtable.", "path", tablePath, "error", err)
+	}
+	l.memMux.Lock()
+	l.installVersion(slices.Concat([]*SSTable{sst}, l.currentParts()))
+	l.immutables = l.immutables[:len(l.immutables)-1]
+	l.flushErr = nil
+	l.flushCond.Broadcast()
+	l.memMux.Unlock()
+	immutableMemTables.Dec()
+	memTableFlushedBytes.Add(float64(sst.size))
+	memTableFlushDuration.Observe(time.Since(startTime).Seconds())
+	slog.Info("Flushed MemTable to disk.", "path", tablePath)
+	l.pokeCompactions()
+	return true, nil
+}
+
+// waitForFlushes waits until every immutable memtable is flushed, or a background flush fails.
+func (l *LSMTree) waitForFlushes() error {
+	l.memMux.Lock()
+	defer l.memMux.Unlock()
+	for len(l.immutables) > 0 && l.flushErr == nil {
+		l.flushCond.Wait()
+	}
+	return l.flushErr
+}
+
+// startFlushes starts the background flusher; it's stopped by Close.
+func (l *LSMTree) startFlushes() {
+	l.flushStop, l.flushDone = make(chan struct{}), make(chan struct{})
+	l.flushPoke = make(chan struct{}, 1)
+	go l.flushLoop()
+	l.pokeFlushes() // Flushes the memtables recovered from write-ahead logs, if any.
+}
+
+// pokeFlushes wakes up the flusher without blocking, if it's running.
+func (l *LSMTree) pokeFlushes() {
+	if l.flushPoke == nil {
+		return
+	}
+	select {
+	case l.flushPoke <- struct{}{}:
+	default: // Already poked.
+	}
+}
+
+// stopFlushes stops the flusher and waits for the running flush, if any.
+func (l *LSMTree) stopFlushes() {
+	if l.flushStop == nil {
+		return
+	}
+	close(l.flushStop)
+	<-l.flushDone
+	l.flushStop, l.flushPoke = nil, nil
+}
+
+// flushLoop flushes immutable memtables whenever it's poked, until there's none left; failed flushes are retried.
+func (l *LSMTree) flushLoop() {
+	defer close(l.flushDone)
+	retry := time.NewTicker(flushRetryInterval)
+	defer retry.Stop()
+	for {
+		select {
+		case <-l.flushStop:
+			return
+		case <-retry.C:
+		case <-l.flushPoke:
+		}
+		for {
+			select {
+			case <-l.flushStop:
+				return
+			default:
+			}
+			flushed, err := l.flushImmutable()
+			if err != nil {
+				slog.Error("Failed to flush memtable.", "dir", l.dir, "error", err)
+				l.memMux.Lock()
+				l.flushErr = err
+				l.flushCond.Broadcast() // Stalled writes fail rather than waiting for the retry.
+				l.memMux.Unlock()
+				break
+			} else if !flushed {
+				break
+			}
+		}
+	}
+}
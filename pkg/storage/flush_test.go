@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLSMTree_ImmutableMemTables(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	pending := testutil.ToFloat64(immutableMemTables)
+
+	lsm.stopFlushes()   // Keeps the full memtables in the flush queue.
+	for i := range 25 { // Makes two immutable memtables and a non-empty memtable.
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	require.Len(t, lsm.immutables, 2)
+	assert.Empty(t, lsm.currentParts())
+	assert.Equal(t, pending+2, testutil.ToFloat64(immutableMemTables))
+	for i := range 25 {
+		val, err := lsm.Get([]byte("k" + strconv.Itoa(i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("v%d", i)), val)
+	}
+	prevVal, err := lsm.Swap([]byte("k0"), []byte("v0*"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v0"), prevVal, "Expected swaps to find the values of immutable memtables")
+	scanned := 0
+	for _, err := range lsm.Scan(nil /*start*/, nil /*end*/) {
+		require.NoError(t, err)
+		scanned++
+	}
+	assert.Equal(t, 25, scanned)
+
+	// Immutable memtables are flushed oldest first, so the part chain keeps the order of writes.
+	lsm.startFlushes()
+	require.NoError(t, lsm.waitForFlushes())
+	assert.Empty(t, lsm.immutables)
+	require.Len(t, lsm.currentParts(), 2)
+	assert.Equal(t, int64(2), lsm.currentParts()[0].header.GetId())
+	assert.Equal(t, int64(1), lsm.currentParts()[0].header.GetPrevPart())
+	assert.Equal(t, pending, testutil.ToFloat64(immutableMemTables))
+	val, err := lsm.Get([]byte("k0"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v0*"), val)
+}
+
+func TestLSMTree_WriteStalls(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "max_immutable_memtables", "2")
+	config.SetTestFlag(t, "write_slowdown_immutable_memtables", "1")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	slowdowns, stalls := testutil.ToFloat64(writeSlowdowns), testutil.ToFloat64(writeStalls)
+
+	lsm.stopFlushes()
+	for i := range 20 { // The second memtable is written while the first one is pending.
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte("v")))
+	}
+	assert.Equal(t, slowdowns+10, testutil.ToFloat64(writeSlowdowns))
+	assert.Equal(t, stalls, testutil.ToFloat64(writeStalls))
+
+	// The write after the third memtable fills up stalls before it's applied, until a flush makes room for it.
+	for i := 20; i < 30; i++ {
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte("v")))
+	}
+	written := make(chan error, 1)
+	go func() { written <- lsm.Set([]byte("k30"), []byte("v")) }()
+	select {
+	case err := <-written:
+		t.Fatalf("Expected the write to stall, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	lsm.startFlushes()
+	select {
+	case err := <-written:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the write to resume after a flush")
+	}
+	assert.Equal(t, stalls+1, testutil.ToFloat64(writeStalls))
+	require.NoError(t, lsm.waitForFlushes())
+	assert.Len(t, lsm.currentParts(), 3)
+}
+
+func TestLSMTree_FlushFailures(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "max_immutable_memtables", "1")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+
+	lsm.stopFlushes()
+	for i := range 20 { // Fills up the memtable behind the pending one.
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte("v")))
+	}
+	config.SetTestFlag(t, "temp_folder", filepath.Join(t.TempDir(), "missing"))
+	lsm.startFlushes()
+	require.Eventually(t, func() bool {
+		lsm.memMux.RLock()
+		defer lsm.memMux.RUnlock()
+		return lsm.flushErr != nil
+	}, 5*time.Second /*waitFor*/, time.Millisecond /*tick*/)
+
+	// Writes are refused before they're applied while there's no room for the full memtable.
+	assert.Error(t, lsm.Set([]byte("k20"), []byte("v")))
+	_, err = lsm.Swap([]byte("k0"), []byte("v*"))
+	assert.Error(t, err)
+	_, err = lsm.Get([]byte("k20"))
+	assert.ErrorIs(t, err, ErrKeyNotFound, "Expected refused writes not to be applied")
+	val, err := lsm.Get([]byte("k0"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("v"), val)
+	assert.Equal(t, int64(20), lsm.LastSequence())
+
+	config.SetTestFlag(t, "temp_folder", t.TempDir())
+	lsm.pokeFlushes()
+	require.Eventually(t, func() bool { return lsm.Set([]byte("k20"), []byte("v")) == nil },
+		5*time.Second /*waitFor*/, time.Millisecond /*tick*/)
+	require.NoError(t, lsm.waitForFlushes())
+	assert.Len(t, lsm.currentParts(), 2)
+}
+
+func TestLSMTree_RecoverImmutableMemTables(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	dataDir := t.TempDir()
+	lsm, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	lsm.stopFlushes()
+	for i := range 25 {
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	require.NoError(t, lsm.CloseWithoutFlush())
+	for _, walId := range []int{1, 2, 3} {
+		assert.FileExists(t, walPath(lsm.dir, int64(walId)))
+	}
+
+	// The logs of immutable memtables are replayed and flushed in the background, and the newest one is kept live.
+	lsm, err = NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	for i := range 25 {
+		val, err := lsm.Get([]byte("k" + strconv.Itoa(i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("v%d", i)), val)
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 2)
+	assert.Equal(t, int64(2), lsm.currentParts()[0].header.GetId())
+	assert.Equal(t, int64(3), lsm.walId)
+	assert.Equal(t, 5, lsm.memTable.entries)
+	assert.Equal(t, int64(25), lsm.lastSequence)
+	assert.NoFileExists(t, walPath(lsm.dir, 1))
+}
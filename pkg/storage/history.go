@@ -0,0 +1,171 @@
+// Each table may retain the history of its keys for a while, so that their past versions can be read, e.g. to look
+// into or undo a bad write. Within the table's history retention window, memtables keep every version of the keys
+// rather than replacing them in place, and flushes and compactions only drop the versions which were superseded
+// before the window; so a read as of any time within the window sees the newest version committed until then.
+//
+// The window only covers the time since the tree was opened with history retention, and versions written before
+// commit times are treated as committed before any time. Truncating the tree drops its history along with the keys.
+
+package storage
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"iter"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/utils"
+)
+
+var (
+	historyRetention = flag.Duration("history_retention", 0,
+		"How long superseded versions are kept for reads of the past, e.g. 1h; zero disables time-travel reads.")
+	historyRetentionTables = flag.String("history_retention_tables", "",
+		"Per table history retention windows, overriding --history_retention; e.g. '1:1h,2:0s'.")
+)
+
+// ErrBeyondHistory is returned by reads of the past before the history retention window of the table.
+var ErrBeyondHistory = errors.New("time is beyond the retained history")
+
+// tableHistoryRetention returns the configured history retention window of the given table.
+func tableHistoryRetention(table int64) (time.Duration, error) {
+	retention := *historyRetention
+	if *historyRetentionTables != "" {
+		for _, entry := range strings.Split(*historyRetentionTables, ",") {
+			tableId, window, found := strings.Cut(strings.TrimSpace(entry), ":")
+			if !found {
+				return 0, fmt.Errorf("expected a table:duration pair in --history_retention_tables, got %q", entry)
+			}
+			id, err := strconv.ParseInt(tableId, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid table in --history_retention_tables: %w", err)
+			}
+			if id != table {
+				continue
+			}
+			if retention, err = time.ParseDuration(window); err != nil {
+				return 0, fmt.Errorf("invalid duration in --history_retention_tables: %w", err)
+			}
+			break
+		}
+	}
+	if retention < 0 {
+		return 0, fmt.Errorf("expected a non-negative history retention, got %v", retention)
+	}
+	return retention, nil
+}
+
+// historyCutoff returns the commit time (unix nanoseconds) until which the superseded versions are out of the history
+// retention window at the given time; every superseded version is when history isn't retained.
+func (l *LSMTree) historyCutoff(now time.Time) int64 {
+	if l.retention <= 0 {
+		return math.MaxInt64
+	}
+	return now.Add(-l.retention).UnixNano()
+}
+
+// PastView is a read-only view of an LSM tree as of a past time; it's safe for concurrent use. Unlike snapshots, it
+// doesn't hold anything, so the versions it sees are dropped once the time falls out of the history retention window.
+type PastView struct {
+	tree  *LSMTree
+	point readPoint
+}
+
+// AsOf returns a view of the LSM tree as of the given time, which sees the newest version of each key committed until
+// then; or ErrBeyondHistory if the time is before the history retention window.
+func (l *LSMTree) AsOf(t time.Time) (*PastView, error) {
+	if t.UnixNano() <= l.historyCutoff(time.Now()) {
+		return nil, fmt.Errorf("%w: %v is more than %v ago", ErrBeyondHistory, t, l.retention)
+	}
+	return &PastView{tree: l, point: atTime(t)}, nil
+}
+
+// GetAsOf returns the value of the given key as of the given time, or ErrKeyNotFound; see AsOf.
+func (l *LSMTree) GetAsOf(key []byte, t time.Time) ([]byte, error) {
+	view, err := l.AsOf(t)
+	if err != nil {
+		return nil, err
+	}
+	return view.Get(key)
+}
+
+// Get returns the value of the given key as of the view, or ErrKeyNotFound.
+func (v *PastView) Get(key []byte) ([]byte, error) {
+	version, err := v.tree.getVersion(key, v.point)
+	return version.value, err
+}
+
+// Scan returns an iterator over the values of the keys within [start, end) as of the view, in ascending key order;
+// nil bounds are open. The returned iterator is single-use, see LSMTree.Scan.
+func (v *PastView) Scan(start, end []byte) iter.Seq2[utils.BytePair, error] {
+	return v.tree.scanAt(start, end, v.point, false /*reverse*/)
+}
+
+// ReverseScan returns an iterator over the values of the keys within [start, end) as of the view, in descending key
+// order, see Scan.
+func (v *PastView) ReverseScan(start, end []byte) iter.Seq2[utils.BytePair, error] {
+	return v.tree.scanAt(start, end, v.point, true /*reverse*/)
+}
+
+// ScanPrefix returns an iterator over the values of the keys with the given prefix as of the view, see Scan.
+func (v *PastView) ScanPrefix(prefix []byte) iter.Seq2[utils.BytePair, error] {
+	return v.Scan(prefix, prefixEnd(prefix))
+}
+
+// Revision is a retained version of a key.
+type Revision struct {
+	Value      []byte
+	CommitTime time.Time // Zero for the versions written before commit times.
+}
+
+// History returns up to `count` retained versions of the given key, newest first; every retained version if `count`
+// isn't positive. Besides the versions within the history retention window, it includes the ones kept for snapshots
+// and the newest one before the window, until flushes and compactions drop them; ErrKeyNotFound if there's none.
+func (l *LSMTree) History(key []byte, count int) ([]Revision, error) {
+	if len(key) == 0 {
+		return nil, errors.New("expected a non-empty key")
+	}
+	// Similar to lookups, the memtables and the parts are taken at once, so flushes meanwhile don't change them.
+	l.memMux.RLock()
+	versions := l.memTable.versions(key)
+	for _, immutable := range l.immutables {
+		versions = append(versions, immutable.memTable.versions(key)...)
+	}
+	parts := l.acquireVersion()
+	l.memMux.RUnlock()
+	if parts == nil {
+		return nil, errors.New("lsm tree is closed")
+	}
+	defer parts.unref()
+	// Sequence numbers order the versions across the memtables and parts, which are newest first.
+	for _, sst := range parts.parts {
+		if count > 0 && len(versions) >= count {
+			break
+		}
+		partVersions, err := sst.versions(key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read history from sstable %d: %w", sst.header.GetId(), err)
+		}
+		versions = append(versions, partVersions...)
+	}
+	if len(versions) == 0 {
+		return nil, ErrKeyNotFound
+	}
+	if count > 0 && len(versions) > count {
+		versions = versions[:count]
+	}
+	revisions := make([]Revision, len(versions))
+	for i, version := range versions {
+		revisions[i].Value = version.value
+		if version.timestamp != 0 {
+			revisions[i].CommitTime = time.Unix(0, version.timestamp)
+		}
+	}
+	return revisions, nil
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableHistoryRetention(t *testing.T) {
+	config.SetTestFlag(t, "history_retention", "1h")
+	config.SetTestFlag(t, "history_retention_tables", "1:10m, 3:0s")
+	for table, want := range map[int64]time.Duration{1: 10 * time.Minute, 2: time.Hour, 3: 0} {
+		retention, err := tableHistoryRetention(table)
+		assert.NoError(t, err)
+		assert.Equal(t, want, retention, "Unexpected history retention of table %d", table)
+	}
+	for _, invalid := range []string{"1", "x:1h", "1:forever", "1:-1h"} {
+		config.SetTestFlag(t, "history_retention_tables", invalid)
+		_, err := tableHistoryRetention(1)
+		assert.Error(t, err, "Expected %q to be invalid", invalid)
+	}
+}
+
+func TestLSMTree_History(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false") // Compactions are run manually.
+	config.SetTestFlag(t, "memtable_flush_size", "4")
+	config.SetTestFlag(t, "compaction_level0_parts", "2")
+	config.SetTestFlag(t, "history_retention", "1h")
+	dataDir := t.TempDir()
+	lsm, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	key := []byte("k")
+
+	beforeWrites := time.Now()
+	var times []time.Time // times[i] is right after k was set to v<i>.
+	for i := range 3 {
+		time.Sleep(time.Millisecond)
+		require.NoError(t, lsm.Set(key, []byte(fmt.Sprintf("v%d", i))))
+		require.NoError(t, lsm.Set([]byte(fmt.Sprintf("o%d", i)), []byte("v")))
+		times = append(times, time.Now())
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 1, "Expected v0 and v1 to be flushed, and v2 to stay in the memtable")
+
+	assertHistory := func(tree *LSMTree) {
+		t.Helper()
+		for i, at := range times {
+			value, err := tree.GetAsOf(key, at)
+			assert.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("v%d", i), string(value), "Unexpected value as of write %d", i)
+		}
+		_, err := tree.GetAsOf(key, beforeWrites)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+		_, err = tree.AsOf(time.Now().Add(-2 * time.Hour))
+		assert.ErrorIs(t, err, ErrBeyondHistory)
+
+		revisions, err := tree.History(key, 0 /*count*/)
+		require.NoError(t, err)
+		require.Len(t, revisions, 3)
+		for i, revision := range revisions {
+			written := len(times) - 1 - i
+			assert.Equal(t, fmt.Sprintf("v%d", written), string(revision.Value))
+			assert.False(t, revision.CommitTime.After(times[written]), "Expected v%d to be committed in time", written)
+			if written > 0 {
+				assert.True(t, revision.CommitTime.After(times[written-1]))
+			}
+		}
+		revisions, err = tree.History(key, 2 /*count*/)
+		require.NoError(t, err)
+		assert.Len(t, revisions, 2)
+		_, err = tree.History([]byte("missing"), 0 /*count*/)
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+
+		view, err := tree.AsOf(times[1])
+		require.NoError(t, err)
+		scanned := make(map[string]string)
+		for pair, err := range view.Scan(nil /*start*/, nil /*end*/) {
+			require.NoError(t, err)
+			scanned[string(pair.Key)] = string(pair.Value)
+		}
+		assert.Equal(t, map[string]string{"k": "v1", "o0": "v", "o1": "v"}, scanned)
+	}
+	assertHistory(lsm)
+
+	// Commit times survive the write-ahead log, flushes and compactions.
+	require.NoError(t, lsm.CloseWithoutFlush())
+	recovered, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, recovered.Close()) })
+	assertHistory(recovered)
+	require.NoError(t, recovered.Set([]byte("o3"), []byte("v")))
+	require.NoError(t, recovered.Set([]byte("o4"), []byte("v")))
+	require.NoError(t, recovered.waitForFlushes())
+	compacted, err := recovered.maybeCompact()
+	require.NoError(t, err)
+	require.True(t, compacted)
+	require.Len(t, recovered.currentParts(), 1)
+	assertHistory(recovered)
+}
+
+func TestLSMTree_NoHistory(t *testing.T) {
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	key := []byte("k")
+
+	require.NoError(t, lsm.Set(key, []byte("v0")))
+	written := time.Now()
+	require.NoError(t, lsm.Set(key, []byte("v1")))
+	assert.Equal(t, 1, lsm.memTable.entries, "Expected superseded versions to be replaced in place")
+	_, err = lsm.GetAsOf(key, written)
+	assert.ErrorIs(t, err, ErrBeyondHistory)
+	revisions, err := lsm.History(key, 0 /*count*/)
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "v1", string(revisions[0].Value))
+}
@@ -3,11 +3,17 @@ package storage
 import (
 	"errors"
 	"iter"
+	"slices"
+	"time"
 
 	"github.com/nobletooth/kiwi/pkg/utils"
 )
 
-var ErrKeyNotFound = errors.New("key was not found")
+var (
+	ErrKeyNotFound = errors.New("key was not found")
+	// ErrCorruption is returned when the data read from disk is malformed, e.g. on checksum mismatches.
+	ErrCorruption = errors.New("data corruption")
+)
 
 // KeyValueHolder is a simple append-only storage interface.
 type KeyValueHolder interface {
@@ -17,6 +23,11 @@ type KeyValueHolder interface {
 	Set(key, value []byte) error
 	// Swap returns the previous value of the key or ErrKeyNotFound if it didn't exist.
 	Swap(key, value []byte) ( /*previousValue*/ []byte, error)
+	// Exists returns true if the given `key` has a live value, i.e. neither a tombstone nor expired.
+	Exists(key []byte) (bool, error)
+	// Delete replaces the value of the given `key` with a tombstone, and returns true if it had a live value.
+	// Nothing is written when the key is provably absent or already deleted.
+	Delete(key []byte) (bool /*existed*/, error)
 	// Close closes every held resource.
 	Close() error
 }
@@ -27,7 +38,33 @@ type RangeScanner interface {
 	// Scan returns an iterator over key-value pairs within the given range [start, end).
 	// If start is nil, scanning begins from the first key.
 	// If end is nil, scanning continues to the last key.
-	Scan(start, end []byte) iter.Seq[utils.BytePair]
+	// A read error stops the iteration; it's yielded with an empty pair as the last element.
+	Scan(start, end []byte) iter.Seq2[utils.BytePair, error]
+	// ReverseScan returns an iterator over key-value pairs within the given range [start, end) in descending key
+	// order; nil bounds are open, and read errors are yielded the same as Scan.
+	ReverseScan(start, end []byte) iter.Seq2[utils.BytePair, error]
 	// ScanPrefix returns an iterator over all key-value pairs with the given prefix.
-	ScanPrefix(prefix []byte) iter.Seq[utils.BytePair]
+	ScanPrefix(prefix []byte) iter.Seq2[utils.BytePair, error]
+}
+
+// prefixEnd returns the smallest key which is bigger than every key with the given prefix, to be used as the end of
+// a range scan; nil means there's no such key, e.g. when the prefix is empty or only consists of 0xff bytes.
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] < 0xff {
+			end := slices.Clone(prefix[:i+1])
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// ValueInspector lets the storage layer understand the values written by upper layers (e.g. port.KiwiStorage),
+// without depending on their encoding; for example, compactions use it to reclaim deleted and expired values.
+type ValueInspector interface {
+	// IsLive returns false when the given value is a tombstone or has expired at the given time.
+	IsLive(value []byte, now time.Time) bool
+	// Tombstone returns the value that marks a deleted key.
+	Tombstone() []byte
 }
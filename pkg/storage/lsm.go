@@ -2,43 +2,118 @@
 // Log structured merge tree (LSM tree) is a data structure that is optimized for write-heavy workloads.
 // It consists of multiple levels of sorted tables, where each level is larger than the previous one.
 // New data is first written to an in-memory table (memtable) and then flushed to disk as a sorted string
-// table (SSTable). When the memtable is full, it is flushed to disk and a new memtable is created.
+// table (SSTable). When the memtable is full, a new memtable is created and the full one is flushed to disk in the
+// background, see flush.go.
 // Periodically, the SSTables are merged together to create larger SSTables, which helps to reduce the number of
 // SSTables that need to be searched when reading data.
+// Writes are appended to a write-ahead log before they reach the memtable, so that a crash before the next flush
+// doesn't lose them; the log is replayed into a fresh memtable when the tree is opened again.
+// The set of live parts and the live log are recorded in the table's manifest, see manifest.go.
+// Writes must be serialized by the caller, while lookups and scans may run concurrently with writes and each other:
+// the memtable is guarded by a short-lived lock, and parts are read through immutable versions, see version.go.
+// Each write gets a sequence number, which allows reading consistent point-in-time snapshots, see snapshot.go; and a
+// commit time, which allows reading past versions within the table's history retention window, see history.go.
 
 package storage
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/nobletooth/kiwi/pkg/scan"
 	"github.com/nobletooth/kiwi/pkg/utils"
+	kiwipb "github.com/nobletooth/kiwi/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+var (
+	lsmGetDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lsm_get_duration_seconds",
+		Help:    "Time spent on each LSM tree lookup.",
+		Buckets: prometheus.ExponentialBuckets(0.000_01 /*start*/, 4 /*factor*/, 10 /*count*/),
+	}, []string{"source" /* memtable | disk | not_found | error */})
+	lsmGetProbedParts = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "lsm_get_probed_parts",
+		Help:    "Number of parts probed by each lookup which missed the memtable.",
+		Buckets: []float64{0, 1, 2, 4, 8, 16, 32, 64},
+	})
+)
+
+// memTableScanChunk is the number of memtable versions that scans copy out under the memtable lock at a time.
+const memTableScanChunk = 256
+
 // LSMTree represents a log-structured merge tree (LSM tree) for a specific Kiwi table (Redis db).
-type LSMTree struct { // Implements KeyValueHolder.
-	table           int64     // The Kiwi table ID (Redis db number).
-	dir             string    // Path where tables files are stored; ends with table.
-	memTable        *MemTable // Lookups are started from the memtable, and then disk tables.
-	latestDiskTable *SSTable  // Disk lookups are started from the latest disk table.
-	diskTables      map[ /*partId*/ int64]*SSTable
+type LSMTree struct { // Implements RangeScanner.
+	table     int64          // The Kiwi table ID (Redis db number).
+	dir       string         // Path where tables files are stored; ends with table.
+	manifest  *manifest      // The source of truth for the live parts and write-ahead log.
+	inspector ValueInspector // Optional; tells dead values apart, e.g. during compactions.
+	codec     Codec          // The compression codec of newly written data blocks.
+	retention time.Duration  // How long superseded versions are kept for reads of the past; zero keeps none.
+
+	memMux              sync.RWMutex         // Protects the memtables against concurrent lookups; held for memory access only.
+	memTable            *MemTable            // Lookups are started from the memtable, then immutables and disk tables.
+	immutables          []*immutableMemTable // Full memtables waiting to be flushed, newest first.
+	wal                 *WAL                 // The write-ahead log of the memtable; replaced when it's frozen.
+	walId               int64                // ID of the write-ahead log, which is also the ID of its memtable's part.
+	lastSequence        int64                // Sequence number of the latest write; updated under memMux.
+	lastTimestamp       int64                // Commit time of the latest write in unix nanoseconds; never goes backward.
+	memTableMinSequence int64                // Sequence number of the first write in the memtable; zero when it's empty.
+	memTableFull        bool                 // Set once the memtable is full, until it's frozen; see freezeIfRoom.
+	liveSnapshots       []int64              // Sequence numbers of the live snapshots, ascending. Protected by memMux.
+
+	flushCond *sync.Cond    // Signaled on memMux when an immutable memtable is flushed, or a flush fails.
+	flushErr  error         // The last background flush error; cleared by the next flush. Protected by memMux.
+	flushStop chan struct{} // Closed to stop the background flusher.
+	flushDone chan struct{} // Closed when the flush loop returns.
+	flushPoke chan struct{} // Wakes up the flush loop, e.g. after a memtable is frozen.
+
+	versionMux sync.Mutex                   // Serializes flushes, compactions and truncations changing the parts.
+	version    atomic.Pointer[partsVersion] // The live parts; flushes replace it along with the memtable under memMux.
+
+	closed         bool
+	compactionStop chan struct{} // Closed to stop background compactions; nil if compactions are disabled.
+	compactionDone chan struct{} // Closed when the compaction loop returns.
+	compactionPoke chan struct{} // Wakes up the compaction loop, e.g. after flushes.
 }
 
-var _ KeyValueHolder = (*LSMTree)(nil)
+var _ RangeScanner = (*LSMTree)(nil)
+
+// partPath returns the path of the .sst file of the given part.
+func partPath(dir string, partId int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.sst", partId))
+}
 
 // NewLSMTree is the constructor for LSMTree.
 // The given `dataDir` path would be used to store the entire table parts, i.e. the .sst files.
 // Each LSM Tree would have its own subdirectory under `dataDir`, named as the table ID.
 // For example, if `dataDir` is "/data/kiwi" and the table ID is 0, then the LSM tree would use `/data/kiwi/0`.
-func NewLSMTree(dataDir string, table int64) (*LSMTree, error) {
+// The optional `inspector` allows compactions to reclaim tombstoned and expired values.
+func NewLSMTree(dataDir string, table int64, inspector ValueInspector) (*LSMTree, error) {
 	if table <= 0 {
 		return nil, fmt.Errorf("expected positivive table id got %d", table)
 	}
+	codec, err := tableCodec(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the block codec of table %d: %w", table, err)
+	}
+	retention, err := tableHistoryRetention(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the history retention of table %d: %w", table, err)
+	}
 
 	// Make sure directory exists.
 	dir := filepath.Join(dataDir, fmt.Sprint(table))
@@ -54,133 +129,285 @@ func NewLSMTree(dataDir string, table int64) (*LSMTree, error) {
 		return nil, fmt.Errorf("lsm tree path %s is not a directory", dir)
 	}
 
-	// Scan for existing .sst files inside the directory.
-	diskTables := make(map[ /*partId*/ int64]*SSTable)
-	prevPartIds := make(map[int64]struct{}) // To find the latest part.
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	m, err := openManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	lsm := &LSMTree{
+		table:        table,
+		dir:          dir,
+		manifest:     m,
+		inspector:    inspector,
+		codec:        codec,
+		retention:    retention,
+		walId:        m.logNumber,
+		lastSequence: m.lastSequence,
+		closed:       false,
+	}
+	lsm.flushCond = sync.NewCond(&lsm.memMux)
+	if err := lsm.removeOrphanFiles(); err != nil {
+		return nil, errors.Join(err, m.close())
+	}
+
+	// Open the live parts, as listed by the manifest.
+	var parts []*SSTable
+	for _, part := range m.liveParts() {
+		sst, err := NewSSTable(partPath(dir, part.GetId()))
+		if errors.Is(err, os.ErrNotExist) {
+			// This should never happen, unless the .sst files are manually tampered with.
+			utils.RaiseInvariant("lsm", "missing_part", "Missing part in LSM tree.", "dir", dir, "part", part.GetId())
+		}
 		if err != nil {
-			return err
+			for _, opened := range parts {
+				err = errors.Join(err, opened.Close())
+			}
+			return nil, errors.Join(fmt.Errorf("failed to open part %d in %s: %w", part.GetId(), dir, err), m.close())
 		}
-		if info.IsDir() { // Skip dirs.
-			return nil
+		parts = append(parts, sst)
+	}
+
+	// Replay the write-ahead logs of the memtables that weren't flushed before the last shutdown, if any.
+	if err := lsm.recoverMemTables(); err != nil {
+		err = fmt.Errorf("failed to recover memtable of lsm tree directory %s: %w", dir, err)
+		for _, sst := range parts {
+			err = errors.Join(err, sst.Close())
 		}
-		if filepath.Ext(path) != ".sst" { // Skip non-sst files.
-			return nil
+		return nil, errors.Join(err, m.close())
+	}
+	lsm.installVersion(parts)
+
+	if *compactionEnabled {
+		lsm.startCompactions()
+	}
+	lsm.startFlushes() // Flushes poke compactions, so they're started afterward.
+	// Close SSTable file descriptors when the LSM tree is garbage collected.
+	runtime.SetFinalizer(lsm, func(lsm *LSMTree) { _ = lsm.Close() })
+
+	return lsm, nil
+}
+
+// removeOrphanFiles removes the parts and write-ahead logs that aren't referenced by the manifest; they're leftovers
+// of flushes and compactions which were interrupted before committing, or failed to clean up after committing.
+func (l *LSMTree) removeOrphanFiles() error {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list lsm tree directory %s: %w", l.dir, err)
+	}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".sst" && ext != ".wal") {
+			continue
 		}
-		sst, err := NewSSTable(path)
+		id, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ext), 10 /*base*/, 64 /*bitSize*/)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to parse file name %q: %w", entry.Name(), err)
 		}
-		diskTables[sst.header.GetId()] = sst
-		prevPartIds[sst.header.GetPrevPart()] = struct{}{}
-		return nil
-	})
+		if _, isLivePart := l.manifest.parts[id]; (ext == ".sst" && isLivePart) || (ext == ".wal" && id >= l.walId) {
+			continue
+		}
+		slog.Info("Removing a file which isn't referenced by the manifest.", "dir", l.dir, "file", entry.Name())
+		if err := os.Remove(filepath.Join(l.dir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove orphan file %q: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// recoverMemTables rebuilds the memtables from the live write-ahead logs, i.e. the manifest's log and the newer ones.
+// The newest log belongs to the memtable, and the older ones to immutable memtables which were waiting to be flushed.
+func (l *LSMTree) recoverMemTables() error {
+	walIds := []int64{l.walId}
+	entries, err := os.ReadDir(l.dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan lsm tree directory %s: %v", dir, err)
+		return fmt.Errorf("failed to list lsm tree directory %s: %w", l.dir, err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".wal" {
+			continue
+		}
+		// Orphan files are removed beforehand, so the remaining logs are live.
+		if id, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ".wal"), 10, 64); err == nil && id > l.walId {
+			walIds = append(walIds, id)
+		}
 	}
+	slices.Sort(walIds)
+	l.manifest.reserveIds(walIds[len(walIds)-1]) // Logs are created before their IDs are committed.
 
-	// All SSTables would be the previous part of some other part, except the latest one.
-	var latestDiskTable *SSTable
-	for part := range diskTables {
-		if _, hasPrevPart := prevPartIds[part]; !hasPrevPart {
-			if latestDiskTable != nil {
-				tail := latestDiskTable.header.GetId()
-				utils.RaiseInvariant("lsm", "multi_tail_lsm", "Multiple latest parts found in lsm tree directory.",
-					"dir", dir, "partOne", tail, "partTwo", part)
-				return nil, fmt.Errorf("multiple tails found in lsm tree directory %s: (%d,%d)", dir, tail, part)
+	for i, walId := range walIds {
+		wal, err := OpenWAL(walPath(l.dir, walId))
+		if err != nil {
+			return errors.Join(err, l.closeWals())
+		}
+		memTable, minSequence := NewMemTable(), int64(0)
+		records, err := wal.Replay(func(sequence, timestamp int64, key, value []byte) {
+			if sequence == 0 { // Logs written before sequence numbers are replayed in order.
+				sequence = l.lastSequence + 1
+			}
+			l.lastSequence = max(l.lastSequence, sequence)
+			l.lastTimestamp = max(l.lastTimestamp, timestamp)
+			if minSequence == 0 {
+				minSequence = sequence
 			}
-			latestDiskTable = diskTables[part]
+			version := keyVersion{sequence: sequence, timestamp: timestamp, value: value}
+			_ = memTable.Set(key, version, l.retainedSequence())
+		})
+		if err != nil {
+			return errors.Join(err, wal.Close(), l.closeWals())
+		}
+		if records > 0 {
+			slog.Info("Replayed write-ahead log into memtable.", "dir", l.dir, "wal", walId, "records", records)
+		}
+		switch {
+		case i == len(walIds)-1:
+			l.memTable, l.wal, l.walId, l.memTableMinSequence = memTable, wal, walId, minSequence
+		case records == 0: // The records of a frozen memtable may be lost if its log wasn't synced.
+			if err := wal.Remove(); err != nil {
+				return errors.Join(err, l.closeWals())
+			}
+		default:
+			l.immutables = slices.Insert(l.immutables, 0, &immutableMemTable{memTable: memTable, wal: wal,
+				walId: walId, minSequence: minSequence, maxSequence: l.lastSequence})
+			immutableMemTables.Inc()
 		}
 	}
-	if latestDiskTable == nil && len(diskTables) > 0 {
-		// This should never happen, unless the .sst files are corrupted or manually tampered with.
-		utils.RaiseInvariant("lsm", "no_tail_lsm", "No latest part found in lsm tree directory.", "dir", dir)
-		return nil, fmt.Errorf("no tail found in lsm tree directory %s", dir)
-	}
+	return nil
+}
 
-	lsm := &LSMTree{
-		table:           table,
-		memTable:        NewMemTable(),
-		latestDiskTable: latestDiskTable,
-		diskTables:      diskTables,
-		dir:             dir,
+// closeWals closes the write-ahead logs of the memtable and the immutable memtables, leaving them to be replayed.
+func (l *LSMTree) closeWals() error {
+	var errs error
+	if l.wal != nil {
+		errs = l.wal.Close()
 	}
-	// Close SSTable file descriptors when the LSM tree is garbage collected.
-	runtime.SetFinalizer(lsm, func(lsm *LSMTree) { _ = lsm.Close() })
+	for _, immutable := range l.immutables {
+		errs = errors.Join(errs, immutable.wal.Close())
+	}
+	immutableMemTables.Sub(float64(len(l.immutables)))
+	return errs
+}
 
-	return lsm, nil
+// getFromMemTables looks up the newest version of the given key which the given read sees, in the given memtable and
+// then the immutable memtables from the newest.
+func getFromMemTables(memTable *MemTable, immutables []*immutableMemTable, key []byte, point readPoint) (
+	keyVersion, bool /*found*/) {
+	if version, exists := memTable.getVersion(key, point); exists {
+		return version, true
+	}
+	for _, immutable := range immutables {
+		if version, exists := immutable.memTable.getVersion(key, point); exists {
+			return version, true
+		}
+	}
+	return keyVersion{}, false
 }
 
-// lookupDiskTables finds the value of the given key. NOTE: Caller should acquire lock.
-func (l *LSMTree) lookupDiskTables(key []byte) ([]byte, error) {
-	// Before any memtable is flushed, there are no disk tables, hence we'd short circuit here.
-	if l.latestDiskTable == nil {
-		return nil, ErrKeyNotFound
+// lookupMemTable looks up the given key in the memtables; on a miss, it also returns the current version of the parts
+// with a reference that the caller should release. Both are taken under the memtable lock, as flushes replace an
+// immutable memtable with its part at once; so a key which is flushed concurrently is found in either of them.
+func (l *LSMTree) lookupMemTable(key []byte, point readPoint) (keyVersion, bool /*found*/, *partsVersion, error) {
+	l.memMux.RLock()
+	defer l.memMux.RUnlock()
+	if version, exists := getFromMemTables(l.memTable, l.immutables, key, point); exists {
+		return version, true, nil, nil
 	}
+	version := l.acquireVersion()
+	if version == nil {
+		return keyVersion{}, false, nil, errors.New("lsm tree is closed")
+	}
+	return keyVersion{}, false, version, nil
+}
 
+// lookupDiskTables finds the newest version of the given key which the given read sees, in the parts of the given
+// version.
+func lookupDiskTables(version *partsVersion, key []byte, point readPoint) (keyVersion, error) {
 	// Since the latest parts contain the most recent values, we'll start our lookup from there.
-	for partId := l.latestDiskTable.header.GetId(); partId > 0; {
-		sst, exists := l.diskTables[partId]
-		if !exists || sst == nil {
-			utils.RaiseInvariant("lsm", "missing_part", "Missing part in LSM tree.", "table", l.table, "part", partId)
-			return nil, fmt.Errorf("missing part %d in lsm tree for table %d", partId, l.table)
-		}
-		val, err := sst.Get(key)
+	for i, sst := range version.parts {
+		found, err := sst.get(key, point)
 		if errors.Is(err, ErrKeyNotFound) {
-			partId = sst.header.GetPrevPart()
 			continue
 		}
+		lsmGetProbedParts.Observe(float64(i + 1))
 		if err != nil {
-			return nil, fmt.Errorf("failed to lookupDiskTables key from sstable %d: %v", partId, err)
+			return keyVersion{}, fmt.Errorf("failed to lookupDiskTables key from sstable %d: %w", sst.header.GetId(),
+				err)
 		}
-		return val, nil
+		return found, nil
 	}
 
-	return nil, ErrKeyNotFound
+	lsmGetProbedParts.Observe(float64(len(version.parts)))
+	return keyVersion{}, ErrKeyNotFound
 }
 
 func (l *LSMTree) Get(key []byte) ([]byte, error) {
+	version, err := l.getVersion(key, latestRead)
+	return version.value, err
+}
+
+// LastWrite returns the sequence number of the latest write to the given key, including deletes; or ErrKeyNotFound
+// if the key has no version. Sequence numbers only grow, so comparing them tells whether a key was written since.
+func (l *LSMTree) LastWrite(key []byte) (int64, error) {
+	version, err := l.getVersion(key, latestRead)
+	return version.sequence, err
+}
+
+// LastSequence returns the sequence number of the latest write; it's never reused, even after a truncation.
+func (l *LSMTree) LastSequence() int64 {
+	l.memMux.RLock()
+	defer l.memMux.RUnlock()
+	return l.lastSequence
+}
+
+// getVersion returns the newest version of the given key which the given read sees, or ErrKeyNotFound.
+func (l *LSMTree) getVersion(key []byte, point readPoint) (keyVersion, error) {
 	if len(key) == 0 {
-		return nil, fmt.Errorf("expected a non-empty key")
+		return keyVersion{}, fmt.Errorf("expected a non-empty key")
 	}
+	startTime := time.Now()
 	// First check the memtable.
-	if val, exists := l.memTable.Get(key); exists {
-		return val, nil
+	found, exists, version, err := l.lookupMemTable(key, point)
+	if exists {
+		lsmGetDuration.WithLabelValues("memtable").Observe(time.Since(startTime).Seconds())
+		return found, nil
+	} else if err != nil {
+		lsmGetDuration.WithLabelValues("error").Observe(time.Since(startTime).Seconds())
+		return keyVersion{}, err
 	}
 	// If not found in memory, we'll look it up from disk.
-	return l.lookupDiskTables(key)
+	found, err = lookupDiskTables(version, key, point)
+	version.unref()
+	source := "disk"
+	if errors.Is(err, ErrKeyNotFound) {
+		source = "not_found"
+	} else if err != nil {
+		source = "error"
+	}
+	lsmGetDuration.WithLabelValues(source).Observe(time.Since(startTime).Seconds())
+	return found, err
 }
 
-// flushMemTable flushes the currently held memTable to disk. NOTE: Caller should acquire lock.
-func (l *LSMTree) flushMemTable() error {
-	// Memtable is full, flush it to disk.
-	prevPartId := int64(0)
-	if l.latestDiskTable != nil {
-		prevPartId = l.latestDiskTable.header.GetId()
+// logWrite appends the given write to the write-ahead log with the next sequence number and its commit time, and
+// returns its version; the sequence number becomes the last one once the write is applied to the memtable.
+func (l *LSMTree) logWrite(key, value []byte) (keyVersion, error) {
+	// Commit times never go backward, even if the clock does, so they order the writes the same as sequence numbers.
+	l.lastTimestamp = max(l.lastTimestamp, time.Now().UnixNano())
+	version := keyVersion{sequence: l.lastSequence + 1, timestamp: l.lastTimestamp, value: value}
+	if err := l.wal.Append(version.sequence, version.timestamp, key, value); err != nil {
+		return keyVersion{}, fmt.Errorf("failed to log key %v: %w", fmt.Sprint(key), err)
 	}
-	nextPartId := prevPartId + 1
-	tablePath := filepath.Join(l.dir, fmt.Sprintf("%d.sst", nextPartId))
-	pairs := slices.Collect(l.memTable.Pairs())
-	if len(pairs) == 0 {
-		return nil
-	}
-	if err := writeSSTable(prevPartId, nextPartId, tablePath, pairs); err != nil {
-		return fmt.Errorf("failed to write sstable to disk: %v", err)
+	if l.memTableMinSequence == 0 {
+		l.memTableMinSequence = version.sequence
 	}
-	sst, err := NewSSTable(tablePath)
-	if err != nil {
-		return fmt.Errorf("failed to load newly created sstable %s: %v", tablePath, err)
-	}
-	if sst.header.GetId() != nextPartId || sst.header.GetPrevPart() != prevPartId {
-		utils.RaiseInvariant("lsm", "invalid_part_ids", "Created sstable has invalid part ids.", "table", tablePath)
-		return fmt.Errorf("newly created sstable %s has invalid part ids: got (%d<-%d), want (%d<-%d)",
-			tablePath, sst.header.GetPrevPart(), sst.header.GetId(), prevPartId, nextPartId)
+	return version, nil
+}
+
+// retainedSequence returns the sequence number up to which memtables keep the superseded versions of their keys:
+// every version while the tree retains history, or else the ones that the newest live snapshot can see.
+// NOTE: Caller should acquire memMux, either read or write.
+func (l *LSMTree) retainedSequence() int64 {
+	if l.retention > 0 {
+		return latestSequence
 	}
-	l.diskTables[nextPartId] = sst
-	l.latestDiskTable = sst
-	l.memTable = NewMemTable() // Reset memtable.
-	slog.Info("Flushed MemTable to disk.", "path", tablePath)
-	return nil
+	return l.newestSnapshot()
 }
 
 // Set sets the given key-value pair in the LSM tree.
@@ -188,66 +415,348 @@ func (l *LSMTree) Set(key, value []byte) error {
 	if len(key) == 0 {
 		return fmt.Errorf("expected a non-empty key")
 	}
-	if shouldFlush := l.memTable.Set(key, value); shouldFlush {
-		return l.flushMemTable()
+	if err := l.makeRoomForWrite(); err != nil {
+		return err
+	}
+	version, err := l.logWrite(key, value)
+	if err != nil {
+		return err
+	}
+	l.memMux.Lock()
+	l.memTableFull = l.memTable.Set(key, version, l.retainedSequence())
+	l.lastSequence = version.sequence
+	l.memMux.Unlock()
+	if l.memTableFull {
+		l.freezeIfRoom()
 	}
 	return nil
 }
 
 // Swap stores the given key, value in the storage and returns the previous value corresponding to the key.
 func (l *LSMTree) Swap(key, value []byte) ( /*previousValue*/ []byte, error) {
-	var (
-		returnValue []byte
-		found       = false
-	)
-	shouldFlush, foundOnMem, prevValue := l.memTable.Swap(key, value)
-	// If the mem table contains the previous value, we won't need to go further and lookup on disk.
-	if foundOnMem {
-		returnValue = prevValue
-		found = true
-	} else {
-		// Look up disk for the previous value.
-		prevValueOnDisk, err := l.lookupDiskTables(key)
-		if err == nil {
-			returnValue = prevValueOnDisk
-			found = true
-		} else if !errors.Is(err, ErrKeyNotFound) { // Some unexpected error happened.
-			return nil, fmt.Errorf("failed to swap key %v: %w", fmt.Sprint(key), err)
+	if err := l.makeRoomForWrite(); err != nil {
+		return nil, err
+	}
+	// The previous value is looked up before the write is logged, so that a failed lookup never fails an applied
+	// write; writes are serialized, so it doesn't change in between.
+	previous, err := l.getVersion(key, latestRead)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("failed to swap key %v: %w", fmt.Sprint(key), err)
+	}
+	found := err == nil
+	version, err := l.logWrite(key, value)
+	if err != nil {
+		return nil, err
+	}
+	l.memMux.Lock()
+	l.memTableFull = l.memTable.Set(key, version, l.retainedSequence())
+	l.lastSequence = version.sequence
+	l.memMux.Unlock()
+	if l.memTableFull {
+		l.freezeIfRoom()
+	}
+
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	return previous.value, nil
+}
+
+// Exists returns true if the given `key` has a live value; without an inspector, every value is considered live.
+func (l *LSMTree) Exists(key []byte) (bool, error) {
+	val, err := l.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return l.inspector == nil || l.inspector.IsLive(val, time.Now()), nil
+}
+
+// Delete writes the inspector's tombstone for the given `key`, and returns true if it had a live value. Keys that
+// are missing from the memtable and every part, which mostly takes range checks and bloom filters, are left as is;
+// so are the already deleted ones. Expired keys are deleted, but reported as non-existent.
+func (l *LSMTree) Delete(key []byte) (bool /*existed*/, error) {
+	if l.inspector == nil {
+		return false, errors.New("deleting keys requires a value inspector")
+	}
+	val, err := l.Get(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	tombstone := l.inspector.Tombstone()
+	if bytes.Equal(val, tombstone) {
+		return false, nil
+	}
+	existed := l.inspector.IsLive(val, time.Now())
+	if err := l.Set(key, tombstone); err != nil {
+		return false, fmt.Errorf("failed to delete key %v: %w", fmt.Sprint(key), err)
+	}
+	return existed, nil
+}
+
+// Scan returns an iterator over the latest values of the keys within [start, end) in ascending key order; nil bounds
+// are open. The memtable is read a chunk at a time, see scanMemTable, while the immutable memtables and the current
+// version of the parts are held until the iteration is done (or the iterator is garbage collected); so the returned
+// iterator is single-use. A read error stops the iteration; it's yielded with an empty pair as the last element, so
+// callers can tell a failed scan from a finished one.
+func (l *LSMTree) Scan(start, end []byte) iter.Seq2[utils.BytePair, error] {
+	return l.scanAt(start, end, latestRead, false /*reverse*/)
+}
+
+// ReverseScan returns an iterator over the latest values of the keys within [start, end) in descending key order,
+// see Scan; so the keys at the end of a range are read without scanning the rest of it.
+func (l *LSMTree) ReverseScan(start, end []byte) iter.Seq2[utils.BytePair, error] {
+	return l.scanAt(start, end, latestRead, true /*reverse*/)
+}
+
+// scanAt returns an iterator over the values of the keys within [start, end) which the given read sees, in
+// descending key order if `reverse` is set; see Scan.
+func (l *LSMTree) scanAt(start, end []byte, point readPoint, reverse bool) iter.Seq2[utils.BytePair, error] {
+	l.memMux.RLock()
+	memTable := l.memTable
+	immutables := slices.Clone(l.immutables)
+	version := l.acquireVersion()
+	l.memMux.RUnlock()
+	if version == nil {
+		return func(yield func(utils.BytePair, error) bool) {
+			yield(utils.BytePair{}, fmt.Errorf("failed to scan closed lsm tree %s", l.dir))
 		}
 	}
+	return l.scanView(l.scanMemTable(memTable, start, end, reverse), immutables, version, start, end, point, reverse)
+}
 
-	if shouldFlush { // Flush memtable when we're done.
-		if err := l.flushMemTable(); err != nil {
-			return nil, err
+// scanMemTable returns an iterator over the versions of the keys within [start, end) of the given memtable, in
+// internal key order, or in reverse if `reverse` is set. The memtable is only safe to read under memMux, so its
+// versions are copied out a chunk at a time, each of which ends with every version of a key; the next chunk starts
+// right after (or before) that key, so writes in between may only show up in the keys that aren't scanned yet, and
+// scans hold a bounded amount of memory.
+func (l *LSMTree) scanMemTable(memTable *MemTable, start, end []byte, reverse bool) iter.Seq[internalPair] {
+	return func(yield func(internalPair) bool) {
+		chunk, done := make([]internalPair, 0, memTableScanChunk), false
+		for {
+			chunk, done = chunk[:0], true
+			l.memMux.RLock()
+			pairs := memTable.Scan(start, end)
+			if reverse {
+				pairs = memTable.ReverseScan(start, end)
+			}
+			for pair := range pairs {
+				if len(chunk) >= memTableScanChunk && !bytes.Equal(pair.Key.key, chunk[len(chunk)-1].Key.key) {
+					done = false
+					break
+				}
+				chunk = append(chunk, pair)
+			}
+			l.memMux.RUnlock()
+			for _, pair := range chunk {
+				if !yield(pair) {
+					return
+				}
+			}
+			if done {
+				return
+			}
+			if reverse {
+				end = bytes.Clone(chunk[len(chunk)-1].Key.key)
+			} else {
+				start = append(bytes.Clone(chunk[len(chunk)-1].Key.key), 0) // The smallest key after the last one.
+			}
 		}
 	}
+}
 
-	if !found {
-		return nil, ErrKeyNotFound
+// scanView returns an iterator over the newest values of the keys within [start, end) which the given read sees,
+// merged from the given memtable scan, immutable memtables and parts version; in descending key order if `reverse`
+// is set, in which case the memtable scan is reversed as well. It takes over the reference to the version, and
+// releases it once the iteration is done (or the iterator is garbage collected).
+func (l *LSMTree) scanView(memPairs iter.Seq[internalPair], immutables []*immutableMemTable, version *partsVersion,
+	start, end []byte, point readPoint, reverse bool) iter.Seq2[utils.BytePair, error] {
+	handle := &versionHandle{version: version}
+	runtime.SetFinalizer(handle, (*versionHandle).release)
+
+	return func(yield func(utils.BytePair, error) bool) {
+		defer handle.release()
+		// The memtables and newer parts come first; they only have a higher priority among the versions written
+		// before sequence numbers, as the sequence numbers order the rest.
+		parts := handle.version.parts
+		readErrs := make([]error, len(parts))
+		sequences := make([]iter.Seq[internalPair], 0, len(immutables)+len(parts)+1)
+		sequences = append(sequences, memPairs)
+		for _, immutable := range immutables {
+			if reverse {
+				sequences = append(sequences, immutable.memTable.ReverseScan(start, end))
+			} else {
+				sequences = append(sequences, immutable.memTable.Scan(start, end))
+			}
+		}
+		for i, sst := range parts {
+			if reverse {
+				sequences = append(sequences, sst.reverseScanPairs(start, end, true /*cached*/, &readErrs[i]))
+			} else {
+				sequences = append(sequences, sst.scanPairs(start, end, true /*cached*/, &readErrs[i]))
+			}
+		}
+		compare := compareInternalKeys
+		if reverse {
+			compare = compareReverseInternalKeys
+		}
+		merged, err := scan.MultiHead(compare, sequences)
+		if err != nil {
+			yield(utils.BytePair{}, fmt.Errorf("failed to merge lsm tree sequences: %w", err))
+			return
+		}
+		if reverse {
+			merged = newestFirst(merged)
+		}
+		for pair := range visibleVersions(merged, point) {
+			// A failed part would expose the older values which it shadows, so the whole scan is stopped.
+			if errors.Join(readErrs...) != nil {
+				break
+			}
+			if !yield(pair, nil) {
+				return
+			}
+		}
+		if err := errors.Join(readErrs...); err != nil {
+			yield(utils.BytePair{}, fmt.Errorf("failed to scan lsm tree %s: %w", l.dir, err))
+		}
+	}
+}
+
+// ScanPrefix returns an iterator over the latest values of the keys with the given prefix, see Scan.
+func (l *LSMTree) ScanPrefix(prefix []byte) iter.Seq2[utils.BytePair, error] {
+	return l.Scan(prefix, prefixEnd(prefix))
+}
+
+// Truncate removes every key of the LSM tree, e.g. for the Redis FLUSHDB command. The live parts are dropped along
+// with the memtable and its write-ahead log, and their blocks are evicted from the shared cache; the dropped files
+// are removed in the background if `async` is set. The history of the keys is dropped as well, so reads of the past
+// don't see them either. NOTE: Caller should serialize writes.
+func (l *LSMTree) Truncate(async bool) error {
+	if l.closed {
+		return errors.New("lsm tree is closed")
+	}
+	// A running compaction or flush would otherwise commit its output after the truncation.
+	l.stopFlushes()
+	defer l.startFlushes()
+	if l.compactionStop != nil {
+		l.stopCompactions()
+		defer l.startCompactions()
+	}
+
+	// The truncation is committed along with a fresh write-ahead log, similar to flushes.
+	nextWalId := l.manifest.allocateId()
+	nextWal, err := OpenWAL(walPath(l.dir, nextWalId))
+	if err != nil {
+		return fmt.Errorf("failed to open the next wal: %w", err)
+	}
+	l.versionMux.Lock()
+	dropped := l.currentParts()
+	edit := &kiwipb.ManifestEdit{LogNumber: nextWalId, LastSequence: l.lastSequence}
+	for _, sst := range dropped {
+		edit.RemovedParts = append(edit.RemovedParts, sst.header.GetId())
+	}
+	if err := l.manifest.commit(edit); err != nil {
+		l.versionMux.Unlock()
+		return errors.Join(fmt.Errorf("failed to commit truncation: %w", err), nextWal.Remove())
+	}
+	l.memMux.Lock()
+	l.installVersion(nil /*parts*/)
+	droppedWals := []*WAL{l.wal}
+	for _, immutable := range l.immutables {
+		droppedWals = append(droppedWals, immutable.wal)
+	}
+	immutableMemTables.Sub(float64(len(l.immutables)))
+	l.memTable, l.immutables, l.flushErr = NewMemTable(), nil, nil
+	l.wal, l.walId = nextWal, nextWalId
+	l.memMux.Unlock()
+	l.versionMux.Unlock()
+	// In-flight lookups may cache the blocks again, until the dropped parts are closed.
+	for _, sst := range dropped {
+		sst.evictCachedBlocks()
 	}
+	l.memTableMinSequence, l.memTableFull = 0, false
+	slog.Info("Truncated LSM tree.", "dir", l.dir, "parts", len(dropped))
 
-	return returnValue, nil
+	// Leftovers aren't referenced by the manifest and get removed when the tree is opened again.
+	removeDropped := func() error {
+		var err error
+		for _, wal := range droppedWals {
+			err = errors.Join(err, wal.Remove())
+		}
+		for _, sst := range dropped { // In-flight scans keep reading the removed files until they're done.
+			err = errors.Join(err, sst.retire(), os.Remove(sst.file.Name()))
+		}
+		return errors.Join(err, syncDir(l.dir))
+	}
+	if async {
+		go func() {
+			if err := removeDropped(); err != nil {
+				slog.Warn("Failed to remove the files of a truncated lsm tree.", "dir", l.dir, "error", err)
+			}
+		}()
+		return nil
+	}
+	if err := removeDropped(); err != nil {
+		return fmt.Errorf("failed to remove the files of the truncated lsm tree: %w", err)
+	}
+	return nil
 }
 
-// Close closes every SSTable in the LSM tree.
+// Close flushes the memtables, and closes every SSTable in the LSM tree.
 func (l *LSMTree) Close() error {
+	return l.close(true /*flush*/)
+}
+
+// CloseWithoutFlush closes the LSM tree like Close, but keeps the memtables in their write-ahead logs only; they're
+// replayed when the tree is opened again.
+func (l *LSMTree) CloseWithoutFlush() error {
+	return l.close(false /*flush*/)
+}
+
+// close closes every SSTable in the LSM tree, after flushing the memtables if `flush` is set.
+func (l *LSMTree) close(flush bool) error {
 	if l == nil {
 		return nil
 	}
 
+	if l.closed {
+		return errors.New("lsm tree is already closed")
+	}
+	l.closed = true
+
 	slog.Info("Closing LSM tree instance.")
+	l.stopFlushes()     // The remaining memtables are flushed right here instead; flushes also poke compactions.
+	l.stopCompactions() // Compactions may still be using the disk tables.
 	var errs error
-	if err := l.flushMemTable(); err != nil {
-		errs = err
-	}
-	for _, sst := range l.diskTables {
-		if sst == nil {
-			continue
+	if flush {
+		errs = l.freezeMemTable()
+		for errs == nil {
+			if flushed, err := l.flushImmutable(); err != nil {
+				errs = err
+			} else if !flushed {
+				break
+			}
 		}
-		if err := sst.Close(); err != nil {
-			errs = errors.Join(errs, err)
+	}
+	if err := l.closeWals(); err != nil { // Syncs the logs as well.
+		errs = errors.Join(errs, err)
+	}
+	// Parts are closed right away, unless in-flight lookups and scans still hold them.
+	if version := l.version.Swap(nil); version != nil {
+		version.unref()
+		for _, sst := range version.parts {
+			if err := sst.retire(); err != nil {
+				errs = errors.Join(errs, err)
+			}
 		}
 	}
+	if err := l.manifest.close(); err != nil {
+		errs = errors.Join(errs, err)
+	}
 
 	return errs
 }
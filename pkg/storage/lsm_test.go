@@ -2,59 +2,71 @@ package storage
 
 import (
 	"fmt"
+	"iter"
+	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/nobletooth/kiwi/pkg/config"
 	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	promclient "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewLSMTree(t *testing.T) {
 	t.Run("empty_dir", func(t *testing.T) {
-		lsm, err := NewLSMTree(t.TempDir(), 1 /*tableId*/)
+		lsm, err := NewLSMTree(t.TempDir(), 1 /*tableId*/, nil /*inspector*/)
 		assert.NoError(t, err)
 		assert.NotNil(t, lsm)
 		assert.Equal(t, int64(1), lsm.table)
-		assert.Nil(t, lsm.latestDiskTable, "Expected a nil SSTable tail")
-		assert.Empty(t, lsm.diskTables, "Expected SSTables to be empty")
+		assert.Empty(t, lsm.currentParts(), "Expected SSTables to be empty")
+		assert.FileExists(t, filepath.Join(lsm.dir, manifestFileName))
 	})
 	t.Run("non_empty_dir", func(t *testing.T) {
 		dataDir := t.TempDir()
 		table := int64(10)
 		tableDir := filepath.Join(dataDir, strconv.FormatInt(table, 10 /*base*/))
-		assert.NoError(t, writeSSTable(0 /*prevId*/, 1 /*nextId*/, filepath.Join(tableDir, "1.sst"), []utils.BytePair{
+		assert.NoError(t, writeSSTable(partInfo{id: 1, prevId: 0}, filepath.Join(tableDir, "1.sst"), unversioned([]utils.BytePair{
 			{Key: []byte("k1"), Value: []byte("v1")},
 			{Key: []byte("k2"), Value: []byte("v2")},
 			{Key: []byte("k3"), Value: []byte("v3")},
-		}))
-		assert.NoError(t, writeSSTable(1 /*prevId*/, 2 /*nextId*/, filepath.Join(tableDir, "2.sst"), []utils.BytePair{
+		})))
+		assert.NoError(t, writeSSTable(partInfo{id: 2, prevId: 1}, filepath.Join(tableDir, "2.sst"), unversioned([]utils.BytePair{
 			{Key: []byte("k2"), Value: []byte("v1*")},
 			{Key: []byte("k1"), Value: []byte("v1*")},
 			{Key: []byte("k4"), Value: []byte("v4")},
-		}))
+		})))
 
-		// Create table and make sure the SSTable chain is set up correctly.
-		lsm, err := NewLSMTree(dataDir, table)
-		assert.NoError(t, err)
-		assert.NotNil(t, lsm)
+		// Create table and make sure the SSTable chain is migrated to the manifest correctly.
+		lsm, err := NewLSMTree(dataDir, table, nil /*inspector*/)
+		require.NoError(t, err)
+		require.NotNil(t, lsm)
 		assert.Equal(t, table, lsm.table)
-		// Check latest disk table.
-		assert.Equal(t, table, lsm.latestDiskTable.table)
-		assert.Equal(t, int64(2), lsm.latestDiskTable.header.GetId())
-		// Check all read disk tables.
-		assert.Len(t, lsm.diskTables, 2)
-		assert.Equal(t, int64(0), lsm.diskTables[1].header.GetPrevPart())
-		assert.Equal(t, int64(1), lsm.diskTables[1].header.GetId())
-		assert.Equal(t, int64(1), lsm.diskTables[2].header.GetPrevPart())
-		assert.Equal(t, int64(2), lsm.diskTables[2].header.GetId())
-		assert.Same(t, lsm.latestDiskTable, lsm.diskTables[2])
+		assert.FileExists(t, filepath.Join(tableDir, manifestFileName))
+		// Check all read disk tables, newest first.
+		require.Len(t, lsm.currentParts(), 2)
+		assert.Equal(t, table, lsm.currentParts()[0].table)
+		assert.Equal(t, int64(2), lsm.currentParts()[0].header.GetId())
+		assert.Equal(t, int64(1), lsm.currentParts()[1].header.GetId())
+		assert.Equal(t, int64(3), lsm.walId, "Expected the log to be named after the next part")
+		val, err := lsm.Get([]byte("k4"))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v4"), val)
 	})
 }
 
 func TestLSMTree(t *testing.T) {
-	lsm, err := NewLSMTree(t.TempDir(), 10 /*table*/)
+	// Compactions are disabled to keep every flushed part around.
+	config.SetTestFlag(t, "enable_compaction", "false")
+	lsm, err := NewLSMTree(t.TempDir(), 10 /*table*/, nil /*inspector*/)
 	assert.NoError(t, err)
 	// Setting a lower value for the flush so that SSTables are created and flushed to disk.
 	config.SetTestFlag(t, "memtable_flush_size", "10")
@@ -64,7 +76,8 @@ func TestLSMTree(t *testing.T) {
 			assert.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i))))
 		}
 		// Since 50 entries were added and flush size was 10, 5 SSTables should be created.
-		assert.Len(t, lsm.diskTables, 5)
+		require.NoError(t, lsm.waitForFlushes())
+		assert.Len(t, lsm.currentParts(), 5)
 	})
 	t.Run("get", func(t *testing.T) { // Get all and make sure they exist.
 		for i := range 50 {
@@ -80,6 +93,404 @@ func TestLSMTree(t *testing.T) {
 			assert.Equal(t, []byte(fmt.Sprintf("v%d", i)), prevVal)
 		}
 		// Since after swapping all keys, 50 new entries were added we expect the total SSTable count to be 10.
-		assert.Len(t, lsm.diskTables, 10)
+		require.NoError(t, lsm.waitForFlushes())
+		assert.Len(t, lsm.currentParts(), 10)
+	})
+}
+
+func TestLSMTree_Recovery(t *testing.T) {
+	dataDir := t.TempDir()
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "enable_compaction", "false")
+	lsm, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	// 15 keys make one flushed part and 5 keys which only exist in the memtable and its write-ahead log.
+	for i := range 15 {
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 1)
+	assert.FileExists(t, filepath.Join(dataDir, "1", "2.wal"))
+	assert.NoFileExists(t, filepath.Join(dataDir, "1", "1.wal"), "Expected the flushed memtable's log to be removed")
+
+	{ // Simulate a crash: release file descriptors without flushing the memtable.
+		runtime.SetFinalizer(lsm, nil)
+		lsm.stopFlushes()
+		require.NoError(t, lsm.closeWals())
+		for _, sst := range lsm.currentParts() {
+			require.NoError(t, sst.Close())
+		}
+		require.NoError(t, lsm.manifest.close())
+	}
+
+	recovered, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, recovered.Close()) })
+	assert.Equal(t, 5, recovered.memTable.entries)
+	for i := range 15 {
+		val, err := recovered.Get([]byte("k" + strconv.Itoa(i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprintf("v%d", i)), val)
+	}
+}
+
+func TestLSMTree_Scan(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false") // Compactions are run manually.
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "compaction_level0_parts", "2")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+
+	// Part 1 has k00..k09, part 2 overwrites the even keys, and the memtable overwrites every third key.
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%02d", i)) }
+	want := make(map[string]string)
+	for i := range 10 {
+		require.NoError(t, lsm.Set(key(i), []byte(fmt.Sprintf("v%d", i))))
+		want[string(key(i))] = fmt.Sprintf("v%d", i)
+	}
+	for i := 0; i < 20; i += 2 {
+		require.NoError(t, lsm.Set(key(i), []byte(fmt.Sprintf("v%d*", i))))
+		want[string(key(i))] = fmt.Sprintf("v%d*", i)
+	}
+	for i := 0; i < 20; i += 3 {
+		require.NoError(t, lsm.Set(key(i), []byte(fmt.Sprintf("v%d**", i))))
+		want[string(key(i))] = fmt.Sprintf("v%d**", i)
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 2)
+	require.NotZero(t, lsm.memTable.entries)
+
+	collect := func(pairs iter.Seq2[utils.BytePair, error]) map[string]string {
+		got := make(map[string]string)
+		var prevKey []byte
+		for pair, err := range pairs {
+			require.NoError(t, err)
+			assert.Less(t, string(prevKey), string(pair.Key), "Expected ascending keys")
+			prevKey = pair.Key
+			got[string(pair.Key)] = string(pair.Value)
+		}
+		return got
+	}
+
+	t.Run("newest_wins", func(t *testing.T) {
+		assert.Equal(t, want, collect(lsm.Scan(nil /*start*/, nil /*end*/)))
 	})
+	t.Run("range", func(t *testing.T) {
+		got := collect(lsm.Scan(key(5), key(9)))
+		assert.Equal(t, map[string]string{"k05": want["k05"], "k06": want["k06"], "k07": want["k07"],
+			"k08": want["k08"]}, got)
+	})
+	t.Run("prefix", func(t *testing.T) {
+		wantPrefix := make(map[string]string)
+		for k, v := range want {
+			if strings.HasPrefix(k, "k1") {
+				wantPrefix[k] = v
+			}
+		}
+		assert.Equal(t, wantPrefix, collect(lsm.ScanPrefix([]byte("k1"))))
+		assert.Empty(t, collect(lsm.ScanPrefix([]byte("x"))))
+	})
+	t.Run("reverse", func(t *testing.T) {
+		// collectKeys returns the scanned keys, in their order, after checking their values.
+		collectKeys := func(pairs iter.Seq2[utils.BytePair, error]) []string {
+			var keys []string
+			for pair, err := range pairs {
+				require.NoError(t, err)
+				assert.Equal(t, want[string(pair.Key)], string(pair.Value))
+				keys = append(keys, string(pair.Key))
+			}
+			return keys
+		}
+		forward := collectKeys(lsm.Scan(nil /*start*/, nil /*end*/))
+		slices.Reverse(forward)
+		assert.Equal(t, forward, collectKeys(lsm.ReverseScan(nil /*start*/, nil /*end*/)))
+		assert.Equal(t, []string{"k08", "k07", "k06", "k05"}, collectKeys(lsm.ReverseScan(key(5), key(9))))
+		assert.Empty(t, collectKeys(lsm.ReverseScan([]byte("x"), nil /*end*/)))
+
+		// Versions kept for a snapshot are reordered newest first, for both the latest and the snapshot reads.
+		snapshot, err := lsm.Snapshot()
+		require.NoError(t, err)
+		defer snapshot.Release()
+		require.NoError(t, lsm.Set(key(19), []byte("v19***")))
+		defer func() { want[string(key(19))] = "v19***" }()
+		next, stop := iter.Pull2(lsm.ReverseScan(nil /*start*/, nil /*end*/))
+		defer stop()
+		latest, err, ok := next()
+		require.True(t, ok)
+		require.NoError(t, err)
+		assert.Equal(t, "v19***", string(latest.Value))
+		assert.Equal(t, forward, collectKeys(snapshot.ReverseScan(nil /*start*/, nil /*end*/)))
+	})
+	t.Run("compaction_midway", func(t *testing.T) {
+		next, stop := iter.Pull2(lsm.Scan(nil /*start*/, nil /*end*/))
+		defer stop()
+		first, err, ok := next()
+		require.True(t, ok)
+		require.NoError(t, err)
+		assert.Equal(t, "k00", string(first.Key))
+
+		compacted, err := lsm.maybeCompact()
+		require.NoError(t, err)
+		require.True(t, compacted)
+		require.Len(t, lsm.currentParts(), 1)
+
+		// The retired parts stay readable until the scan is done.
+		got := map[string]string{string(first.Key): string(first.Value)}
+		for pair, err, ok := next(); ok; pair, err, ok = next() {
+			require.NoError(t, err)
+			got[string(pair.Key)] = string(pair.Value)
+		}
+		assert.Equal(t, want, got)
+	})
+}
+
+func TestLSMTree_ScanMemTableChunks(t *testing.T) {
+	config.SetTestFlag(t, "memtable_flush_size", "10000")
+	config.SetTestFlag(t, "memtable_flush_size_bytes", "1000000")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	const keys = 3 * memTableScanChunk
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%04d", i)) }
+	for i := range keys {
+		require.NoError(t, lsm.Set(key(i), []byte("v")))
+	}
+
+	// Writes don't wait for open scans; they only show up in the chunks that aren't copied yet.
+	var scanned []string
+	for pair, err := range lsm.Scan(nil /*start*/, nil /*end*/) {
+		require.NoError(t, err)
+		scanned = append(scanned, string(pair.Key))
+		if len(scanned) == 1 {
+			require.NoError(t, lsm.Set([]byte("a"), []byte("v")))
+			require.NoError(t, lsm.Set(key(keys), []byte("v")))
+		}
+	}
+	assert.Len(t, scanned, keys+1)
+	assert.Equal(t, string(key(0)), scanned[0], "Expected keys before the scanned chunk to be skipped")
+	assert.Equal(t, string(key(keys)), scanned[keys], "Expected keys after the scanned chunk to be seen")
+	assert.True(t, slices.IsSorted(scanned))
+
+	var reversed []string
+	for pair, err := range lsm.ReverseScan(nil /*start*/, nil /*end*/) {
+		require.NoError(t, err)
+		reversed = append(reversed, string(pair.Key))
+	}
+	slices.Reverse(reversed)
+	assert.Equal(t, append([]string{"a"}, scanned...), reversed, "Expected reverse scans to chain the chunks")
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("ab"), prefixEnd([]byte("aa")))
+	assert.Equal(t, []byte("b"), prefixEnd([]byte{'a', 0xff, 0xff}))
+	assert.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+	assert.Nil(t, prefixEnd(nil))
+}
+
+func TestLSMTree_Truncate(t *testing.T) {
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	dataDir := t.TempDir()
+	tableDir := filepath.Join(dataDir, "1")
+	lsm, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	for i := range 25 { // Makes two parts and a non-empty memtable.
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	_, err = lsm.Get([]byte("k0")) // Caches a block of the first part.
+	require.NoError(t, err)
+	require.NoError(t, lsm.waitForFlushes())
+	require.NotEmpty(t, lsm.currentParts())
+	firstPart := lsm.currentParts()[len(lsm.currentParts())-1]
+	blockOffset := firstPart.header.GetSkipIndex().GetBlockOffsets()[0] + firstPart.dataBlockOffset
+	_, cached := firstPart.sharedCache.Get(1 /*table*/, firstPart.header.GetId(), blockOffset)
+	require.True(t, cached)
+
+	require.NoError(t, lsm.Truncate(false /*async*/))
+	assert.Empty(t, lsm.currentParts())
+	assert.Empty(t, listParts(t, tableDir))
+	_, cached = firstPart.sharedCache.Get(1 /*table*/, firstPart.header.GetId(), blockOffset)
+	assert.False(t, cached, "Expected the blocks of dropped parts to be evicted")
+	for i := range 25 {
+		_, err := lsm.Get([]byte("k" + strconv.Itoa(i)))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+
+	// The truncation survives reopening, and the tree keeps working afterward.
+	require.NoError(t, lsm.Set([]byte("k0"), []byte("new")))
+	require.NoError(t, lsm.Close())
+	lsm, err = NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	val, err := lsm.Get([]byte("k0"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = lsm.Get([]byte("k1"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	require.NoError(t, lsm.Truncate(true /*async*/))
+	assert.Eventually(t, func() bool { return len(listParts(t, tableDir)) == 0 },
+		time.Second /*waitFor*/, 10*time.Millisecond /*tick*/)
+	_, err = lsm.Get([]byte("k0"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestLSMTree_Delete(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, testInspector{})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	for i := range 15 { // k0..k9 are flushed to a part, the rest stay in the memtable.
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	require.NoError(t, lsm.Set([]byte("dead"), deadValue))
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 1)
+
+	for _, key := range []string{"k1", "k12"} { // On disk and in memory.
+		exists, err := lsm.Exists([]byte(key))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		existed, err := lsm.Delete([]byte(key))
+		assert.NoError(t, err)
+		assert.True(t, existed)
+		exists, err = lsm.Exists([]byte(key))
+		assert.NoError(t, err)
+		assert.False(t, exists)
+		val, err := lsm.Get([]byte(key))
+		assert.NoError(t, err)
+		assert.Equal(t, deadValue, val, "Expected a tombstone to be written")
+	}
+
+	// Missing and already deleted keys don't grow the memtable.
+	lastSequence := lsm.lastSequence
+	for _, key := range []string{"k1", "k12", "dead", "a", "k99", "z"} {
+		existed, err := lsm.Delete([]byte(key))
+		assert.NoError(t, err)
+		assert.False(t, existed, "Expected %q to be absent", key)
+	}
+	assert.Equal(t, lastSequence, lsm.lastSequence, "Expected nothing to be written")
+	_, err = lsm.Get([]byte("k99"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	// Deleting requires a tombstone from the inspector.
+	raw, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, raw.Close()) })
+	require.NoError(t, raw.Set([]byte("k"), []byte("v")))
+	exists, err := raw.Exists([]byte("k"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	_, err = raw.Delete([]byte("k"))
+	assert.Error(t, err)
+}
+
+func TestLSMTree_LastWrite(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, testInspector{})
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	lastWrite := func(key string) int64 {
+		t.Helper()
+		sequence, err := lsm.LastWrite([]byte(key))
+		require.NoError(t, err)
+		return sequence
+	}
+
+	_, err = lsm.LastWrite([]byte("k"))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	require.NoError(t, lsm.Set([]byte("k"), []byte("v")))
+	assert.Equal(t, int64(1), lastWrite("k"))
+	require.NoError(t, lsm.Set([]byte("k"), []byte("v")))
+	assert.Equal(t, int64(2), lastWrite("k"), "Expected writes of the same value to count")
+	_, err = lsm.Delete([]byte("k"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), lastWrite("k"), "Expected deletes to count")
+
+	for i := range 9 { // Flushes the memtable; the sequence numbers are kept in the part.
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte("v")))
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 1)
+	assert.Equal(t, int64(3), lastWrite("k"))
+	assert.Equal(t, int64(12), lastWrite("k8"))
+	assert.Equal(t, int64(12), lsm.LastSequence())
+	require.NoError(t, lsm.Truncate(false /*async*/))
+	assert.Equal(t, int64(12), lsm.LastSequence(), "Expected sequence numbers to survive truncations")
+}
+
+func TestLSMTree_Corruption(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "data_block_size", "40") // A few keys per block.
+	const table = 10                               // Not shared with other tests, so that the corrupted blocks can't be cached.
+	dataDir := t.TempDir()
+	lsm, err := NewLSMTree(dataDir, table, nil /*inspector*/)
+	require.NoError(t, err)
+	for i := range 10 {
+		require.NoError(t, lsm.Set([]byte(fmt.Sprintf("%c%d", 'a'+i/5, i%5)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	require.NoError(t, lsm.Close())
+
+	// Flip a bit of the last data block, which holds the last key.
+	partFile := filepath.Join(dataDir, strconv.Itoa(table), "1.sst")
+	part, err := os.ReadFile(partFile)
+	require.NoError(t, err)
+	part[len(part)-1] ^= 0x01
+	require.NoError(t, os.WriteFile(partFile, part, 0o644))
+
+	lsm, err = NewLSMTree(dataDir, table, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	_, err = lsm.Get([]byte("b4"))
+	assert.ErrorIs(t, err, ErrCorruption)
+	val, err := lsm.Get([]byte("a0"))
+	assert.NoError(t, err, "Expected intact blocks to stay readable")
+	assert.Equal(t, []byte("v0"), val)
+
+	var scanned []string
+	var scanErr error
+	for pair, err := range lsm.Scan(nil /*start*/, nil /*end*/) {
+		if scanErr = err; err != nil {
+			break
+		}
+		scanned = append(scanned, string(pair.Key))
+	}
+	assert.ErrorIs(t, scanErr, ErrCorruption, "Expected scans to report corrupted blocks instead of stopping early")
+	assert.NotEmpty(t, scanned)
+	assert.NotContains(t, scanned, "b4")
+}
+
+func TestLSMTree_Metrics(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "2")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	// sampleCount returns the number of observations of the given histogram.
+	sampleCount := func(observer prometheus.Observer) uint64 {
+		metric := &promclient.Metric{}
+		require.NoError(t, observer.(prometheus.Histogram).Write(metric))
+		return metric.GetHistogram().GetSampleCount()
+	}
+	memTableGets, diskGets := sampleCount(lsmGetDuration.WithLabelValues("memtable")),
+		sampleCount(lsmGetDuration.WithLabelValues("disk"))
+	notFoundGets, probes := sampleCount(lsmGetDuration.WithLabelValues("not_found")), sampleCount(lsmGetProbedParts)
+	flushes, flushedBytes := sampleCount(memTableFlushDuration), testutil.ToFloat64(memTableFlushedBytes)
+
+	for _, key := range []string{"a", "b", "c"} { // Flushes a and b into a part.
+		require.NoError(t, lsm.Set([]byte(key), []byte("v")))
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	assert.Equal(t, flushes+1, sampleCount(memTableFlushDuration))
+	assert.Equal(t, flushedBytes+float64(lsm.currentParts()[0].size), testutil.ToFloat64(memTableFlushedBytes))
+	for _, key := range []string{"a", "c", "missing"} {
+		_, _ = lsm.Get([]byte(key))
+	}
+	assert.Equal(t, memTableGets+1, sampleCount(lsmGetDuration.WithLabelValues("memtable")))
+	assert.Equal(t, diskGets+1, sampleCount(lsmGetDuration.WithLabelValues("disk")))
+	assert.Equal(t, notFoundGets+1, sampleCount(lsmGetDuration.WithLabelValues("not_found")))
+	assert.Equal(t, probes+2, sampleCount(lsmGetProbedParts))
 }
@@ -0,0 +1,312 @@
+// Each table keeps a MANIFEST file, which is the source of truth for its live parts and write-ahead log. The manifest
+// is a sequence of ManifestEdit blocks; replaying them in order rebuilds the latest table state. Flushes and
+// compactions first write their new part, then commit by durably appending a single edit, so a crash at any point
+// either keeps the old state or the new one. Files that aren't referenced by the manifest are leftovers of
+// interrupted operations and get removed when the table is opened.
+//
+// Tables written before manifests existed are migrated by walking their part chain through the prev_part links.
+
+package storage
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/nobletooth/kiwi/pkg/utils"
+	kiwipb "github.com/nobletooth/kiwi/proto"
+)
+
+const manifestFileName = "MANIFEST"
+
+// manifest keeps the committed state of a table in memory, and appends new edits to its MANIFEST file.
+type manifest struct {
+	mux          sync.Mutex // Protects every field below.
+	dir          string
+	writer       *BlockWriter // Appends edits to the manifest file; nil when closed.
+	writeErr     error        // Set after a failed append; edits are refused afterward as the tail may be torn.
+	version      int64        // Version of the last applied edit.
+	nextId       int64        // The next unused part / log ID.
+	logNumber    int64        // ID of the oldest live write-ahead log; the newer logs are live as well.
+	lastSequence int64        // The last sequence number covered by the live parts.
+	parts        map[ /*partId*/ int64]*kiwipb.PartMeta
+}
+
+// newPartMeta describes the given part header for the manifest.
+func newPartMeta(header *kiwipb.PartHeader, minSequence, maxSequence int64) *kiwipb.PartMeta {
+	meta := &kiwipb.PartMeta{
+		Id:          header.GetId(),
+		Level:       header.GetLevel(),
+		MinSequence: minSequence,
+		MaxSequence: maxSequence,
+	}
+	if firstKeys := header.GetSkipIndex().GetFirstKeys(); len(firstKeys) > 0 { // Empty parts have no key range.
+		meta.FirstKey, meta.LastKey = firstKeys[0], header.GetSkipIndex().GetLastKey()
+	}
+	return meta
+}
+
+// openManifest loads the manifest of the table in `dir`, creating one if it doesn't exist.
+// The manifest file is rewritten as a single edit, so that it doesn't grow across restarts.
+func openManifest(dir string) (*manifest, error) {
+	m := &manifest{dir: dir, nextId: 1, parts: make(map[int64]*kiwipb.PartMeta)}
+	path := filepath.Join(dir, manifestFileName)
+	if _, err := os.Stat(path); err == nil {
+		if err := m.replay(path); err != nil {
+			return nil, fmt.Errorf("failed to replay manifest %s: %w", path, err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		if err := m.migrate(); err != nil {
+			return nil, fmt.Errorf("failed to create manifest for %s: %w", dir, err)
+		}
+	} else {
+		return nil, fmt.Errorf("failed to stat manifest %s: %w", path, err)
+	}
+
+	if err := m.rewrite(path); err != nil {
+		return nil, fmt.Errorf("failed to rewrite manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// replay applies every committed edit of the manifest file at `path`.
+func (m *manifest) replay(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	reader, err := NewBlockReader(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = reader.Close() }()
+
+	for offset, edits := int64(0), 0; offset < info.Size(); edits++ {
+		edit := &kiwipb.ManifestEdit{}
+		nextOffset, err := reader.ReadBlock(offset, edit)
+		// A crash in the middle of an append leaves a torn edit at the end of the file, which was never committed.
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			slog.Warn("Found a torn manifest edit.", "path", path, "offset", offset, "error", err)
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read manifest edit at offset %d: %w", offset, err)
+		}
+		// The first edit is a snapshot of the state when the manifest was rewritten, and may have any version.
+		if edits > 0 && edit.GetVersion() != m.version+1 {
+			utils.RaiseInvariant("manifest", "version_gap", "Found a gap between manifest edit versions.",
+				"path", path, "version", m.version, "nextVersion", edit.GetVersion())
+			return fmt.Errorf("expected manifest edit version %d, got %d", m.version+1, edit.GetVersion())
+		}
+		m.apply(edit)
+		offset = nextOffset
+	}
+	return nil
+}
+
+// migrate builds the state of a table that was written without a manifest, by walking the part chain from the
+// latest part, which always has the biggest ID. Unreachable parts are leftovers of an interrupted compaction.
+func (m *manifest) migrate() error {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return fmt.Errorf("failed to list parts: %w", err)
+	}
+	headers := make(map[ /*partId*/ int64]*kiwipb.PartHeader)
+	latestPartId := int64(0)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sst" {
+			continue
+		}
+		sst, err := NewSSTable(filepath.Join(m.dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		headers[sst.header.GetId()] = sst.header
+		latestPartId = max(latestPartId, sst.header.GetId())
+		if err := sst.Close(); err != nil {
+			return err
+		}
+	}
+
+	var chain []*kiwipb.PartHeader // Newest first.
+	for partId := latestPartId; partId > 0; {
+		header, exists := headers[partId]
+		if !exists {
+			// This should never happen, unless the .sst files are corrupted or manually tampered with.
+			utils.RaiseInvariant("manifest", "missing_part", "Missing part in LSM tree.", "dir", m.dir, "part", partId)
+			return fmt.Errorf("missing part %d in lsm tree directory %s", partId, m.dir)
+		}
+		chain = append(chain, header)
+		if prevPartId := header.GetPrevPart(); prevPartId >= partId {
+			utils.RaiseInvariant("manifest", "invalid_prev_part", "A part points to a newer previous part.",
+				"dir", m.dir, "part", partId, "prevPart", prevPartId)
+			return fmt.Errorf("part %d points to newer part %d in %s", partId, prevPartId, m.dir)
+		} else {
+			partId = prevPartId
+		}
+	}
+
+	// Parts didn't have sequence numbers, so each one gets a single sequence in the order of the chain.
+	// The live log was named after the part next to the latest one.
+	edit := &kiwipb.ManifestEdit{
+		Version:      1,
+		NextId:       latestPartId + 2,
+		LogNumber:    latestPartId + 1,
+		LastSequence: int64(len(chain)),
+	}
+	for i, header := range chain {
+		sequence := int64(len(chain) - i)
+		edit.AddedParts = append(edit.AddedParts, newPartMeta(header, sequence, sequence))
+	}
+	m.apply(edit)
+	if len(chain) > 0 {
+		slog.Info("Migrated table parts to a manifest.", "dir", m.dir, "parts", len(chain))
+	}
+	return nil
+}
+
+// rewrite atomically replaces the manifest file with a single edit holding the whole state, and opens it for
+// appending new edits.
+func (m *manifest) rewrite(path string) error {
+	snapshot := &kiwipb.ManifestEdit{
+		Version:      m.version + 1,
+		AddedParts:   m.liveParts(),
+		NextId:       m.nextId,
+		LogNumber:    m.logNumber,
+		LastSequence: m.lastSequence,
+	}
+	tmpPath := path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmpPath) }()
+	writer, err := NewBlockWriter(tmpFile)
+	if err != nil {
+		return err
+	}
+	if err := writer.WriteBlock(snapshot); err != nil {
+		return errors.Join(err, writer.Close())
+	}
+	if err := errors.Join(writer.Sync(), writer.Close()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	if err := syncDir(m.dir); err != nil {
+		return err
+	}
+	m.version = snapshot.GetVersion()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	m.writer, err = NewBlockWriter(file)
+	return err
+}
+
+// apply updates the in-memory state with the given edit. NOTE: Caller should acquire lock, if needed.
+func (m *manifest) apply(edit *kiwipb.ManifestEdit) {
+	m.version = edit.GetVersion()
+	for _, partId := range edit.GetRemovedParts() {
+		delete(m.parts, partId)
+	}
+	for _, part := range edit.GetAddedParts() {
+		m.parts[part.GetId()] = part
+	}
+	m.nextId = max(m.nextId, edit.GetNextId())
+	if edit.GetLogNumber() != 0 {
+		m.logNumber = edit.GetLogNumber()
+	}
+	if edit.GetLastSequence() != 0 {
+		m.lastSequence = edit.GetLastSequence()
+	}
+}
+
+// commit durably appends the given edit to the manifest and applies it; the edit's version and next ID are set here.
+func (m *manifest) commit(edit *kiwipb.ManifestEdit) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.writer == nil {
+		return errors.New("manifest is closed")
+	}
+	if m.writeErr != nil {
+		return fmt.Errorf("manifest is broken by a previous failed edit: %w", m.writeErr)
+	}
+
+	edit.Version, edit.NextId = m.version+1, m.nextId
+	if err := errors.Join(m.writer.WriteBlock(edit), m.writer.Sync()); err != nil {
+		m.writeErr = err
+		return fmt.Errorf("failed to append manifest edit: %w", err)
+	}
+	m.apply(edit)
+	return nil
+}
+
+// allocateId reserves a new part / log ID; it's persisted by the next committed edit.
+func (m *manifest) allocateId() int64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	id := m.nextId
+	m.nextId++
+	return id
+}
+
+// reserveIds marks the IDs up to `id` as used, e.g. by write-ahead logs which were created after the last commit.
+func (m *manifest) reserveIds(id int64) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.nextId = max(m.nextId, id+1)
+}
+
+// liveParts returns the live parts, newest first. NOTE: Caller should acquire lock, if needed.
+func (m *manifest) liveParts() []*kiwipb.PartMeta {
+	parts := make([]*kiwipb.PartMeta, 0, len(m.parts))
+	for _, part := range m.parts {
+		parts = append(parts, part)
+	}
+	slices.SortFunc(parts, func(a, b *kiwipb.PartMeta) int {
+		return cmp.Or(cmp.Compare(b.GetMaxSequence(), a.GetMaxSequence()), cmp.Compare(b.GetId(), a.GetId()))
+	})
+	return parts
+}
+
+// sequenceRange returns the range of sequence numbers that the given live parts cover.
+func (m *manifest) sequenceRange(partIds []int64) (int64 /*minSequence*/, int64 /*maxSequence*/) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	minSequence, maxSequence := int64(0), int64(0)
+	for i, partId := range partIds {
+		part := m.parts[partId]
+		if i == 0 || part.GetMinSequence() < minSequence {
+			minSequence = part.GetMinSequence()
+		}
+		maxSequence = max(maxSequence, part.GetMaxSequence())
+	}
+	return minSequence, maxSequence
+}
+
+// close closes the manifest file; the committed state stays readable.
+func (m *manifest) close() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.writer == nil {
+		return errors.New("manifest is already closed")
+	}
+	err := m.writer.Close()
+	m.writer = nil
+	return err
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	kiwipb "github.com/nobletooth/kiwi/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManifest(dir)
+	require.NoError(t, err)
+	assert.Empty(t, m.parts)
+	assert.Equal(t, int64(1), m.logNumber, "Expected a fresh table to start with log 1")
+	assert.Equal(t, int64(2), m.nextId)
+
+	// Flush part 1, then merge it into part 4.
+	assert.Equal(t, int64(2), m.allocateId())
+	require.NoError(t, m.commit(&kiwipb.ManifestEdit{
+		AddedParts:   []*kiwipb.PartMeta{{Id: 1, MinSequence: 1, MaxSequence: 10}},
+		LogNumber:    2,
+		LastSequence: 10,
+	}))
+	assert.Equal(t, int64(3), m.allocateId())
+	assert.Equal(t, int64(4), m.allocateId())
+	require.NoError(t, m.commit(&kiwipb.ManifestEdit{
+		AddedParts:   []*kiwipb.PartMeta{{Id: 4, Level: 1, MinSequence: 1, MaxSequence: 10}},
+		RemovedParts: []int64{1},
+	}))
+	version := m.version
+	require.NoError(t, m.close())
+	assert.Error(t, m.commit(&kiwipb.ManifestEdit{}), "Expected closed manifests to refuse edits")
+
+	// Reopening replays the edits; the manifest gets rewritten as a single edit.
+	m, err = openManifest(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, m.close()) })
+	assert.Equal(t, version+1, m.version)
+	assert.Equal(t, int64(2), m.logNumber)
+	assert.Equal(t, int64(5), m.nextId)
+	assert.Equal(t, int64(10), m.lastSequence)
+	require.Len(t, m.parts, 1)
+	assert.Equal(t, int32(1), m.parts[4].GetLevel())
+	info, err := os.Stat(filepath.Join(dir, manifestFileName))
+	require.NoError(t, err)
+	snapshot := &kiwipb.ManifestEdit{Version: m.version, AddedParts: m.liveParts(), NextId: 5, LogNumber: 2,
+		LastSequence: 10}
+	assert.Equal(t, getBlockSize(snapshot), info.Size())
+}
+
+func TestManifest_TornTail(t *testing.T) {
+	dir := t.TempDir()
+	m, err := openManifest(dir)
+	require.NoError(t, err)
+	require.NoError(t, m.commit(&kiwipb.ManifestEdit{AddedParts: []*kiwipb.PartMeta{{Id: 1}}, LogNumber: 2}))
+	require.NoError(t, m.close())
+
+	{ // Simulate a crash in the middle of appending an edit.
+		file, err := os.OpenFile(filepath.Join(dir, manifestFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		_, err = file.Write([]byte{100, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3})
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+
+	m, err = openManifest(dir)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, m.close()) })
+	assert.Len(t, m.parts, 1)
+	assert.Equal(t, int64(2), m.logNumber)
+}
+
+func TestLSMTree_OrphanFiles(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	dataDir := t.TempDir()
+	tableDir := filepath.Join(dataDir, "1")
+	lsm, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	for i := range 10 {
+		require.NoError(t, lsm.Set([]byte("k"+strconv.Itoa(i)), []byte(fmt.Sprintf("v%d", i))))
+	}
+	require.NoError(t, lsm.Close())
+
+	// A part that was written but never committed to the manifest, and the log of a flushed memtable.
+	part, err := os.ReadFile(filepath.Join(tableDir, "1.sst"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(tableDir, "7.sst"), part, 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(tableDir, "1.wal"), nil, 0o644))
+
+	lsm, err = NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	assert.Equal(t, []string{"1.sst"}, listParts(t, tableDir))
+	assert.NoFileExists(t, filepath.Join(tableDir, "1.wal"))
+	assert.FileExists(t, filepath.Join(tableDir, "2.wal"))
+	assert.Len(t, lsm.currentParts(), 1)
+}
+
+func TestLSMTree_PartOrderAfterReopen(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "2")
+	config.SetTestFlag(t, "compaction_level0_parts", "2")
+	dataDir := t.TempDir()
+	lsm, err := NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+
+	// Parts 1 and 2 are merged into part 4, while log 3 is live; so the newer part 3 gets a smaller ID.
+	for i, value := range []string{"v1", "v2", "v3", "v4"} {
+		require.NoError(t, lsm.Set([]byte(fmt.Sprintf("k%d", i%2)), []byte(value)))
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	compacted, err := lsm.maybeCompact()
+	require.NoError(t, err)
+	require.True(t, compacted)
+	for i, value := range []string{"v5", "v6"} {
+		require.NoError(t, lsm.Set([]byte(fmt.Sprintf("k%d", i%2)), []byte(value)))
+	}
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 2)
+	assert.Equal(t, int64(3), lsm.currentParts()[0].header.GetId())
+	assert.Equal(t, int64(4), lsm.currentParts()[1].header.GetId())
+	require.NoError(t, lsm.Close())
+
+	lsm, err = NewLSMTree(dataDir, 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	require.Len(t, lsm.currentParts(), 2)
+	assert.Equal(t, int64(3), lsm.currentParts()[0].header.GetId())
+	assert.Equal(t, int64(6), lsm.lastSequence)
+	val, err := lsm.Get([]byte("k1"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v6"), val)
+}
@@ -4,8 +4,7 @@ import (
 	"bytes"
 	"flag"
 	"iter"
-
-	"github.com/nobletooth/kiwi/pkg/utils"
+	"slices"
 )
 
 var (
@@ -15,58 +14,119 @@ var (
 		"Triggers mem table flush when number of key-value entries reaches this count.")
 )
 
-// MemTable serves the latest key-value pairs in memory before they are flushed to disk.
+// keyVersion is a value of a key along with the sequence number and commit time of its write.
+type keyVersion struct {
+	sequence  int64
+	timestamp int64 // Unix nanoseconds; zero if the value was written before commit times.
+	value     []byte
+}
+
+// MemTable serves the latest key-value pairs in memory before they are flushed to disk. Each key holds its versions,
+// newest first; a write replaces the latest version of its key, unless a live snapshot or a read within the history
+// retention window can still see it.
 type MemTable struct {
 	// skipList allows fast lookup, insertion, and deletion of key-value pairs.
-	skipList           *SkipList[[]byte /*key*/, []byte /*value*/]
-	entries, heldBytes int // Size is tracked for flush thresholds.
+	skipList           *SkipList[[]byte /*key*/, []keyVersion /*newest first*/]
+	entries, heldBytes int // Size of the versions is tracked for flush thresholds.
 }
 
 // NewMemTable is the constructor for MemTable.
 func NewMemTable() *MemTable {
-	return &MemTable{skipList: NewSkipList[[]byte /*key*/, []byte /*value*/](bytes.Compare), entries: 0, heldBytes: 0}
+	return &MemTable{skipList: NewSkipList[[]byte /*key*/, []keyVersion](bytes.Compare), entries: 0, heldBytes: 0}
+}
+
+// Get returns the newest value for a given key which the given read sees.
+func (m *MemTable) Get(key []byte, point readPoint) ( /*value*/ []byte, bool /*found*/) {
+	version, found := m.getVersion(key, point)
+	return version.value, found
+}
+
+// getVersion returns the newest version of a given key which the given read sees.
+func (m *MemTable) getVersion(key []byte, point readPoint) (keyVersion, bool /*found*/) {
+	versions, _ := m.skipList.Get(key)
+	for _, version := range versions {
+		if point.sees(version.sequence, version.timestamp) {
+			return version, true
+		}
+	}
+	return keyVersion{}, false
 }
 
-// Get returns the value for a given key.
-func (m *MemTable) Get(key []byte) ( /*value*/ []byte, bool /*found*/) {
-	return m.skipList.Get(key)
+// versions returns a copy of every version of the given key, newest first.
+func (m *MemTable) versions(key []byte) []keyVersion {
+	versions, _ := m.skipList.Get(key)
+	return slices.Clone(versions)
 }
 
-// Swap sets the given {key,value} pair, returning the previous value corresponding to the key.
-func (m *MemTable) Swap(key, value []byte) (bool /*shouldFlush*/, bool /*found*/, []byte /*previousValue*/) {
-	// Determine if key exists to update size accounting correctly.
-	// NOTE: Since skip list is initialized, we'll ignore `Set` returned error.
-	prevVal, found := m.skipList.Set(key, value)
-	if !found { // New key.
+// Swap sets the given version of a key, returning its previous value. The previous version is kept only if it's not
+// newer than `retainedSequence`, e.g. the newest live snapshot (zero if there's none) can see it.
+func (m *MemTable) Swap(key []byte, version keyVersion, retainedSequence int64) (
+	bool /*shouldFlush*/, bool /*found*/, []byte /*previousValue*/) {
+	versions, found := m.skipList.Get(key)
+	var prevVal []byte
+	if found {
+		prevVal = versions[0].value
+	}
+	if found && versions[0].sequence > retainedSequence { // Replacing the latest version.
+		versions[0] = version
+		m.heldBytes += len(version.value) - len(prevVal)
+	} else { // New key or version.
+		// NOTE: Since skip list is initialized, we'll ignore `Set` returned error.
+		m.skipList.Set(key, slices.Insert(versions, 0, version))
 		m.entries++
-		m.heldBytes += len(key) + len(value)
-	} else { // Updating existing key.
-		m.heldBytes += len(value) - len(prevVal)
+		m.heldBytes += len(key) + len(version.value)
 	}
 	return m.entries >= *memtableFlushSize || m.heldBytes >= *memtableFlushSizeBytes, found, prevVal
 }
 
-// Set inserts or updates the value for a given key.
-func (m *MemTable) Set(key, value []byte) /*shouldFlush*/ bool {
-	shouldFlush, _, _ := m.Swap(key, value)
+// Set inserts or updates the value for a given key, see Swap.
+func (m *MemTable) Set(key []byte, version keyVersion, retainedSequence int64) /*shouldFlush*/ bool {
+	shouldFlush, _, _ := m.Swap(key, version, retainedSequence)
 	return shouldFlush
 }
 
+// Delete removes every version of the given key.
 func (m *MemTable) Delete(key []byte) /*found*/ bool {
-	prevVal, found := m.skipList.Delete(key)
-	if found {
+	versions, found := m.skipList.Delete(key)
+	for _, version := range versions {
 		m.entries--
-		m.heldBytes -= len(key) + len(prevVal)
+		m.heldBytes -= len(key) + len(version.value)
 	}
 	return found
 }
 
-// Pairs returns an iterator over all key-value pairs in the memtable.
-func (m *MemTable) Pairs() iter.Seq[utils.BytePair] {
-	it := m.skipList.Iterate()
-	return func(yield func(utils.BytePair) bool) {
-		it(func(pair utils.Pair[ /*key*/ []byte /*value*/, []byte]) bool {
-			return yield(utils.BytePair(pair))
-		})
+// Pairs returns an iterator over every version of the memtable in internal key order.
+func (m *MemTable) Pairs() iter.Seq[internalPair] {
+	return m.Scan(nil /*start*/, nil /*end*/)
+}
+
+// Scan returns an iterator over the versions of the keys within [start, end) in internal key order; nil bounds are
+// open. NOTE: The memtable must not be modified while iterating.
+func (m *MemTable) Scan(start, end []byte) iter.Seq[internalPair] {
+	return func(yield func(internalPair) bool) {
+		for pair := range m.skipList.ScanRange(start, end) {
+			for _, version := range pair.Value {
+				internal := internalKey{key: pair.Key, sequence: version.sequence, timestamp: version.timestamp}
+				if !yield(internalPair{Key: internal, Value: version.value}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// ReverseScan returns an iterator over the versions of the keys within [start, end) in reverse internal key order,
+// i.e. the keys in descending order and their versions oldest first; nil bounds are open.
+// NOTE: The memtable must not be modified while iterating.
+func (m *MemTable) ReverseScan(start, end []byte) iter.Seq[internalPair] {
+	return func(yield func(internalPair) bool) {
+		for pair := range m.skipList.ReverseScanRange(start, end) {
+			for _, version := range slices.Backward(pair.Value) {
+				internal := internalKey{key: pair.Key, sequence: version.sequence, timestamp: version.timestamp}
+				if !yield(internalPair{Key: internal, Value: version.value}) {
+					return
+				}
+			}
+		}
 	}
 }
@@ -10,15 +10,15 @@ import (
 func TestMemTable_Get(t *testing.T) {
 	memTable := NewMemTable()
 	assert.NotNil(t, memTable)
-	_ = memTable.Set([]byte("k"), []byte("v"))
+	_ = memTable.Set([]byte("k"), keyVersion{sequence: 1, value: []byte("v")}, 0 /*retainedSequence*/)
 
 	t.Run("existing_key", func(t *testing.T) {
-		val, found := memTable.Get([]byte("k"))
+		val, found := memTable.Get([]byte("k"), latestRead)
 		assert.True(t, found)
 		assert.Equal(t, []byte("v"), val)
 	})
 	t.Run("non_existent_key", func(t *testing.T) {
-		val, found := memTable.Get([]byte("non-existent"))
+		val, found := memTable.Get([]byte("non-existent"), latestRead)
 		assert.False(t, found)
 		assert.Zero(t, val)
 	})
@@ -31,7 +31,7 @@ func TestMemTable_Set(t *testing.T) {
 	assert.NotNil(t, memTable)
 
 	{ // Set first key.
-		shouldFlush := memTable.Set([]byte("a"), []byte("12"))
+		shouldFlush := memTable.Set([]byte("a"), keyVersion{sequence: 1, value: []byte("12")}, 0 /*retainedSequence*/)
 		// Entries   : 1 < 3
 		// Held bytes: len("a") + len("12") = 3 < 9
 		assert.False(t, shouldFlush)
@@ -39,7 +39,7 @@ func TestMemTable_Set(t *testing.T) {
 		assert.Equal(t, 3, memTable.heldBytes)
 	}
 	{ // Set second key.
-		shouldFlush := memTable.Set([]byte("bb"), []byte("123"))
+		shouldFlush := memTable.Set([]byte("bb"), keyVersion{sequence: 2, value: []byte("123")}, 0 /*retainedSequence*/)
 		// Entries   : 2 < 3
 		// Held bytes: 3 + len("bb") + len("123") = 8 < 9
 		assert.False(t, shouldFlush)
@@ -47,7 +47,7 @@ func TestMemTable_Set(t *testing.T) {
 		assert.Equal(t, 8, memTable.heldBytes)
 	}
 	{ // Set third key.
-		shouldFlush := memTable.Set([]byte("ccc"), []byte("1234"))
+		shouldFlush := memTable.Set([]byte("ccc"), keyVersion{sequence: 3, value: []byte("1234")}, 0 /*retainedSequence*/)
 		// Entries   : 3 == 3
 		// Held bytes: 8 + len("ccc") + len("1234") = 15 > 9
 		assert.True(t, shouldFlush)
@@ -55,7 +55,7 @@ func TestMemTable_Set(t *testing.T) {
 		assert.Equal(t, 15, memTable.heldBytes)
 	}
 	{ // Update existing key.
-		shouldFlush := memTable.Set([]byte("bb"), []byte("12345"))
+		shouldFlush := memTable.Set([]byte("bb"), keyVersion{sequence: 4, value: []byte("12345")}, 0 /*retainedSequence*/)
 		// Entries   : 3 == 3
 		// Held bytes: 15 + (len("12345") - len("123")) = 17 > 9
 		assert.True(t, shouldFlush)
@@ -68,16 +68,16 @@ func TestMemTable_Delete(t *testing.T) {
 	memTable := NewMemTable()
 	assert.NotNil(t, memTable)
 	// Set a couple of keys.
-	_ = memTable.Set([]byte("a"), []byte("1"))
-	_ = memTable.Set([]byte("b"), []byte("2"))
+	_ = memTable.Set([]byte("a"), keyVersion{sequence: 1, value: []byte("1")}, 0 /*retainedSequence*/)
+	_ = memTable.Set([]byte("b"), keyVersion{sequence: 2, value: []byte("2")}, 0 /*retainedSequence*/)
 	assert.Equal(t, 2, memTable.entries)
 	assert.Equal(t, 4, memTable.heldBytes)
 
 	{ // Get should return the values
-		v, found := memTable.Get([]byte("a"))
+		v, found := memTable.Get([]byte("a"), latestRead)
 		assert.True(t, found)
 		assert.Equal(t, []byte("1"), v)
-		v, found = memTable.Get([]byte("b"))
+		v, found = memTable.Get([]byte("b"), latestRead)
 		assert.True(t, found)
 		assert.Equal(t, []byte("2"), v)
 	}
@@ -88,14 +88,34 @@ func TestMemTable_Delete(t *testing.T) {
 	}
 	{ // Delete one and verify it's gone; tracked sizes should shrink.
 		assert.True(t, memTable.Delete([]byte("a")))
-		_, found := memTable.Get([]byte("a"))
+		_, found := memTable.Get([]byte("a"), latestRead)
 		assert.False(t, found)
 		assert.Equal(t, 1, memTable.entries)
 		assert.Equal(t, 2, memTable.heldBytes)
 	}
 	{ // Other key remains.
-		v, found := memTable.Get([]byte("b"))
+		v, found := memTable.Get([]byte("b"), latestRead)
 		assert.True(t, found)
 		assert.Equal(t, []byte("2"), v)
 	}
 }
+
+func TestMemTable_Scan(t *testing.T) {
+	memTable := NewMemTable()
+	for _, key := range []string{"d", "a", "c", "b", "e"} {
+		_ = memTable.Set([]byte(key), keyVersion{sequence: 1, value: []byte("v" + key)}, 0 /*retainedSequence*/)
+	}
+	keys := func(start, end []byte) []string {
+		var got []string
+		for pair := range memTable.Scan(start, end) {
+			got = append(got, string(pair.Key.key))
+		}
+		return got
+	}
+
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, keys(nil /*start*/, nil /*end*/))
+	assert.Equal(t, []string{"b", "c"}, keys([]byte("b"), []byte("d")))
+	assert.Equal(t, []string{"c", "d", "e"}, keys([]byte("bb"), nil /*end*/))
+	assert.Equal(t, []string{"a"}, keys(nil /*start*/, []byte("b")))
+	assert.Empty(t, keys([]byte("x"), nil /*end*/))
+}
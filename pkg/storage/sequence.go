@@ -0,0 +1,148 @@
+// Every write to an LSM tree gets the next sequence number, which is logged along with the write and stored next to
+// its key in memtables and parts; a key along with the sequence number of one of its writes makes an internal key.
+// Internal keys are ordered by key, and then by descending sequence number, so the newest version of a key comes
+// first. A read at a sequence number sees the newest version of each key which isn't newer than it.
+//
+// Each write also gets a commit time, which never goes backward while the tree is open; so reads as of a past time
+// see the versions in the same order as reads at a sequence number, see history.go.
+//
+// Superseded versions are only kept while a live snapshot, or a read within the history retention window, can see
+// them: memtables replace the latest version of a key in place otherwise, and flushes and compactions drop the
+// versions no read can see.
+//
+// Parts written before sequence numbers don't store them; their values get sequence number zero, which makes them
+// older than any other version of their keys.
+
+package storage
+
+import (
+	"bytes"
+	"cmp"
+	"iter"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/utils"
+)
+
+// latestSequence is the sequence number of the reads which see the latest version of each key.
+const latestSequence = math.MaxInt64
+
+// internalKey is a key along with the sequence number of the write which set one of its versions.
+type internalKey struct {
+	key       []byte
+	sequence  int64
+	timestamp int64 // Commit time of the write in unix nanoseconds; zero if it was written before commit times.
+}
+
+// readPoint is the point in the history of an LSM tree that a read sees: the newest version of each key which is
+// neither newer than its sequence number, nor committed after its commit time.
+type readPoint struct {
+	sequence  int64
+	timestamp int64 // Unix nanoseconds.
+}
+
+// latestRead is the read point which sees the latest version of each key.
+var latestRead = atSequence(latestSequence)
+
+// atSequence returns the read point of the given sequence number, e.g. of a snapshot.
+func atSequence(sequence int64) readPoint {
+	return readPoint{sequence: sequence, timestamp: math.MaxInt64}
+}
+
+// atTime returns the read point of the given time, i.e. the versions committed until then.
+func atTime(t time.Time) readPoint {
+	return readPoint{sequence: latestSequence, timestamp: t.UnixNano()}
+}
+
+// sees returns true if the read sees the version written with the given sequence number and commit time.
+func (r readPoint) sees(sequence, timestamp int64) bool {
+	return sequence <= r.sequence && timestamp <= r.timestamp
+}
+
+// internalPair is a version of a key, i.e. its value along with its internal key.
+type internalPair = utils.Pair[internalKey, []byte /*value*/]
+
+// compareInternalKeys orders internal keys by key, and then by descending sequence number.
+func compareInternalKeys(a, b internalKey) int {
+	if c := bytes.Compare(a.key, b.key); c != 0 {
+		return c
+	}
+	return cmp.Compare(b.sequence, a.sequence)
+}
+
+// compareReverseInternalKeys orders internal keys in reverse, i.e. by descending key, and then by sequence number.
+func compareReverseInternalKeys(a, b internalKey) int {
+	return compareInternalKeys(b, a)
+}
+
+// newestFirst reorders the versions of each key of the given reverse internal key order newest first, as
+// visibleVersions expects; the keys stay in descending order. Every version of the current key is held meanwhile.
+func newestFirst(pairs iter.Seq[internalPair]) iter.Seq[internalPair] {
+	return func(yield func(internalPair) bool) {
+		var versions []internalPair // The versions of the current key, oldest first.
+		for pair := range pairs {
+			if len(versions) > 0 && !bytes.Equal(versions[0].Key.key, pair.Key.key) {
+				for _, version := range slices.Backward(versions) {
+					if !yield(version) {
+						return
+					}
+				}
+				versions = versions[:0]
+			}
+			versions = append(versions, pair)
+		}
+		for _, version := range slices.Backward(versions) {
+			if !yield(version) {
+				return
+			}
+		}
+	}
+}
+
+// isVisibleToSnapshots returns true if a version, which is superseded by a version with `nextSequence`, is the newest
+// version that any of the given snapshots (ascending sequence numbers) can see.
+func isVisibleToSnapshots(sequence, nextSequence int64, snapshots []int64) bool {
+	i, _ := slices.BinarySearch(snapshots, sequence)
+	return i < len(snapshots) && snapshots[i] < nextSequence
+}
+
+// retainVersions drops the superseded versions of the given internal key order which none of the given snapshots
+// (ascending sequence numbers) can see, unless they're superseded after `historyCutoff` (unix nanoseconds), i.e. reads
+// within the history retention window can see them; the latest version of each key is always kept.
+func retainVersions(pairs iter.Seq[internalPair], snapshots []int64, historyCutoff int64) iter.Seq[internalPair] {
+	return func(yield func(internalPair) bool) {
+		var prev internalKey
+		first := true
+		for pair := range pairs {
+			superseded := !first && bytes.Equal(pair.Key.key, prev.key)
+			next := prev
+			first, prev = false, pair.Key
+			if superseded && next.timestamp <= historyCutoff &&
+				!isVisibleToSnapshots(pair.Key.sequence, next.sequence, snapshots) {
+				continue
+			}
+			if !yield(pair) {
+				return
+			}
+		}
+	}
+}
+
+// visibleVersions returns the newest version of each key of the given internal key order which the given read sees.
+func visibleVersions(pairs iter.Seq[internalPair], point readPoint) iter.Seq[utils.BytePair] {
+	return func(yield func(utils.BytePair) bool) {
+		var lastKey []byte
+		found := false
+		for pair := range pairs {
+			if !point.sees(pair.Key.sequence, pair.Key.timestamp) || (found && bytes.Equal(pair.Key.key, lastKey)) {
+				continue
+			}
+			found, lastKey = true, pair.Key.key
+			if !yield(utils.BytePair{Key: pair.Key.key, Value: pair.Value}) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// unversioned returns the given pairs as versions written before sequence numbers.
+func unversioned(pairs []utils.BytePair) []internalPair {
+	versions := make([]internalPair, len(pairs))
+	for i, pair := range pairs {
+		versions[i] = internalPair{Key: internalKey{key: pair.Key}, Value: pair.Value}
+	}
+	return versions
+}
+
+func TestCompareInternalKeys(t *testing.T) {
+	keys := []internalKey{{key: []byte("b"), sequence: 1}, {key: []byte("a"), sequence: 0},
+		{key: []byte("ab"), sequence: 9}, {key: []byte("a"), sequence: 7}, {key: []byte("b"), sequence: 3}}
+	slices.SortFunc(keys, compareInternalKeys)
+	assert.Equal(t, []internalKey{{key: []byte("a"), sequence: 7}, {key: []byte("a"), sequence: 0},
+		{key: []byte("ab"), sequence: 9}, {key: []byte("b"), sequence: 3}, {key: []byte("b"), sequence: 1}}, keys)
+}
+
+func TestRetainVersions(t *testing.T) {
+	version := func(key string, sequence int64) internalPair { // Committed at 10x the sequence number.
+		return internalPair{Key: internalKey{key: []byte(key), sequence: sequence, timestamp: 10 * sequence},
+			Value: []byte(key)}
+	}
+	pairs := []internalPair{version("a", 9), version("a", 6), version("a", 4), version("a", 2), version("b", 5),
+		version("b", 1)}
+	sequences := func(pairs []internalPair) []int64 {
+		var got []int64
+		for _, pair := range pairs {
+			got = append(got, pair.Key.sequence)
+		}
+		return got
+	}
+
+	retained := func(snapshots []int64, historyCutoff int64) []int64 {
+		return sequences(slices.Collect(retainVersions(slices.Values(pairs), snapshots, historyCutoff)))
+	}
+
+	assert.Equal(t, []int64{9, 5}, retained(nil, math.MaxInt64))
+	// Snapshot 5 sees a@4 and b@5, and snapshot 7 sees a@6 and b@5.
+	assert.Equal(t, []int64{9, 6, 4, 5}, retained([]int64{5, 7}, math.MaxInt64))
+	// Snapshot 1 sees b@1 only, as a@2 isn't written yet.
+	assert.Equal(t, []int64{9, 5, 1}, retained([]int64{1}, math.MaxInt64))
+	// Reads after time 50 see a@4 and the newer versions, while b@1 is superseded at 50.
+	assert.Equal(t, []int64{9, 6, 4, 5}, retained(nil, 50 /*historyCutoff*/))
+	assert.Equal(t, []int64{9, 6, 4, 2, 5, 1}, retained(nil, 0 /*historyCutoff*/))
+
+	visible := func(point readPoint) []string {
+		var got []string
+		for pair := range visibleVersions(slices.Values(pairs), point) {
+			got = append(got, string(pair.Key)+"@"+string(pair.Value))
+		}
+		return got
+	}
+	assert.Equal(t, []string{"a@a", "b@b"}, visible(atSequence(4)))
+	assert.Equal(t, []string{"a@a", "b@b"}, visible(atTime(time.Unix(0, 55))))
+	assert.Empty(t, visible(atTime(time.Unix(0, 5))))
+}
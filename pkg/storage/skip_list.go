@@ -211,6 +211,42 @@ func (s *SkipList[K, V]) ScanRange(start, end K) iter.Seq[utils.Pair[K, V]] {
 	}
 }
 
+// ReverseScanRange returns an iterator over key/value pairs within the range [start, end) in descending key order.
+// If start is the zero value, iteration continues to the first key.
+// If end is the zero value, iteration begins from the last key.
+// Nodes only point forwards, so each step searches from the head again; it takes logarithmic expected time.
+func (s *SkipList[K, V]) ReverseScanRange(start, end K) iter.Seq[utils.Pair[K, V]] {
+	return func(yield func(utils.Pair[K, V]) bool) {
+		if s == nil || s.head == nil {
+			return
+		}
+		specifiedStart := !utils.IsZero(start, s.compare)
+		for current := s.lastBefore(end); current != s.head; current = s.lastBefore(current.key) {
+			// Stop if we've passed the start key
+			if specifiedStart && s.compare(current.key, start) < 0 {
+				return
+			}
+			// The first key may be the zero value, which doesn't bound the next search.
+			if !yield(utils.Pair[K, V]{Key: current.key, Value: current.value}) || current == s.head.forwards[0] {
+				return
+			}
+		}
+	}
+}
+
+// lastBefore returns the last node whose key is less than `bound`, or the last node if `bound` is the zero value;
+// the head if there's no such node.
+func (s *SkipList[K, V]) lastBefore(bound K) *skipListNode[K, V] {
+	specifiedBound := !utils.IsZero(bound, s.compare)
+	node := s.head
+	for lvl := s.level - 1; lvl >= 0; lvl-- {
+		for next := node.forwards[lvl]; next != nil && (!specifiedBound || s.compare(next.key, bound) < 0); {
+			node, next = next, next.forwards[lvl]
+		}
+	}
+	return node
+}
+
 // ScanFrom returns an iterator starting from the given key (inclusive) to the end.
 func (s *SkipList[K, V]) ScanFrom(start K) iter.Seq[utils.Pair[K, V]] {
 	return s.ScanRange(start, *new(K) /*zeroEnd*/)
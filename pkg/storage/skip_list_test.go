@@ -153,4 +153,14 @@ func TestSkipList_Scan(t *testing.T) {
 		expected := []utils.Pair[int, string]{{Key: 3, Value: "three"}, {Key: 4, Value: "four"}, {Key: 5, Value: "five"}}
 		assert.Equal(t, expected, got)
 	})
+	t.Run("reverse_scan_range", func(t *testing.T) {
+		got := slices.Collect(skipList.ReverseScanRange(2 /*start*/, 5 /*end*/))
+		expected := []utils.Pair[int, string]{{Key: 4, Value: "four"}, {Key: 3, Value: "three"}, {Key: 2, Value: "two"}}
+		assert.Equal(t, expected, got)
+		// Zero bounds are open.
+		got = slices.Collect(skipList.ReverseScanRange(0 /*start*/, 0 /*end*/))
+		expected = slices.Collect(skipList.Iterate())
+		slices.Reverse(expected)
+		assert.Equal(t, expected, got)
+	})
 }
@@ -0,0 +1,134 @@
+// A snapshot is a consistent point-in-time view of an LSM tree, e.g. for long scans which shouldn't see a mix of old
+// and new values:
+//   - It pins the sequence number of the latest write, and only sees the versions which aren't newer than it.
+//   - It holds the memtables and the version of the parts which were live when it was taken, so that flushes,
+//     compactions and truncations don't change what it sees; retired parts stay open until it's released.
+//   - While it's live, memtables keep the versions of their keys which it can see, rather than replacing them, and
+//     so do the parts written by flushes and compactions.
+//
+// Snapshots should be released once they're no longer needed, as they hold memory and disk space; they're released
+// when garbage collected as well.
+
+package storage
+
+import (
+	"errors"
+	"iter"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nobletooth/kiwi/pkg/utils"
+)
+
+// Snapshot is a read-only view of an LSM tree as of a sequence number; it's safe for concurrent use.
+type Snapshot struct {
+	tree        *LSMTree
+	sequence    int64
+	memTable    *MemTable            // The memtable when the snapshot was taken; it may still be taking writes.
+	immutables  []*immutableMemTable // Newest first.
+	version     *partsVersion        // Referenced until the snapshot is released.
+	releaseOnce sync.Once
+	released    atomic.Bool
+}
+
+// Snapshot returns a snapshot of the latest writes of the LSM tree; it should be released once it's no longer needed.
+func (l *LSMTree) Snapshot() (*Snapshot, error) {
+	l.memMux.Lock()
+	defer l.memMux.Unlock()
+	version := l.acquireVersion()
+	if version == nil {
+		return nil, errors.New("lsm tree is closed")
+	}
+	snapshot := &Snapshot{tree: l, sequence: l.lastSequence, memTable: l.memTable,
+		immutables: slices.Clone(l.immutables), version: version}
+	i, _ := slices.BinarySearch(l.liveSnapshots, snapshot.sequence)
+	l.liveSnapshots = slices.Insert(l.liveSnapshots, i, snapshot.sequence)
+	runtime.SetFinalizer(snapshot, (*Snapshot).Release)
+	return snapshot, nil
+}
+
+// newestSnapshot returns the sequence number of the newest live snapshot, or zero if there's none.
+// NOTE: Caller should acquire memMux, either read or write.
+func (l *LSMTree) newestSnapshot() int64 {
+	if len(l.liveSnapshots) == 0 {
+		return 0
+	}
+	return l.liveSnapshots[len(l.liveSnapshots)-1]
+}
+
+// snapshotSequences returns the sequence numbers of the live snapshots in ascending order.
+func (l *LSMTree) snapshotSequences() []int64 {
+	l.memMux.RLock()
+	defer l.memMux.RUnlock()
+	return slices.Clone(l.liveSnapshots)
+}
+
+// Sequence returns the sequence number of the latest write that the snapshot sees.
+func (s *Snapshot) Sequence() int64 {
+	return s.sequence
+}
+
+// Get returns the value of the given key as of the snapshot, or ErrKeyNotFound.
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, errors.New("expected a non-empty key")
+	}
+	if s.released.Load() || !s.version.tryRef() { // Reads hold their own reference, in case of a concurrent release.
+		return nil, errors.New("snapshot is released")
+	}
+	defer s.version.unref()
+	s.tree.memMux.RLock()
+	version, exists := getFromMemTables(s.memTable, s.immutables, key, atSequence(s.sequence))
+	s.tree.memMux.RUnlock()
+	if !exists {
+		var err error
+		if version, err = lookupDiskTables(s.version, key, atSequence(s.sequence)); err != nil {
+			return nil, err
+		}
+	}
+	return version.value, nil
+}
+
+// Scan returns an iterator over the values of the keys within [start, end) as of the snapshot, in ascending key
+// order; nil bounds are open. The returned iterator is single-use, see LSMTree.Scan.
+func (s *Snapshot) Scan(start, end []byte) iter.Seq2[utils.BytePair, error] {
+	return s.scan(start, end, false /*reverse*/)
+}
+
+// ReverseScan returns an iterator over the values of the keys within [start, end) as of the snapshot, in descending
+// key order, see Scan.
+func (s *Snapshot) ReverseScan(start, end []byte) iter.Seq2[utils.BytePair, error] {
+	return s.scan(start, end, true /*reverse*/)
+}
+
+// scan returns an iterator over the values of the keys within [start, end) as of the snapshot, see Scan.
+func (s *Snapshot) scan(start, end []byte, reverse bool) iter.Seq2[utils.BytePair, error] {
+	if s.released.Load() || !s.version.tryRef() { // Each scan holds its own reference, so it may outlive the snapshot.
+		return func(yield func(utils.BytePair, error) bool) {
+			yield(utils.BytePair{}, errors.New("snapshot is released"))
+		}
+	}
+	return s.tree.scanView(s.tree.scanMemTable(s.memTable, start, end, reverse), s.immutables, s.version, start, end,
+		atSequence(s.sequence), reverse)
+}
+
+// ScanPrefix returns an iterator over the values of the keys with the given prefix as of the snapshot, see Scan.
+func (s *Snapshot) ScanPrefix(prefix []byte) iter.Seq2[utils.BytePair, error] {
+	return s.Scan(prefix, prefixEnd(prefix))
+}
+
+// Release releases the snapshot, so that the versions and parts only it sees can be dropped; it's safe to call
+// multiple times.
+func (s *Snapshot) Release() {
+	s.releaseOnce.Do(func() {
+		s.released.Store(true)
+		s.tree.memMux.Lock()
+		if i, found := slices.BinarySearch(s.tree.liveSnapshots, s.sequence); found {
+			s.tree.liveSnapshots = slices.Delete(s.tree.liveSnapshots, i, i+1)
+		}
+		s.tree.memMux.Unlock()
+		s.version.unref()
+	})
+}
@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"fmt"
+	"iter"
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLSMTree_Snapshot(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false") // Compactions are run manually.
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "compaction_level0_parts", "2")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	key := func(i int) []byte { return []byte(fmt.Sprintf("k%d", i)) }
+	set := func(value string, keys ...int) {
+		for _, i := range keys {
+			require.NoError(t, lsm.Set(key(i), []byte(value)))
+		}
+	}
+	// collect returns the values of the given scan, indexed by key.
+	collect := func(pairs iter.Seq2[utils.BytePair, error]) map[string]string {
+		got := make(map[string]string)
+		for pair, err := range pairs {
+			require.NoError(t, err)
+			got[string(pair.Key)] = string(pair.Value)
+		}
+		return got
+	}
+	// want returns the expected values of k0..k9.
+	want := func(values ...string) map[string]string {
+		wantValues := make(map[string]string)
+		for i, value := range values {
+			wantValues[string(key(i))] = value
+		}
+		return wantValues
+	}
+
+	set("v0", 0, 1, 2, 3, 4, 5, 6, 7, 8, 9) // Flushed to part 1.
+	first, err := lsm.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), first.Sequence())
+	set("v1", 0, 1, 2, 3, 4)
+	set("v2", 0, 1, 2, 3, 4) // Replaces v1 in place, as no snapshot can see it.
+	assert.Equal(t, 5, lsm.memTable.entries)
+	second, err := lsm.Snapshot()
+	require.NoError(t, err)
+	set("v3", 0) // Keeps v2 for the second snapshot.
+	assert.Equal(t, 6, lsm.memTable.entries)
+	set("v3", 5, 6, 7, 8) // Flushes the memtable with both versions of k0.
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 2)
+
+	assertViews := func() {
+		t.Helper()
+		firstWant := want("v0", "v0", "v0", "v0", "v0", "v0", "v0", "v0", "v0", "v0")
+		secondWant := want("v2", "v2", "v2", "v2", "v2", "v0", "v0", "v0", "v0", "v0")
+		latestWant := want("v3", "v2", "v2", "v2", "v2", "v3", "v3", "v3", "v3", "v0")
+		for snapshot, wantValues := range map[*Snapshot]map[string]string{first: firstWant, second: secondWant} {
+			for i := range 10 {
+				val, err := snapshot.Get(key(i))
+				assert.NoError(t, err)
+				assert.Equal(t, wantValues[string(key(i))], string(val), "Unexpected %s as of %d", key(i),
+					snapshot.Sequence())
+			}
+			assert.Equal(t, wantValues, collect(snapshot.Scan(nil /*start*/, nil /*end*/)))
+		}
+		assert.Equal(t, latestWant, collect(lsm.Scan(nil /*start*/, nil /*end*/)))
+		_, err := first.Get([]byte("missing"))
+		assert.ErrorIs(t, err, ErrKeyNotFound)
+	}
+	assertViews()
+
+	// The compacted part keeps the versions that the snapshots can see.
+	compacted, err := lsm.maybeCompact()
+	require.NoError(t, err)
+	require.True(t, compacted)
+	require.Len(t, lsm.currentParts(), 1)
+	part := lsm.currentParts()[0]
+	assert.Equal(t, int64(20), part.header.GetNumKeys(), "Expected three versions of k0, and two of k1..k8")
+	for sequence, wantValue := range map[int64]string{first.Sequence(): "v0", second.Sequence(): "v2",
+		latestSequence: "v3"} {
+		version, err := part.get(key(0), atSequence(sequence))
+		assert.NoError(t, err)
+		assert.Equal(t, wantValue, string(version.value))
+	}
+	assertViews()
+
+	// Snapshots see the tree as it was, even after a truncation.
+	third, err := lsm.Snapshot()
+	require.NoError(t, err)
+	require.NoError(t, lsm.Truncate(false /*async*/))
+	val, err := third.Get(key(0))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	assert.Len(t, collect(third.ScanPrefix([]byte("k"))), 10)
+	_, err = lsm.Get(key(0))
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	for _, snapshot := range []*Snapshot{first, second, third} {
+		snapshot.Release()
+		snapshot.Release() // Releasing twice is a no-op.
+		_, err := snapshot.Get(key(0))
+		assert.Error(t, err, "Expected released snapshots to fail reads")
+	}
+	assert.Empty(t, lsm.liveSnapshots)
+}
+
+func TestLSMTree_SnapshotsDropVersionsOnRelease(t *testing.T) {
+	config.SetTestFlag(t, "enable_compaction", "false")
+	config.SetTestFlag(t, "memtable_flush_size", "2")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+
+	snapshot, err := lsm.Snapshot()
+	require.NoError(t, err)
+	for _, value := range []string{"v1", "v2"} { // Replaced in place, as both are newer than the snapshot.
+		require.NoError(t, lsm.Set([]byte("k"), []byte(value)))
+	}
+	assert.Equal(t, 1, lsm.memTable.entries)
+	held, err := lsm.Snapshot()
+	require.NoError(t, err)
+	lsm.stopFlushes()
+	require.NoError(t, lsm.Set([]byte("k"), []byte("v3"))) // Keeps v2 for the held snapshot, and fills the memtable.
+	require.Len(t, lsm.immutables, 1)
+	held.Release() // The versions only it sees are dropped by the flush.
+	lsm.startFlushes()
+	require.NoError(t, lsm.waitForFlushes())
+	require.Len(t, lsm.currentParts(), 1)
+	assert.Equal(t, int64(1), lsm.currentParts()[0].header.GetNumKeys())
+	_, err = snapshot.Get([]byte("k"))
+	assert.ErrorIs(t, err, ErrKeyNotFound, "Expected keys written after the snapshot to be invisible")
+	snapshot.Release()
+}
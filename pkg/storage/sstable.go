@@ -4,14 +4,19 @@
 // The header and skip index blocks are eagerly loaded into memory when the SSTable is opened.
 // The data blocks are lazily loaded on demand when a key is requested. To reduce disk reads, frequently accessed
 // data blocks are cached in memory using a shared block cache.
+// Since parts are immutable, lookups and scans don't take any lock; data blocks are read with positional reads, so
+// concurrent reads of a part don't interfere. The LSM tree keeps parts open while they're referenced, see lsm.go.
 
 package storage
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"iter"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -19,10 +24,13 @@ import (
 	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/bits-and-blooms/bloom/v3"
 	"github.com/nobletooth/kiwi/pkg/utils"
 	kiwipb "github.com/nobletooth/kiwi/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 var (
@@ -33,6 +41,11 @@ var (
 			" each datablock.")
 	bfIndexMinKeys = flag.Uint("bloom_filter_min_keys", 5,
 		"The minimum number of keys in a data block to create a bloom filter index for it.")
+
+	bloomFilterChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bloom_filter_checks_total",
+		Help: "Total number of part lookups checked against the part's bloom filter.",
+	}, []string{"result" /* negative | positive */})
 )
 
 // getBloomFalsePositiveRate returns a clipped false positive rate for the bloom filter index to avoid panics.
@@ -46,60 +59,133 @@ func getBloomFalsePositiveRate() float64 {
 	return 0.01
 }
 
-// writeSSTable writes the given key-value pairs to an SSTable file at the specified path.
-func writeSSTable(prevId, nextId int64, path string, pairs []utils.BytePair) error {
-	if len(pairs) == 0 {
-		return errors.New("expected a non-empty list of pairs")
+// partInfo holds the metadata of a part that is about to be written.
+type partInfo struct {
+	id, prevId int64
+	level      int32 // The compaction level of the part; zero for flushed memtables.
+	deadKeys   int64 // Number of keys whose oldest version was droppable at the time of writing, see isDroppable.
+	codec      Codec // The compression codec of data blocks.
+}
+
+// writeSSTable writes the given versions, in internal key order, to an SSTable file at the specified path.
+// An empty list of pairs makes an empty part, e.g. when a compaction drops every key of its input parts.
+func writeSSTable(part partInfo, path string, pairs []internalPair) error {
+	writer, err := newPartWriter(part, path, len(pairs))
+	if err != nil {
+		return err
 	}
+	defer writer.discard()
+	for _, pair := range pairs {
+		if err := writer.add(pair); err != nil {
+			return err
+		}
+	}
+	return writer.finish()
+}
+
+// partWriter writes the versions of a part, in internal key order, to an SSTable file one data block at a time; so
+// only the current block, the skip index and the bloom filter are held in memory, e.g. when compactions merge a
+// whole table. The header block comes first in the file, but it depends on every data block; so the data blocks are
+// spilled to a temporary file, which is copied after the header once every version is added.
+type partWriter struct {
+	part partInfo
+	path string
 
-	// Compress the pairs into data blocks and their corresponding prefixes.
-	prefixes, dataBlocks := compressDataBlocks(pairs)
-	if len(prefixes) != len(dataBlocks) {
-		utils.RaiseInvariant("chain", "datablock_prefix_size_mismatch",
-			"Expected the same number of prefixes and data blocks.",
-			"prefixes", len(prefixes), "dataBlocks", len(dataBlocks))
-		return errors.New("expected the same number of prefixes and data blocks")
+	dataFile *os.File      // The temporary file of the encoded data blocks.
+	data     *bufio.Writer // Buffers the writes to dataFile.
+	offset   int64         // The offset of the next data block, relative to the first one.
+
+	block     []internalPair // The versions of the current data block.
+	blockSize int            // The encoded size of the current data block, see encodedPairSize.
+	numKeys   int64          // The number of versions added so far.
+
+	skipIndex   *kiwipb.PartHeader_SkipIndex
+	bloomFilter *bloom.BloomFilter // Nil when the part is expected to have too few keys for a bloom filter.
+}
+
+// newPartWriter returns a writer of the given part at `path`; `expectedKeys` is an upper bound of the number of
+// versions it's going to have, which the bloom filter is sized for. It should be discarded once it's done.
+func newPartWriter(part partInfo, path string, expectedKeys int) (*partWriter, error) {
+	dataFile, err := os.CreateTemp(*tmpFolder, "sstable_*.data.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file for sstable data blocks: %w", err)
+	}
+	writer := &partWriter{part: part, path: path, dataFile: dataFile, data: bufio.NewWriter(dataFile),
+		skipIndex: &kiwipb.PartHeader_SkipIndex{}}
+	if expectedKeys >= int(*bfIndexMinKeys) {
+		writer.bloomFilter = bloom.NewWithEstimates(uint(expectedKeys), getBloomFalsePositiveRate())
 	}
+	return writer, nil
+}
 
-	// Build header.
-	dataBlockOffsets := make([]int64, len(dataBlocks))
-	lastBlockOffset := int64(0)
-	firstKeys := make([][]byte, len(dataBlocks))
-	for i, block := range dataBlocks {
-		dataBlockOffsets[i] = lastBlockOffset
-		lastBlockOffset += getBlockSize(block)
-		firstKeys[i] = slices.Concat(prefixes[i], block.GetKeys()[0])
+// add appends the given version to the part; versions should be added in internal key order.
+func (w *partWriter) add(pair internalPair) error {
+	if endsDataBlock(w.block, w.blockSize, pair, getDataBlockSize()) {
+		if err := w.writeBlock(); err != nil {
+			return err
+		}
 	}
-	lastDBlockIndex := len(dataBlocks) - 1
-	lastKeyIndex := len(dataBlocks[lastDBlockIndex].GetKeys()) - 1
-	// Optionally create a bloom filter index for this SSTable.
-	var bf *kiwipb.PartHeader_BloomFilterIndex
-	if len(pairs) >= int(*bfIndexMinKeys) {
-		bfIndex := bloom.NewWithEstimates(uint(len(pairs)), getBloomFalsePositiveRate())
-		for _, pair := range pairs {
-			bfIndex.Add(pair.Key)
+	w.block = append(w.block, pair)
+	w.blockSize += encodedPairSize(pair)
+	w.numKeys++
+	if w.bloomFilter != nil {
+		w.bloomFilter.Add(pair.Key.key)
+	}
+	return nil
+}
+
+// writeBlock writes the current data block to the data file, and adds it to the skip index.
+func (w *partWriter) writeBlock() error {
+	prefix, block := newDataBlock(w.block)
+	encoded, err := encodeBlock(block, w.part.codec)
+	if err != nil {
+		return fmt.Errorf("failed to encode data block for sstable: %w", err)
+	}
+	if _, err := w.data.Write(encoded); err != nil {
+		return fmt.Errorf("failed to write data block for sstable: %w", err)
+	}
+	w.skipIndex.Prefixes = append(w.skipIndex.Prefixes, bytes.Clone(prefix))
+	w.skipIndex.FirstKeys = append(w.skipIndex.FirstKeys, bytes.Clone(w.block[0].Key.key))
+	w.skipIndex.LastKey = bytes.Clone(w.block[len(w.block)-1].Key.key)
+	w.skipIndex.BlockOffsets = append(w.skipIndex.BlockOffsets, w.offset)
+	w.offset += int64(len(encoded))
+	w.block, w.blockSize = w.block[:0], 0
+	return nil
+}
+
+// finish writes the header of the part followed by its data blocks to a temporary file, and then renames it to the
+// final path; the part is empty if no versions were added.
+func (w *partWriter) finish() error {
+	if len(w.block) > 0 {
+		if err := w.writeBlock(); err != nil {
+			return err
 		}
+	}
+	if err := w.data.Flush(); err != nil {
+		return fmt.Errorf("failed to write data blocks for sstable: %w", err)
+	}
+	// Optionally attach a bloom filter index to this SSTable.
+	var bf *kiwipb.PartHeader_BloomFilterIndex
+	if w.bloomFilter != nil && w.numKeys >= int64(*bfIndexMinKeys) {
 		bf = &kiwipb.PartHeader_BloomFilterIndex{
-			NumBits:      uint64(bfIndex.Cap()),
-			NumHashFuncs: uint64(bfIndex.K()),
-			BitArray:     bfIndex.BitSet().Words(),
+			NumBits:      uint64(w.bloomFilter.Cap()),
+			NumHashFuncs: uint64(w.bloomFilter.K()),
+			BitArray:     w.bloomFilter.BitSet().Words(),
 		}
-		slog.Info("Constructed bloom filter for sstable.", "path", path, "numKeys", len(pairs),
+		slog.Info("Constructed bloom filter for sstable.", "path", w.path, "numKeys", w.numKeys,
 			"numBits", bf.NumBits, "numHashFuncs", bf.NumHashFuncs)
 	}
 	header := &kiwipb.PartHeader{
-		Id:       nextId,
-		PrevPart: prevId,
-		BfIndex:  bf,
-		SkipIndex: &kiwipb.PartHeader_SkipIndex{
-			Prefixes:     prefixes,
-			FirstKeys:    firstKeys,
-			LastKey:      slices.Concat(prefixes[lastDBlockIndex], dataBlocks[lastDBlockIndex].GetKeys()[lastKeyIndex]),
-			BlockOffsets: dataBlockOffsets,
-		},
-	}
-
-	// Write blocks into a temporary file first.
+		Id:          w.part.id,
+		PrevPart:    w.part.prevId,
+		BfIndex:     bf,
+		Level:       w.part.level,
+		NumKeys:     w.numKeys,
+		NumDeadKeys: w.part.deadKeys,
+		SkipIndex:   w.skipIndex,
+	}
+
+	// Write the header and then copy the data blocks into a temporary file first.
 	tmpFile, err := os.CreateTemp(*tmpFolder, "sstable_*.tmp")
 	if err != nil || tmpFile == nil {
 		return fmt.Errorf("failed to create temp file for sstable: %w", err)
@@ -112,34 +198,64 @@ func writeSSTable(prevId, nextId int64, path string, pairs []utils.BytePair) err
 	if err := blockWriter.WriteBlock(header); err != nil {
 		return fmt.Errorf("failed to write header block for sstable: %w", err)
 	}
-	for _, dataBlock := range dataBlocks {
-		if err := blockWriter.WriteBlock(dataBlock); err != nil {
-			return fmt.Errorf("failed to write data block for sstable: %w", err)
-		}
+	if _, err := w.dataFile.Seek(0 /*offset*/, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind sstable data blocks: %w", err)
+	}
+	if err := blockWriter.copyFrom(w.dataFile); err != nil {
+		return fmt.Errorf("failed to copy data blocks for sstable: %w", err)
+	}
+	if err := blockWriter.Sync(); err != nil { // Make sure data is on disk before the part becomes visible.
+		return fmt.Errorf("failed to sync sstable: %w", err)
 	}
 	if err := blockWriter.Close(); err != nil { // Flush all data.
 		return fmt.Errorf("failed to close block writer for sstable: %w", err)
 	}
 
 	// Rename the temporary file to the final path.
-	if err := os.MkdirAll(filepath.Dir(path), 0o755 /*perm*/); err != nil {
-		return fmt.Errorf("failed to create dirs for sstable path '%s': %w", path, err)
+	if err := os.MkdirAll(filepath.Dir(w.path), 0o755 /*perm*/); err != nil {
+		return fmt.Errorf("failed to create dirs for sstable path '%s': %w", w.path, err)
 	}
-	if err := os.Rename(tmpFile.Name(), path); err != nil {
-		return fmt.Errorf("failed to rename temp file '%s' to final path '%s': %w", tmpFile.Name(), path, err)
+	if err := os.Rename(tmpFile.Name(), w.path); err != nil {
+		return fmt.Errorf("failed to rename temp file '%s' to final path '%s': %w", tmpFile.Name(), w.path, err)
+	}
+	if err := syncDir(filepath.Dir(w.path)); err != nil {
+		return fmt.Errorf("failed to sync sstable dir: %w", err)
 	}
 
 	return nil
 }
 
+// discard removes the temporary file of the data blocks; it's safe to call more than once.
+func (w *partWriter) discard() {
+	if w.dataFile == nil {
+		return
+	}
+	_ = w.dataFile.Close()
+	_ = os.Remove(w.dataFile.Name())
+	w.dataFile = nil
+}
+
+// syncDir fsyncs the given directory, making the file creations, renames and removals inside it durable.
+func syncDir(dir string) error {
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	syncErr := dirFile.Sync()
+	return errors.Join(syncErr, dirFile.Close())
+}
+
 // SSTable represents a single immutable sorted string table stored on disk.
 type SSTable struct {
-	mux    sync.Mutex // Protects against concurrent files.
-	closed bool
-	table  int64 // The table id this SSTable belongs to, e.g. 123 in /path/to/data/123/456.sst
+	mux      sync.Mutex  // Protects the lifecycle of the part, i.e. pins and closing; reads don't take it.
+	closed   atomic.Bool // Set once the part is closed; reads fail afterward.
+	pins     int         // Number of part versions and in-flight scans which keep the part open.
+	obsolete bool        // Set when the part is no longer live; it's closed as soon as it's unpinned.
+	table    int64       // The table id this SSTable belongs to, e.g. 123 in /path/to/data/123/456.sst
 
 	blockReader     *BlockReader       // Reads header and data blocks.
 	file            *os.File           // A readonly file used by blockReader.
+	size            int64              // Size of the file in bytes.
 	dataBlockOffset int64              // The byte offset where data blocks start in the file.
 	header          *kiwipb.PartHeader // Eagerly loaded into memory.
 	bloomFilter     *bloom.BloomFilter // Optional bloom filter for the entire SSTable key space.
@@ -160,6 +276,10 @@ func NewSSTable(filePath string) (*SSTable, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open sstable file: %w", err)
 	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, errors.Join(fmt.Errorf("failed to stat sstable file: %w", err), file.Close())
+	}
 
 	// The header blocks of the SSTable are always eagerly read into memory, as they're small and always needed.
 	// The data blocks on the other hand, are lazily read on demand.
@@ -168,10 +288,11 @@ func NewSSTable(filePath string) (*SSTable, error) {
 		return nil, fmt.Errorf("failed to create sstable: %w", err)
 	}
 	partHeader := &kiwipb.PartHeader{}
-	if _, err := bw.ReadBlock(0 /*offset*/, partHeader); err != nil {
+	// Parts written by older Kiwi versions have smaller block headers, so the header size is taken from the file.
+	headerSize, err := bw.ReadBlock(0 /*offset*/, partHeader)
+	if err != nil {
 		return nil, fmt.Errorf("failed to read sstable part header: %w", err)
 	}
-	headerSize := getBlockSize(partHeader)
 
 	// Instantiate the optional bloom filter.
 	var bf *bloom.BloomFilter
@@ -190,8 +311,8 @@ func NewSSTable(filePath string) (*SSTable, error) {
 	}
 
 	ssTable := &SSTable{
-		blockReader: bw, file: file, table: table, bloomFilter: bf,
-		header: partHeader, sharedCache: getSharedCache(), closed: false,
+		blockReader: bw, file: file, size: fileInfo.Size(), table: table, bloomFilter: bf,
+		header: partHeader, sharedCache: getSharedCache(),
 		// The data blocks start right after the header block.
 		dataBlockOffset: headerSize,
 	}
@@ -200,8 +321,9 @@ func NewSSTable(filePath string) (*SSTable, error) {
 	return ssTable, nil
 }
 
-// getFromDataBlocks scans through the cached and on-disk data blocks to find the value for the given key.
-func (s *SSTable) getFromDataBlocks(key []byte) ([]byte, error) {
+// locateInDataBlocks scans through the cached and on-disk data blocks to find the versions of the given key; they're
+// the entries [first, end) of the returned data block, newest first.
+func (s *SSTable) locateInDataBlocks(key []byte) (*kiwipb.DataBlock, int /*first*/, int /*end*/, error) {
 	// Since the skip index is sorted by key prefixes, we can use binary search to find the right data block.
 	// blockIndex is the first block whose first key is less than the target key. We don't care if we find an
 	// exact match, but the found block needs to be fully scanned.
@@ -210,7 +332,7 @@ func (s *SSTable) getFromDataBlocks(key []byte) ([]byte, error) {
 	if !found { // When not found, BinarySearchFunc returns the index where the key would be inserted.
 		if blockIndex == 0 {
 			// Key is smaller than the first key in the skip index, so it cannot be in this SSTable.
-			return nil, ErrKeyNotFound
+			return nil, 0, 0, ErrKeyNotFound
 		} else {
 			// This is not the first block, so we need to check the previous block.
 			// E.g. if the first keys are [a, d, g] and we're looking for 'e', we need to check the block
@@ -220,32 +342,225 @@ func (s *SSTable) getFromDataBlocks(key []byte) ([]byte, error) {
 	}
 
 	// Now that we have the proper block range, we need to scan each block for the key.
-	sstableId := s.header.GetId() // Cache to avoid expensive heap calls.
-	blockOffsets := s.header.GetSkipIndex().GetBlockOffsets()
 	blockPrefixes := s.header.GetSkipIndex().GetPrefixes()
-	// Read the data block.
-	blockOffset := blockOffsets[blockIndex] + s.dataBlockOffset
-	var dataBlock *kiwipb.DataBlock
-	if cachedBlock, exists := s.sharedCache.Get(s.table, sstableId, blockOffset); exists {
-		// Read from in-memory data block cache.
-		dataBlock = cachedBlock
-	} else {
-		// Read from disk part and populate the cache.
-		dataBlock = &kiwipb.DataBlock{}
-		if _, err := s.blockReader.ReadBlock(blockOffset, dataBlock); err != nil {
-			return nil, fmt.Errorf("failed to read data block at offset %d: %w", blockOffset, err)
-		}
-		s.sharedCache.Set(s.table, sstableId, blockOffset, dataBlock)
+	dataBlock, err := s.getDataBlock(blockIndex)
+	if err != nil {
+		return nil, 0, 0, err
 	}
 
 	// Now that we have the data block, we can scan it for the key. Note that the keys in the data block
-	// are stripped of their mutual prefix aforementioned in the skip index.
+	// are stripped of their mutual prefix aforementioned in the skip index. The search finds the newest version of
+	// the key, and the older ones follow it.
 	keyWithoutPrefix := bytes.TrimPrefix(key, blockPrefixes[blockIndex])
-	if keyIndex, found := slices.BinarySearchFunc(dataBlock.GetKeys(), keyWithoutPrefix, bytes.Compare); found {
-		return dataBlock.GetValues()[keyIndex], nil
+	keys := dataBlock.GetKeys()
+	first, _ := slices.BinarySearchFunc(keys, keyWithoutPrefix, bytes.Compare)
+	end := first
+	for end < len(keys) && bytes.Equal(keys[end], keyWithoutPrefix) {
+		end++
+	}
+	if first == end {
+		return nil, 0, 0, ErrKeyNotFound
+	}
+	return dataBlock, first, end, nil
+}
+
+// entrySequence returns the sequence number of the i-th entry of the given data block; zero for the entries written
+// before sequence numbers.
+func entrySequence(dataBlock *kiwipb.DataBlock, i int) int64 {
+	if sequences := dataBlock.GetSequences(); len(sequences) > 0 {
+		return sequences[i]
 	}
+	return 0
+}
 
-	return nil, ErrKeyNotFound
+// entryTimestamp returns the commit time of the i-th entry of the given data block; zero for the entries written
+// before commit times.
+func entryTimestamp(dataBlock *kiwipb.DataBlock, i int) int64 {
+	if timestamps := dataBlock.GetTimestamps(); len(timestamps) > 0 {
+		return timestamps[i]
+	}
+	return 0
+}
+
+// entryVersion returns the version of the i-th entry of the given data block.
+func entryVersion(dataBlock *kiwipb.DataBlock, i int) keyVersion {
+	return keyVersion{sequence: entrySequence(dataBlock, i), timestamp: entryTimestamp(dataBlock, i),
+		value: dataBlock.GetValues()[i]}
+}
+
+// readDataBlock reads the data block at the given index directly from disk.
+func (s *SSTable) readDataBlock(blockIndex int) (*kiwipb.DataBlock, error) {
+	blockOffset := s.header.GetSkipIndex().GetBlockOffsets()[blockIndex] + s.dataBlockOffset
+	dataBlock := &kiwipb.DataBlock{}
+	if _, err := s.blockReader.ReadBlock(blockOffset, dataBlock); err != nil {
+		return nil, fmt.Errorf("failed to read data block at offset %d: %w", blockOffset, err)
+	}
+	return dataBlock, nil
+}
+
+// getDataBlock returns the data block at the given index from the shared cache, or reads it from disk and populates
+// the cache; concurrent misses of the same block may read it more than once.
+func (s *SSTable) getDataBlock(blockIndex int) (*kiwipb.DataBlock, error) {
+	blockOffset := s.header.GetSkipIndex().GetBlockOffsets()[blockIndex] + s.dataBlockOffset
+	if cachedBlock, exists := s.sharedCache.Get(s.table, s.header.GetId(), blockOffset); exists {
+		return cachedBlock, nil
+	}
+	dataBlock, err := s.readDataBlock(blockIndex)
+	if err != nil {
+		return nil, err
+	}
+	s.sharedCache.Set(s.table, s.header.GetId(), blockOffset, dataBlock)
+	return dataBlock, nil
+}
+
+// scanPairs returns an iterator over the versions of the keys within [start, end) in internal key order; nil bounds
+// are open. Blocks are read through the shared cache only when `cached` is set, so that full scans
+// (e.g. compactions) don't evict hot blocks. Since iterators can't return errors, the first read error stops the
+// iteration and is stored in `readErr`.
+func (s *SSTable) scanPairs(start, end []byte, cached bool, readErr *error) iter.Seq[internalPair] {
+	return func(yield func(internalPair) bool) {
+		skipIndex := s.header.GetSkipIndex()
+		firstKeys, prefixes := skipIndex.GetFirstKeys(), skipIndex.GetPrefixes()
+		firstBlock := 0
+		if len(start) > 0 { // Skip the blocks which end before start, similar to locateInDataBlocks.
+			blockIndex, found := slices.BinarySearchFunc(firstKeys, start, bytes.Compare)
+			if !found && blockIndex > 0 {
+				blockIndex--
+			}
+			firstBlock = blockIndex
+		}
+		for blockIndex := firstBlock; blockIndex < len(firstKeys); blockIndex++ {
+			if len(end) > 0 && bytes.Compare(firstKeys[blockIndex], end) >= 0 {
+				return
+			}
+			dataBlock, err := s.scanDataBlock(blockIndex, cached)
+			if err != nil {
+				*readErr = err
+				return
+			}
+			for i, keyWithoutPrefix := range dataBlock.GetKeys() {
+				key := slices.Concat(prefixes[blockIndex], keyWithoutPrefix)
+				if len(start) > 0 && bytes.Compare(key, start) < 0 {
+					continue
+				}
+				if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+					return
+				}
+				internal := internalKey{key: key, sequence: entrySequence(dataBlock, i),
+					timestamp: entryTimestamp(dataBlock, i)}
+				pair := internalPair{Key: internal, Value: dataBlock.GetValues()[i]}
+				if !yield(pair) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// reverseScanPairs returns an iterator over the versions of the keys within [start, end) in reverse internal key
+// order, i.e. the keys in descending order and their versions oldest first; see scanPairs.
+func (s *SSTable) reverseScanPairs(start, end []byte, cached bool, readErr *error) iter.Seq[internalPair] {
+	return func(yield func(internalPair) bool) {
+		skipIndex := s.header.GetSkipIndex()
+		firstKeys, prefixes := skipIndex.GetFirstKeys(), skipIndex.GetPrefixes()
+		lastBlock := len(firstKeys) - 1
+		if len(end) > 0 { // Skip the blocks which start at or after end.
+			blockIndex, _ := slices.BinarySearchFunc(firstKeys, end, bytes.Compare)
+			lastBlock = blockIndex - 1
+		}
+		for blockIndex := lastBlock; blockIndex >= 0; blockIndex-- {
+			dataBlock, err := s.scanDataBlock(blockIndex, cached)
+			if err != nil {
+				*readErr = err
+				return
+			}
+			keys := dataBlock.GetKeys()
+			for i := len(keys) - 1; i >= 0; i-- {
+				key := slices.Concat(prefixes[blockIndex], keys[i])
+				if len(end) > 0 && bytes.Compare(key, end) >= 0 {
+					continue
+				}
+				if len(start) > 0 && bytes.Compare(key, start) < 0 {
+					return
+				}
+				internal := internalKey{key: key, sequence: entrySequence(dataBlock, i),
+					timestamp: entryTimestamp(dataBlock, i)}
+				pair := internalPair{Key: internal, Value: dataBlock.GetValues()[i]}
+				if !yield(pair) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// scanDataBlock returns the data block at the given index for scans, through the shared cache if `cached` is set.
+func (s *SSTable) scanDataBlock(blockIndex int, cached bool) (*kiwipb.DataBlock, error) {
+	if s.closed.Load() {
+		return nil, errors.New("sstable is closed")
+	}
+	if cached {
+		return s.getDataBlock(blockIndex)
+	}
+	return s.readDataBlock(blockIndex)
+}
+
+// allPairs returns an iterator over every version of the SSTable, bypassing the shared cache.
+func (s *SSTable) allPairs(readErr *error) iter.Seq[internalPair] {
+	return s.scanPairs(nil /*start*/, nil /*end*/, false /*cached*/, readErr)
+}
+
+// Scan returns an iterator over the latest values of the keys within [start, end) through the shared cache; nil
+// bounds are open. A read error stops the iteration; it's yielded with an empty pair as the last element.
+func (s *SSTable) Scan(start, end []byte) iter.Seq2[utils.BytePair, error] {
+	return func(yield func(utils.BytePair, error) bool) {
+		var readErr error
+		for pair := range visibleVersions(s.scanPairs(start, end, true /*cached*/, &readErr), latestRead) {
+			if !yield(pair, nil) {
+				return
+			}
+		}
+		if readErr != nil {
+			yield(utils.BytePair{}, fmt.Errorf("failed to scan sstable %s: %w", s.file.Name(), readErr))
+		}
+	}
+}
+
+// pin keeps the SSTable open, even if the part gets retired; it's undone by unpin.
+func (s *SSTable) pin() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.pins++
+}
+
+// unpin releases a pin, closing the SSTable if it was retired in the meantime.
+func (s *SSTable) unpin() {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.pins--
+	if s.pins == 0 && s.obsolete && !s.closed.Load() {
+		if err := s.closeLocked(); err != nil {
+			slog.Warn("Failed to close a retired sstable.", "path", s.file.Name(), "error", err)
+		}
+	}
+}
+
+// retire marks the SSTable as no longer live, e.g. after it was compacted; it's closed once every pin is released.
+func (s *SSTable) retire() error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.obsolete = true
+	if s.pins > 0 || s.closed.Load() {
+		return nil
+	}
+	return s.closeLocked()
+}
+
+// evictCachedBlocks drops the data blocks of this SSTable from the shared cache; used when the part is removed.
+func (s *SSTable) evictCachedBlocks() {
+	for _, blockOffset := range s.header.GetSkipIndex().GetBlockOffsets() {
+		s.sharedCache.Remove(s.table, s.header.GetId(), blockOffset+s.dataBlockOffset)
+	}
 }
 
 // GetPrevTablePath returns the file path of the previous SSTable in the chain, if any.
@@ -268,28 +583,67 @@ func (s *SSTable) GetPrevTablePath() (string /*filePath*/, bool /*hasPrevious*/)
 	return prevFilePath, true
 }
 
+// Get returns the latest value of the given key, or ErrKeyNotFound.
 func (s *SSTable) Get(key []byte) ([]byte, error) {
-	s.mux.Lock()
-	defer s.mux.Unlock()
+	version, err := s.get(key, latestRead)
+	return version.value, err
+}
+
+// get returns the newest version of the given key which the given read sees, or ErrKeyNotFound.
+func (s *SSTable) get(key []byte, point readPoint) (keyVersion, error) {
+	dataBlock, first, end, err := s.locate(key)
+	if err != nil {
+		return keyVersion{}, err
+	}
+	for i := first; i < end; i++ {
+		if version := entryVersion(dataBlock, i); point.sees(version.sequence, version.timestamp) {
+			return version, nil
+		}
+	}
+	return keyVersion{}, ErrKeyNotFound
+}
 
+// versions returns every version of the given key, newest first; or ErrKeyNotFound if the SSTable has none.
+func (s *SSTable) versions(key []byte) ([]keyVersion, error) {
+	dataBlock, first, end, err := s.locate(key)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]keyVersion, 0, end-first)
+	for i := first; i < end; i++ {
+		versions = append(versions, entryVersion(dataBlock, i))
+	}
+	return versions, nil
+}
+
+// locate finds the data block holding the versions of the given key, which are its entries [first, end); or
+// ErrKeyNotFound if the SSTable has none.
+func (s *SSTable) locate(key []byte) (*kiwipb.DataBlock, int /*first*/, int /*end*/, error) {
 	// When the SSTable is closed, we cannot read from it anymore.
-	if s.closed {
-		return nil, errors.New("sstable is closed")
+	if s.closed.Load() {
+		return nil, 0, 0, errors.New("sstable is closed")
 	}
 
-	// Check if the key is within the min/max range of the SSTable.
+	// Check if the key is within the min/max range of the SSTable; empty parts have no range at all.
 	skipIndex := s.header.GetSkipIndex()
+	if len(skipIndex.GetFirstKeys()) == 0 {
+		return nil, 0, 0, ErrKeyNotFound
+	}
 	if bytes.Compare(key, skipIndex.GetFirstKeys()[0]) < 0 || bytes.Compare(key, skipIndex.GetLastKey()) > 0 {
-		return nil, ErrKeyNotFound
+		return nil, 0, 0, ErrKeyNotFound
 	}
 
 	// The bloom filter can show when the key is definitely not in this SSTable.
 	// On false positives, we still need to scan the data blocks.
-	if s.bloomFilter != nil && !s.bloomFilter.Test(key) {
-		return nil, ErrKeyNotFound
+	if s.bloomFilter != nil {
+		if !s.bloomFilter.Test(key) {
+			bloomFilterChecks.WithLabelValues("negative").Inc()
+			return nil, 0, 0, ErrKeyNotFound
+		}
+		bloomFilterChecks.WithLabelValues("positive").Inc()
 	}
 
-	return s.getFromDataBlocks(key)
+	return s.locateInDataBlocks(key)
 }
 
 func (s *SSTable) Table() int64 {
@@ -300,16 +654,24 @@ func (s *SSTable) Close() error {
 	s.mux.Lock()
 	defer s.mux.Unlock()
 
-	if s.closed {
+	if s.closed.Load() {
 		return errors.New("sstable already closed")
 	}
 
+	return s.closeLocked()
+}
+
+// closeLocked closes the underlying file. NOTE: Caller should acquire lock.
+func (s *SSTable) closeLocked() error {
+	s.closed.Store(true) // Fails new reads, rather than reading from a closed file.
+	// Cached blocks are keyed by the part ID, so they'd be served to another part with the same ID if kept around,
+	// e.g. after the table is truncated or reopened from another data directory.
+	s.evictCachedBlocks()
 	readerCloseErr := s.blockReader.Close()
 	fileCloseErr := s.file.Close()
 	if err := errors.Join(readerCloseErr, fileCloseErr); err != nil {
 		return fmt.Errorf("failed to close sstable: %w", err)
 	}
-	s.closed = true
 
 	return nil
 }
@@ -2,11 +2,14 @@ package storage
 
 import (
 	"bytes"
+	"fmt"
+	"os"
 	"path/filepath"
 	"slices"
 	"strconv"
 	"testing"
 
+	"github.com/nobletooth/kiwi/pkg/config"
 	"github.com/nobletooth/kiwi/pkg/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -30,7 +33,7 @@ func TestSSTable(t *testing.T) {
 	}
 	// Ensure data is sorted by key before writing to SSTable.
 	slices.SortFunc(data, func(a, b utils.BytePair) int { return bytes.Compare(a.Key, b.Key) })
-	require.NoError(t, writeSSTable(0 /*prevId*/, 1 /*nextId*/, resultFile, data))
+	require.NoError(t, writeSSTable(partInfo{id: 1, prevId: 0}, resultFile, unversioned(data)))
 
 	sst, err := NewSSTable(resultFile)
 	require.NoError(t, err)
@@ -63,3 +66,103 @@ func TestSSTable(t *testing.T) {
 			"Last skip index key should be 'zed'")
 	})
 }
+
+func TestSSTable_Scan(t *testing.T) {
+	config.SetTestFlag(t, "data_block_size", "48")
+	path := filepath.Join(t.TempDir(), "1", "1.sst")
+	var data []utils.BytePair
+	for _, key := range []string{"apple", "banana", "bruce", "broccoli", "carrot", "charlie", "charlotte", "cherry",
+		"zebra", "zed"} {
+		data = append(data, utils.BytePair{Key: []byte(key), Value: []byte("v-" + key)})
+	}
+	slices.SortFunc(data, func(a, b utils.BytePair) int { return bytes.Compare(a.Key, b.Key) })
+	require.NoError(t, writeSSTable(partInfo{id: 1}, path, unversioned(data)))
+	sst, err := NewSSTable(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, sst.Close()) })
+	require.Greater(t, len(sst.header.GetSkipIndex().GetBlockOffsets()), 1, "Expected the keys to span blocks")
+
+	keys := func(start, end string) []string {
+		var startKey, endKey []byte
+		if start != "" {
+			startKey = []byte(start)
+		}
+		if end != "" {
+			endKey = []byte(end)
+		}
+		var got []string
+		for pair, err := range sst.Scan(startKey, endKey) {
+			require.NoError(t, err)
+			assert.Equal(t, "v-"+string(pair.Key), string(pair.Value), "Expected prefixes to be re-attached")
+			got = append(got, string(pair.Key))
+		}
+		return got
+	}
+
+	t.Run("open_bounds", func(t *testing.T) {
+		var want []string
+		for _, pair := range data {
+			want = append(want, string(pair.Key))
+		}
+		assert.Equal(t, want, keys("", ""))
+	})
+	t.Run("ranges", func(t *testing.T) {
+		assert.Equal(t, []string{"bruce", "carrot", "charlie"}, keys("bruce", "charlotte"))
+		assert.Equal(t, []string{"charlotte", "cherry", "zebra", "zed"}, keys("charlio", ""))
+		assert.Equal(t, []string{"apple", "banana"}, keys("", "broccoli"))
+		assert.Empty(t, keys("zz", ""))
+		assert.Empty(t, keys("d", "e"))
+	})
+	t.Run("early_stop", func(t *testing.T) {
+		var got []string
+		for pair := range sst.Scan(nil /*start*/, nil /*end*/) {
+			got = append(got, string(pair.Key))
+			if len(got) == 2 {
+				break
+			}
+		}
+		assert.Equal(t, []string{"apple", "banana"}, got)
+	})
+}
+
+func TestPartWriter(t *testing.T) {
+	config.SetTestFlag(t, "data_block_size", "64")
+	tmpDir := t.TempDir()
+	config.SetTestFlag(t, "temp_folder", tmpDir)
+	var pairs []internalPair
+	for i := range 100 {
+		key := []byte(fmt.Sprintf("key-%03d", i))
+		pairs = append(pairs, internalPair{Key: internalKey{key: key, sequence: int64(2*i + 2)}, Value: []byte("new")},
+			internalPair{Key: internalKey{key: key, sequence: int64(2*i + 1)}, Value: []byte("old")})
+	}
+
+	path := filepath.Join(t.TempDir(), "1", "1.sst")
+	writer, err := newPartWriter(partInfo{id: 1, deadKeys: 3}, path, 2*len(pairs) /*expectedKeys*/)
+	require.NoError(t, err)
+	t.Cleanup(writer.discard)
+	for _, pair := range pairs {
+		require.NoError(t, writer.add(pair))
+	}
+	require.NoError(t, writer.finish())
+	writer.discard()
+	leftovers, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	assert.Empty(t, leftovers, "Expected the temporary files to be removed")
+
+	sst, err := NewSSTable(path)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, sst.Close()) })
+	assert.Equal(t, int64(len(pairs)), sst.header.GetNumKeys())
+	assert.Equal(t, int64(3), sst.header.GetNumDeadKeys())
+	assert.NotNil(t, sst.bloomFilter)
+	prefixes, blocks := compressDataBlocks(pairs, 64 /*blockSize*/)
+	require.Greater(t, len(blocks), 1, "Expected the versions to span blocks")
+	assert.Equal(t, prefixes, sst.header.GetSkipIndex().GetPrefixes(), "Expected the same blocks as writeSSTable")
+	assert.Equal(t, "key-099", string(sst.header.GetSkipIndex().GetLastKey()))
+	for i := 0; i < len(pairs); i += 2 {
+		versions, err := sst.versions(pairs[i].Key.key)
+		require.NoError(t, err)
+		require.Len(t, versions, 2, "Expected the versions of %q to be in the same block", pairs[i].Key.key)
+		assert.Equal(t, "new", string(versions[0].value))
+	}
+}
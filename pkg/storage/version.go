@@ -0,0 +1,95 @@
+// The live parts of an LSM tree are published as immutable, reference-counted versions, so that lookups and scans
+// never block flushes and compactions, nor each other:
+//   - Readers grab the current version with a couple of atomic operations, read its parts without any lock, and
+//     release it when they're done.
+//   - Flushes, compactions and truncations install a new version with the updated part set; they're serialized
+//     among themselves, but never wait for readers.
+//   - Each version pins its parts, so a part that's dropped from the current version (e.g. a compaction input) stays
+//     open until the last version which references it is released.
+
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// partsVersion is an immutable set of live parts.
+type partsVersion struct {
+	parts []*SSTable   // Newest first; disk lookups go through them in order.
+	refs  atomic.Int64 // The tree holds one reference while it's the current version, and each reader another one.
+}
+
+// newPartsVersion pins the given parts and returns a version with the tree's reference.
+func newPartsVersion(parts []*SSTable) *partsVersion {
+	for _, sst := range parts {
+		sst.pin()
+	}
+	version := &partsVersion{parts: parts}
+	version.refs.Store(1)
+	return version
+}
+
+// tryRef adds a reference to the version; it fails if the version was already released, i.e. it was replaced and
+// its last reader is gone.
+func (v *partsVersion) tryRef() bool {
+	for {
+		refs := v.refs.Load()
+		if refs <= 0 {
+			return false
+		}
+		if v.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// unref releases a reference to the version, unpinning its parts when it was the last one.
+func (v *partsVersion) unref() {
+	if v.refs.Add(-1) == 0 {
+		for _, sst := range v.parts {
+			sst.unpin()
+		}
+	}
+}
+
+// acquireVersion returns the current version with a reference that the caller should release; it returns nil if the
+// tree is closed.
+func (l *LSMTree) acquireVersion() *partsVersion {
+	for {
+		version := l.version.Load()
+		if version == nil || version.tryRef() {
+			return version
+		}
+		// The version got replaced after it was loaded, and its last reference is gone; so the next load sees a
+		// newer version.
+	}
+}
+
+// installVersion makes a version of the given parts the current one, and releases the previous version.
+// NOTE: Caller should hold versionMux, and `parts` must not be modified afterward.
+func (l *LSMTree) installVersion(parts []*SSTable) {
+	if previous := l.version.Swap(newPartsVersion(parts)); previous != nil {
+		previous.unref()
+	}
+}
+
+// currentParts returns the parts of the current version, without taking a reference; the parts may be closed
+// concurrently, so it's only meant for callers that serialize with version changes, e.g. tests.
+func (l *LSMTree) currentParts() []*SSTable {
+	if version := l.version.Load(); version != nil {
+		return version.parts
+	}
+	return nil
+}
+
+// versionHandle releases an acquired version once, e.g. when a scan is done or garbage collected.
+type versionHandle struct {
+	once    sync.Once
+	version *partsVersion
+}
+
+// release releases the version; it's safe to call multiple times.
+func (h *versionHandle) release() {
+	h.once.Do(h.version.unref)
+}
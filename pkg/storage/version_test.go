@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPartsVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1", "1.sst")
+	require.NoError(t, writeSSTable(partInfo{id: 1}, path,
+		unversioned([]utils.BytePair{{Key: []byte("k"), Value: []byte("v")}})))
+	sst, err := NewSSTable(path)
+	require.NoError(t, err)
+
+	version := newPartsVersion([]*SSTable{sst})
+	require.True(t, version.tryRef(), "Expected a reader to share the tree's reference")
+	version.unref() // The tree's reference, e.g. when the version is replaced.
+	require.NoError(t, sst.retire())
+	val, err := sst.Get([]byte("k"))
+	assert.NoError(t, err, "Expected a retired part to stay open while a version references it")
+	assert.Equal(t, []byte("v"), val)
+
+	version.unref()
+	assert.True(t, sst.closed.Load(), "Expected the retired part to be closed with its last version")
+	assert.False(t, version.tryRef(), "Expected a released version not to be referenced again")
+}
+
+func TestLSMTree_ConcurrentReads(t *testing.T) {
+	config.SetTestFlag(t, "memtable_flush_size", "10")
+	config.SetTestFlag(t, "compaction_level0_parts", "2")
+	lsm, err := NewLSMTree(t.TempDir(), 1 /*table*/, nil /*inspector*/)
+	require.NoError(t, err)
+	t.Cleanup(func() { assert.NoError(t, lsm.Close()) })
+	const keys = 200
+	for i := range keys {
+		require.NoError(t, lsm.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v0")))
+	}
+
+	// Readers always find every key, while writes flush memtables and compactions replace parts.
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for reader := range 4 {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := reader; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := []byte(fmt.Sprintf("k%03d", i%keys))
+				_, err := lsm.Get(key)
+				assert.NoError(t, err, "Expected %q to be found", key)
+				if i%50 == 0 {
+					scanned := 0
+					for _, err := range lsm.Scan(nil /*start*/, nil /*end*/) {
+						assert.NoError(t, err)
+						scanned++
+					}
+					assert.Equal(t, keys, scanned)
+				}
+			}
+		}()
+	}
+	for round := 1; round <= 5; round++ {
+		for i := range keys {
+			require.NoError(t, lsm.Set([]byte(fmt.Sprintf("k%03d", i)), []byte("v"+strconv.Itoa(round))))
+		}
+	}
+	close(stop)
+	readers.Wait()
+
+	for i := range keys {
+		val, err := lsm.Get([]byte(fmt.Sprintf("k%03d", i)))
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v5"), val)
+	}
+}
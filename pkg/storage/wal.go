@@ -0,0 +1,257 @@
+// Kiwi appends every write to a write-ahead log (WAL) before it touches the memtable, so acknowledged writes survive
+// process crashes even though the memtable only reaches disk on flushes. Each memtable has its own log, named after
+// the part it will be flushed to (i.e. <part>.wal); once that part is committed to the manifest, the log is removed.
+//
+// Each record is framed as follows:
+//   - 4 bytes: CRC32-C checksum of the payload, little-endian.
+//   - 4 bytes: Length of the payload, little-endian.
+//   - Payload: A marshalled WalRecord protobuf message.
+//
+// A crash in the middle of an append leaves a torn record at the end of the log; replay detects it through the
+// length / checksum and truncates the log back to its last complete record.
+
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nobletooth/kiwi/pkg/utils"
+	kiwipb "github.com/nobletooth/kiwi/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// walSyncPolicy decides when appended WAL records are fsynced to disk.
+type walSyncPolicy string
+
+const (
+	walSyncAlways   walSyncPolicy = "always"   // Fsync after every appended record; the safest and slowest.
+	walSyncInterval walSyncPolicy = "interval" // Fsync in the background every --wal_sync_interval (group commit).
+	walSyncOS       walSyncPolicy = "os"       // Never fsync explicitly; the OS decides when pages are written back.
+)
+
+var (
+	walSyncPolicyFlag = flag.String("wal_sync_policy", string(walSyncInterval),
+		"When the write-ahead log is synced to disk: always, interval or os.")
+	walSyncIntervalFlag = flag.Duration("wal_sync_interval", 100*time.Millisecond,
+		"The interval between write-ahead log syncs when --wal_sync_policy is interval.")
+
+	// crc32cTable is the Castagnoli polynomial table, which has hardware support on most CPUs.
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+const (
+	walHeaderSize    = 8       // Checksum + payload length.
+	walMaxRecordSize = 1 << 30 // Any bigger record length is considered a corruption.
+)
+
+// getWalSyncPolicy returns the configured sync policy, falling back to interval on unknown values.
+func getWalSyncPolicy() walSyncPolicy {
+	switch policy := walSyncPolicy(*walSyncPolicyFlag); policy {
+	case walSyncAlways, walSyncInterval, walSyncOS:
+		return policy
+	default:
+		utils.RaiseInvariant("wal", "unknown_sync_policy", "Got an unknown WAL sync policy, using interval.",
+			"policy", policy)
+		return walSyncInterval
+	}
+}
+
+// walPath returns the path of the write-ahead log of the memtable that is going to be flushed as `part`.
+func walPath(dir string, part int64) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.wal", part))
+}
+
+// WAL is an append-only write-ahead log of a single memtable.
+type WAL struct {
+	mux    sync.Mutex // Protects the file, dirty and closed flags.
+	closed bool
+	dirty  bool // True when records were appended since the last sync.
+	file   *os.File
+	policy walSyncPolicy
+	stop   chan struct{} // Closed to stop the background syncer, if any.
+	done   chan struct{} // Closed when the background syncer has returned.
+}
+
+// OpenWAL opens the write-ahead log at the given `path` for appending, creating it if it doesn't exist.
+// Existing records should be read back with Replay before appending new ones.
+func OpenWAL(path string) (*WAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wal file %s: %w", path, err)
+	}
+	wal := &WAL{file: file, policy: getWalSyncPolicy(), closed: false}
+	if wal.policy == walSyncInterval {
+		wal.stop, wal.done = make(chan struct{}), make(chan struct{})
+		go wal.syncer(*walSyncIntervalFlag)
+	}
+	return wal, nil
+}
+
+// syncer periodically fsyncs the appended records in the background.
+func (w *WAL) syncer(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if err := w.Sync(); err != nil {
+				slog.Error("Failed to sync write-ahead log.", "path", w.file.Name(), "error", err)
+			}
+		}
+	}
+}
+
+// Replay reads back every complete record in the log, in the order they were appended, and calls `apply` on each.
+// A torn or corrupted tail is truncated, so that new records are appended right after the last valid one.
+func (w *WAL) Replay(apply func(sequence, timestamp int64, key, value []byte)) (int /*records*/, error) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.closed {
+		return 0, errors.New("wal is closed")
+	}
+
+	reader := bufio.NewReaderSize(io.NewSectionReader(w.file, 0, 1<<62), defaultBufferSize)
+	header := make([]byte, walHeaderSize)
+	validOffset, records := int64(0), 0
+	for {
+		if _, err := io.ReadFull(reader, header); errors.Is(err, io.EOF) {
+			break // Clean end of the log.
+		} else if err != nil {
+			slog.Warn("Found a torn write-ahead log record header.", "path", w.file.Name(), "offset", validOffset)
+			break
+		}
+		checksum := binary.LittleEndian.Uint32(header[0:4])
+		size := binary.LittleEndian.Uint32(header[4:8])
+		if size > walMaxRecordSize {
+			slog.Warn("Found an invalid write-ahead log record size.", "path", w.file.Name(),
+				"offset", validOffset, "size", size)
+			break
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			slog.Warn("Found a torn write-ahead log record.", "path", w.file.Name(), "offset", validOffset)
+			break
+		}
+		if crc32.Checksum(payload, crc32cTable) != checksum {
+			slog.Warn("Found a write-ahead log record with checksum mismatch.", "path", w.file.Name(),
+				"offset", validOffset)
+			break
+		}
+		record := &kiwipb.WalRecord{}
+		if err := proto.Unmarshal(payload, record); err != nil {
+			slog.Warn("Failed to unmarshal write-ahead log record.", "path", w.file.Name(),
+				"offset", validOffset, "error", err)
+			break
+		}
+		apply(record.GetSequence(), record.GetTimestamp(), record.GetKey(), record.GetValue())
+		validOffset += walHeaderSize + int64(size)
+		records++
+	}
+
+	// Drop whatever comes after the last valid record.
+	if info, err := w.file.Stat(); err != nil {
+		return records, fmt.Errorf("failed to stat wal file: %w", err)
+	} else if info.Size() > validOffset {
+		slog.Warn("Truncating write-ahead log.", "path", w.file.Name(), "from", info.Size(), "to", validOffset)
+		if err := w.file.Truncate(validOffset); err != nil {
+			return records, fmt.Errorf("failed to truncate wal file: %w", err)
+		}
+	}
+	return records, nil
+}
+
+// Append writes the given key-value pair, along with its sequence number and commit time (unix nanoseconds), as a
+// single record at the end of the log. Depending on the sync policy, the record may only be durable after the next
+// Sync.
+func (w *WAL) Append(sequence, timestamp int64, key, value []byte) error {
+	payload, err := proto.Marshal(&kiwipb.WalRecord{Key: key, Value: value, Sequence: sequence, Timestamp: timestamp})
+	if err != nil {
+		return fmt.Errorf("failed to marshal wal record: %w", err)
+	}
+	record := make([]byte, walHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], crc32.Checksum(payload, crc32cTable))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(len(payload)))
+	copy(record[walHeaderSize:], payload)
+
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.closed {
+		return errors.New("wal is closed")
+	}
+	// A single write call per record, so a crash never leaves more than one torn record behind.
+	if _, err := w.file.Write(record); err != nil {
+		return fmt.Errorf("failed to append wal record: %w", err)
+	}
+	w.dirty = true
+	if w.policy == walSyncAlways {
+		return w.syncLocked()
+	}
+	return nil
+}
+
+// Sync makes every appended record durable.
+func (w *WAL) Sync() error {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	if w.closed {
+		return errors.New("wal is closed")
+	}
+	return w.syncLocked()
+}
+
+// syncLocked fsyncs the log file if anything was appended since the last sync. NOTE: Caller should acquire lock.
+func (w *WAL) syncLocked() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal file: %w", err)
+	}
+	w.dirty = false
+	return nil
+}
+
+// Close syncs and closes the log, leaving it on disk to be replayed later.
+func (w *WAL) Close() error {
+	w.mux.Lock()
+	if w.closed {
+		w.mux.Unlock()
+		return errors.New("wal is already closed")
+	}
+	syncErr := w.syncLocked()
+	w.closed = true
+	w.mux.Unlock()
+
+	// Stop the background syncer outside the lock, as it may be waiting on it.
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+	if err := errors.Join(syncErr, w.file.Close()); err != nil {
+		return fmt.Errorf("failed to close wal: %w", err)
+	}
+	return nil
+}
+
+// Remove closes the log and deletes it from disk; used once its records are durably stored somewhere else.
+func (w *WAL) Remove() error {
+	closeErr := w.Close()
+	if err := os.Remove(w.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errors.Join(closeErr, fmt.Errorf("failed to remove wal file: %w", err))
+	}
+	return closeErr
+}
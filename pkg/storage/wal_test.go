@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"os"
+	"testing"
+
+	"github.com/nobletooth/kiwi/pkg/config"
+	"github.com/nobletooth/kiwi/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayAll opens the log at the given path and returns all of its records.
+func replayAll(t *testing.T, path string) (*WAL, []utils.BytePair) {
+	t.Helper()
+	wal, err := OpenWAL(path)
+	require.NoError(t, err)
+	var pairs []utils.BytePair
+	records, err := wal.Replay(func(sequence, timestamp int64, key, value []byte) {
+		assert.Equal(t, int64(len(pairs)+1), sequence, "Expected records to be appended with incremental sequences")
+		assert.Equal(t, 10*sequence, timestamp, "Expected records to keep their commit times")
+		pairs = append(pairs, utils.BytePair{Key: key, Value: value})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, len(pairs), records)
+	return wal, pairs
+}
+
+func TestWAL(t *testing.T) {
+	for _, policy := range []walSyncPolicy{walSyncAlways, walSyncInterval, walSyncOS} {
+		t.Run(string(policy), func(t *testing.T) {
+			config.SetTestFlag(t, "wal_sync_policy", string(policy))
+			path := walPath(t.TempDir(), 1 /*part*/)
+			expected := []utils.BytePair{
+				{Key: []byte("k1"), Value: []byte("v1")},
+				{Key: []byte("k2"), Value: []byte("v2")},
+				{Key: []byte("k1"), Value: []byte("v1*")},
+			}
+
+			{ // Append to a fresh log.
+				wal, pairs := replayAll(t, path)
+				assert.Empty(t, pairs)
+				for i, pair := range expected {
+					require.NoError(t, wal.Append(int64(i+1), int64(10*(i+1)), pair.Key, pair.Value))
+				}
+				require.NoError(t, wal.Close())
+			}
+			{ // Records are replayed in order, and new ones are appended after them.
+				wal, pairs := replayAll(t, path)
+				assert.Equal(t, expected, pairs)
+				require.NoError(t, wal.Append(4 /*sequence*/, 40 /*timestamp*/, []byte("k3"), []byte("v3")))
+				require.NoError(t, wal.Close())
+			}
+			{
+				wal, pairs := replayAll(t, path)
+				assert.Equal(t, append(expected, utils.BytePair{Key: []byte("k3"), Value: []byte("v3")}), pairs)
+				require.NoError(t, wal.Remove())
+				assert.NoFileExists(t, path)
+			}
+		})
+	}
+}
+
+func TestWAL_TornTail(t *testing.T) {
+	path := walPath(t.TempDir(), 1 /*part*/)
+	wal, _ := replayAll(t, path)
+	require.NoError(t, wal.Append(1 /*sequence*/, 10 /*timestamp*/, []byte("k1"), []byte("v1")))
+	require.NoError(t, wal.Append(2 /*sequence*/, 20 /*timestamp*/, []byte("k2"), []byte("v2")))
+	require.NoError(t, wal.Close())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	validSize := info.Size()
+
+	{ // Simulate a crash in the middle of an append.
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+		require.NoError(t, err)
+		_, err = file.Write([]byte{1, 2, 3, 4, 100, 0, 0, 0, 5, 6})
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+	}
+
+	wal, pairs := replayAll(t, path)
+	t.Cleanup(func() { assert.NoError(t, wal.Close()) })
+	assert.Equal(t, []utils.BytePair{
+		{Key: []byte("k1"), Value: []byte("v1")},
+		{Key: []byte("k2"), Value: []byte("v2")},
+	}, pairs)
+	info, err = os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, validSize, info.Size(), "Expected the torn record to be truncated")
+}
+
+func TestWAL_Corruption(t *testing.T) {
+	path := walPath(t.TempDir(), 1 /*part*/)
+	wal, _ := replayAll(t, path)
+	require.NoError(t, wal.Append(1 /*sequence*/, 10 /*timestamp*/, []byte("k1"), []byte("v1")))
+	require.NoError(t, wal.Append(2 /*sequence*/, 20 /*timestamp*/, []byte("k2"), []byte("v2")))
+	require.NoError(t, wal.Close())
+
+	{ // Flip a bit in the last record's payload.
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		data[len(data)-1] ^= 0x1
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+	}
+
+	wal, pairs := replayAll(t, path)
+	t.Cleanup(func() { assert.NoError(t, wal.Close()) })
+	assert.Equal(t, []utils.BytePair{{Key: []byte("k1"), Value: []byte("v1")}}, pairs)
+}
@@ -26,10 +26,15 @@ type Config struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Server     *Config_Server     `protobuf:"bytes,1,opt,name=server,proto3" json:"server,omitempty"`
-	Index      *Config_Index      `protobuf:"bytes,2,opt,name=index,proto3" json:"index,omitempty"`
-	BlockCache *Config_BlockCache `protobuf:"bytes,3,opt,name=block_cache,json=blockCache,proto3" json:"block_cache,omitempty"`
-	Data       *Config_Data       `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	Server        *Config_Server        `protobuf:"bytes,1,opt,name=server,proto3" json:"server,omitempty"`
+	Index         *Config_Index         `protobuf:"bytes,2,opt,name=index,proto3" json:"index,omitempty"`
+	BlockCache    *Config_BlockCache    `protobuf:"bytes,3,opt,name=block_cache,json=blockCache,proto3" json:"block_cache,omitempty"`
+	Data          *Config_Data          `protobuf:"bytes,4,opt,name=data,proto3" json:"data,omitempty"`
+	Compaction    *Config_Compaction    `protobuf:"bytes,5,opt,name=compaction,proto3" json:"compaction,omitempty"`
+	ActiveExpiry  *Config_ActiveExpiry  `protobuf:"bytes,6,opt,name=active_expiry,json=activeExpiry,proto3" json:"active_expiry,omitempty"`
+	Compression   *Config_Compression   `protobuf:"bytes,7,opt,name=compression,proto3" json:"compression,omitempty"`
+	History       *Config_History       `protobuf:"bytes,8,opt,name=history,proto3" json:"history,omitempty"`
+	MemberReclaim *Config_MemberReclaim `protobuf:"bytes,9,opt,name=member_reclaim,json=memberReclaim,proto3" json:"member_reclaim,omitempty"`
 }
 
 func (x *Config) Reset() {
@@ -92,6 +97,41 @@ func (x *Config) GetData() *Config_Data {
 	return nil
 }
 
+func (x *Config) GetCompaction() *Config_Compaction {
+	if x != nil {
+		return x.Compaction
+	}
+	return nil
+}
+
+func (x *Config) GetActiveExpiry() *Config_ActiveExpiry {
+	if x != nil {
+		return x.ActiveExpiry
+	}
+	return nil
+}
+
+func (x *Config) GetCompression() *Config_Compression {
+	if x != nil {
+		return x.Compression
+	}
+	return nil
+}
+
+func (x *Config) GetHistory() *Config_History {
+	if x != nil {
+		return x.History
+	}
+	return nil
+}
+
+func (x *Config) GetMemberReclaim() *Config_MemberReclaim {
+	if x != nil {
+		return x.MemberReclaim
+	}
+	return nil
+}
+
 type Config_Server struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -103,6 +143,11 @@ type Config_Server struct {
 	LogLevel string `protobuf:"bytes,2,opt,name=log_level,json=logLevel,proto3" json:"log_level,omitempty"`
 	// The log handler type; possible values are json, text.
 	LogHandler string `protobuf:"bytes,3,opt,name=log_handler,json=logHandler,proto3" json:"log_handler,omitempty"`
+	// The admin HTTP server address in host:port format, serving /metrics, /healthz, /readyz and /debug pages;
+	// if empty, the admin server is disabled.
+	AdminAddress string `protobuf:"bytes,4,opt,name=admin_address,json=adminAddress,proto3" json:"admin_address,omitempty"`
+	// The maximum time in duration format (e.g. 10s) to wait for in-flight commands to finish when shutting down.
+	ShutdownTimeout string `protobuf:"bytes,5,opt,name=shutdown_timeout,json=shutdownTimeout,proto3" json:"shutdown_timeout,omitempty"`
 }
 
 func (x *Config_Server) Reset() {
@@ -158,6 +203,20 @@ func (x *Config_Server) GetLogHandler() string {
 	return ""
 }
 
+func (x *Config_Server) GetAdminAddress() string {
+	if x != nil {
+		return x.AdminAddress
+	}
+	return ""
+}
+
+func (x *Config_Server) GetShutdownTimeout() string {
+	if x != nil {
+		return x.ShutdownTimeout
+	}
+	return ""
+}
+
 type Config_Index struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -222,7 +281,7 @@ type Config_BlockCache struct {
 
 	// Whether to enable the block cache.
 	Enable bool `protobuf:"varint,1,opt,name=enable,proto3" json:"enable,omitempty"`
-	// The maximum number of key values in the block cache; if zero or negative, block caching is disabled.
+	// The maximum total size in bytes of the blocks in the block cache; if zero or negative, block caching is disabled.
 	Capacity int64 `protobuf:"varint,2,opt,name=capacity,proto3" json:"capacity,omitempty"`
 	// The total number of shards in the block cache; if zero or negative, cache sharding is disabled.
 	ShardCount int64 `protobuf:"varint,3,opt,name=shard_count,json=shardCount,proto3" json:"shard_count,omitempty"`
@@ -312,6 +371,23 @@ type Config_Data struct {
 	BlockFlushSize int64 `protobuf:"varint,3,opt,name=block_flush_size,json=blockFlushSize,proto3" json:"block_flush_size,omitempty"`
 	// The size threshold in bytes to trigger a memtable flush.
 	BlockFlushSizeBytes int64 `protobuf:"varint,4,opt,name=block_flush_size_bytes,json=blockFlushSizeBytes,proto3" json:"block_flush_size_bytes,omitempty"`
+	// When the write-ahead log is synced to disk; possible values are always, interval, os.
+	WalSyncPolicy string `protobuf:"bytes,5,opt,name=wal_sync_policy,json=walSyncPolicy,proto3" json:"wal_sync_policy,omitempty"`
+	// Interval in duration format (e.g. 100ms or 1s) between write-ahead log syncs when the policy is interval.
+	WalSyncInterval string `protobuf:"bytes,6,opt,name=wal_sync_interval,json=walSyncInterval,proto3" json:"wal_sync_interval,omitempty"`
+	// The number of Redis databases, i.e. the valid range of the SELECT command's index.
+	Databases int64 `protobuf:"varint,7,opt,name=databases,proto3" json:"databases,omitempty"`
+	// Whether to verify the checksum of each block read from disk; cached blocks were verified when they were read.
+	VerifyBlockChecksums bool `protobuf:"varint,8,opt,name=verify_block_checksums,json=verifyBlockChecksums,proto3" json:"verify_block_checksums,omitempty"`
+	// The target size of data blocks in bytes; keys are prefix compressed within each block.
+	DataBlockSize int64 `protobuf:"varint,9,opt,name=data_block_size,json=dataBlockSize,proto3" json:"data_block_size,omitempty"`
+	// The maximum number of full memtables waiting to be flushed per table; writes stall when it's reached.
+	MaxImmutableMemtables int64 `protobuf:"varint,10,opt,name=max_immutable_memtables,json=maxImmutableMemtables,proto3" json:"max_immutable_memtables,omitempty"`
+	// The number of full memtables waiting to be flushed per table that slows down writes; if zero or negative,
+	// writes are never slowed down.
+	WriteSlowdownImmutableMemtables int64 `protobuf:"varint,11,opt,name=write_slowdown_immutable_memtables,json=writeSlowdownImmutableMemtables,proto3" json:"write_slowdown_immutable_memtables,omitempty"`
+	// The delay in duration format (e.g. 1ms) added to each write while writes are slowed down.
+	WriteSlowdownDelay string `protobuf:"bytes,12,opt,name=write_slowdown_delay,json=writeSlowdownDelay,proto3" json:"write_slowdown_delay,omitempty"`
 }
 
 func (x *Config_Data) Reset() {
@@ -374,6 +450,411 @@ func (x *Config_Data) GetBlockFlushSizeBytes() int64 {
 	return 0
 }
 
+func (x *Config_Data) GetWalSyncPolicy() string {
+	if x != nil {
+		return x.WalSyncPolicy
+	}
+	return ""
+}
+
+func (x *Config_Data) GetWalSyncInterval() string {
+	if x != nil {
+		return x.WalSyncInterval
+	}
+	return ""
+}
+
+func (x *Config_Data) GetDatabases() int64 {
+	if x != nil {
+		return x.Databases
+	}
+	return 0
+}
+
+func (x *Config_Data) GetVerifyBlockChecksums() bool {
+	if x != nil {
+		return x.VerifyBlockChecksums
+	}
+	return false
+}
+
+func (x *Config_Data) GetDataBlockSize() int64 {
+	if x != nil {
+		return x.DataBlockSize
+	}
+	return 0
+}
+
+func (x *Config_Data) GetMaxImmutableMemtables() int64 {
+	if x != nil {
+		return x.MaxImmutableMemtables
+	}
+	return 0
+}
+
+func (x *Config_Data) GetWriteSlowdownImmutableMemtables() int64 {
+	if x != nil {
+		return x.WriteSlowdownImmutableMemtables
+	}
+	return 0
+}
+
+func (x *Config_Data) GetWriteSlowdownDelay() string {
+	if x != nil {
+		return x.WriteSlowdownDelay
+	}
+	return ""
+}
+
+type Config_Compaction struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Whether to merge table parts in the background.
+	Enable bool `protobuf:"varint,1,opt,name=enable,proto3" json:"enable,omitempty"`
+	// Interval in duration format (e.g. 30s or 5m) between background checks for compactions.
+	Interval string `protobuf:"bytes,2,opt,name=interval,proto3" json:"interval,omitempty"`
+	// The number of level 0 parts (flushed memtables) that triggers merging them into level 1.
+	Level0Parts int64 `protobuf:"varint,3,opt,name=level0_parts,json=level0Parts,proto3" json:"level0_parts,omitempty"`
+	// The size in bytes of level 1 that triggers merging it into level 2; each next level is bigger.
+	LevelBaseBytes int64 `protobuf:"varint,4,opt,name=level_base_bytes,json=levelBaseBytes,proto3" json:"level_base_bytes,omitempty"`
+	// The size ratio between each two consecutive levels.
+	LevelMultiplier int64 `protobuf:"varint,5,opt,name=level_multiplier,json=levelMultiplier,proto3" json:"level_multiplier,omitempty"`
+	// The ratio of tombstoned and expired keys in (0.0, 1.0] that triggers merging all parts of a table into one; 0 or
+	// negative disables the trigger.
+	DeadKeysRatio float64 `protobuf:"fixed64,6,opt,name=dead_keys_ratio,json=deadKeysRatio,proto3" json:"dead_keys_ratio,omitempty"`
+}
+
+func (x *Config_Compaction) Reset() {
+	*x = Config_Compaction{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_config_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Config_Compaction) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Config_Compaction) ProtoMessage() {}
+
+func (x *Config_Compaction) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Config_Compaction.ProtoReflect.Descriptor instead.
+func (*Config_Compaction) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{0, 4}
+}
+
+func (x *Config_Compaction) GetEnable() bool {
+	if x != nil {
+		return x.Enable
+	}
+	return false
+}
+
+func (x *Config_Compaction) GetInterval() string {
+	if x != nil {
+		return x.Interval
+	}
+	return ""
+}
+
+func (x *Config_Compaction) GetLevel0Parts() int64 {
+	if x != nil {
+		return x.Level0Parts
+	}
+	return 0
+}
+
+func (x *Config_Compaction) GetLevelBaseBytes() int64 {
+	if x != nil {
+		return x.LevelBaseBytes
+	}
+	return 0
+}
+
+func (x *Config_Compaction) GetLevelMultiplier() int64 {
+	if x != nil {
+		return x.LevelMultiplier
+	}
+	return 0
+}
+
+func (x *Config_Compaction) GetDeadKeysRatio() float64 {
+	if x != nil {
+		return x.DeadKeysRatio
+	}
+	return 0
+}
+
+type Config_ActiveExpiry struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Whether to delete expired keys in the background.
+	Enable bool `protobuf:"varint,1,opt,name=enable,proto3" json:"enable,omitempty"`
+	// Interval in duration format (e.g. 100ms or 1s) between active expiry cycles.
+	Interval string `protobuf:"bytes,2,opt,name=interval,proto3" json:"interval,omitempty"`
+	// The maximum number of expired keys deleted per database in each active expiry cycle.
+	KeysPerCycle int64 `protobuf:"varint,3,opt,name=keys_per_cycle,json=keysPerCycle,proto3" json:"keys_per_cycle,omitempty"`
+}
+
+func (x *Config_ActiveExpiry) Reset() {
+	*x = Config_ActiveExpiry{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_config_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Config_ActiveExpiry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Config_ActiveExpiry) ProtoMessage() {}
+
+func (x *Config_ActiveExpiry) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Config_ActiveExpiry.ProtoReflect.Descriptor instead.
+func (*Config_ActiveExpiry) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{0, 5}
+}
+
+func (x *Config_ActiveExpiry) GetEnable() bool {
+	if x != nil {
+		return x.Enable
+	}
+	return false
+}
+
+func (x *Config_ActiveExpiry) GetInterval() string {
+	if x != nil {
+		return x.Interval
+	}
+	return ""
+}
+
+func (x *Config_ActiveExpiry) GetKeysPerCycle() int64 {
+	if x != nil {
+		return x.KeysPerCycle
+	}
+	return 0
+}
+
+type Config_Compression struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The codec of data blocks; possible values are none, snappy, lz4, zstd.
+	Codec string `protobuf:"bytes,1,opt,name=codec,proto3" json:"codec,omitempty"`
+	// Per table codecs of data blocks in table:codec form separated by commas, e.g. "1:zstd,2:none".
+	TableCodecs string `protobuf:"bytes,2,opt,name=table_codecs,json=tableCodecs,proto3" json:"table_codecs,omitempty"`
+	// The minimum ratio of uncompressed to compressed size for a data block to be stored compressed.
+	MinRatio float64 `protobuf:"fixed64,3,opt,name=min_ratio,json=minRatio,proto3" json:"min_ratio,omitempty"`
+}
+
+func (x *Config_Compression) Reset() {
+	*x = Config_Compression{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_config_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Config_Compression) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Config_Compression) ProtoMessage() {}
+
+func (x *Config_Compression) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Config_Compression.ProtoReflect.Descriptor instead.
+func (*Config_Compression) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{0, 6}
+}
+
+func (x *Config_Compression) GetCodec() string {
+	if x != nil {
+		return x.Codec
+	}
+	return ""
+}
+
+func (x *Config_Compression) GetTableCodecs() string {
+	if x != nil {
+		return x.TableCodecs
+	}
+	return ""
+}
+
+func (x *Config_Compression) GetMinRatio() float64 {
+	if x != nil {
+		return x.MinRatio
+	}
+	return 0
+}
+
+type Config_History struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// How long superseded versions are kept for time-travel reads in duration format (e.g. 1h); zero disables it.
+	Retention string `protobuf:"bytes,1,opt,name=retention,proto3" json:"retention,omitempty"`
+	// Per table retention windows in table:duration form separated by commas, e.g. "1:1h,2:0s".
+	TableRetentions string `protobuf:"bytes,2,opt,name=table_retentions,json=tableRetentions,proto3" json:"table_retentions,omitempty"`
+}
+
+func (x *Config_History) Reset() {
+	*x = Config_History{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_config_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Config_History) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Config_History) ProtoMessage() {}
+
+func (x *Config_History) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Config_History.ProtoReflect.Descriptor instead.
+func (*Config_History) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{0, 7}
+}
+
+func (x *Config_History) GetRetention() string {
+	if x != nil {
+		return x.Retention
+	}
+	return ""
+}
+
+func (x *Config_History) GetTableRetentions() string {
+	if x != nil {
+		return x.TableRetentions
+	}
+	return ""
+}
+
+type Config_MemberReclaim struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Whether to delete the members of deleted or overwritten collections in the background.
+	Enable bool `protobuf:"varint,1,opt,name=enable,proto3" json:"enable,omitempty"`
+	// Interval in duration format (e.g. 100ms or 1s) between member reclaim cycles.
+	Interval string `protobuf:"bytes,2,opt,name=interval,proto3" json:"interval,omitempty"`
+	// The maximum number of member keys deleted per database in each member reclaim cycle.
+	KeysPerCycle int64 `protobuf:"varint,3,opt,name=keys_per_cycle,json=keysPerCycle,proto3" json:"keys_per_cycle,omitempty"`
+}
+
+func (x *Config_MemberReclaim) Reset() {
+	*x = Config_MemberReclaim{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_config_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Config_MemberReclaim) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Config_MemberReclaim) ProtoMessage() {}
+
+func (x *Config_MemberReclaim) ProtoReflect() protoreflect.Message {
+	mi := &file_config_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Config_MemberReclaim.ProtoReflect.Descriptor instead.
+func (*Config_MemberReclaim) Descriptor() ([]byte, []int) {
+	return file_config_proto_rawDescGZIP(), []int{0, 8}
+}
+
+func (x *Config_MemberReclaim) GetEnable() bool {
+	if x != nil {
+		return x.Enable
+	}
+	return false
+}
+
+func (x *Config_MemberReclaim) GetInterval() string {
+	if x != nil {
+		return x.Interval
+	}
+	return ""
+}
+
+func (x *Config_MemberReclaim) GetKeysPerCycle() int64 {
+	if x != nil {
+		return x.KeysPerCycle
+	}
+	return 0
+}
+
 var file_config_proto_extTypes = []protoimpl.ExtensionInfo{
 	{
 		ExtendedType:  (*descriptorpb.FieldOptions)(nil),
@@ -399,7 +880,7 @@ var file_config_proto_rawDesc = []byte{
 	0x0a, 0x0c, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04,
 	0x6b, 0x69, 0x77, 0x69, 0x1a, 0x20, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f,
 	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x65, 0x73, 0x63, 0x72, 0x69, 0x70, 0x74, 0x6f, 0x72,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x85, 0x08, 0x0a, 0x06, 0x43, 0x6f, 0x6e, 0x66, 0x69,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xda, 0x18, 0x0a, 0x06, 0x43, 0x6f, 0x6e, 0x66, 0x69,
 	0x67, 0x12, 0x2b, 0x0a, 0x06, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28,
 	0x0b, 0x32, 0x13, 0x2e, 0x6b, 0x69, 0x77, 0x69, 0x2e, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e,
 	0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x06, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x28,
@@ -411,66 +892,199 @@ var file_config_proto_rawDesc = []byte{
 	0x6b, 0x43, 0x61, 0x63, 0x68, 0x65, 0x52, 0x0a, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x43, 0x61, 0x63,
 	0x68, 0x65, 0x12, 0x25, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b,
 	0x32, 0x11, 0x2e, 0x6b, 0x69, 0x77, 0x69, 0x2e, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x44,
-	0x61, 0x74, 0x61, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x1a, 0x92, 0x01, 0x0a, 0x06, 0x53, 0x65,
-	0x72, 0x76, 0x65, 0x72, 0x12, 0x25, 0x0a, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x09, 0x42, 0x0b, 0x8a, 0xb5, 0x18, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65,
-	0x73, 0x73, 0x52, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x2a, 0x0a, 0x09, 0x6c,
-	0x6f, 0x67, 0x5f, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x42, 0x0d,
-	0x8a, 0xb5, 0x18, 0x09, 0x6c, 0x6f, 0x67, 0x5f, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x52, 0x08, 0x6c,
-	0x6f, 0x67, 0x4c, 0x65, 0x76, 0x65, 0x6c, 0x12, 0x35, 0x0a, 0x0b, 0x6c, 0x6f, 0x67, 0x5f, 0x68,
-	0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x42, 0x14, 0x8a, 0xb5,
-	0x18, 0x10, 0x6c, 0x6f, 0x67, 0x5f, 0x68, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0x5f, 0x74, 0x79,
-	0x70, 0x65, 0x52, 0x0a, 0x6c, 0x6f, 0x67, 0x48, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0x1a, 0x9d,
-	0x01, 0x0a, 0x05, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x59, 0x0a, 0x16, 0x62, 0x66, 0x5f, 0x66,
-	0x61, 0x6c, 0x73, 0x65, 0x5f, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x72, 0x61,
-	0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x42, 0x24, 0x8a, 0xb5, 0x18, 0x20, 0x62, 0x6c,
-	0x6f, 0x6f, 0x6d, 0x5f, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x5f, 0x66, 0x61, 0x6c, 0x73, 0x65,
-	0x5f, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x52, 0x13,
-	0x62, 0x66, 0x46, 0x61, 0x6c, 0x73, 0x65, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65, 0x52,
-	0x61, 0x74, 0x65, 0x12, 0x39, 0x0a, 0x0b, 0x62, 0x66, 0x5f, 0x6d, 0x69, 0x6e, 0x5f, 0x6b, 0x65,
-	0x79, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x42, 0x19, 0x8a, 0xb5, 0x18, 0x15, 0x62, 0x6c,
-	0x6f, 0x6f, 0x6d, 0x5f, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x5f, 0x6d, 0x69, 0x6e, 0x5f, 0x6b,
-	0x65, 0x79, 0x73, 0x52, 0x09, 0x62, 0x66, 0x4d, 0x69, 0x6e, 0x4b, 0x65, 0x79, 0x73, 0x1a, 0x9b,
-	0x02, 0x0a, 0x0a, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x43, 0x61, 0x63, 0x68, 0x65, 0x12, 0x2e, 0x0a,
-	0x06, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x42, 0x16, 0x8a,
-	0xb5, 0x18, 0x12, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f,
-	0x63, 0x61, 0x63, 0x68, 0x65, 0x52, 0x06, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x12, 0x34, 0x0a,
-	0x08, 0x63, 0x61, 0x70, 0x61, 0x63, 0x69, 0x74, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x42,
-	0x18, 0x8a, 0xb5, 0x18, 0x14, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x63, 0x61, 0x63, 0x68, 0x65,
-	0x5f, 0x63, 0x61, 0x70, 0x61, 0x63, 0x69, 0x74, 0x79, 0x52, 0x08, 0x63, 0x61, 0x70, 0x61, 0x63,
-	0x69, 0x74, 0x79, 0x12, 0x3c, 0x0a, 0x0b, 0x73, 0x68, 0x61, 0x72, 0x64, 0x5f, 0x63, 0x6f, 0x75,
-	0x6e, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x42, 0x1b, 0x8a, 0xb5, 0x18, 0x17, 0x62, 0x6c,
-	0x6f, 0x63, 0x6b, 0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x64, 0x5f,
-	0x63, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x0a, 0x73, 0x68, 0x61, 0x72, 0x64, 0x43, 0x6f, 0x75, 0x6e,
-	0x74, 0x12, 0x42, 0x0a, 0x0d, 0x74, 0x69, 0x63, 0x6b, 0x5f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76,
-	0x61, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x42, 0x1d, 0x8a, 0xb5, 0x18, 0x19, 0x62, 0x6c,
-	0x6f, 0x63, 0x6b, 0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x5f, 0x74, 0x69, 0x63, 0x6b, 0x5f, 0x69,
-	0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x52, 0x0c, 0x74, 0x69, 0x63, 0x6b, 0x49, 0x6e, 0x74,
-	0x65, 0x72, 0x76, 0x61, 0x6c, 0x12, 0x25, 0x0a, 0x03, 0x74, 0x74, 0x6c, 0x18, 0x05, 0x20, 0x01,
-	0x28, 0x09, 0x42, 0x13, 0x8a, 0xb5, 0x18, 0x0f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x63, 0x61,
-	0x63, 0x68, 0x65, 0x5f, 0x74, 0x74, 0x6c, 0x52, 0x03, 0x74, 0x74, 0x6c, 0x1a, 0xef, 0x01, 0x0a,
-	0x04, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1e, 0x0a, 0x03, 0x64, 0x69, 0x72, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x42, 0x0c, 0x8a, 0xb5, 0x18, 0x08, 0x64, 0x61, 0x74, 0x61, 0x5f, 0x64, 0x69, 0x72,
-	0x52, 0x03, 0x64, 0x69, 0x72, 0x12, 0x30, 0x0a, 0x0b, 0x74, 0x65, 0x6d, 0x70, 0x5f, 0x66, 0x6f,
-	0x6c, 0x64, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x42, 0x0f, 0x8a, 0xb5, 0x18, 0x0b,
-	0x74, 0x65, 0x6d, 0x70, 0x5f, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x52, 0x0a, 0x74, 0x65, 0x6d,
-	0x70, 0x46, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x41, 0x0a, 0x10, 0x62, 0x6c, 0x6f, 0x63, 0x6b,
-	0x5f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28,
-	0x03, 0x42, 0x17, 0x8a, 0xb5, 0x18, 0x13, 0x6d, 0x65, 0x6d, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f,
-	0x66, 0x6c, 0x75, 0x73, 0x68, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x52, 0x0e, 0x62, 0x6c, 0x6f, 0x63,
-	0x6b, 0x46, 0x6c, 0x75, 0x73, 0x68, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x52, 0x0a, 0x16, 0x62, 0x6c,
-	0x6f, 0x63, 0x6b, 0x5f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x5f, 0x62,
-	0x79, 0x74, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x42, 0x1d, 0x8a, 0xb5, 0x18, 0x19,
-	0x6d, 0x65, 0x6d, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x5f, 0x73,
-	0x69, 0x7a, 0x65, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x52, 0x13, 0x62, 0x6c, 0x6f, 0x63, 0x6b,
-	0x46, 0x6c, 0x75, 0x73, 0x68, 0x53, 0x69, 0x7a, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x3a, 0x3c,
-	0x0a, 0x09, 0x66, 0x6c, 0x61, 0x67, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1d, 0x2e, 0x67, 0x6f,
-	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x46, 0x69,
-	0x65, 0x6c, 0x64, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0xd1, 0x86, 0x03, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x08, 0x66, 0x6c, 0x61, 0x67, 0x4e, 0x61, 0x6d, 0x65, 0x42, 0x22, 0x5a, 0x20,
-	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e, 0x6f, 0x62, 0x6c, 0x65,
-	0x74, 0x6f, 0x6f, 0x74, 0x68, 0x2f, 0x6b, 0x69, 0x77, 0x69, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x61, 0x74, 0x61, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x12, 0x37, 0x0a, 0x0a, 0x63, 0x6f, 0x6d,
+	0x70, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e,
+	0x6b, 0x69, 0x77, 0x69, 0x2e, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x43, 0x6f, 0x6d, 0x70,
+	0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0a, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0x69,
+	0x6f, 0x6e, 0x12, 0x3e, 0x0a, 0x0d, 0x61, 0x63, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x65, 0x78, 0x70,
+	0x69, 0x72, 0x79, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x6b, 0x69, 0x77, 0x69,
+	0x2e, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x41, 0x63, 0x74, 0x69, 0x76, 0x65, 0x45, 0x78,
+	0x70, 0x69, 0x72, 0x79, 0x52, 0x0c, 0x61, 0x63, 0x74, 0x69, 0x76, 0x65, 0x45, 0x78, 0x70, 0x69,
+	0x72, 0x79, 0x12, 0x3a, 0x0a, 0x0b, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f,
+	0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x6b, 0x69, 0x77, 0x69, 0x2e, 0x43,
+	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f,
+	0x6e, 0x52, 0x0b, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x2e,
+	0x0a, 0x07, 0x68, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x14, 0x2e, 0x6b, 0x69, 0x77, 0x69, 0x2e, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x48, 0x69,
+	0x73, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x07, 0x68, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x12, 0x41,
+	0x0a, 0x0e, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x5f, 0x72, 0x65, 0x63, 0x6c, 0x61, 0x69, 0x6d,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x6b, 0x69, 0x77, 0x69, 0x2e, 0x43, 0x6f,
+	0x6e, 0x66, 0x69, 0x67, 0x2e, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x52, 0x65, 0x63, 0x6c, 0x61,
+	0x69, 0x6d, 0x52, 0x0d, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x52, 0x65, 0x63, 0x6c, 0x61, 0x69,
+	0x6d, 0x1a, 0x8b, 0x02, 0x0a, 0x06, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x25, 0x0a, 0x07,
+	0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x42, 0x0b, 0x8a,
+	0xb5, 0x18, 0x07, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x52, 0x07, 0x61, 0x64, 0x64, 0x72,
+	0x65, 0x73, 0x73, 0x12, 0x2a, 0x0a, 0x09, 0x6c, 0x6f, 0x67, 0x5f, 0x6c, 0x65, 0x76, 0x65, 0x6c,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x42, 0x0d, 0x8a, 0xb5, 0x18, 0x09, 0x6c, 0x6f, 0x67, 0x5f,
+	0x6c, 0x65, 0x76, 0x65, 0x6c, 0x52, 0x08, 0x6c, 0x6f, 0x67, 0x4c, 0x65, 0x76, 0x65, 0x6c, 0x12,
+	0x35, 0x0a, 0x0b, 0x6c, 0x6f, 0x67, 0x5f, 0x68, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x42, 0x14, 0x8a, 0xb5, 0x18, 0x10, 0x6c, 0x6f, 0x67, 0x5f, 0x68, 0x61,
+	0x6e, 0x64, 0x6c, 0x65, 0x72, 0x5f, 0x74, 0x79, 0x70, 0x65, 0x52, 0x0a, 0x6c, 0x6f, 0x67, 0x48,
+	0x61, 0x6e, 0x64, 0x6c, 0x65, 0x72, 0x12, 0x36, 0x0a, 0x0d, 0x61, 0x64, 0x6d, 0x69, 0x6e, 0x5f,
+	0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x42, 0x11, 0x8a,
+	0xb5, 0x18, 0x0d, 0x61, 0x64, 0x6d, 0x69, 0x6e, 0x5f, 0x61, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73,
+	0x52, 0x0c, 0x61, 0x64, 0x6d, 0x69, 0x6e, 0x41, 0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x3f,
+	0x0a, 0x10, 0x73, 0x68, 0x75, 0x74, 0x64, 0x6f, 0x77, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x6f,
+	0x75, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x42, 0x14, 0x8a, 0xb5, 0x18, 0x10, 0x73, 0x68,
+	0x75, 0x74, 0x64, 0x6f, 0x77, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x52, 0x0f,
+	0x73, 0x68, 0x75, 0x74, 0x64, 0x6f, 0x77, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x1a,
+	0x9d, 0x01, 0x0a, 0x05, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x59, 0x0a, 0x16, 0x62, 0x66, 0x5f,
+	0x66, 0x61, 0x6c, 0x73, 0x65, 0x5f, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x72,
+	0x61, 0x74, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x01, 0x42, 0x24, 0x8a, 0xb5, 0x18, 0x20, 0x62,
+	0x6c, 0x6f, 0x6f, 0x6d, 0x5f, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x5f, 0x66, 0x61, 0x6c, 0x73,
+	0x65, 0x5f, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x72, 0x61, 0x74, 0x65, 0x52,
+	0x13, 0x62, 0x66, 0x46, 0x61, 0x6c, 0x73, 0x65, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x76, 0x65,
+	0x52, 0x61, 0x74, 0x65, 0x12, 0x39, 0x0a, 0x0b, 0x62, 0x66, 0x5f, 0x6d, 0x69, 0x6e, 0x5f, 0x6b,
+	0x65, 0x79, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x42, 0x19, 0x8a, 0xb5, 0x18, 0x15, 0x62,
+	0x6c, 0x6f, 0x6f, 0x6d, 0x5f, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x5f, 0x6d, 0x69, 0x6e, 0x5f,
+	0x6b, 0x65, 0x79, 0x73, 0x52, 0x09, 0x62, 0x66, 0x4d, 0x69, 0x6e, 0x4b, 0x65, 0x79, 0x73, 0x1a,
+	0x9b, 0x02, 0x0a, 0x0a, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x43, 0x61, 0x63, 0x68, 0x65, 0x12, 0x2e,
+	0x0a, 0x06, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x42, 0x16,
+	0x8a, 0xb5, 0x18, 0x12, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x62, 0x6c, 0x6f, 0x63, 0x6b,
+	0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x52, 0x06, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x12, 0x34,
+	0x0a, 0x08, 0x63, 0x61, 0x70, 0x61, 0x63, 0x69, 0x74, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03,
+	0x42, 0x18, 0x8a, 0xb5, 0x18, 0x14, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x63, 0x61, 0x63, 0x68,
+	0x65, 0x5f, 0x63, 0x61, 0x70, 0x61, 0x63, 0x69, 0x74, 0x79, 0x52, 0x08, 0x63, 0x61, 0x70, 0x61,
+	0x63, 0x69, 0x74, 0x79, 0x12, 0x3c, 0x0a, 0x0b, 0x73, 0x68, 0x61, 0x72, 0x64, 0x5f, 0x63, 0x6f,
+	0x75, 0x6e, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x42, 0x1b, 0x8a, 0xb5, 0x18, 0x17, 0x62,
+	0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x64,
+	0x5f, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x52, 0x0a, 0x73, 0x68, 0x61, 0x72, 0x64, 0x43, 0x6f, 0x75,
+	0x6e, 0x74, 0x12, 0x42, 0x0a, 0x0d, 0x74, 0x69, 0x63, 0x6b, 0x5f, 0x69, 0x6e, 0x74, 0x65, 0x72,
+	0x76, 0x61, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x42, 0x1d, 0x8a, 0xb5, 0x18, 0x19, 0x62,
+	0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x63, 0x61, 0x63, 0x68, 0x65, 0x5f, 0x74, 0x69, 0x63, 0x6b, 0x5f,
+	0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x52, 0x0c, 0x74, 0x69, 0x63, 0x6b, 0x49, 0x6e,
+	0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x12, 0x25, 0x0a, 0x03, 0x74, 0x74, 0x6c, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x09, 0x42, 0x13, 0x8a, 0xb5, 0x18, 0x0f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x63,
+	0x61, 0x63, 0x68, 0x65, 0x5f, 0x74, 0x74, 0x6c, 0x52, 0x03, 0x74, 0x74, 0x6c, 0x1a, 0xc1, 0x06,
+	0x0a, 0x04, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1e, 0x0a, 0x03, 0x64, 0x69, 0x72, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x42, 0x0c, 0x8a, 0xb5, 0x18, 0x08, 0x64, 0x61, 0x74, 0x61, 0x5f, 0x64, 0x69,
+	0x72, 0x52, 0x03, 0x64, 0x69, 0x72, 0x12, 0x30, 0x0a, 0x0b, 0x74, 0x65, 0x6d, 0x70, 0x5f, 0x66,
+	0x6f, 0x6c, 0x64, 0x65, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x42, 0x0f, 0x8a, 0xb5, 0x18,
+	0x0b, 0x74, 0x65, 0x6d, 0x70, 0x5f, 0x66, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x52, 0x0a, 0x74, 0x65,
+	0x6d, 0x70, 0x46, 0x6f, 0x6c, 0x64, 0x65, 0x72, 0x12, 0x41, 0x0a, 0x10, 0x62, 0x6c, 0x6f, 0x63,
+	0x6b, 0x5f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x03, 0x42, 0x17, 0x8a, 0xb5, 0x18, 0x13, 0x6d, 0x65, 0x6d, 0x74, 0x61, 0x62, 0x6c, 0x65,
+	0x5f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x52, 0x0e, 0x62, 0x6c, 0x6f,
+	0x63, 0x6b, 0x46, 0x6c, 0x75, 0x73, 0x68, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x52, 0x0a, 0x16, 0x62,
+	0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x5f,
+	0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x42, 0x1d, 0x8a, 0xb5, 0x18,
+	0x19, 0x6d, 0x65, 0x6d, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x66, 0x6c, 0x75, 0x73, 0x68, 0x5f,
+	0x73, 0x69, 0x7a, 0x65, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x52, 0x13, 0x62, 0x6c, 0x6f, 0x63,
+	0x6b, 0x46, 0x6c, 0x75, 0x73, 0x68, 0x53, 0x69, 0x7a, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12,
+	0x3b, 0x0a, 0x0f, 0x77, 0x61, 0x6c, 0x5f, 0x73, 0x79, 0x6e, 0x63, 0x5f, 0x70, 0x6f, 0x6c, 0x69,
+	0x63, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x42, 0x13, 0x8a, 0xb5, 0x18, 0x0f, 0x77, 0x61,
+	0x6c, 0x5f, 0x73, 0x79, 0x6e, 0x63, 0x5f, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x52, 0x0d, 0x77,
+	0x61, 0x6c, 0x53, 0x79, 0x6e, 0x63, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x12, 0x41, 0x0a, 0x11,
+	0x77, 0x61, 0x6c, 0x5f, 0x73, 0x79, 0x6e, 0x63, 0x5f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61,
+	0x6c, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x42, 0x15, 0x8a, 0xb5, 0x18, 0x11, 0x77, 0x61, 0x6c,
+	0x5f, 0x73, 0x79, 0x6e, 0x63, 0x5f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x52, 0x0f,
+	0x77, 0x61, 0x6c, 0x53, 0x79, 0x6e, 0x63, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x12,
+	0x2b, 0x0a, 0x09, 0x64, 0x61, 0x74, 0x61, 0x62, 0x61, 0x73, 0x65, 0x73, 0x18, 0x07, 0x20, 0x01,
+	0x28, 0x03, 0x42, 0x0d, 0x8a, 0xb5, 0x18, 0x09, 0x64, 0x61, 0x74, 0x61, 0x62, 0x61, 0x73, 0x65,
+	0x73, 0x52, 0x09, 0x64, 0x61, 0x74, 0x61, 0x62, 0x61, 0x73, 0x65, 0x73, 0x12, 0x50, 0x0a, 0x16,
+	0x76, 0x65, 0x72, 0x69, 0x66, 0x79, 0x5f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x63, 0x68, 0x65,
+	0x63, 0x6b, 0x73, 0x75, 0x6d, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x08, 0x42, 0x1a, 0x8a, 0xb5,
+	0x18, 0x16, 0x76, 0x65, 0x72, 0x69, 0x66, 0x79, 0x5f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x63,
+	0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x73, 0x52, 0x14, 0x76, 0x65, 0x72, 0x69, 0x66, 0x79,
+	0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x43, 0x68, 0x65, 0x63, 0x6b, 0x73, 0x75, 0x6d, 0x73, 0x12, 0x3b,
+	0x0a, 0x0f, 0x64, 0x61, 0x74, 0x61, 0x5f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x73, 0x69, 0x7a,
+	0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x42, 0x13, 0x8a, 0xb5, 0x18, 0x0f, 0x64, 0x61, 0x74,
+	0x61, 0x5f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x73, 0x69, 0x7a, 0x65, 0x52, 0x0d, 0x64, 0x61,
+	0x74, 0x61, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x53, 0x0a, 0x17, 0x6d,
+	0x61, 0x78, 0x5f, 0x69, 0x6d, 0x6d, 0x75, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x6d, 0x65, 0x6d,
+	0x74, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x03, 0x42, 0x1b, 0x8a, 0xb5,
+	0x18, 0x17, 0x6d, 0x61, 0x78, 0x5f, 0x69, 0x6d, 0x6d, 0x75, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f,
+	0x6d, 0x65, 0x6d, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x52, 0x15, 0x6d, 0x61, 0x78, 0x49, 0x6d,
+	0x6d, 0x75, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x4d, 0x65, 0x6d, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x73,
+	0x12, 0x73, 0x0a, 0x22, 0x77, 0x72, 0x69, 0x74, 0x65, 0x5f, 0x73, 0x6c, 0x6f, 0x77, 0x64, 0x6f,
+	0x77, 0x6e, 0x5f, 0x69, 0x6d, 0x6d, 0x75, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x6d, 0x65, 0x6d,
+	0x74, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x03, 0x42, 0x26, 0x8a, 0xb5,
+	0x18, 0x22, 0x77, 0x72, 0x69, 0x74, 0x65, 0x5f, 0x73, 0x6c, 0x6f, 0x77, 0x64, 0x6f, 0x77, 0x6e,
+	0x5f, 0x69, 0x6d, 0x6d, 0x75, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x6d, 0x65, 0x6d, 0x74, 0x61,
+	0x62, 0x6c, 0x65, 0x73, 0x52, 0x1f, 0x77, 0x72, 0x69, 0x74, 0x65, 0x53, 0x6c, 0x6f, 0x77, 0x64,
+	0x6f, 0x77, 0x6e, 0x49, 0x6d, 0x6d, 0x75, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x4d, 0x65, 0x6d, 0x74,
+	0x61, 0x62, 0x6c, 0x65, 0x73, 0x12, 0x4a, 0x0a, 0x14, 0x77, 0x72, 0x69, 0x74, 0x65, 0x5f, 0x73,
+	0x6c, 0x6f, 0x77, 0x64, 0x6f, 0x77, 0x6e, 0x5f, 0x64, 0x65, 0x6c, 0x61, 0x79, 0x18, 0x0c, 0x20,
+	0x01, 0x28, 0x09, 0x42, 0x18, 0x8a, 0xb5, 0x18, 0x14, 0x77, 0x72, 0x69, 0x74, 0x65, 0x5f, 0x73,
+	0x6c, 0x6f, 0x77, 0x64, 0x6f, 0x77, 0x6e, 0x5f, 0x64, 0x65, 0x6c, 0x61, 0x79, 0x52, 0x12, 0x77,
+	0x72, 0x69, 0x74, 0x65, 0x53, 0x6c, 0x6f, 0x77, 0x64, 0x6f, 0x77, 0x6e, 0x44, 0x65, 0x6c, 0x61,
+	0x79, 0x1a, 0x8f, 0x03, 0x0a, 0x0a, 0x43, 0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e,
+	0x12, 0x2d, 0x0a, 0x06, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08,
+	0x42, 0x15, 0x8a, 0xb5, 0x18, 0x11, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x63, 0x6f, 0x6d,
+	0x70, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x12,
+	0x33, 0x0a, 0x08, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x09, 0x42, 0x17, 0x8a, 0xb5, 0x18, 0x13, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0x69, 0x6f,
+	0x6e, 0x5f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x52, 0x08, 0x69, 0x6e, 0x74, 0x65,
+	0x72, 0x76, 0x61, 0x6c, 0x12, 0x3e, 0x0a, 0x0c, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x30, 0x5f, 0x70,
+	0x61, 0x72, 0x74, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x42, 0x1b, 0x8a, 0xb5, 0x18, 0x17,
+	0x63, 0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x6c, 0x65, 0x76, 0x65, 0x6c,
+	0x30, 0x5f, 0x70, 0x61, 0x72, 0x74, 0x73, 0x52, 0x0b, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x30, 0x50,
+	0x61, 0x72, 0x74, 0x73, 0x12, 0x49, 0x0a, 0x10, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x5f, 0x62, 0x61,
+	0x73, 0x65, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x42, 0x1f,
+	0x8a, 0xb5, 0x18, 0x1b, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x6c,
+	0x65, 0x76, 0x65, 0x6c, 0x5f, 0x62, 0x61, 0x73, 0x65, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x52,
+	0x0e, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x42, 0x61, 0x73, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12,
+	0x4a, 0x0a, 0x10, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x5f, 0x6d, 0x75, 0x6c, 0x74, 0x69, 0x70, 0x6c,
+	0x69, 0x65, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x42, 0x1f, 0x8a, 0xb5, 0x18, 0x1b, 0x63,
+	0x6f, 0x6d, 0x70, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x5f,
+	0x6d, 0x75, 0x6c, 0x74, 0x69, 0x70, 0x6c, 0x69, 0x65, 0x72, 0x52, 0x0f, 0x6c, 0x65, 0x76, 0x65,
+	0x6c, 0x4d, 0x75, 0x6c, 0x74, 0x69, 0x70, 0x6c, 0x69, 0x65, 0x72, 0x12, 0x46, 0x0a, 0x0f, 0x64,
+	0x65, 0x61, 0x64, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x5f, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x01, 0x42, 0x1e, 0x8a, 0xb5, 0x18, 0x1a, 0x63, 0x6f, 0x6d, 0x70, 0x61, 0x63,
+	0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x64, 0x65, 0x61, 0x64, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x5f, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x52, 0x0d, 0x64, 0x65, 0x61, 0x64, 0x4b, 0x65, 0x79, 0x73, 0x52, 0x61,
+	0x74, 0x69, 0x6f, 0x1a, 0xc0, 0x01, 0x0a, 0x0c, 0x41, 0x63, 0x74, 0x69, 0x76, 0x65, 0x45, 0x78,
+	0x70, 0x69, 0x72, 0x79, 0x12, 0x30, 0x0a, 0x06, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x08, 0x42, 0x18, 0x8a, 0xb5, 0x18, 0x14, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65,
+	0x5f, 0x61, 0x63, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x65, 0x78, 0x70, 0x69, 0x72, 0x79, 0x52, 0x06,
+	0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x12, 0x36, 0x0a, 0x08, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76,
+	0x61, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x42, 0x1a, 0x8a, 0xb5, 0x18, 0x16, 0x61, 0x63,
+	0x74, 0x69, 0x76, 0x65, 0x5f, 0x65, 0x78, 0x70, 0x69, 0x72, 0x79, 0x5f, 0x69, 0x6e, 0x74, 0x65,
+	0x72, 0x76, 0x61, 0x6c, 0x52, 0x08, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x12, 0x46,
+	0x0a, 0x0e, 0x6b, 0x65, 0x79, 0x73, 0x5f, 0x70, 0x65, 0x72, 0x5f, 0x63, 0x79, 0x63, 0x6c, 0x65,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x42, 0x20, 0x8a, 0xb5, 0x18, 0x1c, 0x61, 0x63, 0x74, 0x69,
+	0x76, 0x65, 0x5f, 0x65, 0x78, 0x70, 0x69, 0x72, 0x79, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x5f, 0x70,
+	0x65, 0x72, 0x5f, 0x63, 0x79, 0x63, 0x6c, 0x65, 0x52, 0x0c, 0x6b, 0x65, 0x79, 0x73, 0x50, 0x65,
+	0x72, 0x43, 0x79, 0x63, 0x6c, 0x65, 0x1a, 0xb9, 0x01, 0x0a, 0x0b, 0x43, 0x6f, 0x6d, 0x70, 0x72,
+	0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x2b, 0x0a, 0x05, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x09, 0x42, 0x15, 0x8a, 0xb5, 0x18, 0x11, 0x62, 0x6c, 0x6f, 0x63, 0x6b,
+	0x5f, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x52, 0x05, 0x63, 0x6f,
+	0x64, 0x65, 0x63, 0x12, 0x3f, 0x0a, 0x0c, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x63, 0x6f, 0x64,
+	0x65, 0x63, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x42, 0x1c, 0x8a, 0xb5, 0x18, 0x18, 0x62,
+	0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x5f, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x52, 0x0b, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x43, 0x6f,
+	0x64, 0x65, 0x63, 0x73, 0x12, 0x3c, 0x0a, 0x09, 0x6d, 0x69, 0x6e, 0x5f, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x42, 0x1f, 0x8a, 0xb5, 0x18, 0x1b, 0x62, 0x6c, 0x6f,
+	0x63, 0x6b, 0x5f, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x6d,
+	0x69, 0x6e, 0x5f, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x52, 0x08, 0x6d, 0x69, 0x6e, 0x52, 0x61, 0x74,
+	0x69, 0x6f, 0x1a, 0x87, 0x01, 0x0a, 0x07, 0x48, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x12, 0x33,
+	0x0a, 0x09, 0x72, 0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x42, 0x15, 0x8a, 0xb5, 0x18, 0x11, 0x68, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x5f, 0x72,
+	0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09, 0x72, 0x65, 0x74, 0x65, 0x6e, 0x74,
+	0x69, 0x6f, 0x6e, 0x12, 0x47, 0x0a, 0x10, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x72, 0x65, 0x74,
+	0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x42, 0x1c, 0x8a,
+	0xb5, 0x18, 0x18, 0x68, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x5f, 0x72, 0x65, 0x74, 0x65, 0x6e,
+	0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x52, 0x0f, 0x74, 0x61, 0x62,
+	0x6c, 0x65, 0x52, 0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x1a, 0xc4, 0x01, 0x0a,
+	0x0d, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x52, 0x65, 0x63, 0x6c, 0x61, 0x69, 0x6d, 0x12, 0x31,
+	0x0a, 0x06, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x42, 0x19,
+	0x8a, 0xb5, 0x18, 0x15, 0x65, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x5f, 0x6d, 0x65, 0x6d, 0x62, 0x65,
+	0x72, 0x5f, 0x72, 0x65, 0x63, 0x6c, 0x61, 0x69, 0x6d, 0x52, 0x06, 0x65, 0x6e, 0x61, 0x62, 0x6c,
+	0x65, 0x12, 0x37, 0x0a, 0x08, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x42, 0x1b, 0x8a, 0xb5, 0x18, 0x17, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x5f,
+	0x72, 0x65, 0x63, 0x6c, 0x61, 0x69, 0x6d, 0x5f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c,
+	0x52, 0x08, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x76, 0x61, 0x6c, 0x12, 0x47, 0x0a, 0x0e, 0x6b, 0x65,
+	0x79, 0x73, 0x5f, 0x70, 0x65, 0x72, 0x5f, 0x63, 0x79, 0x63, 0x6c, 0x65, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x03, 0x42, 0x21, 0x8a, 0xb5, 0x18, 0x1d, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x5f, 0x72,
+	0x65, 0x63, 0x6c, 0x61, 0x69, 0x6d, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x5f, 0x70, 0x65, 0x72, 0x5f,
+	0x63, 0x79, 0x63, 0x6c, 0x65, 0x52, 0x0c, 0x6b, 0x65, 0x79, 0x73, 0x50, 0x65, 0x72, 0x43, 0x79,
+	0x63, 0x6c, 0x65, 0x3a, 0x3c, 0x0a, 0x09, 0x66, 0x6c, 0x61, 0x67, 0x5f, 0x6e, 0x61, 0x6d, 0x65,
+	0x12, 0x1d, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x46, 0x69, 0x65, 0x6c, 0x64, 0x4f, 0x70, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18,
+	0xd1, 0x86, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x66, 0x6c, 0x61, 0x67, 0x4e, 0x61, 0x6d,
+	0x65, 0x42, 0x22, 0x5a, 0x20, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
+	0x6e, 0x6f, 0x62, 0x6c, 0x65, 0x74, 0x6f, 0x6f, 0x74, 0x68, 0x2f, 0x6b, 0x69, 0x77, 0x69, 0x2f,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -485,26 +1099,36 @@ func file_config_proto_rawDescGZIP() []byte {
 	return file_config_proto_rawDescData
 }
 
-var file_config_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_config_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_config_proto_goTypes = []interface{}{
 	(*Config)(nil),                    // 0: kiwi.Config
 	(*Config_Server)(nil),             // 1: kiwi.Config.Server
 	(*Config_Index)(nil),              // 2: kiwi.Config.Index
 	(*Config_BlockCache)(nil),         // 3: kiwi.Config.BlockCache
 	(*Config_Data)(nil),               // 4: kiwi.Config.Data
-	(*descriptorpb.FieldOptions)(nil), // 5: google.protobuf.FieldOptions
+	(*Config_Compaction)(nil),         // 5: kiwi.Config.Compaction
+	(*Config_ActiveExpiry)(nil),       // 6: kiwi.Config.ActiveExpiry
+	(*Config_Compression)(nil),        // 7: kiwi.Config.Compression
+	(*Config_History)(nil),            // 8: kiwi.Config.History
+	(*Config_MemberReclaim)(nil),      // 9: kiwi.Config.MemberReclaim
+	(*descriptorpb.FieldOptions)(nil), // 10: google.protobuf.FieldOptions
 }
 var file_config_proto_depIdxs = []int32{
-	1, // 0: kiwi.Config.server:type_name -> kiwi.Config.Server
-	2, // 1: kiwi.Config.index:type_name -> kiwi.Config.Index
-	3, // 2: kiwi.Config.block_cache:type_name -> kiwi.Config.BlockCache
-	4, // 3: kiwi.Config.data:type_name -> kiwi.Config.Data
-	5, // 4: kiwi.flag_name:extendee -> google.protobuf.FieldOptions
-	5, // [5:5] is the sub-list for method output_type
-	5, // [5:5] is the sub-list for method input_type
-	5, // [5:5] is the sub-list for extension type_name
-	4, // [4:5] is the sub-list for extension extendee
-	0, // [0:4] is the sub-list for field type_name
+	1,  // 0: kiwi.Config.server:type_name -> kiwi.Config.Server
+	2,  // 1: kiwi.Config.index:type_name -> kiwi.Config.Index
+	3,  // 2: kiwi.Config.block_cache:type_name -> kiwi.Config.BlockCache
+	4,  // 3: kiwi.Config.data:type_name -> kiwi.Config.Data
+	5,  // 4: kiwi.Config.compaction:type_name -> kiwi.Config.Compaction
+	6,  // 5: kiwi.Config.active_expiry:type_name -> kiwi.Config.ActiveExpiry
+	7,  // 6: kiwi.Config.compression:type_name -> kiwi.Config.Compression
+	8,  // 7: kiwi.Config.history:type_name -> kiwi.Config.History
+	9,  // 8: kiwi.Config.member_reclaim:type_name -> kiwi.Config.MemberReclaim
+	10, // 9: kiwi.flag_name:extendee -> google.protobuf.FieldOptions
+	10, // [10:10] is the sub-list for method output_type
+	10, // [10:10] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	9,  // [9:10] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
 }
 
 func init() { file_config_proto_init() }
@@ -573,6 +1197,66 @@ func file_config_proto_init() {
 				return nil
 			}
 		}
+		file_config_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Config_Compaction); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_config_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Config_ActiveExpiry); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_config_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Config_Compression); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_config_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Config_History); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_config_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Config_MemberReclaim); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -580,7 +1264,7 @@ func file_config_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_config_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   5,
+			NumMessages:   10,
 			NumExtensions: 1,
 			NumServices:   0,
 		},
@@ -12,7 +12,24 @@
 //            Skip index, which is a sparse index of the first key of each block and its offsets.
 //            BF index, an optional Bloom filter for quick key existence checks per each block.
 //  - Data  : Actual key-value pairs stripped of their common prefixes, organized in blocks.
-//            Each block contains a list of keys and their corresponding values, sorted by key.
+//            Each block contains a list of keys and their corresponding values, sorted by key. A key may have
+//            multiple versions, newest first, each tagged with the sequence number of its write (i.e. an internal
+//            key) and its commit time; the versions of a key are never split across blocks. Superseded versions are
+//            kept for as long as snapshots or reads within the table's history retention window can see them.
+//
+//  Parts are periodically merged together by compactions; each part belongs to a level, where level 0 holds the
+//  flushed memtables and each higher level holds the merged result of the lower ones. A part may be empty when
+//  a compaction drops all of its keys.
+//
+// 3. WAL  : Every write is appended to a write-ahead log before it reaches the memtable, so acknowledged writes
+//           survive crashes. Each memtable has its own log named after the part it will be flushed to,
+//           i.e. <part>.wal, which is removed once that part is durably written.
+// 4. MANIFEST: Each table has an append-only MANIFEST file of ManifestEdit blocks, which is the source of truth for
+//           the set of live parts and the live write-ahead log. Flushes and compactions commit by appending a single
+//           edit, so files that aren't referenced by the manifest are leftovers of interrupted operations.
+//           Part and log IDs are taken from the same counter, hence they never collide.
+// 5. DATABASES: The data directory has a single DATABASES file, which maps each Redis DB to its table; the mapping
+//           only changes by SWAPDB, and is atomically replaced as a whole. Redis DB n maps to table n+1 by default.
 
 // Code generated by protoc-gen-go. DO NOT EDIT.
 // versions:
@@ -42,11 +59,16 @@ type PartHeader struct {
 	unknownFields protoimpl.UnknownFields
 
 	// NOTE: Since keeping the reference to the next part needs disk updates, we don't do that.
-	Id        int64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`                               // Part's ID; sorted by time; File's name is <id>.part
-	PrevPart  int64                 `protobuf:"varint,2,opt,name=prev_part,json=prevPart,proto3" json:"prev_part,omitempty"`   // ID of the previous part; zero if this is the first part.
+	Id int64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"` // Part's ID; File's name is <id>.sst
+	// ID of the previous part at the time of writing; zero if this was the first part. Since the manifest, the order
+	// of parts is decided by their sequence ranges and this is only used to migrate tables without a manifest.
+	PrevPart  int64                 `protobuf:"varint,2,opt,name=prev_part,json=prevPart,proto3" json:"prev_part,omitempty"`
 	SkipIndex *PartHeader_SkipIndex `protobuf:"bytes,3,opt,name=skip_index,json=skipIndex,proto3" json:"skip_index,omitempty"` // In-memory skip index for the entire part.
 	// NOTE: Bloom filter index is not stored as a gob, but we use protobuf instead for better on-disk size.
-	BfIndex *PartHeader_BloomFilterIndex `protobuf:"bytes,4,opt,name=bf_index,json=bfIndex,proto3" json:"bf_index,omitempty"` // In-memory Bloom filter for the entire part (optional).
+	BfIndex     *PartHeader_BloomFilterIndex `protobuf:"bytes,4,opt,name=bf_index,json=bfIndex,proto3" json:"bf_index,omitempty"`                // In-memory Bloom filter for the entire part (optional).
+	Level       int32                        `protobuf:"varint,5,opt,name=level,proto3" json:"level,omitempty"`                                  // The compaction level of the part; zero for flushed memtables.
+	NumKeys     int64                        `protobuf:"varint,6,opt,name=num_keys,json=numKeys,proto3" json:"num_keys,omitempty"`               // Total number of key versions in the part.
+	NumDeadKeys int64                        `protobuf:"varint,7,opt,name=num_dead_keys,json=numDeadKeys,proto3" json:"num_dead_keys,omitempty"` // Number of keys whose oldest version was dead, beyond history, when the part was written.
 }
 
 func (x *PartHeader) Reset() {
@@ -109,7 +131,28 @@ func (x *PartHeader) GetBfIndex() *PartHeader_BloomFilterIndex {
 	return nil
 }
 
-// The data section contains multiple data blocks, each structured as follows:
+func (x *PartHeader) GetLevel() int32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+func (x *PartHeader) GetNumKeys() int64 {
+	if x != nil {
+		return x.NumKeys
+	}
+	return 0
+}
+
+func (x *PartHeader) GetNumDeadKeys() int64 {
+	if x != nil {
+		return x.NumDeadKeys
+	}
+	return 0
+}
+
+// The data section contains multiple data blocks of about --data_block_size bytes, each structured as follows:
 type DataBlock struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -117,6 +160,12 @@ type DataBlock struct {
 
 	Keys   [][]byte `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`     // The key without the common prefix mentioned in SkipIndex.
 	Values [][]byte `protobuf:"bytes,2,rep,name=values,proto3" json:"values,omitempty"` // The corresponding value for each key.
+	// The sequence number of the write of each value; empty when every value was written before sequence numbers,
+	// i.e. they're older than any other version.
+	Sequences []int64 `protobuf:"varint,3,rep,packed,name=sequences,proto3" json:"sequences,omitempty"`
+	// The commit time of the write of each value in unix nanoseconds; empty when every value was written before commit
+	// times, which are treated as older than any time.
+	Timestamps []int64 `protobuf:"varint,4,rep,packed,name=timestamps,proto3" json:"timestamps,omitempty"`
 }
 
 func (x *DataBlock) Reset() {
@@ -165,6 +214,327 @@ func (x *DataBlock) GetValues() [][]byte {
 	return nil
 }
 
+func (x *DataBlock) GetSequences() []int64 {
+	if x != nil {
+		return x.Sequences
+	}
+	return nil
+}
+
+func (x *DataBlock) GetTimestamps() []int64 {
+	if x != nil {
+		return x.Timestamps
+	}
+	return nil
+}
+
+// Each write-ahead log record is framed as [crc32c][length][WalRecord], see wal.go for details.
+type WalRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Key       []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value     []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Sequence  int64  `protobuf:"varint,3,opt,name=sequence,proto3" json:"sequence,omitempty"`   // The sequence number of the write; zero for logs written before sequence numbers.
+	Timestamp int64  `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"` // The commit time of the write in unix nanoseconds; zero for logs written before commit times.
+}
+
+func (x *WalRecord) Reset() {
+	*x = WalRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_layout_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WalRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WalRecord) ProtoMessage() {}
+
+func (x *WalRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_layout_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WalRecord.ProtoReflect.Descriptor instead.
+func (*WalRecord) Descriptor() ([]byte, []int) {
+	return file_layout_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *WalRecord) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *WalRecord) GetValue() []byte {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *WalRecord) GetSequence() int64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *WalRecord) GetTimestamp() int64 {
+	if x != nil {
+		return x.Timestamp
+	}
+	return 0
+}
+
+// Describes a live part in the manifest.
+type PartMeta struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id       int64  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Level    int32  `protobuf:"varint,2,opt,name=level,proto3" json:"level,omitempty"`
+	FirstKey []byte `protobuf:"bytes,3,opt,name=first_key,json=firstKey,proto3" json:"first_key,omitempty"` // Empty for empty parts.
+	LastKey  []byte `protobuf:"bytes,4,opt,name=last_key,json=lastKey,proto3" json:"last_key,omitempty"`    // Empty for empty parts.
+	// The range of write sequence numbers that the part covers; newer parts always cover higher sequences.
+	MinSequence int64 `protobuf:"varint,5,opt,name=min_sequence,json=minSequence,proto3" json:"min_sequence,omitempty"`
+	MaxSequence int64 `protobuf:"varint,6,opt,name=max_sequence,json=maxSequence,proto3" json:"max_sequence,omitempty"`
+}
+
+func (x *PartMeta) Reset() {
+	*x = PartMeta{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_layout_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PartMeta) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PartMeta) ProtoMessage() {}
+
+func (x *PartMeta) ProtoReflect() protoreflect.Message {
+	mi := &file_layout_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PartMeta.ProtoReflect.Descriptor instead.
+func (*PartMeta) Descriptor() ([]byte, []int) {
+	return file_layout_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PartMeta) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *PartMeta) GetLevel() int32 {
+	if x != nil {
+		return x.Level
+	}
+	return 0
+}
+
+func (x *PartMeta) GetFirstKey() []byte {
+	if x != nil {
+		return x.FirstKey
+	}
+	return nil
+}
+
+func (x *PartMeta) GetLastKey() []byte {
+	if x != nil {
+		return x.LastKey
+	}
+	return nil
+}
+
+func (x *PartMeta) GetMinSequence() int64 {
+	if x != nil {
+		return x.MinSequence
+	}
+	return 0
+}
+
+func (x *PartMeta) GetMaxSequence() int64 {
+	if x != nil {
+		return x.MaxSequence
+	}
+	return 0
+}
+
+// Each manifest block is an edit to the table state; replaying every edit in order rebuilds the latest state.
+// When a table is opened, its manifest is rewritten as a single edit holding the whole state.
+type ManifestEdit struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version      int64       `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"` // Incremented by one per edit.
+	AddedParts   []*PartMeta `protobuf:"bytes,2,rep,name=added_parts,json=addedParts,proto3" json:"added_parts,omitempty"`
+	RemovedParts []int64     `protobuf:"varint,3,rep,packed,name=removed_parts,json=removedParts,proto3" json:"removed_parts,omitempty"` // IDs of the parts which are no longer live.
+	NextId       int64       `protobuf:"varint,4,opt,name=next_id,json=nextId,proto3" json:"next_id,omitempty"`                          // The next unused part / log ID.
+	LogNumber    int64       `protobuf:"varint,5,opt,name=log_number,json=logNumber,proto3" json:"log_number,omitempty"`                 // ID of the oldest live write-ahead log; zero if unchanged.
+	LastSequence int64       `protobuf:"varint,6,opt,name=last_sequence,json=lastSequence,proto3" json:"last_sequence,omitempty"`        // The last sequence number covered by the parts; zero if unchanged.
+}
+
+func (x *ManifestEdit) Reset() {
+	*x = ManifestEdit{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_layout_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ManifestEdit) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ManifestEdit) ProtoMessage() {}
+
+func (x *ManifestEdit) ProtoReflect() protoreflect.Message {
+	mi := &file_layout_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ManifestEdit.ProtoReflect.Descriptor instead.
+func (*ManifestEdit) Descriptor() ([]byte, []int) {
+	return file_layout_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ManifestEdit) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *ManifestEdit) GetAddedParts() []*PartMeta {
+	if x != nil {
+		return x.AddedParts
+	}
+	return nil
+}
+
+func (x *ManifestEdit) GetRemovedParts() []int64 {
+	if x != nil {
+		return x.RemovedParts
+	}
+	return nil
+}
+
+func (x *ManifestEdit) GetNextId() int64 {
+	if x != nil {
+		return x.NextId
+	}
+	return 0
+}
+
+func (x *ManifestEdit) GetLogNumber() int64 {
+	if x != nil {
+		return x.LogNumber
+	}
+	return 0
+}
+
+func (x *ManifestEdit) GetLastSequence() int64 {
+	if x != nil {
+		return x.LastSequence
+	}
+	return 0
+}
+
+type DatabaseMapping struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Tables []int64 `protobuf:"varint,1,rep,packed,name=tables,proto3" json:"tables,omitempty"` // The table of each Redis DB, indexed by the DB number.
+	// Whether the Redis keys that start with a zero byte are escaped in every table; unset by versions before the
+	// internal keys of collections, which stored every key as is.
+	EscapedKeys bool `protobuf:"varint,2,opt,name=escaped_keys,json=escapedKeys,proto3" json:"escaped_keys,omitempty"`
+}
+
+func (x *DatabaseMapping) Reset() {
+	*x = DatabaseMapping{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_layout_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DatabaseMapping) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DatabaseMapping) ProtoMessage() {}
+
+func (x *DatabaseMapping) ProtoReflect() protoreflect.Message {
+	mi := &file_layout_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DatabaseMapping.ProtoReflect.Descriptor instead.
+func (*DatabaseMapping) Descriptor() ([]byte, []int) {
+	return file_layout_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DatabaseMapping) GetTables() []int64 {
+	if x != nil {
+		return x.Tables
+	}
+	return nil
+}
+
+func (x *DatabaseMapping) GetEscapedKeys() bool {
+	if x != nil {
+		return x.EscapedKeys
+	}
+	return false
+}
+
 type PartHeader_SkipIndex struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -179,7 +549,7 @@ type PartHeader_SkipIndex struct {
 func (x *PartHeader_SkipIndex) Reset() {
 	*x = PartHeader_SkipIndex{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_layout_proto_msgTypes[2]
+		mi := &file_layout_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -192,7 +562,7 @@ func (x *PartHeader_SkipIndex) String() string {
 func (*PartHeader_SkipIndex) ProtoMessage() {}
 
 func (x *PartHeader_SkipIndex) ProtoReflect() protoreflect.Message {
-	mi := &file_layout_proto_msgTypes[2]
+	mi := &file_layout_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -249,7 +619,7 @@ type PartHeader_BloomFilterIndex struct {
 func (x *PartHeader_BloomFilterIndex) Reset() {
 	*x = PartHeader_BloomFilterIndex{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_layout_proto_msgTypes[3]
+		mi := &file_layout_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -262,7 +632,7 @@ func (x *PartHeader_BloomFilterIndex) String() string {
 func (*PartHeader_BloomFilterIndex) ProtoMessage() {}
 
 func (x *PartHeader_BloomFilterIndex) ProtoReflect() protoreflect.Message {
-	mi := &file_layout_proto_msgTypes[3]
+	mi := &file_layout_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -303,7 +673,7 @@ var File_layout_proto protoreflect.FileDescriptor
 
 var file_layout_proto_rawDesc = []byte{
 	0x0a, 0x0c, 0x6c, 0x61, 0x79, 0x6f, 0x75, 0x74, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x04,
-	0x6b, 0x69, 0x77, 0x69, 0x22, 0xad, 0x03, 0x0a, 0x0a, 0x50, 0x61, 0x72, 0x74, 0x48, 0x65, 0x61,
+	0x6b, 0x69, 0x77, 0x69, 0x22, 0x82, 0x04, 0x0a, 0x0a, 0x50, 0x61, 0x72, 0x74, 0x48, 0x65, 0x61,
 	0x64, 0x65, 0x72, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52,
 	0x02, 0x69, 0x64, 0x12, 0x1b, 0x0a, 0x09, 0x70, 0x72, 0x65, 0x76, 0x5f, 0x70, 0x61, 0x72, 0x74,
 	0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x70, 0x72, 0x65, 0x76, 0x50, 0x61, 0x72, 0x74,
@@ -314,29 +684,75 @@ var file_layout_proto_rawDesc = []byte{
 	0x66, 0x5f, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x21, 0x2e,
 	0x6b, 0x69, 0x77, 0x69, 0x2e, 0x50, 0x61, 0x72, 0x74, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x2e,
 	0x42, 0x6c, 0x6f, 0x6f, 0x6d, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x49, 0x6e, 0x64, 0x65, 0x78,
-	0x52, 0x07, 0x62, 0x66, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x1a, 0x86, 0x01, 0x0a, 0x09, 0x53, 0x6b,
-	0x69, 0x70, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72, 0x65, 0x66, 0x69,
-	0x78, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x08, 0x70, 0x72, 0x65, 0x66, 0x69,
-	0x78, 0x65, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x66, 0x69, 0x72, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79,
-	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x09, 0x66, 0x69, 0x72, 0x73, 0x74, 0x4b, 0x65,
-	0x79, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x03,
-	0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x6c, 0x61, 0x73, 0x74, 0x4b, 0x65, 0x79, 0x12, 0x23, 0x0a,
-	0x0d, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x73, 0x18, 0x04,
-	0x20, 0x03, 0x28, 0x03, 0x52, 0x0c, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x4f, 0x66, 0x66, 0x73, 0x65,
-	0x74, 0x73, 0x1a, 0x70, 0x0a, 0x10, 0x42, 0x6c, 0x6f, 0x6f, 0x6d, 0x46, 0x69, 0x6c, 0x74, 0x65,
-	0x72, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x19, 0x0a, 0x08, 0x6e, 0x75, 0x6d, 0x5f, 0x62, 0x69,
-	0x74, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x6e, 0x75, 0x6d, 0x42, 0x69, 0x74,
-	0x73, 0x12, 0x24, 0x0a, 0x0e, 0x6e, 0x75, 0x6d, 0x5f, 0x68, 0x61, 0x73, 0x68, 0x5f, 0x66, 0x75,
-	0x6e, 0x63, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x6e, 0x75, 0x6d, 0x48, 0x61,
-	0x73, 0x68, 0x46, 0x75, 0x6e, 0x63, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x62, 0x69, 0x74, 0x5f, 0x61,
-	0x72, 0x72, 0x61, 0x79, 0x18, 0x03, 0x20, 0x03, 0x28, 0x04, 0x52, 0x08, 0x62, 0x69, 0x74, 0x41,
-	0x72, 0x72, 0x61, 0x79, 0x22, 0x37, 0x0a, 0x09, 0x44, 0x61, 0x74, 0x61, 0x42, 0x6c, 0x6f, 0x63,
-	0x6b, 0x12, 0x12, 0x0a, 0x04, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52,
-	0x04, 0x6b, 0x65, 0x79, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x18,
-	0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x06, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x42, 0x22, 0x5a,
-	0x20, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e, 0x6f, 0x62, 0x6c,
-	0x65, 0x74, 0x6f, 0x6f, 0x74, 0x68, 0x2f, 0x6b, 0x69, 0x77, 0x69, 0x2f, 0x70, 0x72, 0x6f, 0x74,
-	0x6f, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x52, 0x07, 0x62, 0x66, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x65, 0x76,
+	0x65, 0x6c, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x6c, 0x65, 0x76, 0x65, 0x6c, 0x12,
+	0x19, 0x0a, 0x08, 0x6e, 0x75, 0x6d, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x07, 0x6e, 0x75, 0x6d, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x22, 0x0a, 0x0d, 0x6e, 0x75,
+	0x6d, 0x5f, 0x64, 0x65, 0x61, 0x64, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x0b, 0x6e, 0x75, 0x6d, 0x44, 0x65, 0x61, 0x64, 0x4b, 0x65, 0x79, 0x73, 0x1a, 0x86,
+	0x01, 0x0a, 0x09, 0x53, 0x6b, 0x69, 0x70, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x1a, 0x0a, 0x08,
+	0x70, 0x72, 0x65, 0x66, 0x69, 0x78, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x08,
+	0x70, 0x72, 0x65, 0x66, 0x69, 0x78, 0x65, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x66, 0x69, 0x72, 0x73,
+	0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x09, 0x66, 0x69,
+	0x72, 0x73, 0x74, 0x4b, 0x65, 0x79, 0x73, 0x12, 0x19, 0x0a, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x5f,
+	0x6b, 0x65, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x07, 0x6c, 0x61, 0x73, 0x74, 0x4b,
+	0x65, 0x79, 0x12, 0x23, 0x0a, 0x0d, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x6f, 0x66, 0x66, 0x73,
+	0x65, 0x74, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x03, 0x52, 0x0c, 0x62, 0x6c, 0x6f, 0x63, 0x6b,
+	0x4f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x73, 0x1a, 0x70, 0x0a, 0x10, 0x42, 0x6c, 0x6f, 0x6f, 0x6d,
+	0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x19, 0x0a, 0x08, 0x6e,
+	0x75, 0x6d, 0x5f, 0x62, 0x69, 0x74, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x6e,
+	0x75, 0x6d, 0x42, 0x69, 0x74, 0x73, 0x12, 0x24, 0x0a, 0x0e, 0x6e, 0x75, 0x6d, 0x5f, 0x68, 0x61,
+	0x73, 0x68, 0x5f, 0x66, 0x75, 0x6e, 0x63, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c,
+	0x6e, 0x75, 0x6d, 0x48, 0x61, 0x73, 0x68, 0x46, 0x75, 0x6e, 0x63, 0x73, 0x12, 0x1b, 0x0a, 0x09,
+	0x62, 0x69, 0x74, 0x5f, 0x61, 0x72, 0x72, 0x61, 0x79, 0x18, 0x03, 0x20, 0x03, 0x28, 0x04, 0x52,
+	0x08, 0x62, 0x69, 0x74, 0x41, 0x72, 0x72, 0x61, 0x79, 0x22, 0x75, 0x0a, 0x09, 0x44, 0x61, 0x74,
+	0x61, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x12, 0x12, 0x0a, 0x04, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0c, 0x52, 0x04, 0x6b, 0x65, 0x79, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x76, 0x61,
+	0x6c, 0x75, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0c, 0x52, 0x06, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x73, 0x18,
+	0x03, 0x20, 0x03, 0x28, 0x03, 0x52, 0x09, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x73,
+	0x12, 0x1e, 0x0a, 0x0a, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x73, 0x18, 0x04,
+	0x20, 0x03, 0x28, 0x03, 0x52, 0x0a, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x73,
+	0x22, 0x6d, 0x0a, 0x09, 0x57, 0x61, 0x6c, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x10, 0x0a,
+	0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12,
+	0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63,
+	0x65, 0x12, 0x1c, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x22,
+	0xae, 0x01, 0x0a, 0x08, 0x50, 0x61, 0x72, 0x74, 0x4d, 0x65, 0x74, 0x61, 0x12, 0x0e, 0x0a, 0x02,
+	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x02, 0x69, 0x64, 0x12, 0x14, 0x0a, 0x05,
+	0x6c, 0x65, 0x76, 0x65, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x05, 0x6c, 0x65, 0x76,
+	0x65, 0x6c, 0x12, 0x1b, 0x0a, 0x09, 0x66, 0x69, 0x72, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x08, 0x66, 0x69, 0x72, 0x73, 0x74, 0x4b, 0x65, 0x79, 0x12,
+	0x19, 0x0a, 0x08, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x0c, 0x52, 0x07, 0x6c, 0x61, 0x73, 0x74, 0x4b, 0x65, 0x79, 0x12, 0x21, 0x0a, 0x0c, 0x6d, 0x69,
+	0x6e, 0x5f, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0b, 0x6d, 0x69, 0x6e, 0x53, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x21, 0x0a,
+	0x0c, 0x6d, 0x61, 0x78, 0x5f, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x0b, 0x6d, 0x61, 0x78, 0x53, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65,
+	0x22, 0xdb, 0x01, 0x0a, 0x0c, 0x4d, 0x61, 0x6e, 0x69, 0x66, 0x65, 0x73, 0x74, 0x45, 0x64, 0x69,
+	0x74, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x2f, 0x0a, 0x0b, 0x61,
+	0x64, 0x64, 0x65, 0x64, 0x5f, 0x70, 0x61, 0x72, 0x74, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x0e, 0x2e, 0x6b, 0x69, 0x77, 0x69, 0x2e, 0x50, 0x61, 0x72, 0x74, 0x4d, 0x65, 0x74, 0x61,
+	0x52, 0x0a, 0x61, 0x64, 0x64, 0x65, 0x64, 0x50, 0x61, 0x72, 0x74, 0x73, 0x12, 0x23, 0x0a, 0x0d,
+	0x72, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x64, 0x5f, 0x70, 0x61, 0x72, 0x74, 0x73, 0x18, 0x03, 0x20,
+	0x03, 0x28, 0x03, 0x52, 0x0c, 0x72, 0x65, 0x6d, 0x6f, 0x76, 0x65, 0x64, 0x50, 0x61, 0x72, 0x74,
+	0x73, 0x12, 0x17, 0x0a, 0x07, 0x6e, 0x65, 0x78, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x06, 0x6e, 0x65, 0x78, 0x74, 0x49, 0x64, 0x12, 0x1d, 0x0a, 0x0a, 0x6c, 0x6f,
+	0x67, 0x5f, 0x6e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
+	0x6c, 0x6f, 0x67, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x23, 0x0a, 0x0d, 0x6c, 0x61, 0x73,
+	0x74, 0x5f, 0x73, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0c, 0x6c, 0x61, 0x73, 0x74, 0x53, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x65, 0x22, 0x4c,
+	0x0a, 0x0f, 0x44, 0x61, 0x74, 0x61, 0x62, 0x61, 0x73, 0x65, 0x4d, 0x61, 0x70, 0x70, 0x69, 0x6e,
+	0x67, 0x12, 0x16, 0x0a, 0x06, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28,
+	0x03, 0x52, 0x06, 0x74, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x65, 0x73, 0x63,
+	0x61, 0x70, 0x65, 0x64, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x0b, 0x65, 0x73, 0x63, 0x61, 0x70, 0x65, 0x64, 0x4b, 0x65, 0x79, 0x73, 0x42, 0x22, 0x5a, 0x20,
+	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e, 0x6f, 0x62, 0x6c, 0x65,
+	0x74, 0x6f, 0x6f, 0x74, 0x68, 0x2f, 0x6b, 0x69, 0x77, 0x69, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -351,21 +767,26 @@ func file_layout_proto_rawDescGZIP() []byte {
 	return file_layout_proto_rawDescData
 }
 
-var file_layout_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_layout_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
 var file_layout_proto_goTypes = []interface{}{
 	(*PartHeader)(nil),                  // 0: kiwi.PartHeader
 	(*DataBlock)(nil),                   // 1: kiwi.DataBlock
-	(*PartHeader_SkipIndex)(nil),        // 2: kiwi.PartHeader.SkipIndex
-	(*PartHeader_BloomFilterIndex)(nil), // 3: kiwi.PartHeader.BloomFilterIndex
+	(*WalRecord)(nil),                   // 2: kiwi.WalRecord
+	(*PartMeta)(nil),                    // 3: kiwi.PartMeta
+	(*ManifestEdit)(nil),                // 4: kiwi.ManifestEdit
+	(*DatabaseMapping)(nil),             // 5: kiwi.DatabaseMapping
+	(*PartHeader_SkipIndex)(nil),        // 6: kiwi.PartHeader.SkipIndex
+	(*PartHeader_BloomFilterIndex)(nil), // 7: kiwi.PartHeader.BloomFilterIndex
 }
 var file_layout_proto_depIdxs = []int32{
-	2, // 0: kiwi.PartHeader.skip_index:type_name -> kiwi.PartHeader.SkipIndex
-	3, // 1: kiwi.PartHeader.bf_index:type_name -> kiwi.PartHeader.BloomFilterIndex
-	2, // [2:2] is the sub-list for method output_type
-	2, // [2:2] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	6, // 0: kiwi.PartHeader.skip_index:type_name -> kiwi.PartHeader.SkipIndex
+	7, // 1: kiwi.PartHeader.bf_index:type_name -> kiwi.PartHeader.BloomFilterIndex
+	3, // 2: kiwi.ManifestEdit.added_parts:type_name -> kiwi.PartMeta
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
 }
 
 func init() { file_layout_proto_init() }
@@ -399,7 +820,7 @@ func file_layout_proto_init() {
 			}
 		}
 		file_layout_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*PartHeader_SkipIndex); i {
+			switch v := v.(*WalRecord); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -411,6 +832,54 @@ func file_layout_proto_init() {
 			}
 		}
 		file_layout_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PartMeta); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_layout_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ManifestEdit); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_layout_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DatabaseMapping); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_layout_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PartHeader_SkipIndex); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_layout_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*PartHeader_BloomFilterIndex); i {
 			case 0:
 				return &v.state
@@ -429,7 +898,7 @@ func file_layout_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_layout_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   4,
+			NumMessages:   8,
 			NumExtensions: 0,
 			NumServices:   0,
 		},